@@ -78,6 +78,18 @@ func main() {
 				}
 			case eventmon.AuthTypeU2F:
 				data.AuthType = eventrecorder.AuthTypeU2F
+			case eventmon.AuthTypeTOTP:
+				data.AuthType = eventrecorder.AuthTypeTOTP
+			case eventmon.AuthTypePushApproval:
+				data.AuthType = eventrecorder.AuthTypePushApproval
+			case eventmon.AuthTypeDuo:
+				data.AuthType = eventrecorder.AuthTypeDuo
+			case eventmon.AuthTypeOkta:
+				data.AuthType = eventrecorder.AuthTypeOkta
+			case eventmon.AuthTypeRADIUS:
+				data.AuthType = eventrecorder.AuthTypeRADIUS
+			case eventmon.AuthTypeKerberos:
+				data.AuthType = eventrecorder.AuthTypeKerberos
 			default:
 				continue
 			}
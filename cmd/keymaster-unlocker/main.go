@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Symantec/Dominator/lib/log/cmdlogger"
 	"github.com/howeyc/gopass"
@@ -20,6 +23,8 @@ var (
 	keyFile    = flag.String("key", "key.pem", "A PEM encoded private key file.")
 	targetHost = flag.String("keymasterHostname", "", "The hostname/port for keymaster")
 	targetPort = flag.Int("keymasterPort", 6920, "The port for keymaster control port")
+	useShare   = flag.Bool("share", false, "Submit an unseal share instead of the password")
+	statusOnly = flag.Bool("status", false, "Only show the unseal status")
 )
 
 func Usage() {
@@ -41,13 +46,6 @@ func main() {
 		logger.Fatal(err)
 	}
 
-	fmt.Printf("Password for unlocking %s: ", *targetHost)
-	password, err := gopass.GetPasswd()
-	if err != nil {
-		logger.Fatal(err)
-		// Handle gopass.ErrInterrupted or getch() read error
-	}
-
 	// Setup HTTPS client
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
@@ -55,20 +53,68 @@ func main() {
 	tlsConfig.BuildNameToCertificate()
 	transport := &http.Transport{TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: transport}
+	baseURL := "https://" + *targetHost + ":" + strconv.Itoa(*targetPort)
 
-	// Do GET something
-	resp, err := client.PostForm("https://"+*targetHost+":"+strconv.Itoa(*targetPort)+"/admin/inject",
-		url.Values{"ssh_ca_password": {string(password[:])}})
-	//resp, err := client.Get("https://goldportugal.local:8443")
+	if !*statusOnly {
+		formField := "ssh_ca_password"
+		prompt := "Password"
+		if *useShare {
+			formField = "ssh_ca_share"
+			prompt = "Unseal share"
+		}
+		fmt.Printf("%s for unlocking %s: ", prompt, *targetHost)
+		password, err := gopass.GetPasswd()
+		if err != nil {
+			logger.Fatal(err)
+			// Handle gopass.ErrInterrupted or getch() read error
+		}
+		resp, err := client.PostForm(baseURL+"/admin/inject",
+			url.Values{formField: {string(password[:])}})
+		if err != nil {
+			logger.Fatal(err)
+		}
+		// Dump response
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Println(string(data))
+	}
+
+	resp, err := client.Get(baseURL + "/admin/unsealStatus")
 	if err != nil {
 		logger.Fatal(err)
 	}
 	defer resp.Body.Close()
-
-	// Dump response
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if resp.StatusCode != http.StatusOK {
+		logger.Fatalf("Cannot get unseal status: %s", resp.Status)
+	}
+	var status struct {
+		Sealed         bool
+		Threshold      int
+		SharesReceived int
+		Operators      []string
+		ExpiresAt      *time.Time
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
 		logger.Fatal(err)
 	}
-	logger.Println(string(data))
+	if !status.Sealed {
+		fmt.Println("Status: unsealed")
+		return
+	}
+	if status.Threshold < 1 {
+		fmt.Println("Status: sealed")
+		return
+	}
+	fmt.Printf("Status: sealed, %d of %d shares received\n",
+		status.SharesReceived, status.Threshold)
+	if len(status.Operators) > 0 {
+		fmt.Printf("Shares from: %s\n", strings.Join(status.Operators, ", "))
+	}
+	if status.ExpiresAt != nil {
+		fmt.Printf("Partial progress expires at: %s\n",
+			status.ExpiresAt.Local().Format(time.RFC3339))
+	}
 }
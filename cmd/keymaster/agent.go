@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/config"
+	"github.com/Symantec/keymaster/lib/client/sshagent"
+	"github.com/Symantec/keymaster/lib/client/twofa"
+	"github.com/Symantec/keymaster/lib/client/util"
+)
+
+var (
+	agentSocket = flag.String("agentSocket", "",
+		"Socket for the keymaster agent to listen on (default ~/.keymaster/agent.sock)")
+	renewBefore = flag.Duration("renewBefore", time.Hour,
+		"The keymaster agent renews its certificate this long before it expires")
+)
+
+// runAgent runs keymaster as an SSH agent. The keymaster key and certificate
+// are only held in memory, and the requests for other keys are forwarded to
+// the agent given by -sshAgentSocket or SSH_AUTH_SOCK, if any.
+func runAgent(
+	userName string,
+	homeDir string,
+	configContents config.AppConfigFile,
+	client *http.Client,
+	logger log.DebugLogger) {
+	targetURLs := strings.Split(configContents.Base.Gen_Cert_URLS, ",")
+	socketPath := *agentSocket
+	if socketPath == "" {
+		socketPath = filepath.Join(homeDir, ".keymaster", "agent.sock")
+	}
+	upstreamPath := *sshAgentSocket
+	if upstreamPath == "" {
+		upstreamPath = os.Getenv("SSH_AUTH_SOCK")
+	}
+	var upstream *sshagent.Agent
+	// Do not forward to ourselves when started from a shell already using
+	// the keymaster agent.
+	if upstreamPath != "" && upstreamPath != socketPath {
+		var err error
+		upstream, err = sshagent.Dial(upstreamPath)
+		if err != nil {
+			logger.Fatalf("Cannot connect to SSH agent: %s", err)
+		}
+		defer upstream.Close()
+	}
+	sshKeyPath := filepath.Join(homeDir, DefaultSSHKeysLocation, FilePrefix)
+	keyring := sshagent.NewKeyring(upstream, sshKeyPath)
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		logger.Fatal(err)
+	}
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer listener.Close()
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		logger.Fatal(err)
+	}
+
+	err = backgroundConnectToAnyKeymasterServer(targetURLs, client, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	signer, err := util.GenerateKeyWithType(configContents.Base.KeyType)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	sshCert, _, _, err := getCerts(signer, userName, homeDir, targetURLs,
+		configContents, client, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := keyring.SetCertificate(signer, sshCert); err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socketPath)
+	go renewAgentCertificate(keyring, userName, targetURLs, configContents,
+		socketPath, client, logger)
+	logger.Fatal(keyring.Serve(listener))
+}
+
+// renewAgentCertificate renews the certificate held by keyring through the
+// login session kept in the jar of client, -renewBefore before it expires.
+// Once the session has expired the user is told to run keymaster, which
+// loads a new certificate into the keymaster agent.
+func renewAgentCertificate(
+	keyring *sshagent.Keyring,
+	userName string,
+	targetURLs []string,
+	configContents config.AppConfigFile,
+	socketPath string,
+	client *http.Client,
+	logger log.DebugLogger) {
+	var notifiedExpiry time.Time
+	for ; ; time.Sleep(time.Minute) {
+		expiry := keyring.CertificateExpiry()
+		if expiry.IsZero() || time.Until(expiry) > *renewBefore ||
+			expiry.Equal(notifiedExpiry) {
+			continue
+		}
+		signer, err := util.GenerateKeyWithType(configContents.Base.KeyType)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		sshCert, err := twofa.GetSSHCertFromSession(signer, userName,
+			targetURLs, client, userAgentString, logger)
+		if err == nil {
+			err = keyring.SetCertificate(signer, sshCert)
+		}
+		if err != nil {
+			logger.Printf("Cannot renew the keymaster certificate, which expires at %s: %s",
+				expiry.Format(time.RFC1123), err)
+			logger.Printf("Run keymaster with SSH_AUTH_SOCK=%s to get a new one",
+				socketPath)
+			notifiedExpiry = expiry
+			continue
+		}
+		logger.Printf("Renewed keymaster certificate, it expires at %s",
+			keyring.CertificateExpiry().Format(time.RFC1123))
+	}
+}
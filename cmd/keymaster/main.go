@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -11,7 +12,6 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
@@ -22,14 +22,20 @@ import (
 	"github.com/Symantec/Dominator/lib/log/cmdlogger"
 	"github.com/Symantec/Dominator/lib/net/rrdialer"
 	"github.com/Symantec/keymaster/lib/client/config"
+	"github.com/Symantec/keymaster/lib/client/kerberos"
+	"github.com/Symantec/keymaster/lib/client/kubeconfig"
 	libnet "github.com/Symantec/keymaster/lib/client/net"
+	"github.com/Symantec/keymaster/lib/client/session"
+	"github.com/Symantec/keymaster/lib/client/sshagent"
 	"github.com/Symantec/keymaster/lib/client/twofa"
 	"github.com/Symantec/keymaster/lib/client/twofa/u2f"
 	"github.com/Symantec/keymaster/lib/client/util"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
 )
 
 const DefaultSSHKeysLocation = "/.ssh/"
 const DefaultTLSKeysLocation = "/.ssl/"
+const DefaultKubeConfigLocation = "/.kube/config"
 
 const userAgentAppName = "keymaster"
 const defaultVersionNumber = "No version provided"
@@ -51,6 +57,14 @@ var (
 	cliFilePrefix    = flag.String("fileprefix", "", "Prefix for the output files")
 	roundRobinDialer = flag.Bool("roundRobinDialer", false,
 		"If true, use the smart round-robin dialer")
+	sshAgentSocket = flag.String("sshAgentSocket", "",
+		"SSH agent socket to load the key and certificate into (default $SSH_AUTH_SOCK)")
+	agentOnly = flag.Bool("agentOnly", false,
+		"If true, only load the SSH key and certificate into the SSH agent and never write the private key to disk. No X.509 certificates are written")
+	noSessionCache = flag.Bool("noSessionCache", false,
+		"If true, do not use or save a cached login session")
+	useKerberos = flag.Bool("kerberos", false,
+		"If true, log in with the Kerberos tickets of the user and ask for the password only if that fails")
 
 	FilePrefix = "keymaster"
 )
@@ -195,6 +209,20 @@ func setupCerts(
 	if err != nil {
 		logger.Fatal(err)
 	}
+	sshAgent, err := sshagent.Dial(*sshAgentSocket)
+	if err != nil {
+		if *agentOnly {
+			logger.Fatalf("Cannot connect to SSH agent: %s", err)
+		}
+		if err != sshagent.ErrNoAgent {
+			logger.Printf("Not using SSH agent: %s", err)
+		}
+	} else {
+		defer sshAgent.Close()
+	}
+	if *agentOnly && *twofa.KubernetesCluster != "" {
+		logger.Fatal("-kubernetesCluster needs the private key on disk, it cannot be used with -agentOnly")
+	}
 
 	// create dirs
 	sshKeyPath := filepath.Join(homeDir, DefaultSSHKeysLocation, FilePrefix)
@@ -212,30 +240,26 @@ func setupCerts(
 
 	// get signer
 	tempPrivateKeyPath := filepath.Join(homeDir, DefaultSSHKeysLocation, "keymaster-temp")
-	signer, tempPublicKeyPath, err := util.GenKeyPair(
-		tempPrivateKeyPath, userName+"@keymaster", logger)
-	if err != nil {
-		logger.Fatal(err)
-	}
-	defer os.Remove(tempPrivateKeyPath)
-	defer os.Remove(tempPublicKeyPath)
-	// Get user creds
-	password, err := util.GetUserCreds(userName)
-	if err != nil {
-		logger.Fatal(err)
+	var signer crypto.Signer
+	var tempPublicKeyPath string
+	if *agentOnly {
+		signer, err = util.GenerateKeyWithType(configContents.Base.KeyType)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		signer, tempPublicKeyPath, err = util.GenKeyPairWithType(
+			tempPrivateKeyPath, userName+"@keymaster",
+			configContents.Base.KeyType, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer os.Remove(tempPrivateKeyPath)
+		defer os.Remove(tempPublicKeyPath)
 	}
-
 	// Get the certs
-	sshCert, x509Cert, kubernetesCert, err := twofa.GetCertFromTargetUrls(
-		signer,
-		userName,
-		password,
-		strings.Split(configContents.Base.Gen_Cert_URLS, ","),
-		false,
-		configContents.Base.AddGroups,
-		client,
-		userAgentString,
-		logger)
+	sshCert, x509Cert, kubernetesCert, err := getCerts(signer, userName,
+		homeDir, targetURLs, configContents, client, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -244,11 +268,15 @@ func setupCerts(
 		logger.Fatal(err)
 	}
 	logger.Debugf(0, "Got Certs from server")
-	//..
-	if _, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
-		// TODO(rgooch): Parse certificate to get actual lifetime.
-		cmd := exec.Command("ssh-add", "-d", sshKeyPath)
-		cmd.Run()
+	// The key path is also the comment used by ssh-add, so keys added by
+	// older clients are replaced as well.
+	if *agentOnly {
+		err = sshAgent.AddCertificate(signer, sshCert, sshKeyPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("Success")
+		return
 	}
 
 	//rename files to expected paths
@@ -301,22 +329,156 @@ func setupCerts(
 	}
 	if kubernetesCert != nil {
 		kubernetesCertPath := tlsKeyPath + "-kubernetes.cert"
+		if *twofa.KubernetesCluster != "" {
+			kubernetesCertPath = tlsKeyPath + "-kubernetes-" +
+				*twofa.KubernetesCluster + ".cert"
+		}
 		err = ioutil.WriteFile(kubernetesCertPath, kubernetesCert, 0644)
 		if err != nil {
 			err := errors.New("Could not write ssh cert")
 			logger.Fatal(err)
 		}
+		if *twofa.KubernetesCluster != "" {
+			err = writeKubeConfig(homeDir, targetURLs, kubernetesCertPath,
+				tlsPrivateKeyName, client)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
 	}
 
 	logger.Printf("Success")
-	if _, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
-		// TODO(rgooch): Parse certificate to get actual lifetime.
-		lifetime := fmt.Sprintf("%ds", uint64((*twofa.Duration).Seconds()))
-		cmd := exec.Command("ssh-add", "-t", lifetime, sshKeyPath)
-		cmd.Run()
+	if sshAgent != nil {
+		err := sshAgent.AddCertificate(signer, sshCert, sshKeyPath)
+		if err != nil {
+			logger.Printf("Cannot add certificate to SSH agent: %s", err)
+		}
 	}
 }
 
+// getCerts gets the certs for signer. It uses the cached login session if
+// there is one the server still accepts, and logs in otherwise.
+func getCerts(
+	signer crypto.Signer,
+	userName string,
+	homeDir string,
+	targetURLs []string,
+	configContents config.AppConfigFile,
+	client *http.Client,
+	logger log.DebugLogger) (
+	sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	sessionPath := filepath.Join(homeDir, ".keymaster", FilePrefix+"-session")
+	if !*noSessionCache {
+		err = session.Load(sessionPath, userName, client)
+		if err == nil {
+			sshCert, x509Cert, kubernetesCert, err = twofa.GetCertFromSession(
+				signer, userName, targetURLs, configContents.Base.AddGroups,
+				client, userAgentString, logger)
+			if err == nil {
+				logger.Debugf(0, "Got Certs using cached session")
+				return sshCert, x509Cert, kubernetesCert, nil
+			}
+			logger.Debugf(0, "Cannot use cached session: %s", err)
+		} else if !os.IsNotExist(err) {
+			logger.Printf("Not using cached session: %s", err)
+		}
+	}
+	loggedIn := false
+	if *useKerberos {
+		sshCert, x509Cert, kubernetesCert, err = getCertsWithKerberos(
+			signer, userName, targetURLs, configContents, client, logger)
+		if err == nil {
+			loggedIn = true
+		} else {
+			logger.Printf("Cannot log in with Kerberos: %s", err)
+		}
+	}
+	if !loggedIn {
+		// Get user creds
+		password, err := util.GetUserCreds(userName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sshCert, x509Cert, kubernetesCert, err = twofa.GetCertFromTargetUrls(
+			signer,
+			userName,
+			password,
+			targetURLs,
+			false,
+			configContents.Base.AddGroups,
+			client,
+			userAgentString,
+			logger)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if !*noSessionCache {
+		err := session.Save(sessionPath, userName, targetURLs, client)
+		if err != nil {
+			logger.Printf("Cannot cache session: %s", err)
+		}
+	}
+	return sshCert, x509Cert, kubernetesCert, nil
+}
+
+// getCertsWithKerberos logs in with the Kerberos tickets of the user instead
+// of a password.
+func getCertsWithKerberos(
+	signer crypto.Signer,
+	userName string,
+	targetURLs []string,
+	configContents config.AppConfigFile,
+	client *http.Client,
+	logger log.DebugLogger) (
+	sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	krbClient, err := kerberos.NewClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if krbClient.UserName() != userName {
+		return nil, nil, nil, fmt.Errorf("the Kerberos tickets are for %s",
+			krbClient.UserName())
+	}
+	return twofa.GetCertFromTargetUrlsWithKerberos(
+		signer,
+		userName,
+		krbClient,
+		targetURLs,
+		false,
+		configContents.Base.AddGroups,
+		client,
+		userAgentString,
+		logger)
+}
+
+// writeKubeConfig adds the cluster given with -kubernetesCluster to the
+// kubeconfig in ~/.kube and makes it the current context.
+func writeKubeConfig(homeDir string, targetURLs []string,
+	certFilename string, keyFilename string, client *http.Client) error {
+	var cluster *proto.KubernetesCluster
+	var err error
+	for _, baseUrl := range targetURLs {
+		cluster, err = kubeconfig.GetCluster(client, baseUrl,
+			*twofa.KubernetesCluster)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	kubeConfigPath := filepath.Join(homeDir, DefaultKubeConfigLocation)
+	err = kubeconfig.UpdateFile(kubeConfigPath, cluster, certFilename,
+		keyFilename)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote context %s to %s\n",
+		kubeconfig.ContextName(cluster.Name), kubeConfigPath)
+	return nil
+}
+
 func computeUserAgent() {
 	uaVersion := Version
 	if Version == defaultVersionNumber {
@@ -350,6 +512,7 @@ func getHttpClient(rootCAs *x509.CertPool, logger log.DebugLogger) (*http.Client
 func Usage() {
 	fmt.Fprintf(
 		os.Stderr, "Usage of %s (version %s):\n", os.Args[0], Version)
+	fmt.Fprintf(os.Stderr, "  %s [flags] [agent]\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -394,5 +557,9 @@ func main() {
 		FilePrefix = *cliFilePrefix
 	}
 
+	if flag.Arg(0) == "agent" {
+		runAgent(userName, homeDir, config, client, logger)
+		return
+	}
 	setupCerts(userName, homeDir, config, client, logger)
 }
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+const (
+	secondFactorAdminPath      = "/secondFactors/"
+	resetSecondFactorPath      = "/api/v0/resetSecondFactor"
+	issueEnrollmentBypassPath  = "/api/v0/issueEnrollmentBypass"
+	enrollmentBypassPath       = "/api/v0/enrollmentBypass"
+	enrollmentBypassLifetime   = 24 * time.Hour
+	maxSecondFactorAdminReason = 1024
+)
+
+type enrollmentBypassResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func hashEnrollmentBypassToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// getManagedSecondFactorProviders returns the enabled providers whose
+// enrollments can be managed by admins.
+func (state *RuntimeState) getManagedSecondFactorProviders() []managedSecondFactorProvider {
+	var providers []managedSecondFactorProvider
+	for _, provider := range secondFactorProviders {
+		managed, ok := provider.(managedSecondFactorProvider)
+		if ok && managed.Enabled(state) {
+			providers = append(providers, managed)
+		}
+	}
+	return providers
+}
+
+func (state *RuntimeState) writeSecondFactorAdminPage(w http.ResponseWriter,
+	authUser string, loginLevel int, username string, bypassLink string) {
+	profile, _, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	displayData := secondFactorAdminPageTemplateData{
+		Title:        "Keymaster Second Factors",
+		AuthUsername: authUser,
+		Username:     username,
+		BypassLink:   bypassLink,
+	}
+	if (loginLevel & AuthTypeU2F) == 0 {
+		displayData.ReadOnlyMsg = "Admins must U2F authenticate to change the second factors of others."
+	}
+	if fromCache {
+		displayData.ReadOnlyMsg = "The active keymaster is running disconnected from its DB backend. Second factors cannot be changed."
+	}
+	if len(profile.EnrollmentBypassHash) > 0 &&
+		profile.EnrollmentBypassExpiresAt.After(time.Now()) {
+		displayData.BypassExpiresAt = profile.EnrollmentBypassExpiresAt
+	}
+	for _, provider := range state.getManagedSecondFactorProviders() {
+		factor := secondFactorAdminFactorDisplayInfo{Name: provider.Name()}
+		factor.Enrollments, err = provider.ListEnrollments(state, username,
+			profile)
+		if err != nil {
+			logger.Printf("listing %s enrollments of %s error: %v",
+				provider.Name(), username, err)
+			factor.Error = "Cannot list enrollments"
+		}
+		displayData.Factors = append(displayData.Factors, factor)
+	}
+	setSecurityHeaders(w)
+	err = state.htmlTemplate.ExecuteTemplate(w, "secondFactorAdminPage",
+		displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// secondFactorAdminHandler shows the second factors of the user
+// secondFactorAdminPath/<username>.
+func (state *RuntimeState) secondFactorAdminHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if !state.IsAdminUser(authUser) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username := strings.TrimPrefix(r.URL.Path, secondFactorAdminPath)
+	if username == "" || strings.Contains(username, "/") {
+		http.Error(w, "bad username", http.StatusBadRequest)
+		return
+	}
+	state.writeSecondFactorAdminPage(w, authUser, loginLevel, username, "")
+}
+
+// checkSecondFactorAdminRequest checks that r is a POST from an admin with
+// a username and a reason, and loads the profile of that user. On failure
+// the response has been written and profile is nil.
+func (state *RuntimeState) checkSecondFactorAdminRequest(w http.ResponseWriter,
+	r *http.Request) (authUser string, loginLevel int, username string,
+	reason string, profile *userProfile) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.IsAdminUserAndU2F(authUser, loginLevel) {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Not an admin")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	username = r.Form.Get("username")
+	if username == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing username")
+		return
+	}
+	reason = strings.TrimSpace(r.Form.Get("reason"))
+	if reason == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"A reason is required")
+		return
+	}
+	if len(reason) > maxSecondFactorAdminReason {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Reason too long")
+		return
+	}
+	loadedProfile, _, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		logger.Printf("DB is being cached and requesting second factor change aborting it")
+		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
+		return
+	}
+	return authUser, loginLevel, username, reason, loadedProfile
+}
+
+func (state *RuntimeState) resetSecondFactorHandler(w http.ResponseWriter,
+	r *http.Request) {
+	authUser, _, username, reason, profile :=
+		state.checkSecondFactorAdminRequest(w, r)
+	if profile == nil {
+		return
+	}
+	factorName := r.Form.Get("factor")
+	var provider managedSecondFactorProvider
+	for _, managed := range state.getManagedSecondFactorProviders() {
+		if managed.Name() == factorName {
+			provider = managed
+		}
+	}
+	if provider == nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid factor")
+		return
+	}
+	id := r.Form.Get("id")
+	err := provider.ResetEnrollment(state, username, profile, id)
+	if err != nil {
+		if factorErr, ok := err.(*secondFactorError); ok {
+			state.writeFailureResponse(w, r, factorErr.statusCode,
+				factorErr.message)
+			return
+		}
+		logger.Printf("resetting %s of %s error: %v", factorName, username, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	err = state.SaveUserProfile(username, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if id == "" {
+		id = "all"
+	}
+	logger.Printf("%s reset %s enrollment %s of %s reason='%s'",
+		authUser, factorName, id, username, reason)
+	eventNotifier.PublishSecurityEvent(
+		eventmon.SecurityEventTypeSecondFactorReset, username, authUser,
+		factorName, reason)
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		http.Redirect(w, r, secondFactorAdminPath+username, 302)
+	default:
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "Success!")
+	}
+}
+
+// issueEnrollmentBypassHandler creates a one-time link that lets a user
+// who lost all their second factors enroll a new one.
+func (state *RuntimeState) issueEnrollmentBypassHandler(w http.ResponseWriter,
+	r *http.Request) {
+	authUser, loginLevel, username, reason, profile :=
+		state.checkSecondFactorAdminRequest(w, r)
+	if profile == nil {
+		return
+	}
+	token, err := genRandomString()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	profile.EnrollmentBypassHash = hashEnrollmentBypassToken(token)
+	profile.EnrollmentBypassExpiresAt = time.Now().Add(enrollmentBypassLifetime)
+	err = state.SaveUserProfile(username, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("%s issued an enrollment bypass for %s reason='%s'",
+		authUser, username, reason)
+	eventNotifier.PublishSecurityEvent(
+		eventmon.SecurityEventTypeEnrollmentBypassIssued, username, authUser,
+		"", reason)
+	bypassLink := u2fAppID + enrollmentBypassPath + "?token=" +
+		url.QueryEscape(token)
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		state.writeSecondFactorAdminPage(w, authUser, loginLevel, username,
+			bypassLink)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(enrollmentBypassResponse{
+			URL:       bypassLink,
+			ExpiresAt: profile.EnrollmentBypassExpiresAt,
+		})
+	}
+}
+
+// enrollmentBypassHandler consumes an enrollment bypass of the password
+// authenticated user and grants AuthTypeEnrollmentBypass to the session.
+func (state *RuntimeState) enrollmentBypassHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	profile, _, fromCache, err := state.LoadUserProfile(authUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
+		return
+	}
+	tokenHash := hashEnrollmentBypassToken(r.Form.Get("token"))
+	if len(profile.EnrollmentBypassHash) < 1 ||
+		profile.EnrollmentBypassExpiresAt.Before(time.Now()) ||
+		subtle.ConstantTimeCompare(tokenHash, profile.EnrollmentBypassHash) != 1 {
+		logger.Printf("invalid enrollment bypass for %s", authUser)
+		state.writeFailureResponse(w, r, http.StatusForbidden,
+			"Invalid or expired enrollment link")
+		return
+	}
+	profile.EnrollmentBypassHash = nil
+	profile.EnrollmentBypassExpiresAt = time.Time{}
+	err = state.SaveUserProfile(authUser, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	_, err = state.updateAuthCookieAuthlevel(w, r,
+		currentAuthLevel|AuthTypeEnrollmentBypass)
+	if err != nil {
+		logger.Printf("Auth Cookie NOT found ? %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError,
+			"Failure when updating auth cookie")
+		return
+	}
+	logger.Printf("enrollment bypass used by %s", authUser)
+	eventNotifier.PublishSecurityEvent(
+		eventmon.SecurityEventTypeEnrollmentBypassUsed, authUser, "", "",
+		"")
+	switch getPreferredAcceptType(r) {
+	case "text/html":
+		http.Redirect(w, r, profilePath, 302)
+	default:
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "Success!")
+	}
+}
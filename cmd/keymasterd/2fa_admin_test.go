@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/keymasterd/admincache"
+)
+
+func setupSecondFactorAdminTestState(t *testing.T) (*RuntimeState,
+	*http.Cookie, *http.Cookie, func()) {
+	state, userCookie, cleanup := setupProfileTestState(t)
+	state.isAdminCache = admincache.New(time.Minute)
+	state.Config.Base.AdminUsers = []string{"admin"}
+	state.Config.TOTP.Enabled = true
+	cookieVal, err := state.setNewAuthCookie(nil, "admin",
+		AuthTypePassword|AuthTypeU2F)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return state, userCookie,
+		&http.Cookie{Name: authCookieName, Value: cookieVal}, cleanup
+}
+
+func newSecondFactorAdminRequest(t *testing.T, path string,
+	cookie *http.Cookie, form url.Values) *http.Request {
+	req, err := http.NewRequest("POST", path,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(cookie)
+	return req
+}
+
+func TestResetSecondFactor(t *testing.T) {
+	state, userCookie, adminCookie, cleanup :=
+		setupSecondFactorAdminTestState(t)
+	defer cleanup()
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.U2fAuthData[3] = newTestU2fAuthData(t)
+	profile.TOTPKey = []byte("encrypted key")
+	profile.TOTPLastCounter = 50000000
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	enrollments, err := u2fProvider{}.ListEnrollments(state, "username",
+		profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].ID != "u2f-3" ||
+		enrollments[0].Counter != 7 {
+		t.Fatalf("bad u2f enrollments: %+v", enrollments)
+	}
+	enrollments, err = totpProvider{}.ListEnrollments(state, "username",
+		profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 ||
+		enrollments[0].LastUsedAt.Unix() != 50000000*totpPeriodSecs {
+		t.Fatalf("bad totp enrollments: %+v", enrollments)
+	}
+
+	form := url.Values{"username": {"username"}, "factor": {"TOTP"}}
+	// Users cannot reset, and admins must give a reason.
+	_, err = checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		resetSecondFactorPath, userCookie, form),
+		state.resetSecondFactorHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		resetSecondFactorPath, adminCookie, form),
+		state.resetSecondFactorHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("reason", "lost phone")
+	_, err = checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		resetSecondFactorPath, adminCookie, form),
+		state.resetSecondFactorHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("factor", "U2F")
+	form.Set("id", "u2f-4")
+	_, err = checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		resetSecondFactorPath, adminCookie, form),
+		state.resetSecondFactorHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("id", "u2f-3")
+	_, err = checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		resetSecondFactorPath, adminCookie, form),
+		state.resetSecondFactorHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.TOTPKey) > 0 || len(profile.U2fAuthData) > 0 {
+		t.Fatalf("second factors not reset: %+v", profile)
+	}
+}
+
+func TestEnrollmentBypass(t *testing.T) {
+	state, userCookie, adminCookie, cleanup :=
+		setupSecondFactorAdminTestState(t)
+	defer cleanup()
+	form := url.Values{"username": {"username"}, "reason": {"lost all"}}
+	rr, err := checkRequestHandlerCode(newSecondFactorAdminRequest(t,
+		issueEnrollmentBypassPath, adminCookie, form),
+		state.issueEnrollmentBypassHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response enrollmentBypassResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	bypassURL, err := url.Parse(response.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBypassRequest := func(token string) *http.Request {
+		req, err := http.NewRequest("GET",
+			enrollmentBypassPath+"?token="+url.QueryEscape(token), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(userCookie)
+		return req
+	}
+	token := bypassURL.Query().Get("token")
+	_, err = checkRequestHandlerCode(newBypassRequest("bad"+token),
+		state.enrollmentBypassHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(newBypassRequest(token),
+		state.enrollmentBypassHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bypassCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == authCookieName {
+			bypassCookie = cookie
+		}
+	}
+	if bypassCookie == nil {
+		t.Fatal("auth cookie not updated")
+	}
+	info, err := state.getAuthInfoFromAuthJWT(bypassCookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AuthType&state.getRequiredEnrollmentAuthLevel() == 0 {
+		t.Fatal("bypass does not allow enrollment")
+	}
+	if info.AuthType&(AuthTypeU2F|AuthTypeTOTP) != 0 {
+		t.Fatalf("bypass granted a second factor: %d", info.AuthType)
+	}
+	// The link can only be used once.
+	_, err = checkRequestHandlerCode(newBypassRequest(token),
+		state.enrollmentBypassHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/duo"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+const duoAuthPath = "/api/v0/duoAuth"
+
+// duoProvider is the secondFactorProvider for Duo Security passcodes.
+// Duo pushes are polled through VIPPollCheckHandler.
+type duoProvider struct{}
+
+func (duoProvider) Name() string { return proto.AuthTypeDuo }
+
+func (duoProvider) AuthType() int { return AuthTypeDuo }
+
+func (duoProvider) AuthPath() string { return duoAuthPath }
+
+func (duoProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.Duo.Enabled
+}
+
+// Duo enrollment is kept by Duo, so every user is assumed to have it.
+func (duoProvider) UserEnabled(state *RuntimeState, username string) (bool, error) {
+	return true, nil
+}
+
+func (duoProvider) BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+	r *http.Request, username string) error {
+	return setPushTransactionCookie(w)
+}
+
+func (duoProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	err := r.ParseForm()
+	if err != nil {
+		logger.Println(err)
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing form"}
+	}
+	var passcode string
+	if val, ok := r.Form["OTP"]; ok {
+		if len(val) > 1 {
+			logger.Printf("Login with multiple OTP Values")
+			return false, &secondFactorError{http.StatusBadRequest,
+				"Just one OTP Value allowed"}
+		}
+		passcode = strings.TrimSpace(val[0])
+	}
+	if passcode == "" {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Missing passcode"}
+	}
+	start := time.Now()
+	valid, err := state.Config.Duo.Client.ValidateUserPasscode(username, passcode)
+	if err != nil {
+		return false, err
+	}
+	metricLogExternalServiceDuration("duo", time.Since(start))
+	return valid, nil
+}
+
+func (duoProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeDuo, username)
+}
+
+func (duoProvider) StartPush(state *RuntimeState, r *http.Request,
+	username string, expiresAt time.Time) (string, error) {
+	start := time.Now()
+	transactionID, err := state.Config.Duo.Client.StartUserPush(username)
+	if err != nil {
+		return "", err
+	}
+	metricLogExternalServiceDuration("duo", time.Since(start))
+	return transactionID, nil
+}
+
+func (duoProvider) PushApproved(state *RuntimeState,
+	transaction pushPollTransaction) (bool, error) {
+	approved, err := state.Config.Duo.Client.PushHasBeenApproved(
+		transaction.TransactionID)
+	if err == duo.ErrDenied {
+		return false, &secondFactorError{http.StatusForbidden, "Push denied"}
+	}
+	return approved, err
+}
+
+func (provider duoProvider) PublishPushEvent(username string) {
+	provider.PublishAuthEvent(username)
+}
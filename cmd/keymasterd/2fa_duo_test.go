@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/duo"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// fakeDuoAPI answers like the Duo Auth API without checking signatures,
+// lib/duo tests those. The pushes started are counted in pushes.
+func fakeDuoAPI(pushResult *string, pushes *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		result := map[string]string{}
+		switch {
+		case r.URL.Path == "/auth/v2/auth" && r.Form.Get("factor") == "passcode":
+			result["result"] = "deny"
+			if r.Form.Get("passcode") == "123456" {
+				result["result"] = "allow"
+			}
+		case r.URL.Path == "/auth/v2/auth" && r.Form.Get("factor") == "push":
+			atomic.AddInt32(pushes, 1)
+			result["txid"] = "txid-" + r.Form.Get("username")
+		case r.URL.Path == "/auth/v2/auth_status":
+			result["result"] = *pushResult
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"stat": "OK", "response": result})
+	}
+}
+
+func setupDuoTestState(t *testing.T, pushResult *string,
+	pushes *int32) (*RuntimeState, *http.Cookie, func()) {
+	state, authCookie, cleanup := setupProfileTestState(t)
+	server := httptest.NewServer(fakeDuoAPI(pushResult, pushes))
+	client, err := duo.NewClient("ikey", "skey", "api-test.duosecurity.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = server.URL
+	state.Config.Duo = DuoConfig{Enabled: true, Client: &client}
+	state.vipPushCookie = make(map[string]pushPollTransaction)
+	return state, authCookie, func() {
+		server.Close()
+		cleanup()
+	}
+}
+
+func TestDuoPasscodeAuth(t *testing.T) {
+	pushResult := "waiting"
+	var pushes int32
+	state, authCookie, cleanup := setupDuoTestState(t, &pushResult, &pushes)
+	defer cleanup()
+	handler := state.secondFactorAuthHandler(duoProvider{})
+	newRequest := func(passcode string) *http.Request {
+		form := url.Values{"OTP": {passcode}}
+		req, err := http.NewRequest("POST", duoAuthPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(authCookie)
+		return req
+	}
+	if _, err := checkRequestHandlerCode(newRequest("654321"), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRequestHandlerCode(newRequest(""), handler,
+		http.StatusBadRequest); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(newRequest("123456"), handler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		info, err := state.getAuthInfoFromAuthJWT(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.AuthType&AuthTypeDuo == 0 {
+			t.Fatalf("duo not recorded: %d", info.AuthType)
+		}
+		return
+	}
+	t.Fatal("auth cookie not updated")
+}
+
+func TestDuoPush(t *testing.T) {
+	pushResult := "waiting"
+	var pushes int32
+	state, authCookie, cleanup := setupDuoTestState(t, &pushResult, &pushes)
+	defer cleanup()
+	if provider, ok := state.getPushProvider(); !ok ||
+		provider.Name() != proto.AuthTypeDuo {
+		t.Fatal("duo is not the push provider")
+	}
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	_, err := checkRequestHandlerCode(newPushRequest(vipPushStartPath),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushResult = "deny"
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushResult = "allow"
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuoPushWithVIP(t *testing.T) {
+	pushResult := "allow"
+	var duoPushes, vipCalls int32
+	state, authCookie, cleanup := setupDuoTestState(t, &pushResult,
+		&duoPushes)
+	defer cleanup()
+	defer enableTestVIP(state, &vipCalls)()
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET",
+			path+"?"+pushProviderParam+"="+proto.AuthTypeDuo, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	_, err := checkRequestHandlerCode(newPushRequest(vipPushStartPath),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushes := atomic.LoadInt32(&duoPushes); pushes != 1 {
+		t.Fatalf("%d Duo pushes, expected 1", pushes)
+	}
+	rr, err := checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&vipCalls); calls != 0 {
+		t.Fatalf("%d VIP calls for a Duo push", calls)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		info, err := state.getAuthInfoFromAuthJWT(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.AuthType&AuthTypeDuo == 0 ||
+			info.AuthType&AuthTypeSymantecVIP != 0 {
+			t.Fatalf("bad auth type for a Duo push: %d", info.AuthType)
+		}
+		return
+	}
+	t.Fatal("auth cookie not updated")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/pwauth/okta"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+const oktaAuthPath = "/api/v0/oktaAuth"
+
+// newOktaPasswordAuthenticator creates the Okta password backend. It is
+// kept in the state since the Okta factors are verified with the
+// transaction started by the password authentication.
+func (state *RuntimeState) newOktaPasswordAuthenticator() (
+	*okta.PasswordAuthenticator, error) {
+	authenticator, err := okta.NewPublic(state.Config.Okta.Domain, logger)
+	if err != nil {
+		return nil, err
+	}
+	state.oktaAuthenticator = authenticator
+	return authenticator, nil
+}
+
+func (state *RuntimeState) loadOktaConfig() error {
+	config := state.Config.Okta
+	if config.Enable2FA && state.oktaAuthenticator == nil {
+		return errors.New("okta enable_2fa needs okta as password backend")
+	}
+	if config.APITokenFilename == "" {
+		return nil
+	}
+	if config.Domain == "" {
+		return errors.New("okta api_token_filename needs an okta domain")
+	}
+	apiToken, err := ioutil.ReadFile(config.APITokenFilename)
+	if err != nil {
+		return fmt.Errorf("cannot read okta API token: %s", err)
+	}
+	state.oktaUserInfo, err = okta.NewUserInfo(config.Domain,
+		strings.TrimSpace(string(apiToken)), logger)
+	return err
+}
+
+// oktaProvider is the pushSecondFactorProvider for the Okta Verify push and
+// TOTP factors that Okta requires after the password authentication.
+type oktaProvider struct{}
+
+func (oktaProvider) Name() string { return proto.AuthTypeOkta }
+
+func (oktaProvider) AuthType() int { return AuthTypeOkta }
+
+func (oktaProvider) AuthPath() string { return oktaAuthPath }
+
+func (oktaProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.Okta.Enable2FA && state.oktaAuthenticator != nil
+}
+
+// Only the users for which Okta asked for MFA at their last password
+// authentication can use it.
+func (oktaProvider) UserEnabled(state *RuntimeState, username string) (bool, error) {
+	return state.oktaAuthenticator.UserHasMFA(username), nil
+}
+
+func (oktaProvider) BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+	r *http.Request, username string) error {
+	return setPushTransactionCookie(w)
+}
+
+func (oktaProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	err := r.ParseForm()
+	if err != nil {
+		logger.Println(err)
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing form"}
+	}
+	var otpValue string
+	if val, ok := r.Form["OTP"]; ok {
+		if len(val) > 1 {
+			logger.Printf("Login with multiple OTP Values")
+			return false, &secondFactorError{http.StatusBadRequest,
+				"Just one OTP Value allowed"}
+		}
+		otpValue = strings.TrimSpace(val[0])
+	}
+	if otpValue == "" {
+		return false, &secondFactorError{http.StatusBadRequest, "Missing OTP"}
+	}
+	start := time.Now()
+	valid, err := state.oktaAuthenticator.ValidateUserOTP(username, otpValue)
+	if err != nil {
+		return false, err
+	}
+	metricLogExternalServiceDuration("okta", time.Since(start))
+	return valid, nil
+}
+
+func (oktaProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeOkta, username)
+}
+
+// Okta keeps a single pending transaction per user, so the username is
+// used as the transaction ID.
+func (oktaProvider) StartPush(state *RuntimeState, r *http.Request,
+	username string, expiresAt time.Time) (string, error) {
+	start := time.Now()
+	if err := state.oktaAuthenticator.StartUserPush(username); err != nil {
+		return "", err
+	}
+	metricLogExternalServiceDuration("okta", time.Since(start))
+	return username, nil
+}
+
+func (oktaProvider) PushApproved(state *RuntimeState,
+	transaction pushPollTransaction) (bool, error) {
+	approved, err := state.oktaAuthenticator.UserPushApproved(
+		transaction.Username)
+	switch err {
+	case okta.ErrPushRejected:
+		return false, &secondFactorError{http.StatusForbidden, "Push denied"}
+	case okta.ErrPushTimeout:
+		return false, &secondFactorError{http.StatusPreconditionFailed,
+			"push transaction expired"}
+	}
+	return approved, err
+}
+
+func (provider oktaProvider) PublishPushEvent(username string) {
+	provider.PublishAuthEvent(username)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/pwauth/okta"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// fakeOktaAPI answers like the Okta authn and users APIs for "username".
+func fakeOktaAPI(pushResult *string) http.HandlerFunc {
+	var serverURL string
+	return func(w http.ResponseWriter, r *http.Request) {
+		if serverURL == "" {
+			serverURL = "http://" + r.Host
+		}
+		var request map[string]string
+		if r.Method == "POST" {
+			json.NewDecoder(r.Body).Decode(&request)
+		}
+		factor := func(id, factorType string) map[string]interface{} {
+			return map[string]interface{}{
+				"id": id, "factorType": factorType, "provider": "OKTA",
+				"_links": map[string]interface{}{
+					"verify": map[string]string{
+						"href": serverURL + "/api/v1/authn/factors/" + id +
+							"/verify"}}}
+		}
+		var response interface{}
+		switch {
+		case r.URL.Path == "/api/v1/authn":
+			if request["username"] != "username" ||
+				request["password"] != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			response = map[string]interface{}{
+				"status": "MFA_REQUIRED", "stateToken": "token",
+				"_embedded": map[string]interface{}{
+					"factors": []interface{}{
+						factor("push", "push"),
+						factor("totp", "token:software:totp")}}}
+		case request["stateToken"] != "token":
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case r.URL.Path == "/api/v1/authn/factors/totp/verify":
+			if request["passCode"] != "123456" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			response = map[string]string{"status": "SUCCESS"}
+		case r.URL.Path == "/api/v1/authn/factors/push/verify":
+			if *pushResult == "SUCCESS" {
+				response = map[string]string{"status": "SUCCESS"}
+				break
+			}
+			response = map[string]string{"status": "MFA_CHALLENGE",
+				"factorResult": *pushResult}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func fakeOktaGroupsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "SSWS api-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode([]interface{}{
+		map[string]interface{}{
+			"profile": map[string]string{"name": "group1"}}})
+}
+
+func setupOktaTestState(t *testing.T, pushResult *string) (*RuntimeState,
+	*http.Cookie, func()) {
+	state, authCookie, cleanup := setupProfileTestState(t)
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/api/v1/authn", fakeOktaAPI(pushResult))
+	serveMux.HandleFunc("/api/v1/authn/", fakeOktaAPI(pushResult))
+	serveMux.HandleFunc("/api/v1/users/username/groups", fakeOktaGroupsAPI)
+	server := httptest.NewServer(serveMux)
+	state.Config.Okta = OktaConfig{Domain: server.URL, Enable2FA: true}
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypeOkta}
+	var err error
+	state.passwordChecker, err = state.newOktaPasswordAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.vipPushCookie = make(map[string]pushPollTransaction)
+	valid, err := checkUserPassword("username", "password", state.Config,
+		state.passwordChecker, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Fatal("password rejected")
+	}
+	return state, authCookie, func() {
+		server.Close()
+		cleanup()
+	}
+}
+
+func TestOktaOTPAuth(t *testing.T) {
+	pushResult := "WAITING"
+	state, authCookie, cleanup := setupOktaTestState(t, &pushResult)
+	defer cleanup()
+	certBackends, err := state.getCertBackends("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(certBackends, []string{proto.AuthTypeOkta}) {
+		t.Fatalf("okta not offered: %v", certBackends)
+	}
+	handler := state.secondFactorAuthHandler(oktaProvider{})
+	newRequest := func(otpValue string) *http.Request {
+		form := url.Values{"OTP": {otpValue}}
+		req, err := http.NewRequest("POST", oktaAuthPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(authCookie)
+		return req
+	}
+	if _, err := checkRequestHandlerCode(newRequest("654321"), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(newRequest("123456"), handler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Okta transaction is over once verified.
+	if _, err := checkRequestHandlerCode(newRequest("123456"), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		info, err := state.getAuthInfoFromAuthJWT(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.AuthType&AuthTypeOkta == 0 {
+			t.Fatalf("okta not recorded: %d", info.AuthType)
+		}
+		return
+	}
+	t.Fatal("auth cookie not updated")
+}
+
+func TestOktaPush(t *testing.T) {
+	pushResult := "WAITING"
+	state, authCookie, cleanup := setupOktaTestState(t, &pushResult)
+	defer cleanup()
+	if provider, ok := state.getPushProvider(); !ok ||
+		provider.Name() != proto.AuthTypeOkta {
+		t.Fatal("okta is not the push provider")
+	}
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	_, err := checkRequestHandlerCode(newPushRequest(vipPushStartPath),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushResult = "SUCCESS"
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOktaPushWithVIP(t *testing.T) {
+	pushResult := "SUCCESS"
+	state, authCookie, cleanup := setupOktaTestState(t, &pushResult)
+	defer cleanup()
+	var vipCalls int32
+	defer enableTestVIP(state, &vipCalls)()
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET",
+			path+"?"+pushProviderParam+"="+proto.AuthTypeOkta, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	_, err := checkRequestHandlerCode(newPushRequest(vipPushStartPath),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&vipCalls); calls != 0 {
+		t.Fatalf("%d VIP calls for an Okta Verify push", calls)
+	}
+}
+
+func TestOktaUserGroups(t *testing.T) {
+	pushResult := "WAITING"
+	state, _, cleanup := setupOktaTestState(t, &pushResult)
+	defer cleanup()
+	userInfo, err := okta.NewUserInfo(state.Config.Okta.Domain, "api-token",
+		logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.oktaUserInfo = userInfo
+	groups, err := state.getUserGroups("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"group1"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+}
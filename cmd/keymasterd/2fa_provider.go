@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// secondFactorProvider is implemented by every second factor that can
+// upgrade a password authenticated session. The login, certgen and audit
+// code only know about factors through secondFactorProviders.
+type secondFactorProvider interface {
+	// Name returns the name of the factor used in the configuration,
+	// in metrics and in proto.LoginResponse.CertAuthBackend.
+	Name() string
+	// AuthType returns the AuthType bit granted once the factor is verified.
+	AuthType() int
+	// AuthPath returns the path where the response to a challenge is
+	// posted, or "" if the factor is only verified out of band.
+	AuthPath() string
+	// Enabled reports whether the factor is configured on this server.
+	Enabled(state *RuntimeState) bool
+	// UserEnabled reports whether username can currently use the factor.
+	UserEnabled(state *RuntimeState, username string) (bool, error)
+	// BeginChallenge is called after a successful password login, before
+	// the login response is written. It may set cookies on w.
+	BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+		r *http.Request, username string) error
+	// Verify checks the challenge response in r for username. Errors of
+	// type *secondFactorError are sent to the client as is.
+	Verify(state *RuntimeState, r *http.Request, username string) (bool, error)
+	// PublishAuthEvent publishes the event for a successful verification.
+	PublishAuthEvent(username string)
+}
+
+// pushSecondFactorProvider is implemented by the providers that approve
+// logins out of band. Their transactions are started and polled through
+// vipPushStartPath and vipPollCheckPath.
+type pushSecondFactorProvider interface {
+	secondFactorProvider
+	// StartPush asks username to approve the login made with r and returns
+	// the ID of the new transaction.
+	StartPush(state *RuntimeState, r *http.Request, username string,
+		expiresAt time.Time) (string, error)
+	// PushApproved reports whether transaction has been approved.
+	PushApproved(state *RuntimeState, transaction pushPollTransaction) (bool, error)
+	// PublishPushEvent publishes the event for an approved push.
+	PublishPushEvent(username string)
+}
+
+// managedSecondFactorProvider is implemented by the providers whose
+// enrollments admins can list and reset from secondFactorAdminPath.
+type managedSecondFactorProvider interface {
+	secondFactorProvider
+	// ListEnrollments returns the enrollments of username, whose stored
+	// profile is profile.
+	ListEnrollments(state *RuntimeState, username string,
+		profile *userProfile) ([]secondFactorEnrollment, error)
+	// ResetEnrollment removes the enrollment id of username, or all of
+	// them if id is "". Changes to profile are saved by the caller.
+	ResetEnrollment(state *RuntimeState, username string,
+		profile *userProfile, id string) error
+}
+
+// secondFactorEnrollment describes an enrolled device or credential.
+// Zero times are unknown.
+type secondFactorEnrollment struct {
+	ID         string
+	Name       string
+	Enabled    bool
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	Counter    uint64
+}
+
+// secondFactorProviders lists the second factors in order of preference.
+var secondFactorProviders = []secondFactorProvider{
+	u2fProvider{},
+	totpProvider{},
+	vipProvider{},
+	duoProvider{},
+	pushApprovalProvider{},
+	oktaProvider{},
+	radiusProvider{},
+}
+
+// secondFactorError is returned by secondFactorProvider.Verify when the
+// request must be rejected with a status other than 500.
+type secondFactorError struct {
+	statusCode int
+	message    string
+}
+
+func (err *secondFactorError) Error() string {
+	return err.message
+}
+
+func getSecondFactorProvider(name string) (secondFactorProvider, bool) {
+	for _, provider := range secondFactorProviders {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+// getPushProvider returns the preferred enabled push provider.
+func (state *RuntimeState) getPushProvider() (pushSecondFactorProvider, bool) {
+	for _, provider := range secondFactorProviders {
+		pushProvider, ok := provider.(pushSecondFactorProvider)
+		if ok && pushProvider.Enabled(state) {
+			return pushProvider, true
+		}
+	}
+	return nil, false
+}
+
+// pushProviderParam is the parameter of the push start and poll requests
+// naming the push provider chosen by the client.
+const pushProviderParam = "provider"
+
+// getRequestPushProvider returns the enabled push provider named by the
+// pushProviderParam of r. Clients which do not send it get the preferred
+// enabled push provider.
+func (state *RuntimeState) getRequestPushProvider(r *http.Request) (
+	pushSecondFactorProvider, bool) {
+	name := r.FormValue(pushProviderParam)
+	if name == "" {
+		return state.getPushProvider()
+	}
+	provider, ok := getSecondFactorProvider(name)
+	if !ok || !provider.Enabled(state) {
+		return nil, false
+	}
+	pushProvider, ok := provider.(pushSecondFactorProvider)
+	return pushProvider, ok
+}
+
+// getCertBackends returns the backends username may use to get certs, as
+// sent in proto.LoginResponse.CertAuthBackend.
+func (state *RuntimeState) getCertBackends(username string) ([]string, error) {
+	var certBackends []string
+	for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
+		if certPref == proto.AuthTypePassword {
+			certBackends = append(certBackends, proto.AuthTypePassword)
+			continue
+		}
+		provider, ok := getSecondFactorProvider(certPref)
+		if !ok || !provider.Enabled(state) {
+			continue
+		}
+		userEnabled, err := provider.UserEnabled(state, username)
+		if err != nil {
+			return nil, err
+		}
+		if userEnabled {
+			certBackends = append(certBackends, certPref)
+		}
+	}
+	if len(certBackends) == 0 {
+		certBackends = append(certBackends, proto.AuthTypeU2F)
+	}
+	return certBackends, nil
+}
+
+// beginSecondFactorChallenges starts the challenges of all the enabled
+// factors that can be used for certs or for the web UI.
+func (state *RuntimeState) beginSecondFactorChallenges(w http.ResponseWriter,
+	r *http.Request, username string) error {
+	requiredWebAuth := state.getRequiredWebUIAuthLevel()
+	for _, provider := range secondFactorProviders {
+		if !provider.Enabled(state) {
+			continue
+		}
+		used := (requiredWebAuth & provider.AuthType()) != 0
+		for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
+			if certPref == provider.Name() {
+				used = true
+			}
+		}
+		if !used {
+			continue
+		}
+		err := provider.BeginChallenge(state, w, r, username)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secondFactorAuthSucceeded upgrades the auth cookie of username once
+// provider has verified the user.
+func (state *RuntimeState) secondFactorAuthSucceeded(w http.ResponseWriter,
+	r *http.Request, provider secondFactorProvider, username string,
+	currentAuthLevel int) error {
+	logger.Debugf(1, "Successful %s auth for user: %s", provider.Name(), username)
+	provider.PublishAuthEvent(username)
+	_, err := state.updateAuthCookieAuthlevel(w, r,
+		currentAuthLevel|provider.AuthType())
+	return err
+}
+
+// secondFactorAuthHandler returns the handler for provider.AuthPath().
+func (state *RuntimeState) secondFactorAuthHandler(
+	provider secondFactorProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state.sendFailureToClientIfLocked(w, r) {
+			return
+		}
+		if r.Method != "GET" && r.Method != "POST" {
+			state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+		if err != nil {
+			logger.Debugf(1, "%v", err)
+			return
+		}
+		w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+		if !provider.Enabled(state) {
+			state.writeFailureResponse(w, r, http.StatusNotFound,
+				provider.Name()+" is not enabled")
+			return
+		}
+		valid, err := provider.Verify(state, r, authUser)
+		if err != nil {
+			if factorErr, ok := err.(*secondFactorError); ok {
+				logger.Debugf(1, "%s auth for %s: %s", provider.Name(),
+					authUser, factorErr)
+				state.writeFailureResponse(w, r, factorErr.statusCode,
+					factorErr.message)
+				return
+			}
+			logger.Printf("%s verification error: %v", provider.Name(), err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		metricLogAuthOperation(getClientType(r), provider.Name(), valid)
+		if !valid {
+			logger.Printf("Invalid %s login for %s", provider.Name(), authUser)
+			state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+			return
+		}
+		err = state.secondFactorAuthSucceeded(w, r, provider, authUser,
+			currentAuthLevel)
+		if err != nil {
+			logger.Printf("Auth Cookie NOT found ? %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError,
+				"Failure when updating auth cookie")
+			return
+		}
+		returnAcceptType := getPreferredAcceptType(r)
+		switch returnAcceptType {
+		case "text/html":
+			http.Redirect(w, r, getLoginDestination(r), 302)
+		default:
+			w.WriteHeader(200)
+			json.NewEncoder(w).Encode(proto.LoginResponse{Message: "success"})
+		}
+		// Browser logins, from a form or from the web UI scripts.
+		_, isXHR := r.Header["X-Requested-With"]
+		if isXHR || returnAcceptType == "text/html" {
+			eventNotifier.PublishWebLoginEvent(authUser)
+		}
+	}
+}
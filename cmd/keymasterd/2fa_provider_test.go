@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestGetCertBackends(t *testing.T) {
+	state, _, cleanup := setupTOTPTestState(t)
+	defer cleanup()
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypeSymantecVIP, proto.AuthTypeTOTP, proto.AuthTypeU2F}
+	backends, err := state.getCertBackends("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No factor is usable, so U2F is asked for.
+	if !reflect.DeepEqual(backends, []string{proto.AuthTypeU2F}) {
+		t.Fatalf("bad backends: %v", backends)
+	}
+
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: newTestU2fAuthData(t)},
+		WebauthnData: map[int64]*webauthnAuthData{},
+		TOTPKey:      []byte("key"),
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.SymantecVIP.Enabled = true
+	state.Config.Base.AllowedAuthBackendsForCerts = append(
+		state.Config.Base.AllowedAuthBackendsForCerts, proto.AuthTypePassword)
+	backends, err = state.getCertBackends("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{proto.AuthTypeSymantecVIP, proto.AuthTypeTOTP,
+		proto.AuthTypeU2F, proto.AuthTypePassword}
+	if !reflect.DeepEqual(backends, expected) {
+		t.Fatalf("bad backends: %v", backends)
+	}
+}
+
+func TestGetRequiredWebUIAuthLevel(t *testing.T) {
+	state := &RuntimeState{}
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{
+		proto.AuthTypeU2F, proto.AuthTypeTOTP, "unknown"}
+	authLevel := state.getRequiredWebUIAuthLevel()
+	if authLevel != AuthTypeU2F|AuthTypeTOTP {
+		t.Fatalf("bad auth level: %d", authLevel)
+	}
+	for _, provider := range secondFactorProviders {
+		found, ok := getSecondFactorProvider(provider.Name())
+		if !ok || found.AuthType() != provider.AuthType() {
+			t.Fatalf("provider %s not found", provider.Name())
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+// PushApprovalConfig configures the generic push approval factor. The
+// approval requests are posted to RequestURL and the approver posts its
+// decision back to CallbackBaseURL + pushApprovalCallbackPath. Both are
+// signed with the shared secret.
+type PushApprovalConfig struct {
+	Enabled              bool   `yaml:"enabled"`
+	RequestURL           string `yaml:"request_url"`
+	CallbackBaseURL      string `yaml:"callback_base_url"`
+	SharedSecretFilename string `yaml:"shared_secret_filename"`
+}
+
+const (
+	pushApprovalCallbackPath      = "/api/v0/pushApprovalCallback"
+	pushApprovalSignatureHeader   = "X-Keymaster-Signature"
+	pushApprovalTimestampHeader   = "X-Keymaster-Timestamp"
+	pushApprovalMinSecretLength   = 32
+	pushApprovalMaxClockSkewSecs  = 300
+	pushApprovalMaxCallbackLength = 1 << 16
+	pushApprovalRequestTimeout    = 10 * time.Second
+	pushApprovalDecisionApprove   = "approve"
+	pushApprovalDecisionDeny      = "deny"
+)
+
+var pushApprovalHTTPClient = &http.Client{Timeout: pushApprovalRequestTimeout}
+
+// pushApprovalRequest is posted to the approver.
+type pushApprovalRequest struct {
+	TransactionID string    `json:"transaction_id"`
+	Username      string    `json:"username"`
+	RemoteAddr    string    `json:"remote_addr"`
+	UserAgent     string    `json:"user_agent"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	CallbackURL   string    `json:"callback_url"`
+}
+
+// pushApprovalCallback is posted back by the approver.
+type pushApprovalCallback struct {
+	TransactionID string `json:"transaction_id"`
+	Decision      string `json:"decision"`
+}
+
+type pushApprovalState struct {
+	ExpiresAt time.Time
+	Username  string
+	Decided   bool
+	Approved  bool
+}
+
+func (state *RuntimeState) loadPushApprovalConfig() error {
+	config := state.Config.PushApproval
+	if !config.Enabled {
+		return nil
+	}
+	requestURL, err := url.Parse(config.RequestURL)
+	if err != nil {
+		return fmt.Errorf("invalid push_approval request_url: %s", err)
+	}
+	if requestURL.Scheme != "https" && requestURL.Scheme != "http" {
+		return errors.New("push_approval request_url must be an http(s) URL")
+	}
+	if config.CallbackBaseURL != "" {
+		if _, err := url.Parse(config.CallbackBaseURL); err != nil {
+			return fmt.Errorf("invalid push_approval callback_base_url: %s", err)
+		}
+	}
+	secret, err := ioutil.ReadFile(config.SharedSecretFilename)
+	if err != nil {
+		return fmt.Errorf("cannot read push approval shared secret: %s", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < pushApprovalMinSecretLength {
+		return fmt.Errorf("push approval shared secret must be at least %d bytes",
+			pushApprovalMinSecretLength)
+	}
+	state.pushApprovalSecret = secret
+	return nil
+}
+
+func (state *RuntimeState) pushApprovalSignature(timestamp string,
+	body []byte) string {
+	mac := hmac.New(sha256.New, state.pushApprovalSecret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkPushApprovalSignature verifies the signature headers of r for body.
+func (state *RuntimeState) checkPushApprovalSignature(r *http.Request,
+	body []byte, now time.Time) error {
+	timestamp := r.Header.Get(pushApprovalTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+	skew := now.Unix() - seconds
+	if skew > pushApprovalMaxClockSkewSecs || skew < -pushApprovalMaxClockSkewSecs {
+		return errors.New("timestamp out of range")
+	}
+	expected := state.pushApprovalSignature(timestamp, body)
+	signature := r.Header.Get(pushApprovalSignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+func (state *RuntimeState) pushApprovalCallbackURL() string {
+	baseURL := state.Config.PushApproval.CallbackBaseURL
+	if baseURL == "" {
+		baseURL = u2fAppID
+	}
+	return strings.TrimSuffix(baseURL, "/") + pushApprovalCallbackPath
+}
+
+func (state *RuntimeState) sendPushApprovalRequest(
+	approvalRequest pushApprovalRequest) error {
+	body, err := json.Marshal(approvalRequest)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", state.Config.PushApproval.RequestURL,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(pushApprovalTimestampHeader, timestamp)
+	req.Header.Set(pushApprovalSignatureHeader,
+		state.pushApprovalSignature(timestamp, body))
+	start := time.Now()
+	resp, err := pushApprovalHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	metricLogExternalServiceDuration("push_approval", time.Since(start))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push approval request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// pushApprovalProvider is the pushSecondFactorProvider for the approval
+// webhook.
+type pushApprovalProvider struct{}
+
+func (pushApprovalProvider) Name() string { return proto.AuthTypePushApproval }
+
+func (pushApprovalProvider) AuthType() int { return AuthTypePushApproval }
+
+func (pushApprovalProvider) AuthPath() string { return "" }
+
+func (pushApprovalProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.PushApproval.Enabled
+}
+
+// The approver decides who can approve, so every user is offered the push.
+func (pushApprovalProvider) UserEnabled(state *RuntimeState,
+	username string) (bool, error) {
+	return true, nil
+}
+
+func (pushApprovalProvider) BeginChallenge(state *RuntimeState,
+	w http.ResponseWriter, r *http.Request, username string) error {
+	return setPushTransactionCookie(w)
+}
+
+func (pushApprovalProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	return false, &secondFactorError{http.StatusNotFound,
+		"push approvals are polled"}
+}
+
+func (pushApprovalProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypePushApproval, username)
+}
+
+// StartPush records the transaction before sending it, so that a quick
+// callback always finds it.
+func (pushApprovalProvider) StartPush(state *RuntimeState, r *http.Request,
+	username string, expiresAt time.Time) (string, error) {
+	transactionID, err := genRandomString()
+	if err != nil {
+		return "", err
+	}
+	state.Mutex.Lock()
+	state.pushApprovals[transactionID] = pushApprovalState{
+		ExpiresAt: expiresAt,
+		Username:  username,
+	}
+	state.Mutex.Unlock()
+	err = state.sendPushApprovalRequest(pushApprovalRequest{
+		TransactionID: transactionID,
+		Username:      username,
+		RemoteAddr:    r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+		ExpiresAt:     expiresAt,
+		CallbackURL:   state.pushApprovalCallbackURL(),
+	})
+	if err != nil {
+		state.Mutex.Lock()
+		delete(state.pushApprovals, transactionID)
+		state.Mutex.Unlock()
+		return "", err
+	}
+	return transactionID, nil
+}
+
+func (pushApprovalProvider) PushApproved(state *RuntimeState,
+	transaction pushPollTransaction) (bool, error) {
+	state.Mutex.Lock()
+	approval, ok := state.pushApprovals[transaction.TransactionID]
+	state.Mutex.Unlock()
+	if !ok || approval.ExpiresAt.Before(time.Now()) {
+		return false, &secondFactorError{http.StatusPreconditionFailed,
+			"push transaction expired"}
+	}
+	if approval.Decided && !approval.Approved {
+		return false, &secondFactorError{http.StatusForbidden, "Push denied"}
+	}
+	return approval.Approved, nil
+}
+
+func (provider pushApprovalProvider) PublishPushEvent(username string) {
+	provider.PublishAuthEvent(username)
+}
+
+func (state *RuntimeState) pushApprovalCallbackHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.Config.PushApproval.Enabled {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"push approval is not enabled")
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body,
+		pushApprovalMaxCallbackLength))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
+		return
+	}
+	if err := state.checkPushApprovalSignature(r, body, time.Now()); err != nil {
+		logger.Printf("push approval callback from %s rejected: %s",
+			r.RemoteAddr, err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	var callback pushApprovalCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"invalid callback")
+		return
+	}
+	if callback.Decision != pushApprovalDecisionApprove &&
+		callback.Decision != pushApprovalDecisionDeny {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"decision must be approve or deny")
+		return
+	}
+	decided := false
+	state.Mutex.Lock()
+	approval, ok := state.pushApprovals[callback.TransactionID]
+	if ok && !approval.Decided && approval.ExpiresAt.After(time.Now()) {
+		approval.Decided = true
+		approval.Approved = callback.Decision == pushApprovalDecisionApprove
+		state.pushApprovals[callback.TransactionID] = approval
+		decided = true
+	}
+	state.Mutex.Unlock()
+	if !ok {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"unknown transaction")
+		return
+	}
+	if !decided {
+		state.writeFailureResponse(w, r, http.StatusConflict,
+			"transaction already decided or expired")
+		return
+	}
+	logger.Printf("push approval for %s: %s", approval.Username,
+		callback.Decision)
+	w.WriteHeader(http.StatusOK)
+}
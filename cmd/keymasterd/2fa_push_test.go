@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/vip"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const testPushApprovalSecret = "0123456789abcdef0123456789abcdef"
+
+func setupPushApprovalTestState(t *testing.T,
+	approverURL string) (*RuntimeState, *http.Cookie, func()) {
+	state, authCookie, cleanup := setupProfileTestState(t)
+	state.vipPushCookie = make(map[string]pushPollTransaction)
+	state.pushApprovals = make(map[string]pushApprovalState)
+	secretFile, err := ioutil.TempFile("", "push.secret")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	defer os.Remove(secretFile.Name())
+	secretFile.WriteString(testPushApprovalSecret + "\n")
+	secretFile.Close()
+	state.Config.PushApproval = PushApprovalConfig{Enabled: true,
+		RequestURL:           approverURL,
+		CallbackBaseURL:      "https://keymaster.example.com/",
+		SharedSecretFilename: secretFile.Name()}
+	if err := state.loadPushApprovalConfig(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return state, authCookie, cleanup
+}
+
+func newPushApprovalCallbackRequest(t *testing.T, state *RuntimeState,
+	transactionID string, decision string, now time.Time) *http.Request {
+	body, err := json.Marshal(pushApprovalCallback{
+		TransactionID: transactionID, Decision: decision})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", pushApprovalCallbackPath,
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(pushApprovalTimestampHeader, timestamp)
+	req.Header.Set(pushApprovalSignatureHeader,
+		state.pushApprovalSignature(timestamp, body))
+	return req
+}
+
+func TestCheckPushApprovalSignature(t *testing.T) {
+	state, _, cleanup := setupPushApprovalTestState(t, "http://localhost/")
+	defer cleanup()
+	now := time.Now()
+	req := newPushApprovalCallbackRequest(t, state, "id", "approve", now)
+	body, _ := ioutil.ReadAll(req.Body)
+	if err := state.checkPushApprovalSignature(req, body, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkPushApprovalSignature(req, body,
+		now.Add(time.Hour)); err == nil {
+		t.Fatal("stale timestamp accepted")
+	}
+	body[0] = ' '
+	if err := state.checkPushApprovalSignature(req, body, now); err == nil {
+		t.Fatal("modified body accepted")
+	}
+}
+
+func TestPushApprovalFlow(t *testing.T) {
+	requests := make(chan pushApprovalRequest, 2)
+	var state *RuntimeState
+	approver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := state.checkPushApprovalSignature(r, body,
+				time.Now()); err != nil {
+				t.Errorf("bad approval request signature: %s", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var approvalRequest pushApprovalRequest
+			if err := json.Unmarshal(body, &approvalRequest); err != nil {
+				t.Error(err)
+			}
+			requests <- approvalRequest
+		}))
+	defer approver.Close()
+	state, authCookie, cleanup := setupPushApprovalTestState(t, approver.URL)
+	defer cleanup()
+
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string) *http.Request {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	_, err := checkRequestHandlerCode(newPushRequest(vipPushStartPath),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvalRequest := <-requests
+	if approvalRequest.Username != "username" ||
+		approvalRequest.CallbackURL !=
+			"https://keymaster.example.com"+pushApprovalCallbackPath {
+		t.Fatalf("bad approval request: %+v", approvalRequest)
+	}
+	_, err = checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsigned callbacks are ignored.
+	req := newPushApprovalCallbackRequest(t, state,
+		approvalRequest.TransactionID, "approve", time.Now())
+	req.Header.Set(pushApprovalSignatureHeader, "sha256=00")
+	_, err = checkRequestHandlerCode(req, state.pushApprovalCallbackHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = newPushApprovalCallbackRequest(t, state,
+		approvalRequest.TransactionID, "approve", time.Now())
+	_, err = checkRequestHandlerCode(req, state.pushApprovalCallbackHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = newPushApprovalCallbackRequest(t, state,
+		approvalRequest.TransactionID, "deny", time.Now())
+	_, err = checkRequestHandlerCode(req, state.pushApprovalCallbackHandler,
+		http.StatusConflict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := checkRequestHandlerCode(newPushRequest(vipPollCheckPath),
+		state.VIPPollCheckHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newAuthCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == authCookieName {
+			newAuthCookie = cookie
+		}
+	}
+	if newAuthCookie == nil {
+		t.Fatal("auth cookie not updated")
+	}
+	info, err := state.getAuthInfoFromAuthJWT(newAuthCookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AuthType&AuthTypePushApproval == 0 {
+		t.Fatalf("push approval not recorded: %d", info.AuthType)
+	}
+}
+
+func TestPushApprovalDenied(t *testing.T) {
+	state, _, cleanup := setupPushApprovalTestState(t, "http://localhost/")
+	defer cleanup()
+	expiresAt := time.Now().Add(time.Minute)
+	state.pushApprovals["id"] = pushApprovalState{ExpiresAt: expiresAt,
+		Username: "username"}
+	req := newPushApprovalCallbackRequest(t, state, "id", "deny", time.Now())
+	_, err := checkRequestHandlerCode(req, state.pushApprovalCallbackHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved, err := pushApprovalProvider{}.PushApproved(state,
+		pushPollTransaction{TransactionID: "id", Username: "username",
+			ExpiresAt: expiresAt})
+	if approved {
+		t.Fatal("denied push approved")
+	}
+	if factorErr, ok := err.(*secondFactorError); !ok ||
+		factorErr.statusCode != http.StatusForbidden {
+		t.Fatalf("expected forbidden, got %v", err)
+	}
+	req = newPushApprovalCallbackRequest(t, state, "unknown", "approve",
+		time.Now())
+	_, err = checkRequestHandlerCode(req, state.pushApprovalCallbackHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enableTestVIP enables VIP with a client whose requests are counted in
+// calls and fail. It returns the function stopping the fake VIP server.
+func enableTestVIP(state *RuntimeState, calls *int32) func() {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	state.Config.SymantecVIP = SymantecVIPConfig{Enabled: true,
+		Client: &vip.Client{
+			VipUserServicesURL:              server.URL,
+			VipUserServiceAuthenticationURL: server.URL,
+			VipUserServiceManagementURL:     server.URL,
+		}}
+	return server.Close
+}
+
+func TestPushStartProvider(t *testing.T) {
+	requests := make(chan pushApprovalRequest, 2)
+	approver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var approvalRequest pushApprovalRequest
+			json.NewDecoder(r.Body).Decode(&approvalRequest)
+			requests <- approvalRequest
+		}))
+	defer approver.Close()
+	state, authCookie, cleanup := setupPushApprovalTestState(t, approver.URL)
+	defer cleanup()
+	var vipCalls int32
+	defer enableTestVIP(state, &vipCalls)()
+	if provider, ok := state.getPushProvider(); !ok ||
+		provider.Name() != proto.AuthTypeSymantecVIP {
+		t.Fatal("VIP is not the preferred push provider")
+	}
+
+	pushCookie := &http.Cookie{Name: vipTransactionCookieName, Value: "push"}
+	newPushRequest := func(path string, provider string) *http.Request {
+		req, err := http.NewRequest("GET", path+"?"+pushProviderParam+"="+
+			provider, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(authCookie)
+		req.AddCookie(pushCookie)
+		return req
+	}
+	// U2F is not a push factor.
+	_, err := checkRequestHandlerCode(
+		newPushRequest(vipPushStartPath, proto.AuthTypeU2F),
+		state.vipPushStartHandler, http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(
+		newPushRequest(vipPushStartPath, proto.AuthTypePushApproval),
+		state.vipPushStartHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approvalRequest := <-requests; approvalRequest.Username != "username" {
+		t.Fatalf("bad approval request: %+v", approvalRequest)
+	}
+	if calls := atomic.LoadInt32(&vipCalls); calls != 0 {
+		t.Fatalf("%d VIP calls for a push approval", calls)
+	}
+	transaction, ok := state.getPushPollTransaction(pushCookie.Value)
+	if !ok || transaction.Provider != proto.AuthTypePushApproval {
+		t.Fatalf("bad push transaction: %+v", transaction)
+	}
+	// The transaction is only polled for its own provider.
+	_, err = checkRequestHandlerCode(
+		newPushRequest(vipPollCheckPath, proto.AuthTypeSymantecVIP),
+		state.VIPPollCheckHandler, http.StatusPreconditionFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&vipCalls); calls != 0 {
+		t.Fatalf("%d VIP calls for a push approval", calls)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/pwauth/radius"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/eventmon"
+)
+
+const radiusAuthPath = "/api/v0/radiusAuth"
+
+// newRadiusPasswordAuthenticator creates the RADIUS password backend. It is
+// kept in the state since the RADIUS challenges are answered to the
+// authenticator that received them.
+func (state *RuntimeState) newRadiusPasswordAuthenticator() (
+	*radius.PasswordAuthenticator, error) {
+	config := state.Config.Radius
+	secret, err := ioutil.ReadFile(config.SharedSecretFilename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read radius shared secret: %s", err)
+	}
+	authenticator, err := radius.New(config.Servers, bytes.TrimSpace(secret),
+		config.Method, config.TimeoutSecs, config.Enable2FA, logger)
+	if err != nil {
+		return nil, err
+	}
+	state.radiusAuthenticator = authenticator
+	return authenticator, nil
+}
+
+// radiusProvider is the secondFactorProvider answering the Access-Challenge
+// of the RADIUS password backend.
+type radiusProvider struct{}
+
+func (radiusProvider) Name() string { return proto.AuthTypeRADIUS }
+
+func (radiusProvider) AuthType() int { return AuthTypeRADIUS }
+
+func (radiusProvider) AuthPath() string { return radiusAuthPath }
+
+func (radiusProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.Radius.Enable2FA && state.radiusAuthenticator != nil
+}
+
+// Only the users challenged at their last password authentication can use
+// it.
+func (radiusProvider) UserEnabled(state *RuntimeState,
+	username string) (bool, error) {
+	return state.radiusAuthenticator.UserHasChallenge(username), nil
+}
+
+func (radiusProvider) BeginChallenge(state *RuntimeState,
+	w http.ResponseWriter, r *http.Request, username string) error {
+	return nil
+}
+
+func (radiusProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	err := r.ParseForm()
+	if err != nil {
+		logger.Println(err)
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing form"}
+	}
+	var challengeResponse string
+	if val, ok := r.Form["OTP"]; ok {
+		if len(val) > 1 {
+			logger.Printf("Login with multiple OTP Values")
+			return false, &secondFactorError{http.StatusBadRequest,
+				"Just one OTP Value allowed"}
+		}
+		challengeResponse = strings.TrimSpace(val[0])
+	}
+	if challengeResponse == "" {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Missing challenge response"}
+	}
+	start := time.Now()
+	valid, err := state.radiusAuthenticator.ValidateChallengeResponse(
+		username, challengeResponse)
+	if err != nil {
+		return false, err
+	}
+	metricLogExternalServiceDuration("radius", time.Since(start))
+	return valid, nil
+}
+
+func (radiusProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeRADIUS, username)
+}
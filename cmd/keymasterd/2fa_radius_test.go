@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// fakeRadiusServer challenges "username" for 123456 after "password".
+func fakeRadiusServer(w radius.ResponseWriter, r *radius.Request) {
+	password := rfc2865.UserPassword_GetString(r.Packet)
+	switch {
+	case rfc2865.UserName_GetString(r.Packet) != "username":
+	case rfc2865.State_GetString(r.Packet) == "state" && password == "123456":
+		w.Write(r.Response(radius.CodeAccessAccept))
+		return
+	case rfc2865.State_GetString(r.Packet) == "" && password == "password":
+		response := r.Response(radius.CodeAccessChallenge)
+		rfc2865.State_SetString(response, "state")
+		w.Write(response)
+		return
+	}
+	w.Write(r.Response(radius.CodeAccessReject))
+}
+
+func TestRadiusChallengeAuth(t *testing.T) {
+	state, authCookie, cleanup := setupProfileTestState(t)
+	defer cleanup()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := &radius.PacketServer{
+		Handler:      radius.HandlerFunc(fakeRadiusServer),
+		SecretSource: radius.StaticSecretSource([]byte("secret")),
+	}
+	go server.Serve(conn)
+	secretFilename := filepath.Join(state.Config.Base.DataDirectory, "secret")
+	if err := ioutil.WriteFile(secretFilename, []byte("secret\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Radius = RadiusConfig{
+		Servers:              []string{conn.LocalAddr().String()},
+		SharedSecretFilename: secretFilename,
+		Enable2FA:            true,
+	}
+	state.Config.Base.AllowedAuthBackendsForCerts = []string{
+		proto.AuthTypeRADIUS}
+	state.passwordChecker, err = state.newRadiusPasswordAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func() {
+		valid, err := checkUserPassword("username", "password", state.Config,
+			state.passwordChecker, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Fatal("password rejected")
+		}
+	}
+	login()
+	certBackends, err := state.getCertBackends("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(certBackends, []string{proto.AuthTypeRADIUS}) {
+		t.Fatalf("radius not offered: %v", certBackends)
+	}
+	handler := state.secondFactorAuthHandler(radiusProvider{})
+	newRequest := func(challengeResponse string) *http.Request {
+		form := url.Values{"OTP": {challengeResponse}}
+		req, err := http.NewRequest("POST", radiusAuthPath,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(authCookie)
+		return req
+	}
+	if _, err := checkRequestHandlerCode(newRequest("654321"), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	// The challenge is gone once rejected.
+	if _, err := checkRequestHandlerCode(newRequest("123456"), handler,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+	login()
+	rr, err := checkRequestHandlerCode(newRequest("123456"), handler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		info, err := state.getAuthInfoFromAuthJWT(cookie.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.AuthType&AuthTypeRADIUS == 0 {
+			t.Fatalf("radius not recorded: %d", info.AuthType)
+		}
+		return
+	}
+	t.Fatal("auth cookie not updated")
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/Symantec/keymaster/proto/eventmon"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// TOTPConfig enables RFC 6238 TOTP as a second factor. The secrets of the
+// users are encrypted in their profiles with the key in
+// EncryptionKeyFilename, which holds 32 base64 encoded random bytes and
+// must be the same on all the replicas.
+type TOTPConfig struct {
+	Enabled               bool   `yaml:"enabled"`
+	Issuer                string `yaml:"issuer"`
+	EncryptionKeyFilename string `yaml:"encryption_key_filename"`
+}
+
+const (
+	totpAuthPath           = "/api/v0/totpAuth"
+	totpEnrollPath         = "/api/v0/totpEnroll"
+	totpPeriodSecs         = 30
+	totpSkewPeriods        = 1
+	totpRecoveryCodeCount  = 10
+	totpRecoveryCodeLength = 10
+	totpQRCodeSize         = 200
+)
+
+var totpCodeRegexp = regexp.MustCompile("^[0-9]{6}$")
+
+func (state *RuntimeState) loadTOTPEncryptionKey() error {
+	if !state.Config.TOTP.Enabled {
+		return nil
+	}
+	if state.Config.TOTP.EncryptionKeyFilename == "" {
+		return errors.New("totp requires encryption_key_filename")
+	}
+	fileBytes, err := ioutil.ReadFile(state.Config.TOTP.EncryptionKeyFilename)
+	if err != nil {
+		return err
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(
+		strings.TrimSpace(string(fileBytes)))
+	if err != nil {
+		return fmt.Errorf("bad totp encryption key: %s", err)
+	}
+	var key [32]byte
+	if len(keyBytes) != len(key) {
+		return fmt.Errorf("totp encryption key must be %d bytes", len(key))
+	}
+	copy(key[:], keyBytes)
+	state.totpEncryptionKey = &key
+	return nil
+}
+
+func (state *RuntimeState) encryptTOTPKey(key *otp.Key) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], []byte(key.String()), &nonce,
+		state.totpEncryptionKey), nil
+}
+
+func (state *RuntimeState) decryptTOTPKey(ciphertext []byte) (*otp.Key, error) {
+	var nonce [24]byte
+	if len(ciphertext) < len(nonce) {
+		return nil, errors.New("malformed totp key")
+	}
+	copy(nonce[:], ciphertext)
+	plaintext, ok := secretbox.Open(nil, ciphertext[len(nonce):], &nonce,
+		state.totpEncryptionKey)
+	if !ok {
+		return nil, errors.New("cannot decrypt totp key")
+	}
+	return otp.NewKeyFromURL(string(plaintext))
+}
+
+// matchTOTPCode returns the time step counter which code matches, allowing
+// for totpSkewPeriods of clock skew.
+func matchTOTPCode(key *otp.Key, code string, now time.Time) (uint64, bool) {
+	current := uint64(now.Unix()) / totpPeriodSecs
+	for counter := current - totpSkewPeriods; counter <= current+totpSkewPeriods; counter++ {
+		valid, err := hotp.ValidateCustom(code, counter, key.Secret(),
+			hotp.ValidateOpts{Digits: otp.DigitsSix,
+				Algorithm: otp.AlgorithmSHA1})
+		if err == nil && valid {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTPCode checks code against the enrolled key of profile. Each
+// time step can only be used once, so the profile must be loaded under
+// lockUserTOTP and saved after a successful verification.
+func (state *RuntimeState) verifyTOTPCode(profile *userProfile, code string,
+	now time.Time) (bool, error) {
+	if len(profile.TOTPKey) < 1 {
+		return false, nil
+	}
+	key, err := state.decryptTOTPKey(profile.TOTPKey)
+	if err != nil {
+		return false, err
+	}
+	counter, ok := matchTOTPCode(key, code, now)
+	if !ok {
+		return false, nil
+	}
+	if counter <= profile.TOTPLastCounter {
+		logger.Printf("Replayed TOTP code")
+		return false, nil
+	}
+	profile.TOTPLastCounter = counter
+	return true, nil
+}
+
+// totpUserLock serializes the TOTP verifications of a user.
+type totpUserLock struct {
+	mutex sync.Mutex
+	users int // Protected by state.Mutex.
+}
+
+// lockUserTOTP holds the TOTP lock of username until the returned function
+// is called. Codes are single use, so the profile must be loaded, checked
+// and saved under this lock.
+func (state *RuntimeState) lockUserTOTP(username string) func() {
+	state.Mutex.Lock()
+	if state.totpUserLocks == nil {
+		state.totpUserLocks = make(map[string]*totpUserLock)
+	}
+	lock, ok := state.totpUserLocks[username]
+	if !ok {
+		lock = &totpUserLock{}
+		state.totpUserLocks[username] = lock
+	}
+	lock.users++
+	state.Mutex.Unlock()
+	lock.mutex.Lock()
+	return func() {
+		lock.mutex.Unlock()
+		state.Mutex.Lock()
+		lock.users--
+		if lock.users < 1 {
+			delete(state.totpUserLocks, username)
+		}
+		state.Mutex.Unlock()
+	}
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+func genRecoveryCodes() (codes []string, hashes [][]byte, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		randomBytes := make([]byte, totpRecoveryCodeLength*5/8)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(randomBytes))
+		code = code[:totpRecoveryCodeLength/2] + "-" + code[totpRecoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// useRecoveryCode removes code from the recovery codes of profile if it is
+// one of them.
+func useRecoveryCode(profile *userProfile, code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, storedHash := range profile.TOTPRecoveryCodes {
+		if subtle.ConstantTimeCompare(hash, storedHash) == 1 {
+			profile.TOTPRecoveryCodes = append(profile.TOTPRecoveryCodes[:i],
+				profile.TOTPRecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (profile *userProfile) deleteTOTP() {
+	profile.TOTPKey = nil
+	profile.TOTPPendingKey = nil
+	profile.TOTPLastCounter = 0
+	profile.TOTPRecoveryCodes = nil
+}
+
+func (state *RuntimeState) userHasTOTP(username string) (bool, error) {
+	if !state.Config.TOTP.Enabled {
+		return false, nil
+	}
+	profile, ok, _, err := state.LoadUserProfile(username)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	return len(profile.TOTPKey) > 0, nil
+}
+
+func (state *RuntimeState) totpIssuer() string {
+	if state.Config.TOTP.Issuer != "" {
+		return state.Config.TOTP.Issuer
+	}
+	return state.HostIdentity
+}
+
+func totpQRCode(key *otp.Key) (template.URL, error) {
+	image, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," +
+		base64.StdEncoding.EncodeToString(buffer.Bytes())), nil
+}
+
+func (state *RuntimeState) writeTOTPEnrollPage(w http.ResponseWriter,
+	displayData totpEnrollPageTemplateData, key *otp.Key) {
+	if key != nil {
+		qrCode, err := totpQRCode(key)
+		if err != nil {
+			logger.Printf("Failed to generate QR code %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		displayData.QRCode = qrCode
+		displayData.Secret = key.Secret()
+	}
+	displayData.Title = "Keymaster TOTP Enrollment"
+	err := state.htmlTemplate.ExecuteTemplate(w, "totpEnrollPage", displayData)
+	if err != nil {
+		logger.Printf("Failed to execute %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+	}
+}
+
+func (state *RuntimeState) totpEnrollHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if !state.Config.TOTP.Enabled {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "TOTP is not enabled")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
+		return
+	}
+	assumedUser := r.Form.Get("username")
+	if assumedUser == "" {
+		assumedUser = authUser
+	}
+	actionName := r.Form.Get("action")
+	// Admins can only remove the TOTP of other users, since enrolling
+	// reveals the secret.
+	if assumedUser != authUser && (actionName != "Delete" ||
+		!state.IsAdminUserAndU2F(authUser, loginLevel)) {
+		logger.Printf("bad username authUser=%s requested=%s", authUser, assumedUser)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	unlock := state.lockUserTOTP(assumedUser)
+	defer unlock()
+	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		logger.Printf("DB is being cached and requesting registration aborting it")
+		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
+		return
+	}
+	displayData := totpEnrollPageTemplateData{
+		AuthUsername: authUser,
+		Username:     assumedUser,
+	}
+	var pendingKey *otp.Key
+	switch actionName {
+	case "Start":
+		pendingKey, err = totp.Generate(totp.GenerateOpts{
+			Issuer:      state.totpIssuer(),
+			AccountName: assumedUser,
+			Period:      totpPeriodSecs,
+		})
+		if err == nil {
+			profile.TOTPPendingKey, err = state.encryptTOTPKey(pendingKey)
+		}
+		if err != nil {
+			logger.Printf("Generating TOTP key error: %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	case "Verify":
+		if len(profile.TOTPPendingKey) < 1 {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, "No pending TOTP enrollment")
+			return
+		}
+		pendingKey, err = state.decryptTOTPKey(profile.TOTPPendingKey)
+		if err != nil {
+			logger.Printf("Decrypting TOTP key error: %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		counter, ok := matchTOTPCode(pendingKey, r.Form.Get("OTP"), time.Now())
+		if !ok {
+			displayData.ErrorMessage = "Invalid code, please try again."
+			break
+		}
+		codes, hashes, err := genRecoveryCodes()
+		if err != nil {
+			logger.Printf("Generating recovery codes error: %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+		profile.TOTPKey = profile.TOTPPendingKey
+		profile.TOTPPendingKey = nil
+		profile.TOTPEnrolledAt = time.Now()
+		profile.TOTPLastCounter = counter
+		profile.TOTPRecoveryCodes = hashes
+		displayData.RecoveryCodes = codes
+		pendingKey = nil
+		logger.Printf("TOTP enrolled for %s", assumedUser)
+	case "Delete":
+		profile.deleteTOTP()
+	default:
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Operation")
+		return
+	}
+	err = state.SaveUserProfile(assumedUser, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if actionName == "Delete" {
+		http.Redirect(w, r, profileURI(authUser, assumedUser), 302)
+		return
+	}
+	state.writeTOTPEnrollPage(w, displayData, pendingKey)
+}
+
+// totpProvider is the secondFactorProvider for TOTP and recovery codes.
+type totpProvider struct{}
+
+func (totpProvider) Name() string { return proto.AuthTypeTOTP }
+
+func (totpProvider) AuthType() int { return AuthTypeTOTP }
+
+func (totpProvider) AuthPath() string { return totpAuthPath }
+
+func (totpProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.TOTP.Enabled
+}
+
+func (totpProvider) UserEnabled(state *RuntimeState, username string) (bool, error) {
+	return state.userHasTOTP(username)
+}
+
+func (totpProvider) BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+	r *http.Request, username string) error {
+	return nil
+}
+
+func (totpProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	if r.Method != "POST" {
+		return false, &secondFactorError{http.StatusMethodNotAllowed, ""}
+	}
+	if err := r.ParseForm(); err != nil {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing form"}
+	}
+	if len(r.Form["OTP"]) != 1 {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Just one OTP Value allowed"}
+	}
+	code := strings.TrimSpace(r.Form.Get("OTP"))
+	unlock := state.lockUserTOTP(username)
+	defer unlock()
+	profile, ok, fromCache, err := state.LoadUserProfile(username)
+	if err != nil {
+		return false, err
+	}
+	if !ok || len(profile.TOTPKey) < 1 {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"No TOTP enrolled"}
+	}
+	// Codes are single use, which we can only enforce when we can write.
+	if fromCache {
+		logger.Printf("DB is being cached and requesting TOTP auth aborting it")
+		return false, &secondFactorError{http.StatusServiceUnavailable,
+			"db backend is offline for writes"}
+	}
+	var valid bool
+	if totpCodeRegexp.MatchString(code) {
+		valid, err = state.verifyTOTPCode(profile, code, time.Now())
+		if err != nil {
+			return false, err
+		}
+	} else {
+		valid = useRecoveryCode(profile, code)
+		if valid {
+			logger.Printf("Recovery code used by %s, %d left", username,
+				len(profile.TOTPRecoveryCodes))
+		}
+	}
+	if !valid {
+		return false, nil
+	}
+	if err := state.SaveUserProfile(username, profile); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (totpProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeTOTP, username)
+}
+
+// The TOTP enrollment has a single ID. Its counter is the TOTP time step
+// of the last code used.
+const totpEnrollmentID = "totp"
+
+func (totpProvider) ListEnrollments(state *RuntimeState, username string,
+	profile *userProfile) ([]secondFactorEnrollment, error) {
+	if len(profile.TOTPKey) < 1 {
+		return nil, nil
+	}
+	enrollment := secondFactorEnrollment{
+		ID: totpEnrollmentID,
+		Name: fmt.Sprintf("Authenticator app, %d recovery code(s) left",
+			len(profile.TOTPRecoveryCodes)),
+		Enabled:   true,
+		CreatedAt: profile.TOTPEnrolledAt,
+		Counter:   profile.TOTPLastCounter,
+	}
+	if profile.TOTPLastCounter > 0 {
+		enrollment.LastUsedAt = time.Unix(
+			int64(profile.TOTPLastCounter*totpPeriodSecs), 0)
+	}
+	return []secondFactorEnrollment{enrollment}, nil
+}
+
+func (totpProvider) ResetEnrollment(state *RuntimeState, username string,
+	profile *userProfile, id string) error {
+	if id != "" && id != totpEnrollmentID {
+		return &secondFactorError{http.StatusBadRequest, "bad enrollment ID"}
+	}
+	profile.deleteTOTP()
+	return nil
+}
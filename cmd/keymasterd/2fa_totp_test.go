@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func setupTOTPTestState(t *testing.T) (*RuntimeState, *http.Cookie, func()) {
+	state, authCookie, cleanup := setupProfileTestState(t)
+	keyFile, err := ioutil.TempFile("", "totp.key")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString(base64.StdEncoding.EncodeToString(
+		[]byte("0123456789abcdef0123456789abcdef")) + "\n")
+	keyFile.Close()
+	state.Config.TOTP = TOTPConfig{Enabled: true, Issuer: "keymaster-test",
+		EncryptionKeyFilename: keyFile.Name()}
+	if err := state.loadTOTPEncryptionKey(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := state.loadTemplates(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return state, authCookie, cleanup
+}
+
+func newTOTPKey(t *testing.T) *otp.Key {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "keymaster-test",
+		AccountName: "username"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestTOTPKeyEncryption(t *testing.T) {
+	state, _, cleanup := setupTOTPTestState(t)
+	defer cleanup()
+	key := newTOTPKey(t)
+	ciphertext, err := state.encryptTOTPKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ciphertext), key.Secret()) {
+		t.Fatal("secret stored in clear")
+	}
+	decryptedKey, err := state.decryptTOTPKey(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decryptedKey.Secret() != key.Secret() {
+		t.Fatal("secret mismatch")
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := state.decryptTOTPKey(ciphertext); err == nil {
+		t.Fatal("modified ciphertext should not decrypt")
+	}
+}
+
+func TestVerifyTOTPCodeReplay(t *testing.T) {
+	state, _, cleanup := setupTOTPTestState(t)
+	defer cleanup()
+	key := newTOTPKey(t)
+	var profile userProfile
+	var err error
+	profile.TOTPKey, err = state.encryptTOTPKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	oldCode, err := totp.GenerateCode(key.Secret(), now.Add(-5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := state.verifyTOTPCode(&profile, oldCode, now); err != nil || ok {
+		t.Fatalf("old code accepted: %v", err)
+	}
+	code, err := totp.GenerateCode(key.Secret(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := state.verifyTOTPCode(&profile, code, now); err != nil || !ok {
+		t.Fatalf("valid code rejected: %v", err)
+	}
+	if ok, _ := state.verifyTOTPCode(&profile, code, now); ok {
+		t.Fatal("replayed code accepted")
+	}
+	nextCode, err := totp.GenerateCode(key.Secret(), now.Add(totpPeriodSecs*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := state.verifyTOTPCode(&profile, nextCode,
+		now.Add(totpPeriodSecs*time.Second)); err != nil || !ok {
+		t.Fatalf("next code rejected: %v", err)
+	}
+}
+
+func TestTOTPVerifyConcurrentReplay(t *testing.T) {
+	state, _, cleanup := setupTOTPTestState(t)
+	defer cleanup()
+	key := newTOTPKey(t)
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.TOTPKey, err = state.encryptTOTPKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const numAttempts = 8
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < numAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"OTP": {code}}
+			req, err := http.NewRequest("POST", totpAuthPath,
+				strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			valid, err := totpProvider{}.Verify(state, req, "username")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if valid {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Fatalf("code accepted %d times", accepted)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := genRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != totpRecoveryCodeCount || len(hashes) != len(codes) {
+		t.Fatalf("bad number of recovery codes: %d", len(codes))
+	}
+	profile := userProfile{TOTPRecoveryCodes: hashes}
+	if useRecoveryCode(&profile, "aaaaa-aaaaa") {
+		t.Fatal("unknown recovery code accepted")
+	}
+	code := strings.ToUpper(strings.Replace(codes[3], "-", "", -1))
+	if !useRecoveryCode(&profile, code) {
+		t.Fatal("recovery code rejected")
+	}
+	if useRecoveryCode(&profile, codes[3]) {
+		t.Fatal("recovery code used twice")
+	}
+	if len(profile.TOTPRecoveryCodes) != totpRecoveryCodeCount-1 {
+		t.Fatal("recovery code not removed")
+	}
+}
+
+func postTOTPForm(t *testing.T, state *RuntimeState, authCookie *http.Cookie,
+	path string, form url.Values, handler http.HandlerFunc,
+	expectedStatus int) string {
+	req, err := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	rr, err := checkRequestHandlerCode(req, handler, expectedStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr.Body.String()
+}
+
+func TestTOTPEnrollAndAuth(t *testing.T) {
+	state, authCookie, cleanup := setupTOTPTestState(t)
+	defer cleanup()
+
+	postTOTPForm(t, state, authCookie, totpEnrollPath,
+		url.Values{"action": {"Start"}}, state.totpEnrollHandler, http.StatusOK)
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingKey, err := state.decryptTOTPKey(profile.TOTPPendingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(pendingKey.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := postTOTPForm(t, state, authCookie, totpEnrollPath,
+		url.Values{"action": {"Verify"}, "OTP": {code}},
+		state.totpEnrollHandler, http.StatusOK)
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.TOTPKey) < 1 || len(profile.TOTPPendingKey) > 0 {
+		t.Fatal("TOTP not enrolled")
+	}
+	if len(profile.TOTPRecoveryCodes) != totpRecoveryCodeCount {
+		t.Fatal("recovery codes not stored")
+	}
+	hasTOTP, err := state.userHasTOTP("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasTOTP {
+		t.Fatal("userHasTOTP should be true")
+	}
+
+	// The code used for enrollment cannot be used again.
+	totpAuthHandler := state.secondFactorAuthHandler(totpProvider{})
+	postTOTPForm(t, state, authCookie, totpAuthPath,
+		url.Values{"OTP": {code}}, totpAuthHandler,
+		http.StatusUnauthorized)
+
+	// Recovery codes are shown once, on the enrollment page.
+	recoveryCode := ""
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == totpRecoveryCodeLength+1 && line[totpRecoveryCodeLength/2] == '-' {
+			recoveryCode = line
+			break
+		}
+	}
+	if recoveryCode == "" {
+		t.Fatal("recovery codes not shown")
+	}
+	postTOTPForm(t, state, authCookie, totpAuthPath,
+		url.Values{"OTP": {recoveryCode}}, totpAuthHandler, http.StatusOK)
+	postTOTPForm(t, state, authCookie, totpAuthPath,
+		url.Values{"OTP": {recoveryCode}}, totpAuthHandler,
+		http.StatusUnauthorized)
+
+	postTOTPForm(t, state, authCookie, totpEnrollPath,
+		url.Values{"action": {"Delete"}}, state.totpEnrollHandler,
+		http.StatusFound)
+	hasTOTP, err = state.userHasTOTP("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasTOTP {
+		t.Fatal("TOTP not removed")
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,7 +49,7 @@ func (state *RuntimeState) u2fRegisterRequest(w http.ResponseWriter, r *http.Req
 		/*
 	*/
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
 	if err != nil {
 		logger.Debugf(1, "%v", err)
 		return
@@ -114,7 +117,7 @@ func (state *RuntimeState) u2fRegisterResponse(w http.ResponseWriter, r *http.Re
 	/*
 	 */
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
 	if err != nil {
 		logger.Debugf(1, "%v", err)
 		return
@@ -246,96 +249,180 @@ func (state *RuntimeState) u2fSignRequest(w http.ResponseWriter, r *http.Request
 
 const u2fSignResponsePath = "/u2f/SignResponse"
 
-func (state *RuntimeState) u2fSignResponse(w http.ResponseWriter, r *http.Request) {
-	// User must be logged in
-	if state.sendFailureToClientIfLocked(w, r) {
-		return
-	}
-	/*
-	 */
-	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
-	if err != nil {
-		logger.Debugf(1, "%v", err)
-		return
-	}
-	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+// u2fProvider is the secondFactorProvider for U2F tokens. WebAuthn
+// credentials grant the same AuthType.
+type u2fProvider struct{}
+
+func (u2fProvider) Name() string { return proto.AuthTypeU2F }
+
+func (u2fProvider) AuthType() int { return AuthTypeU2F }
+
+func (u2fProvider) AuthPath() string { return u2fSignResponsePath }
+
+func (u2fProvider) Enabled(state *RuntimeState) bool { return true }
 
-	//now the actual work
+func (u2fProvider) UserEnabled(state *RuntimeState, username string) (bool, error) {
+	return state.userHasU2FTokens(username)
+}
+
+// The challenge is requested by the client from u2fSignRequestPath.
+func (u2fProvider) BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+	r *http.Request, username string) error {
+	return nil
+}
+
+func (u2fProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
 	var signResp u2f.SignResponse
 	if err := json.NewDecoder(r.Body).Decode(&signResp); err != nil {
-		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
-		return
+		return false, &secondFactorError{http.StatusBadRequest,
+			"invalid response: " + err.Error()}
 	}
-
 	logger.Debugf(1, "signResponse: %+v", signResp)
 
-	profile, ok, _, err := state.LoadUserProfile(authUser)
+	profile, ok, fromCache, err := state.LoadUserProfile(username)
 	if err != nil {
-		logger.Printf("loading profile error: %v", err)
-		http.Error(w, "error", http.StatusInternalServerError)
-		return
-
+		return false, err
 	}
-
-	/////////
 	if !ok {
-		http.Error(w, "No regstered data", http.StatusBadRequest)
-		return
+		return false, &secondFactorError{http.StatusBadRequest,
+			"No regstered data"}
 	}
 	registrations := getRegistrationArray(profile.U2fAuthData)
 	if len(registrations) < 1 {
-		http.Error(w, "registration missing", http.StatusBadRequest)
-		return
-	}
-
-	if registrations == nil {
-		http.Error(w, "registration missing", http.StatusBadRequest)
-		return
+		return false, &secondFactorError{http.StatusBadRequest,
+			"registration missing"}
 	}
 	state.Mutex.Lock()
-	localAuth, ok := state.localAuthData[authUser]
+	localAuth, ok := state.localAuthData[username]
 	state.Mutex.Unlock()
-	if !ok {
-		http.Error(w, "challenge missing", http.StatusBadRequest)
-		return
+	if !ok || localAuth.U2fAuthChallenge == nil {
+		return false, &secondFactorError{http.StatusBadRequest,
+			"challenge missing"}
 	}
-
-	//var err error
-	for i, u2fReg := range profile.U2fAuthData {
-		//newCounter, authErr := u2fReg.Registration.Authenticate(signResp, *profile.U2fAuthChallenge, u2fReg.Counter)
-		newCounter, authErr := u2fReg.Registration.Authenticate(signResp, *localAuth.U2fAuthChallenge, u2fReg.Counter)
-		if authErr == nil {
-			metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, true)
-
-			logger.Debugf(0, "newCounter: %d", newCounter)
-			//counter = newCounter
-			u2fReg.Counter = newCounter
-			//profile.U2fAuthData[i].Counter = newCounter
-			u2fReg.Counter = newCounter
-			profile.U2fAuthData[i] = u2fReg
-			//profile.U2fAuthChallenge = nil
-			delete(state.localAuthData, authUser)
-
-			eventNotifier.PublishAuthEvent(eventmon.AuthTypeU2F, authUser)
-			_, isXHR := r.Header["X-Requested-With"]
-			if isXHR {
-				eventNotifier.PublishWebLoginEvent(authUser)
-			}
-			_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeU2F)
-			if err != nil {
-				logger.Printf("Auth Cookie NOT found ? %s", err)
-				state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure updating vip token")
-				return
+	for _, u2fReg := range profile.U2fAuthData {
+		if !u2fReg.Enabled {
+			continue
+		}
+		// The library checks the counter before the signature, so it is
+		// compared here to only act on otherwise valid responses.
+		newCounter, authErr := u2fReg.Registration.Authenticate(signResp,
+			*localAuth.U2fAuthChallenge, 0)
+		if authErr != nil {
+			logger.Debugf(1, "VerifySignResponse error: %v", authErr)
+			continue
+		}
+		if newCounter <= u2fReg.Counter &&
+			(newCounter != 0 || u2fReg.Counter != 0) {
+			logger.Debugf(0, "counter regression: %d -> %d", u2fReg.Counter,
+				newCounter)
+			state.Mutex.Lock()
+			delete(state.localAuthData, username)
+			state.Mutex.Unlock()
+			u2fReg.Enabled = false
+			state.disableRegressedU2FToken(username, profile, fromCache,
+				u2fReg.Name, u2fReg.Counter, getClientIP(r))
+			return false, nil
+		}
+		logger.Debugf(0, "newCounter: %d", newCounter)
+		u2fReg.Counter = newCounter
+		u2fReg.LastUsedAt = time.Now()
+		u2fReg.LastUsedAddr = getClientIP(r)
+		state.Mutex.Lock()
+		delete(state.localAuthData, username)
+		state.Mutex.Unlock()
+		if !fromCache {
+			if err := state.SaveUserProfile(username, profile); err != nil {
+				logger.Printf("Saving profile error: %v", err)
 			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
 
-			// TODO: update local cookie state
-			w.Write([]byte("success"))
-			return
+// disableRegressedU2FToken handles a U2F or WebAuthn token that signed a
+// valid response with a counter not above the stored one, which is what a
+// cloned key looks like. The token (already disabled in profile by the
+// caller) is saved and a security event is published.
+func (state *RuntimeState) disableRegressedU2FToken(username string,
+	profile *userProfile, fromCache bool, tokenName string,
+	storedCounter uint32, clientIP string) {
+	reason := fmt.Sprintf("token %q disabled: counter did not increase past %d, request from %s",
+		tokenName, storedCounter, clientIP)
+	logger.Printf("counter regression for %s: %s", username, reason)
+	if !fromCache {
+		if err := state.SaveUserProfile(username, profile); err != nil {
+			logger.Printf("Saving profile error: %v", err)
 		}
 	}
-	metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+	eventNotifier.PublishSecurityEvent(
+		eventmon.SecurityEventTypeU2FCounterRegression, username, "",
+		eventmon.AuthTypeU2F, reason)
+}
+
+func (u2fProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishAuthEvent(eventmon.AuthTypeU2F, username)
+}
+
+// U2F and WebAuthn enrollment IDs are the type used by
+// u2fTokenManagerHandler followed by the index in the profile.
+func (u2fProvider) ListEnrollments(state *RuntimeState, username string,
+	profile *userProfile) ([]secondFactorEnrollment, error) {
+	var enrollments []secondFactorEnrollment
+	for i, tokenInfo := range profile.U2fAuthData {
+		enrollments = append(enrollments, secondFactorEnrollment{
+			ID:         fmt.Sprintf("u2f-%d", i),
+			Name:       tokenInfo.Name,
+			Enabled:    tokenInfo.Enabled,
+			CreatedAt:  tokenInfo.CreatedAt,
+			LastUsedAt: tokenInfo.LastUsedAt,
+			Counter:    uint64(tokenInfo.Counter),
+		})
+	}
+	for i, tokenInfo := range profile.WebauthnData {
+		enrollments = append(enrollments, secondFactorEnrollment{
+			ID:         fmt.Sprintf("webauthn-%d", i),
+			Name:       tokenInfo.Name,
+			Enabled:    tokenInfo.Enabled,
+			CreatedAt:  tokenInfo.CreatedAt,
+			LastUsedAt: tokenInfo.LastUsedAt,
+			Counter:    uint64(tokenInfo.Credential.Authenticator.SignCount),
+		})
+	}
+	sort.Slice(enrollments, func(i, j int) bool {
+		return enrollments[i].CreatedAt.Before(enrollments[j].CreatedAt)
+	})
+	return enrollments, nil
+}
 
-	logger.Printf("VerifySignResponse error: %v", err)
-	http.Error(w, "error verifying response", http.StatusInternalServerError)
+func (u2fProvider) ResetEnrollment(state *RuntimeState, username string,
+	profile *userProfile, id string) error {
+	if id == "" {
+		profile.U2fAuthData = make(map[int64]*u2fAuthData)
+		profile.WebauthnData = make(map[int64]*webauthnAuthData)
+		return nil
+	}
+	badID := &secondFactorError{http.StatusBadRequest, "bad enrollment ID"}
+	pieces := strings.SplitN(id, "-", 2)
+	if len(pieces) != 2 {
+		return badID
+	}
+	index, err := strconv.ParseInt(pieces[1], 10, 64)
+	if err != nil {
+		return badID
+	}
+	var ok bool
+	switch pieces[0] {
+	case "u2f":
+		_, ok = profile.U2fAuthData[index]
+		delete(profile.U2fAuthData, index)
+	case "webauthn":
+		_, ok = profile.WebauthnData[index]
+		delete(profile.WebauthnData, index)
+	}
+	if !ok {
+		return badID
+	}
+	return nil
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// signU2FChallenge answers challenge like a token holding key would, with
+// counter as the token counter.
+func signU2FChallenge(t *testing.T, key *ecdsa.PrivateKey,
+	challenge *u2f.Challenge, counter uint32) u2f.SignResponse {
+	clientData, err := json.Marshal(u2f.ClientData{
+		Typ:       "navigator.id.getAssertion",
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge.Challenge),
+		Origin:    challenge.TrustedFacets[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := []byte{1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(raw[1:], counter)
+	appParam := sha256.Sum256([]byte(challenge.AppID))
+	clientParam := sha256.Sum256(clientData)
+	var signed []byte
+	signed = append(signed, appParam[:]...)
+	signed = append(signed, raw...)
+	signed = append(signed, clientParam[:]...)
+	hash := sha256.Sum256(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u2f.SignResponse{
+		KeyHandle:     base64.RawURLEncoding.EncodeToString([]byte("keyhandle")),
+		SignatureData: base64.RawURLEncoding.EncodeToString(append(raw, signature...)),
+		ClientData:    base64.RawURLEncoding.EncodeToString(clientData),
+	}
+}
+
+func verifyTestU2FResponse(t *testing.T, state *RuntimeState,
+	key *ecdsa.PrivateKey, counter uint32) bool {
+	challenge, err := u2f.NewChallenge(u2fAppID, []string{u2fAppID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.localAuthData["username"] = localUserData{
+		U2fAuthChallenge: challenge,
+		ExpiresAt:        time.Now().Add(time.Minute),
+	}
+	body, err := json.Marshal(signU2FChallenge(t, key, challenge, counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", u2fSignResponsePath,
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	ok, err := u2fProvider{}.Verify(state, req, "username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestU2fCounterRegression(t *testing.T) {
+	state, _, cleanup := setupProfileTestState(t)
+	defer cleanup()
+	state.localAuthData = make(map[string]localUserData)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: newTestU2fAuthDataWithKey(t, key)},
+		WebauthnData: map[int64]*webauthnAuthData{},
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	if !verifyTestU2FResponse(t, state, key, 8) {
+		t.Fatal("valid response rejected")
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := profile.U2fAuthData[1]
+	if data.Counter != 8 || data.LastUsedAt.IsZero() ||
+		data.LastUsedAddr != "192.0.2.1" {
+		t.Fatalf("use not recorded: %+v", data)
+	}
+
+	// A response with a low counter and a bad signature is just rejected.
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyTestU2FResponse(t, state, otherKey, 3) {
+		t.Fatal("forged response accepted")
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !profile.U2fAuthData[1].Enabled {
+		t.Fatal("token disabled by forged response")
+	}
+
+	if verifyTestU2FResponse(t, state, key, 8) {
+		t.Fatal("replayed counter accepted")
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.U2fAuthData[1].Enabled {
+		t.Fatal("token with counter regression not disabled")
+	}
+}
@@ -1,25 +1,25 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
 	"github.com/Symantec/keymaster/proto/eventmon"
 )
 
-func (state *RuntimeState) startVIPPush(cookieVal string, username string) error {
-	transactionId, err := state.Config.SymantecVIP.Client.StartUserVIPPush(username)
+func (state *RuntimeState) startPush(provider pushSecondFactorProvider,
+	cookieVal string, r *http.Request, username string) error {
+	expiresAt := time.Now().Add(maxAgeSecondsVIPCookie * time.Second)
+	transactionId, err := provider.StartPush(state, r, username, expiresAt)
 	if err != nil {
 		logger.Println(err)
 		return err
 	}
-	newLocalData := pushPollTransaction{Username: username, TransactionID: transactionId, ExpiresAt: time.Now().Add(maxAgeSecondsVIPCookie * time.Second)}
+	newLocalData := pushPollTransaction{Username: username, TransactionID: transactionId, ExpiresAt: expiresAt, Provider: provider.Name()}
 	state.Mutex.Lock()
 	defer state.Mutex.Unlock()
 	state.vipPushCookie[cookieVal] = newLocalData
@@ -27,115 +27,149 @@ func (state *RuntimeState) startVIPPush(cookieVal string, username string) error
 	return nil
 }
 
+// setPushTransactionCookie sets the cookie used to track push transactions.
+func setPushTransactionCookie(w http.ResponseWriter) error {
+	cookieValue, err := genRandomString()
+	if err != nil {
+		return err
+	}
+	expiration := time.Now().Add(maxAgeSecondsVIPCookie * time.Second)
+	vipPushCookie := http.Cookie{Name: vipTransactionCookieName,
+		Value: cookieValue, Expires: expiration,
+		Path: "/", HttpOnly: true, Secure: true}
+	http.SetCookie(w, &vipPushCookie)
+	return nil
+}
+
 ///
 const vipAuthPath = "/api/v0/vipAuth"
 
-func (state *RuntimeState) VIPAuthHandler(w http.ResponseWriter, r *http.Request) {
-	if state.sendFailureToClientIfLocked(w, r) {
-		return
-	}
+// vipProvider is the secondFactorProvider for Symantec VIP OTP values.
+// Push approvals are polled through VIPPollCheckHandler.
+type vipProvider struct{}
 
-	//Check for valid method here?
-	switch r.Method {
-	case "GET":
-		logger.Debugf(3, "Got client GET connection")
-		err := r.ParseForm()
-		if err != nil {
-			logger.Println(err)
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
-			return
-		}
-	case "POST":
-		logger.Debugf(3, "Got client POST connection")
-		err := r.ParseForm()
-		if err != nil {
-			logger.Println(err)
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
-			return
-		}
-	default:
-		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
-		return
-	}
-	//authUser, authType, err := state.checkAuth(w, r, AuthTypeAny)
-	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+func (vipProvider) Name() string { return proto.AuthTypeSymantecVIP }
+
+func (vipProvider) AuthType() int { return AuthTypeSymantecVIP }
+
+func (vipProvider) AuthPath() string { return vipAuthPath }
+
+func (vipProvider) Enabled(state *RuntimeState) bool {
+	return state.Config.SymantecVIP.Enabled
+}
+
+// VIP enrollment is kept by Symantec, so every user is assumed to have it.
+func (vipProvider) UserEnabled(state *RuntimeState, username string) (bool, error) {
+	return true, nil
+}
+
+func (vipProvider) BeginChallenge(state *RuntimeState, w http.ResponseWriter,
+	r *http.Request, username string) error {
+	return setPushTransactionCookie(w)
+}
+
+func (vipProvider) Verify(state *RuntimeState, r *http.Request,
+	username string) (bool, error) {
+	err := r.ParseForm()
 	if err != nil {
-		logger.Debugf(1, "%v", err)
-		return
+		logger.Println(err)
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing form"}
 	}
-	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
-
 	var OTPString string
 	if val, ok := r.Form["OTP"]; ok {
 		if len(val) > 1 {
-			state.writeFailureResponse(w, r, http.StatusBadRequest, "Just one OTP Value allowed")
 			logger.Printf("Login with multiple OTP Values")
-			return
+			return false, &secondFactorError{http.StatusBadRequest,
+				"Just one OTP Value allowed"}
 		}
 		OTPString = val[0]
 	}
 	otpValue, err := strconv.Atoi(OTPString)
 	if err != nil {
 		logger.Println(err)
-		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing OTP value")
+		return false, &secondFactorError{http.StatusBadRequest,
+			"Error parsing OTP value"}
 	}
 
 	start := time.Now()
-	valid, err := state.Config.SymantecVIP.Client.ValidateUserOTP(authUser, otpValue)
+	valid, err := state.Config.SymantecVIP.Client.ValidateUserOTP(username, otpValue)
 	if err != nil {
-		logger.Println(err)
-		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
-		return
+		return false, err
 	}
-
 	metricLogExternalServiceDuration("vip", time.Since(start))
+	return valid, nil
+}
 
-	//
-	metricLogAuthOperation(getClientType(r), proto.AuthTypeSymantecVIP, valid)
+func (vipProvider) PublishAuthEvent(username string) {
+	eventNotifier.PublishVIPAuthEvent(eventmon.VIPAuthTypeOTP, username)
+}
 
-	if !valid {
-		logger.Printf("Invalid OTP value login for %s", authUser)
-		// TODO if client is html then do a redirect back to vipLoginPage
-		state.writeFailureResponse(w, r, http.StatusUnauthorized, "")
-		return
+func (vipProvider) StartPush(state *RuntimeState, r *http.Request,
+	username string, expiresAt time.Time) (string, error) {
+	return state.Config.SymantecVIP.Client.StartUserVIPPush(username)
+}
 
-	}
+func (vipProvider) PushApproved(state *RuntimeState,
+	transaction pushPollTransaction) (bool, error) {
+	return state.Config.SymantecVIP.Client.VipPushHasBeenApproved(
+		transaction.TransactionID)
+}
 
-	// OTP check was  successful
-	logger.Debugf(1, "Successful vipOTP auth for user: %s", authUser)
-	eventNotifier.PublishVIPAuthEvent(eventmon.VIPAuthTypeOTP, authUser)
-	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeSymantecVIP)
+func (vipProvider) PublishPushEvent(username string) {
+	eventNotifier.PublishVIPAuthEvent(eventmon.VIPAuthTypePush, username)
+}
+
+// VIP credentials are kept by Symantec, so they are listed and removed
+// through the VIP user services.
+func (vipProvider) ListEnrollments(state *RuntimeState, username string,
+	profile *userProfile) ([]secondFactorEnrollment, error) {
+	start := time.Now()
+	credentials, err := state.Config.SymantecVIP.Client.GetUserCredentials(
+		username)
 	if err != nil {
-		logger.Printf("Auth Cookie NOT found ? %s", err)
-		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
-		return
+		return nil, err
 	}
-
-	// Now we send to the appropiate place
-	returnAcceptType := "application/json"
-	acceptHeader, ok := r.Header["Accept"]
-	if ok {
-		for _, acceptValue := range acceptHeader {
-			if strings.Contains(acceptValue, "text/html") {
-				logger.Debugf(1, "Got it  %+v", acceptValue)
-				returnAcceptType = "text/html"
-			}
+	metricLogExternalServiceDuration("vip", time.Since(start))
+	var enrollments []secondFactorEnrollment
+	for _, credential := range credentials {
+		name := credential.Type
+		if credential.FriendlyName != "" {
+			name = credential.FriendlyName + " (" + credential.Type + ")"
 		}
+		enrollments = append(enrollments, secondFactorEnrollment{
+			ID:         credential.ID,
+			Name:       name,
+			Enabled:    credential.Status == "ENABLED",
+			LastUsedAt: credential.LastAuthnTime,
+		})
 	}
+	return enrollments, nil
+}
 
-	// TODO: The cert backend should depend also on per user preferences.
-	loginResponse := proto.LoginResponse{Message: "success"} //CertAuthBackend: certBackends
-	switch returnAcceptType {
-	case "text/html":
-		loginDestination := getLoginDestination(r)
-		eventNotifier.PublishWebLoginEvent(authUser)
-		http.Redirect(w, r, loginDestination, 302)
-	default:
-		w.WriteHeader(200)
-		json.NewEncoder(w).Encode(loginResponse)
-		//fmt.Fprintf(w, "Success!")
+func (vipProvider) ResetEnrollment(state *RuntimeState, username string,
+	profile *userProfile, id string) error {
+	client := state.Config.SymantecVIP.Client
+	credentials, err := client.GetUserCredentials(username)
+	if err != nil {
+		return err
 	}
-	return
+	found := false
+	for _, credential := range credentials {
+		if id != "" && credential.ID != id {
+			continue
+		}
+		found = true
+		err := client.RemoveCredential(username, credential.ID,
+			credential.Type)
+		if err != nil {
+			return err
+		}
+	}
+	if id != "" && !found {
+		return &secondFactorError{http.StatusBadRequest, "bad enrollment ID"}
+	}
+	return nil
 }
 
 func (state *RuntimeState) getPushPollTransaction(cookieValue string) (pushPollTransaction, bool) {
@@ -152,8 +186,10 @@ func (state *RuntimeState) vipPushStartHandler(w http.ResponseWriter, r *http.Re
 	if state.sendFailureToClientIfLocked(w, r) {
 		return
 	}
-	if !state.Config.SymantecVIP.Enabled {
-		logger.Printf("asked for push status but VIP is not enabled")
+	provider, ok := state.getRequestPushProvider(r)
+	if !ok {
+		logger.Printf("asked for push start with %s=%q but no such push factor is enabled",
+			pushProviderParam, r.FormValue(pushProviderParam))
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
 		return
 	}
@@ -178,12 +214,12 @@ func (state *RuntimeState) vipPushStartHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	if len(pushTransaction.TransactionID) > 0 {
-		err := errors.New("VIP push transaction already initiated")
+		err := errors.New("push transaction already initiated")
 		logger.Println(err)
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Push already sent")
 		return
 	}
-	err = state.startVIPPush(vipPushCookie.Value, authUser)
+	err = state.startPush(provider, vipPushCookie.Value, r, authUser)
 	if err != nil {
 		logger.Println(err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Cookie not setup ")
@@ -201,8 +237,8 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 	if state.sendFailureToClientIfLocked(w, r) {
 		return
 	}
-	if !state.Config.SymantecVIP.Enabled {
-		logger.Printf("asked for push status but VIP is not enabled")
+	if _, ok := state.getPushProvider(); !ok {
+		logger.Printf("asked for push status but no push factor is enabled")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "")
 		return
 	}
@@ -249,9 +285,33 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "Error parsing form")
 		return
 	}
-	//TODO: check username
-	valid, err := state.Config.SymantecVIP.Client.VipPushHasBeenApproved(pushTransaction.TransactionID)
+	if pushTransaction.Username != authUser {
+		logger.Printf("VIPPollCheckHandler: push transaction of %s polled by %s",
+			pushTransaction.Username, authUser)
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "")
+		return
+	}
+	if name := r.Form.Get(pushProviderParam); name != "" &&
+		name != pushTransaction.Provider {
+		logger.Printf("VIPPollCheckHandler: %s push transaction polled for %s",
+			pushTransaction.Provider, name)
+		state.writeFailureResponse(w, r, http.StatusPreconditionFailed, "")
+		return
+	}
+	provider, ok := getSecondFactorProvider(pushTransaction.Provider)
+	if !ok {
+		logger.Printf("VIPPollCheckHandler: unknown push provider %s",
+			pushTransaction.Provider)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	pushProvider := provider.(pushSecondFactorProvider)
+	valid, err := pushProvider.PushApproved(state, pushTransaction)
 	if err != nil {
+		if factorErr, ok := err.(*secondFactorError); ok {
+			state.writeFailureResponse(w, r, factorErr.statusCode, factorErr.message)
+			return
+		}
 		logger.Println(err)
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error checking push transaction")
 		return
@@ -263,14 +323,14 @@ func (state *RuntimeState) VIPPollCheckHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// VIP Push check was  successful
-	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|AuthTypeSymantecVIP)
+	// Push check was  successful
+	_, err = state.updateAuthCookieAuthlevel(w, r, currentAuthLevel|pushProvider.AuthType())
 	if err != nil {
 		logger.Printf("VIPPollCheckHandler:  Failure to update AuthCookie %s", err)
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure when validating VIP token")
 		return
 	}
-	eventNotifier.PublishVIPAuthEvent(eventmon.VIPAuthTypePush, authUser)
+	pushProvider.PublishPushEvent(authUser)
 
 	// TODO make something more fancy: JSON?
 	w.WriteHeader(http.StatusOK)
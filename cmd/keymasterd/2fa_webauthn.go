@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	webauthnRegisterBeginPath  = "/webauthn/RegisterBegin/"
+	webauthnRegisterFinishPath = "/webauthn/RegisterFinish/"
+	webauthnAuthBeginPath      = "/webauthn/AuthBegin"
+	webauthnAuthFinishPath     = "/webauthn/AuthFinish"
+	webauthnUserIDLen          = 64
+)
+
+// webauthnUser presents a user profile to the webauthn library. Enabled U2F
+// registrations are included as fido-u2f credentials so they keep working
+// through the appid extension.
+type webauthnUser struct {
+	username string
+	profile  *userProfile
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return u.profile.WebauthnID
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.username
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.username
+}
+
+func (u *webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	var credentials []webauthn.Credential
+	for _, data := range u.profile.WebauthnData {
+		if data.Enabled {
+			credentials = append(credentials, data.Credential)
+		}
+	}
+	for _, data := range u.profile.U2fAuthData {
+		if data.Enabled {
+			credentials = append(credentials, u2fRegistrationToCredential(data))
+		}
+	}
+	return credentials
+}
+
+// u2fRegistrationToCredential converts a legacy U2F registration. The public
+// key is kept in the raw uncompressed point format, which is what the
+// webauthn library expects for credentials asserted through appid.
+func u2fRegistrationToCredential(data *u2fAuthData) webauthn.Credential {
+	pubKey := &data.Registration.PubKey
+	return webauthn.Credential{
+		ID:              data.Registration.KeyHandle,
+		PublicKey:       elliptic.Marshal(elliptic.P256(), pubKey.X, pubKey.Y),
+		AttestationType: protocol.CredentialTypeFIDOU2F,
+		Authenticator:   webauthn.Authenticator{SignCount: data.Counter},
+	}
+}
+
+// newWebauthn returns the relying party for the current u2fAppID, which also
+// serves as the appid of the legacy U2F registrations.
+func newWebauthn() (*webauthn.WebAuthn, error) {
+	appID, err := url.Parse(u2fAppID)
+	if err != nil {
+		return nil, err
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          appID.Hostname(),
+		RPDisplayName: "Keymaster",
+		RPOrigins:     []string{u2fAppID},
+	})
+}
+
+func getWebauthnRegistrationOptions(r *http.Request,
+	user *webauthnUser) ([]webauthn.RegistrationOption, error) {
+	var selection protocol.AuthenticatorSelection
+	switch attachment := r.Form.Get("attachment"); attachment {
+	case "":
+	case string(protocol.Platform), string(protocol.CrossPlatform):
+		selection.AuthenticatorAttachment = protocol.AuthenticatorAttachment(
+			attachment)
+	default:
+		return nil, errors.New("invalid attachment")
+	}
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	options := []webauthn.RegistrationOption{
+		webauthn.WithAuthenticatorSelection(selection),
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAppIdExcludeExtension(u2fAppID),
+	}
+	switch residentKey := r.Form.Get("resident_key"); residentKey {
+	case "":
+	case string(protocol.ResidentKeyRequirementRequired),
+		string(protocol.ResidentKeyRequirementPreferred),
+		string(protocol.ResidentKeyRequirementDiscouraged):
+		options = append(options, webauthn.WithResidentKeyRequirement(
+			protocol.ResidentKeyRequirement(residentKey)))
+	default:
+		return nil, errors.New("invalid resident_key")
+	}
+	return options, nil
+}
+
+// getWebauthnAssumedUser parses /webauthn/<operation>/<assumed user> and
+// checks that the authenticated user may manage the credentials of the
+// assumed user.
+func (state *RuntimeState) getWebauthnAssumedUser(w http.ResponseWriter,
+	r *http.Request) (string, bool) {
+	// pieces[0] == "" pieces[1] = "webauthn" pieces[2] == <operation>
+	pieces := strings.Split(r.URL.Path, "/")
+	if len(pieces) < 4 {
+		http.Error(w, "error", http.StatusBadRequest)
+		return "", false
+	}
+	assumedUser := pieces[3]
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return "", false
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+
+	// Check that they can change other users
+	if !state.IsAdminUserAndU2F(authUser, loginLevel) && authUser != assumedUser {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	return assumedUser, true
+}
+
+func (state *RuntimeState) webauthnRegisterBegin(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	assumedUser, ok := state.getWebauthnAssumedUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Error parsing form")
+		return
+	}
+	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		logger.Printf("DB is being cached and requesting registration aborting it")
+		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
+		return
+	}
+	if len(profile.WebauthnID) == 0 {
+		profile.WebauthnID = make([]byte, webauthnUserIDLen)
+		if _, err := rand.Read(profile.WebauthnID); err != nil {
+			logger.Printf("generating webauthn user id error: %v", err)
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		}
+	}
+	user := &webauthnUser{username: assumedUser, profile: profile}
+	options, err := getWebauthnRegistrationOptions(r, user)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	relyingParty, err := newWebauthn()
+	if err != nil {
+		logger.Printf("webauthn config error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	creation, session, err := relyingParty.BeginRegistration(user, options...)
+	if err != nil {
+		logger.Printf("webauthn BeginRegistration error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	profile.WebauthnRegistrationSession = session
+	err = state.SaveUserProfile(assumedUser, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	logger.Debugf(1, "webauthn registration request: %+v", creation)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+func (state *RuntimeState) webauthnRegisterFinish(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	assumedUser, ok := state.getWebauthnAssumedUser(w, r)
+	if !ok {
+		return
+	}
+	profile, _, fromCache, err := state.LoadUserProfile(assumedUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if fromCache {
+		logger.Printf("DB is being cached and requesting registration aborting it")
+		http.Error(w, "db backend is offline for writes", http.StatusServiceUnavailable)
+		return
+	}
+	if profile.WebauthnRegistrationSession == nil {
+		http.Error(w, "challenge not found", http.StatusBadRequest)
+		return
+	}
+	relyingParty, err := newWebauthn()
+	if err != nil {
+		logger.Printf("webauthn config error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	user := &webauthnUser{username: assumedUser, profile: profile}
+	credential, err := relyingParty.FinishRegistration(user,
+		*profile.WebauthnRegistrationSession, r)
+	if err != nil {
+		logger.Printf("webauthn FinishRegistration error: %v", err)
+		http.Error(w, "error verifying response", http.StatusBadRequest)
+		return
+	}
+	newReg := webauthnAuthData{
+		Enabled:     true,
+		CreatedAt:   time.Now(),
+		CreatorAddr: r.RemoteAddr,
+		Credential:  *credential,
+	}
+	profile.WebauthnData[newReg.CreatedAt.Unix()] = &newReg
+	profile.WebauthnRegistrationSession = nil
+	logger.Printf("WebAuthn registration success for %s: %s %s", assumedUser,
+		credential.AttestationType, credential.Authenticator.Attachment)
+	err = state.SaveUserProfile(assumedUser, profile)
+	if err != nil {
+		logger.Printf("Saving profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("success"))
+}
+
+func (state *RuntimeState) webauthnAuthBegin(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, _, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+
+	profile, ok, _, err := state.LoadUserProfile(authUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No regstered data", http.StatusBadRequest)
+		return
+	}
+	user := &webauthnUser{username: authUser, profile: profile}
+	if len(user.WebAuthnCredentials()) < 1 {
+		http.Error(w, "registration missing", http.StatusBadRequest)
+		return
+	}
+	relyingParty, err := newWebauthn()
+	if err != nil {
+		logger.Printf("webauthn config error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	assertion, session, err := relyingParty.BeginLogin(user,
+		webauthn.WithAppIdExtension(u2fAppID))
+	if err != nil {
+		logger.Printf("webauthn BeginLogin error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	//save cached copy
+	var localAuth localUserData
+	localAuth.WebauthnLoginSession = session
+	localAuth.ExpiresAt = time.Now().Add(maxAgeU2FVerifySeconds * time.Second)
+	state.Mutex.Lock()
+	state.localAuthData[authUser] = localAuth
+	state.Mutex.Unlock()
+
+	logger.Debugf(3, "webauthn assertion request: %+v", assertion)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(assertion); err != nil {
+		logger.Printf("json encofing error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// updateWebauthnCounter stores the sign counter of the credential used for
+// an assertion, in either the WebAuthn or the legacy U2F data.
+func (profile *userProfile) updateWebauthnCounter(
+	credential *webauthn.Credential) {
+	for _, data := range profile.WebauthnData {
+		if bytes.Equal(data.Credential.ID, credential.ID) {
+			data.Credential.Authenticator = credential.Authenticator
+			data.Credential.Flags = credential.Flags
+			return
+		}
+	}
+	for _, data := range profile.U2fAuthData {
+		if bytes.Equal(data.Registration.KeyHandle, credential.ID) {
+			data.Counter = credential.Authenticator.SignCount
+			return
+		}
+	}
+}
+
+// recordWebauthnUse stores when and from where the credential with
+// credentialID was last used.
+func (profile *userProfile) recordWebauthnUse(credentialID []byte,
+	clientIP string, now time.Time) {
+	for _, data := range profile.WebauthnData {
+		if bytes.Equal(data.Credential.ID, credentialID) {
+			data.LastUsedAt = now
+			data.LastUsedAddr = clientIP
+			return
+		}
+	}
+	for _, data := range profile.U2fAuthData {
+		if bytes.Equal(data.Registration.KeyHandle, credentialID) {
+			data.LastUsedAt = now
+			data.LastUsedAddr = clientIP
+			return
+		}
+	}
+}
+
+// disableWebauthnToken disables the token with credentialID and returns its
+// name and stored sign counter.
+func (profile *userProfile) disableWebauthnToken(
+	credentialID []byte) (string, uint32) {
+	for _, data := range profile.WebauthnData {
+		if bytes.Equal(data.Credential.ID, credentialID) {
+			data.Enabled = false
+			return data.Name, data.Credential.Authenticator.SignCount
+		}
+	}
+	for _, data := range profile.U2fAuthData {
+		if bytes.Equal(data.Registration.KeyHandle, credentialID) {
+			data.Enabled = false
+			return data.Name, data.Counter
+		}
+	}
+	return "", 0
+}
+
+func (state *RuntimeState) webauthnAuthFinish(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, currentAuthLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+
+	profile, ok, fromCache, err := state.LoadUserProfile(authUser)
+	if err != nil {
+		logger.Printf("loading profile error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No regstered data", http.StatusBadRequest)
+		return
+	}
+	state.Mutex.Lock()
+	localAuth, ok := state.localAuthData[authUser]
+	state.Mutex.Unlock()
+	if !ok || localAuth.WebauthnLoginSession == nil {
+		http.Error(w, "challenge missing", http.StatusBadRequest)
+		return
+	}
+	relyingParty, err := newWebauthn()
+	if err != nil {
+		logger.Printf("webauthn config error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+	user := &webauthnUser{username: authUser, profile: profile}
+	credential, err := relyingParty.FinishLogin(user,
+		*localAuth.WebauthnLoginSession, r)
+	if err != nil {
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+		logger.Printf("webauthn FinishLogin error: %v", err)
+		http.Error(w, "error verifying response", http.StatusUnauthorized)
+		return
+	}
+	state.Mutex.Lock()
+	delete(state.localAuthData, authUser)
+	state.Mutex.Unlock()
+	if credential.Authenticator.CloneWarning {
+		metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, false)
+		tokenName, storedCounter := profile.disableWebauthnToken(credential.ID)
+		state.disableRegressedU2FToken(authUser, profile, fromCache,
+			tokenName, storedCounter, getClientIP(r))
+		http.Error(w, "token disabled", http.StatusUnauthorized)
+		return
+	}
+	metricLogAuthOperation(getClientType(r), proto.AuthTypeU2F, true)
+	if !fromCache {
+		profile.updateWebauthnCounter(credential)
+		profile.recordWebauthnUse(credential.ID, getClientIP(r), time.Now())
+		if err := state.SaveUserProfile(authUser, profile); err != nil {
+			logger.Printf("Saving profile error: %v", err)
+		}
+	}
+
+	_, isXHR := r.Header["X-Requested-With"]
+	if isXHR {
+		eventNotifier.PublishWebLoginEvent(authUser)
+	}
+	err = state.secondFactorAuthSucceeded(w, r, u2fProvider{}, authUser,
+		currentAuthLevel)
+	if err != nil {
+		logger.Printf("Auth Cookie NOT found ? %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "Failure updating auth cookie")
+		return
+	}
+	w.Write([]byte("success"))
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/tstranex/u2f"
+)
+
+func setupWebauthnState(t *testing.T) (*RuntimeState, *http.Cookie, func()) {
+	state := &RuntimeState{localAuthData: make(map[string]localUserData)}
+	signer, err := getSignerFromPEMBytes([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Signer = signer
+	state.signerPublicKeyToKeymasterKeys()
+	dir, err := ioutil.TempDir("", "webauthn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.DataDirectory = dir
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{"password"}
+	if err := initDB(state); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypePassword)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return state, &http.Cookie{Name: authCookieName, Value: cookieVal},
+		func() { os.RemoveAll(dir) }
+}
+
+// newTestU2fAuthData builds a U2F registration from raw registration data,
+// since that is what is kept when the profile is stored.
+func newTestU2fAuthData(t *testing.T) *u2fAuthData {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestU2fAuthDataWithKey(t, key)
+}
+
+func newTestU2fAuthDataWithKey(t *testing.T,
+	key *ecdsa.PrivateKey) *u2fAuthData {
+	template := x509.Certificate{SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "U2F Test Token"}}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHandle := []byte("keyhandle")
+	raw := []byte{0x05}
+	raw = append(raw, elliptic.Marshal(elliptic.P256(), key.X, key.Y)...)
+	raw = append(raw, byte(len(keyHandle)))
+	raw = append(raw, keyHandle...)
+	raw = append(raw, certDER...)
+	raw = append(raw, 0x30, 0x00) // Empty signature, not verified here.
+	var registration u2f.Registration
+	if err := registration.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	return &u2fAuthData{Enabled: true, Counter: 7, Registration: &registration}
+}
+
+func TestU2fRegistrationToCredential(t *testing.T) {
+	data := newTestU2fAuthData(t)
+	credential := u2fRegistrationToCredential(data)
+	if credential.AttestationType != protocol.CredentialTypeFIDOU2F {
+		t.Fatalf("bad attestation type: %s", credential.AttestationType)
+	}
+	if string(credential.ID) != "keyhandle" {
+		t.Fatal("key handle not used as credential ID")
+	}
+	if credential.Authenticator.SignCount != 7 {
+		t.Fatalf("bad sign count: %d", credential.Authenticator.SignCount)
+	}
+	key, err := webauthncose.ParseFIDOPublicKey(credential.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Registration.PubKey.X.Cmp(new(big.Int).SetBytes(key.XCoord)) != 0 {
+		t.Fatal("public key mismatch")
+	}
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: data},
+		WebauthnData: map[int64]*webauthnAuthData{},
+	}
+	credential.Authenticator.SignCount = 8
+	profile.updateWebauthnCounter(&credential)
+	if data.Counter != 8 {
+		t.Fatalf("U2F counter not updated: %d", data.Counter)
+	}
+}
+
+func TestWebauthnRegisterBegin(t *testing.T) {
+	state, authCookie, cleanup := setupWebauthnState(t)
+	defer cleanup()
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: newTestU2fAuthData(t)},
+		WebauthnData: map[int64]*webauthnAuthData{},
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", webauthnRegisterBeginPath+
+		"username?attachment=bogus", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnRegisterBegin,
+		http.StatusBadRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("GET", webauthnRegisterBeginPath+
+		"username?attachment=platform&resident_key=required", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	rr, err := checkRequestHandlerCode(req, state.webauthnRegisterBegin,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var creation protocol.CredentialCreation
+	if err := json.NewDecoder(rr.Body).Decode(&creation); err != nil {
+		t.Fatal(err)
+	}
+	options := creation.Response
+	if options.AuthenticatorSelection.AuthenticatorAttachment != protocol.Platform {
+		t.Fatalf("bad attachment: %+v", options.AuthenticatorSelection)
+	}
+	if options.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Fatalf("bad resident key: %+v", options.AuthenticatorSelection)
+	}
+	if len(options.CredentialExcludeList) != 1 ||
+		options.Extensions[protocol.ExtensionAppIDExclude] != u2fAppID {
+		t.Fatalf("U2F registration not excluded: %+v", options)
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.WebauthnID) != webauthnUserIDLen {
+		t.Fatal("webauthn user id not stored")
+	}
+	if profile.WebauthnRegistrationSession == nil {
+		t.Fatal("registration session not stored")
+	}
+
+	// Other users cannot register for username.
+	cookieVal, err := state.setNewAuthCookie(nil, "otheruser", AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("GET", webauthnRegisterBeginPath+"username", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	if _, err := checkRequestHandlerCode(req, state.webauthnRegisterBegin,
+		http.StatusUnauthorized); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebauthnAuthBeginWithU2fRegistration(t *testing.T) {
+	state, authCookie, cleanup := setupWebauthnState(t)
+	defer cleanup()
+
+	req, err := http.NewRequest("GET", webauthnAuthBeginPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnAuthBegin,
+		http.StatusBadRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: newTestU2fAuthData(t)},
+		WebauthnData: map[int64]*webauthnAuthData{},
+		WebauthnID:   []byte("webauthnid"),
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := checkRequestHandlerCode(req, state.webauthnAuthBegin,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assertion protocol.CredentialAssertion
+	if err := json.NewDecoder(rr.Body).Decode(&assertion); err != nil {
+		t.Fatal(err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 1 {
+		t.Fatalf("bad allowed credentials: %+v", assertion.Response)
+	}
+	if assertion.Response.Extensions[protocol.ExtensionAppID] != u2fAppID {
+		t.Fatalf("appid extension missing: %+v", assertion.Response.Extensions)
+	}
+	if state.localAuthData["username"].WebauthnLoginSession == nil {
+		t.Fatal("login session not stored")
+	}
+	hasCredentials, err := state.userHasWebauthnCredentials("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasCredentials {
+		t.Fatal("U2F registration should be usable with WebAuthn")
+	}
+}
+
+func TestU2fTokenManagerHandlerWebauthn(t *testing.T) {
+	state, authCookie, cleanup := setupWebauthnState(t)
+	defer cleanup()
+	profile := &userProfile{
+		U2fAuthData: map[int64]*u2fAuthData{},
+		WebauthnData: map[int64]*webauthnAuthData{
+			3: {Enabled: true, Name: "laptop",
+				Credential: webauthn.Credential{ID: []byte("id")}},
+		},
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("username", "username")
+	form.Add("index", "3")
+	form.Add("type", "webauthn")
+	form.Add("action", "Disable")
+	req, err := http.NewRequest("POST", u2fTokenManagementPath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := checkRequestHandlerCode(req, state.u2fTokenManagerHandler,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.WebauthnData[3].Enabled {
+		t.Fatal("webauthn credential not disabled")
+	}
+	hasCredentials, err := state.userHasWebauthnCredentials("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasCredentials {
+		t.Fatal("disabled credential still usable")
+	}
+}
+
+// testWebauthnAuthenticator is a software authenticator holding one P-256
+// key, which answers the requests of the finish handlers.
+type testWebauthnAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	counter      uint32
+}
+
+func newTestWebauthnAuthenticator(t *testing.T) *testWebauthnAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testWebauthnAuthenticator{key: key,
+		credentialID: []byte("credential-id")}
+}
+
+func (a *testWebauthnAuthenticator) clientData(t *testing.T,
+	ceremonyType string, challenge string) []byte {
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    u2fAppID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData
+}
+
+// authenticatorData returns the authenticator data for the relying party
+// rpID, with the user present flag set.
+func (a *testWebauthnAuthenticator) authenticatorData(rpID string,
+	flags byte, attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags|0x01)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.counter)
+	data = append(data, counter...)
+	return append(data, attestedData...)
+}
+
+// register returns the body of a registration finish request, with a
+// "none" attestation.
+func (a *testWebauthnAuthenticator) register(t *testing.T, rpID string,
+	challenge string) []byte {
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestedData := make([]byte, 16) // AAGUID.
+	credentialIDLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(credentialIDLen, uint16(len(a.credentialID)))
+	attestedData = append(attestedData, credentialIDLen...)
+	attestedData = append(attestedData, a.credentialID...)
+	attestedData = append(attestedData, publicKey...)
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(rpID, 0x40, attestedData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON": base64.RawURLEncoding.EncodeToString(
+				a.clientData(t, "webauthn.create", challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(
+				attestationObject),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// assert increments the counter and returns the body of an auth finish
+// request signed for the relying party rpID. If appID is true the appid
+// extension is reported as used, as for U2F registrations.
+func (a *testWebauthnAuthenticator) assert(t *testing.T, rpID string,
+	challenge string, appID bool) []byte {
+	a.counter++
+	authData := a.authenticatorData(rpID, 0, nil)
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...),
+		clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON": base64.RawURLEncoding.EncodeToString(
+				clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(
+				authData),
+			"signature": base64.RawURLEncoding.EncodeToString(signature),
+		},
+		"clientExtensionResults": map[string]bool{"appid": appID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func webauthnRPID(t *testing.T) string {
+	appID, err := url.Parse(u2fAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appID.Hostname()
+}
+
+// webauthnLogin begins a login and finishes it with the response of
+// authenticator, checking the status of the finish handler.
+func webauthnLogin(t *testing.T, state *RuntimeState, authCookie *http.Cookie,
+	authenticator *testWebauthnAuthenticator, rpID string, appID bool,
+	expectedStatus int) {
+	req, err := http.NewRequest("GET", webauthnAuthBeginPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnAuthBegin,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	challenge := state.localAuthData["username"].WebauthnLoginSession.Challenge
+	req, err = http.NewRequest("POST", webauthnAuthFinishPath,
+		bytes.NewReader(authenticator.assert(t, rpID, challenge, appID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnAuthFinish,
+		expectedStatus); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebauthnRegisterFinishAndLogin(t *testing.T) {
+	state, authCookie, cleanup := setupWebauthnState(t)
+	defer cleanup()
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{},
+		WebauthnData: map[int64]*webauthnAuthData{},
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", webauthnRegisterBeginPath+"username",
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnRegisterBegin,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := newTestWebauthnAuthenticator(t)
+	rpID := webauthnRPID(t)
+	challenge := profile.WebauthnRegistrationSession.Challenge
+
+	// A response for another relying party is rejected.
+	req, err = http.NewRequest("POST", webauthnRegisterFinishPath+"username",
+		bytes.NewReader(authenticator.register(t, "other.example.com",
+			challenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnRegisterFinish,
+		http.StatusBadRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("POST", webauthnRegisterFinishPath+"username",
+		bytes.NewReader(authenticator.register(t, rpID, challenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(authCookie)
+	if _, err := checkRequestHandlerCode(req, state.webauthnRegisterFinish,
+		http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.WebauthnRegistrationSession != nil {
+		t.Fatal("registration session not cleared")
+	}
+	if len(profile.WebauthnData) != 1 {
+		t.Fatalf("expected 1 credential, got %d", len(profile.WebauthnData))
+	}
+	for _, data := range profile.WebauthnData {
+		if !data.Enabled ||
+			!bytes.Equal(data.Credential.ID, authenticator.credentialID) {
+			t.Fatalf("bad stored credential: %+v", data)
+		}
+	}
+
+	webauthnLogin(t, state, authCookie, authenticator, rpID, false,
+		http.StatusOK)
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range profile.WebauthnData {
+		if data.Credential.Authenticator.SignCount != authenticator.counter {
+			t.Fatalf("sign count not stored: %d",
+				data.Credential.Authenticator.SignCount)
+		}
+		if data.LastUsedAt.IsZero() {
+			t.Fatal("last use not stored")
+		}
+	}
+}
+
+func TestWebauthnAuthFinishWithU2fRegistration(t *testing.T) {
+	state, authCookie, cleanup := setupWebauthnState(t)
+	defer cleanup()
+	authenticator := newTestWebauthnAuthenticator(t)
+	u2fData := newTestU2fAuthDataWithKey(t, authenticator.key)
+	authenticator.credentialID = u2fData.Registration.KeyHandle
+	authenticator.counter = u2fData.Counter
+	profile := &userProfile{
+		U2fAuthData:  map[int64]*u2fAuthData{1: u2fData},
+		WebauthnData: map[int64]*webauthnAuthData{},
+		WebauthnID:   []byte("webauthnid"),
+	}
+	if err := state.SaveUserProfile("username", profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old U2F key signs for the appid, which is only accepted with the
+	// appid extension.
+	webauthnLogin(t, state, authCookie, authenticator, u2fAppID, false,
+		http.StatusUnauthorized)
+	authenticator.counter--
+	webauthnLogin(t, state, authCookie, authenticator, u2fAppID, true,
+		http.StatusOK)
+	profile, _, _, err := state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.U2fAuthData[1].Counter != authenticator.counter {
+		t.Fatalf("U2F counter not updated: %d", profile.U2fAuthData[1].Counter)
+	}
+	if profile.U2fAuthData[1].LastUsedAt.IsZero() {
+		t.Fatal("last use not stored")
+	}
+
+	// A counter that does not increase means a cloned key.
+	authenticator.counter--
+	webauthnLogin(t, state, authCookie, authenticator, u2fAppID, true,
+		http.StatusUnauthorized)
+	profile, _, _, err = state.LoadUserProfile("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.U2fAuthData[1].Enabled {
+		t.Fatal("cloned U2F token not disabled")
+	}
+}
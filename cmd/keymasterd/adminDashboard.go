@@ -47,6 +47,10 @@ func (dashboard *adminDashboardType) ServeHTTP(w http.ResponseWriter,
 		dashboard.htmlWriter.WriteHtml(writer)
 	}
 	fmt.Fprintln(writer, "</h3>")
+	fmt.Fprintf(writer, "<a href=\"%s\">Certificate audit log</a><br>\n",
+		certAuditPath)
+	fmt.Fprintf(writer, "<a href=\"%s\">CA rotation</a><br>\n",
+		caRotationPath)
 	fmt.Fprintln(writer, "<hr>")
 	if Version != "" {
 		fmt.Fprintf(writer, "Keymasterd version: %s <br>", Version)
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/tls"
@@ -16,6 +15,7 @@ import (
 	"html/template"
 	"io/ioutil"
 	stdlog "log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -36,18 +36,20 @@ import (
 	"github.com/Symantec/keymaster/lib/authutil"
 	"github.com/Symantec/keymaster/lib/certgen"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"github.com/Symantec/keymaster/lib/kerberos"
 	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/Symantec/keymaster/lib/pwauth/okta"
+	"github.com/Symantec/keymaster/lib/pwauth/radius"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
 	"github.com/Symantec/keymaster/proto/eventmon"
 	"github.com/Symantec/tricorder/go/healthserver"
 	"github.com/Symantec/tricorder/go/tricorder"
 	"github.com/Symantec/tricorder/go/tricorder/units"
 	"github.com/cloudflare/cfssl/revoke"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tstranex/u2f"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/net/context"
 )
 
@@ -58,12 +60,19 @@ const (
 	AuthTypeU2F
 	AuthTypeSymantecVIP
 	AuthTypeIPCertificate
+	AuthTypeTOTP
+	AuthTypePushApproval
+	AuthTypeDuo
+	AuthTypeEnrollmentBypass
+	AuthTypeOkta
+	AuthTypeRADIUS
 )
 
 const AuthTypeAny = 0xFFFF
 
 type authInfo struct {
 	ExpiresAt time.Time
+	IssuedAt  time.Time
 	Username  string
 	AuthType  int
 }
@@ -98,17 +107,43 @@ type u2fAuthData struct {
 	Counter      uint32
 	Name         string
 	Registration *u2f.Registration
+	LastUsedAt   time.Time
+	LastUsedAddr string
+}
+
+type webauthnAuthData struct {
+	Enabled      bool
+	CreatedAt    time.Time
+	CreatorAddr  string
+	Name         string
+	Credential   webauthn.Credential
+	LastUsedAt   time.Time
+	LastUsedAddr string
 }
 
 type userProfile struct {
 	U2fAuthData           map[int64]*u2fAuthData
 	RegistrationChallenge *u2f.Challenge
 	//U2fAuthChallenge      *u2f.Challenge
+	WebauthnID                  []byte
+	WebauthnData                map[int64]*webauthnAuthData
+	WebauthnRegistrationSession *webauthn.SessionData
+	// TOTP keys are otpauth URLs encrypted with the TOTP encryption key.
+	TOTPKey           []byte
+	TOTPPendingKey    []byte
+	TOTPEnrolledAt    time.Time
+	TOTPLastCounter   uint64
+	TOTPRecoveryCodes [][]byte
+	// An enrollment bypass lets a user who lost all their second factors
+	// enroll a new one. Only the hash of the one-time token is stored.
+	EnrollmentBypassHash      []byte
+	EnrollmentBypassExpiresAt time.Time
 }
 
 type localUserData struct {
-	U2fAuthChallenge *u2f.Challenge
-	ExpiresAt        time.Time
+	U2fAuthChallenge     *u2f.Challenge
+	WebauthnLoginSession *webauthn.SessionData
+	ExpiresAt            time.Time
 }
 
 type pendingAuth2Request struct {
@@ -121,12 +156,14 @@ type pushPollTransaction struct {
 	ExpiresAt     time.Time
 	Username      string
 	TransactionID string
+	Provider      string
 }
 
 type RuntimeState struct {
 	Config              AppConfigFile
 	SSHCARawFileContent []byte
 	Signer              crypto.Signer
+	HostSigner          crypto.Signer
 	ClientCAPool        *x509.CertPool
 	HostIdentity        string
 	KerberosRealm       *string
@@ -147,6 +184,32 @@ type RuntimeState struct {
 	passwordChecker      pwauth.PasswordAuthenticator
 	KeymasterPublicKeys  []crypto.PublicKey
 	isAdminCache         *admincache.Cache
+	// CA rotation state, see ca_rotation.go
+	NextSigner              crypto.Signer
+	nextCARawFileContent    []byte
+	nextCACertDer           []byte
+	caRotationTime          time.Time
+	retiredCAPublicKey      crypto.PublicKey
+	retiredCASigner         crypto.Signer
+	retiredCACertDer        []byte
+	retiredCAPublishedUntil time.Time
+	// Threshold unsealing state, see unseal_shares.go
+	unsealShares    map[string][]byte
+	unsealStartedAt time.Time
+	peerCertificate *tls.Certificate
+	// Key for the TOTP secrets in user profiles, see 2fa_totp.go
+	totpEncryptionKey *[32]byte
+	totpUserLocks     map[string]*totpUserLock
+	// Push approval webhook state, see 2fa_push.go
+	pushApprovalSecret []byte
+	pushApprovals      map[string]pushApprovalState
+	// Okta MFA and group lookups, see 2fa_okta.go
+	oktaAuthenticator *okta.PasswordAuthenticator
+	oktaUserInfo      *okta.UserInfo
+	// RADIUS challenges, see 2fa_radius.go
+	radiusAuthenticator *radius.PasswordAuthenticator
+	// Kerberos SPNEGO logins, see auth_kerberos.go
+	kerberosAuthenticator *kerberos.Authenticator
 }
 
 const redirectPath = "/auth/oauth2/callback"
@@ -159,6 +222,10 @@ var (
 		"The filename of the configuration")
 	generateConfig = flag.Bool("generateConfig", false,
 		"Generate new valid configuration")
+	unsealShares = flag.Int("unsealShares", 0,
+		"With -generateConfig, split the CA passphrase into this many shares")
+	unsealThreshold = flag.Int("unsealThreshold", 2,
+		"With -unsealShares, the number of shares required to unseal")
 	u2fAppID         = "https://www.example.com:33443"
 	u2fTrustedFacets = []string{}
 
@@ -186,6 +253,14 @@ var (
 		},
 		[]string{"service_name"},
 	)
+	ldapServerDurationTotal = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "keymaster_ldap_server_request_duration",
+			Help:    "Time spent in operations answered by each LDAP server in ms",
+			Buckets: []float64{5, 7.5, 10, 15, 25, 50, 75, 100, 150, 250, 500, 750, 1000, 1500, 2500, 5000},
+		},
+		[]string{"server", "operation"},
+	)
 	tricorderLDAPExternalServiceDurationTotal    = tricorder.NewGeometricBucketer(5, 5000.0).NewCumulativeDistribution()
 	tricorderStorageExternalServiceDurationTotal = tricorder.NewGeometricBucketer(1, 2000.0).NewCumulativeDistribution()
 	tricorderVIPExternalServiceDurationTotal     = tricorder.NewGeometricBucketer(5, 5000.0).NewCumulativeDistribution()
@@ -228,6 +303,14 @@ func metricLogExternalServiceDuration(service string, duration time.Duration) {
 	}
 }
 
+func metricLogLDAPServerDuration(server string, operation string,
+	duration time.Duration) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	ldapServerDurationTotal.WithLabelValues(server, operation).Observe(
+		duration.Seconds() * 1000)
+}
+
 func metricLogCertDuration(certType string, stage string, val float64) {
 	metricsMutex.Lock()
 	defer metricsMutex.Unlock()
@@ -290,6 +373,12 @@ func (state *RuntimeState) performStateCleanup(secsBetweenCleanup int) {
 			}
 
 		}
+		for key, approval := range state.pushApprovals {
+			if approval.ExpiresAt.Before(time.Now()) {
+				delete(state.pushApprovals, key)
+			}
+		}
+		state.applyCARotation(time.Now())
 
 		state.Mutex.Unlock()
 		logger.Debugf(3, "Pending Cookie sizes: before(%d) after(%d)",
@@ -372,12 +461,20 @@ func browserSupportsU2F(r *http.Request) bool {
 		strings.Contains(r.UserAgent(), "Firefox/59") ||
 		strings.Contains(r.UserAgent(), "Firefox/6") ||
 		strings.Contains(r.UserAgent(), "Firefox/7") ||
-		strings.Contains(r.UserAgent(), "Firefox/8") {
+		strings.Contains(r.UserAgent(), "Firefox/8") ||
+		firefoxWebauthnRegexp.MatchString(r.UserAgent()) {
+		return true
+	}
+	// Safari only supports WebAuthn, which also covers U2F tokens.
+	if strings.Contains(r.UserAgent(), "Safari/") &&
+		strings.Contains(r.UserAgent(), "Version/") {
 		return true
 	}
 	return false
 }
 
+var firefoxWebauthnRegexp = regexp.MustCompile("Firefox/[1-9][0-9]{2}")
+
 func getClientType(r *http.Request) string {
 	if r == nil {
 		return "unknown"
@@ -397,18 +494,33 @@ func getClientType(r *http.Request) string {
 	}
 }
 
+// getClientIP returns the IP address of the client of r.
+func getClientIP(r *http.Request) string {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return clientIP
+}
+
 func (state *RuntimeState) writeHTML2FAAuthPage(w http.ResponseWriter, r *http.Request,
 	loginDestination string, tryShowU2f bool) error {
 	JSSources := []string{"/static/jquery-3.4.1.min.js", "/static/u2f-api.js", "/static/webui-2fa-symc-vip.js"}
 	showU2F := browserSupportsU2F(r) && tryShowU2f
 	if showU2F {
-		JSSources = append(JSSources, "/static/webui-2fa-u2f.js")
+		JSSources = append(JSSources, "/static/keymaster-webauthn.js", "/static/webui-2fa-u2f.js")
 	}
+	pushProvider, showPush := state.getPushProvider()
 	displayData := secondFactorAuthTemplateData{
 		Title:            "Keymaster 2FA Auth",
 		JSSources:        JSSources,
 		ShowOTP:          state.Config.SymantecVIP.Enabled,
+		ShowTOTP:         state.Config.TOTP.Enabled,
+		ShowDuo:          state.Config.Duo.Enabled,
+		ShowOkta:         oktaProvider{}.Enabled(state),
+		ShowRADIUS:       radiusProvider{}.Enabled(state),
 		ShowU2F:          showU2F,
+		ShowPush:         showPush && pushProvider.Name() != proto.AuthTypeSymantecVIP,
 		LoginDestination: loginDestination}
 	err := state.htmlTemplate.ExecuteTemplate(w, "secondFactorLoginPage", displayData)
 	if err != nil {
@@ -442,6 +554,10 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter, r *http.R
 	if code == http.StatusUnauthorized && returnAcceptType != "text/html" {
 		w.Header().Set("WWW-Authenticate", `Basic realm="User Credentials"`)
 	}
+	if code == http.StatusUnauthorized && r.URL.Path == proto.LoginPath &&
+		state.kerberosAuthenticator != nil {
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+	}
 	w.WriteHeader(code)
 	publicErrorText := fmt.Sprintf("%d %s %s\n", code, http.StatusText(code), message)
 	setSecurityHeaders(w)
@@ -458,7 +574,8 @@ func (state *RuntimeState) writeFailureResponse(w http.ResponseWriter, r *http.R
 				authCookie = cookie
 			}
 			loginDestnation := profilePath
-			if r.URL.Path == idpOpenIDCAuthorizationPath {
+			if r.URL.Path == idpOpenIDCAuthorizationPath ||
+				r.URL.Path == enrollmentBypassPath {
 				loginDestnation = r.URL.String()
 			}
 			if r.Method == "POST" {
@@ -645,6 +762,16 @@ func (state *RuntimeState) checkAuth(w http.ResponseWriter, r *http.Request, req
 				state.writeFailureResponse(w, r, http.StatusUnauthorized, "revoked Cert")
 				return "", AuthTypeNone, fmt.Errorf("checkAuth: IP cert is revoked")
 			}
+			// Certs we issued ourselves are checked against our own ledger
+			revoked, err = state.isOwnX509CertRevoked(userCert)
+			if err != nil {
+				logger.Printf("Error checking local revocation of IP restricted cert: %s", err)
+			}
+			if revoked {
+				logger.Printf("Cert is revoked locally")
+				state.writeFailureResponse(w, r, http.StatusUnauthorized, "revoked Cert")
+				return "", AuthTypeNone, fmt.Errorf("checkAuth: IP cert is revoked")
+			}
 			return clientName, AuthTypeIPCertificate, nil
 
 		}
@@ -725,17 +852,19 @@ func (state *RuntimeState) getRequiredWebUIAuthLevel() int {
 		if webUIPref == proto.AuthTypeFederated {
 			AuthLevel |= AuthTypeFederated
 		}
-		if webUIPref == proto.AuthTypeU2F {
-			AuthLevel |= AuthTypeU2F
-		}
-
-		if webUIPref == proto.AuthTypeSymantecVIP {
-			AuthLevel |= AuthTypeSymantecVIP
+		if provider, ok := getSecondFactorProvider(webUIPref); ok {
+			AuthLevel |= provider.AuthType()
 		}
 	}
 	return AuthLevel
 }
 
+// getRequiredEnrollmentAuthLevel returns the auth level needed to manage
+// one's own second factors, which also accepts an enrollment bypass.
+func (state *RuntimeState) getRequiredEnrollmentAuthLevel() int {
+	return state.getRequiredWebUIAuthLevel() | AuthTypeEnrollmentBypass
+}
+
 const secretInjectorPath = "/admin/inject"
 
 func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.Request) {
@@ -756,12 +885,6 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 	clientName := r.TLS.VerifiedChains[0][0].Subject.CommonName
 	logger.Printf("Got connection from %s", clientName)
 	r.ParseForm()
-	sshCAPassword, ok := r.Form["ssh_ca_password"]
-	if !ok {
-		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Post, missing data")
-		logger.Printf("missing ssh_ca_password")
-		return
-	}
 	state.Mutex.Lock()
 	defer state.Mutex.Unlock()
 
@@ -772,48 +895,55 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	decbuf := bytes.NewBuffer(state.SSHCARawFileContent)
-
-	armorBlock, err := armor.Decode(decbuf)
-	if err != nil {
-		logger.Printf("Cannot decode armored file")
+	password, ok := state.getUnsealPassword(w, r, clientName)
+	if !ok {
 		return
 	}
-	password := []byte(sshCAPassword[0])
-	failed := false
-	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
-		// If the given passphrase isn't correct, the function will be called again, forever.
-		// This method will fail fast.
-		// Ref: https://godoc.org/golang.org/x/crypto/openpgp#PromptFunction
-		if failed {
-			return nil, errors.New("decryption failed")
+	var signer crypto.Signer
+	var err error
+	if state.Config.Base.SignerType == signerTypePKCS11 {
+		// The password is the PIN of the token
+		signer, err = newPKCS11Signer(state.Config.Base, string(password))
+		if err != nil {
+			logger.Printf("Cannot open PKCS#11 key: %s", err)
+			state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Unlocking key")
+			return
+		}
+	} else {
+		plaintextBytes, err := decryptArmoredPrivateKey(
+			state.SSHCARawFileContent, password)
+		if err != nil {
+			logger.Printf("cannot read message: %s", err)
+			state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Unlocking key")
+			return
+		}
+		signer, err = getSignerFromPEMBytes(plaintextBytes)
+		if err != nil {
+			logger.Printf("Cannot parse Priave Key file")
+			return
 		}
-		failed = true
-		return password, nil
-	}
-	md, err := openpgp.ReadMessage(armorBlock.Body, nil, prompt, nil)
-	if err != nil {
-		logger.Printf("cannot read message")
-		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Unlocking key")
-		return
 	}
 
-	plaintextBytes, err := ioutil.ReadAll(md.UnverifiedBody)
-	if err != nil {
+	logger.Printf("About to generate cader %s", clientName)
+	if err := state.installUnsealedSigner(signer, nil, password); err != nil {
+		logger.Printf("Cannot generate CA Der")
 		return
 	}
 
-	signer, err := getSignerFromPEMBytes(plaintextBytes)
-	if err != nil {
-		logger.Printf("Cannot parse Priave Key file")
-		return
-	}
+	// TODO... make success a goroutine
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "OK\n")
+	//fmt.Fprintf(w, "%+v\n", r.TLS)
+}
 
-	logger.Printf("About to generate cader %s", clientName)
-	state.caCertDer, err = generateCADer(state, signer)
+// installUnsealedSigner makes signer the CA key of a sealed instance. The next
+// CA key is nextSigner if given, else it is loaded using password. Must be
+// called with the mutex held.
+func (state *RuntimeState) installUnsealedSigner(signer, nextSigner crypto.Signer,
+	password []byte) error {
+	caCertDer, err := generateCADer(state, signer)
 	if err != nil {
-		logger.Printf("Cannot generate CA Der")
-		return
+		return err
 	}
 	sendMessage := false
 	if state.Signer == nil {
@@ -822,16 +952,22 @@ func (state *RuntimeState) secretInjectorHandler(w http.ResponseWriter, r *http.
 
 	// Assignmet of signer MUST be the last operation after
 	// all error checks
+	state.caCertDer = caCertDer
 	state.Signer = signer
 	state.signerPublicKeyToKeymasterKeys()
+	if nextSigner != nil {
+		err = state.setNextCASigner(nextSigner)
+	} else {
+		err = state.loadNextCASigner(password)
+	}
+	if err != nil {
+		logger.Printf("Cannot load next CA key: %s", err)
+	}
+	state.applyCARotation(time.Now())
 	if sendMessage {
 		state.SignerIsReady <- true
 	}
-
-	// TODO... make success a goroutine
-	w.WriteHeader(200)
-	fmt.Fprintf(w, "OK\n")
-	//fmt.Fprintf(w, "%+v\n", r.TLS)
+	return nil
 }
 
 const publicPath = "/public/"
@@ -861,11 +997,24 @@ func (state *RuntimeState) publicPathHandler(w http.ResponseWriter, r *http.Requ
 		state.writeHTMLLoginPage(w, r, profilePath, "")
 		return
 	case "x509ca":
-		pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: state.caCertDer}))
+		// The current CA comes first, followed by the next and retired CAs
+		_, certsDer := state.publishedCAKeys()
+		var pemCert string
+		for _, certDer := range certsDer {
+			pemCert += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}))
+		}
 
 		w.Header().Set("Content-Disposition", `attachment; filename="id_rsa-cert.pub"`)
 		w.WriteHeader(200)
 		fmt.Fprintf(w, "%s", pemCert)
+	case sshCAPublicName:
+		state.writeSSHCAPublicKeys(w, r)
+	case sshHostCAPublicName:
+		state.writeSSHHostCAPublicKey(w, r)
+	case sshKRLPublicName, x509CRLPublicName, retiredX509CRLPublicName:
+		state.writeRevocationList(w, r, target)
+	case kubernetesClustersPublicName:
+		state.writeKubernetesClusters(w, r)
 	default:
 		state.writeFailureResponse(w, r, http.StatusNotFound, "")
 		return
@@ -890,6 +1039,21 @@ func (state *RuntimeState) userHasU2FTokens(username string) (bool, error) {
 
 }
 
+// userHasWebauthnCredentials reports whether the user can authenticate in
+// the browser with WebAuthn, either with a WebAuthn credential or with a
+// legacy U2F registration.
+func (state *RuntimeState) userHasWebauthnCredentials(username string) (bool, error) {
+	profile, ok, _, err := state.LoadUserProfile(username)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	user := &webauthnUser{username: username, profile: profile}
+	return len(user.WebAuthnCredentials()) > 0, nil
+}
+
 const authCookieName = "auth_cookie"
 const vipTransactionCookieName = "vip_push_cookie"
 const maxAgeSecondsVIPCookie = 120
@@ -953,9 +1117,18 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	kerberosUsername, err := state.getKerberosUsername(r)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, "Invalid Kerberos token")
+		logger.Printf("Invalid Kerberos login: %s", err)
+		return
+	}
+
 	//First headers and then check form
 	username, password, ok := r.BasicAuth()
-	if !ok {
+	if kerberosUsername != "" {
+		username = kerberosUsername
+	} else if !ok {
 		//var username string
 		if val, ok := r.Form["username"]; ok {
 			if len(val) > 1 {
@@ -984,35 +1157,42 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	if !state.Config.Base.DisableUsernameNormalization {
 		username = strings.ToLower(username)
 	}
-	valid, err := checkUserPassword(username, password, state.Config, state.passwordChecker, r)
-	if err != nil {
-		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
-		return
-	}
-	if !valid {
-		state.writeFailureResponse(w, r, http.StatusUnauthorized, "Invalid Username/Password")
-		logger.Printf("Invalid login for %s", username)
-		//err := errors.New("Invalid Credentials")
-		return
-	}
+	eventAuthType := eventmon.AuthTypePassword
+	if kerberosUsername != "" {
+		eventAuthType = eventmon.AuthTypeKerberos
+		logger.Debugf(1, "Valid Kerberos AUTH login for %s", username)
+	} else {
+		valid, err := checkUserPassword(username, password, state.Config, state.passwordChecker, r)
+		if err != nil {
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if !valid {
+			state.writeFailureResponse(w, r, http.StatusUnauthorized, "Invalid Username/Password")
+			logger.Printf("Invalid login for %s", username)
+			//err := errors.New("Invalid Credentials")
+			return
+		}
 
-	// AUTHN has passed
-	logger.Debug(1, "Valid passwd AUTH login for %s", username)
-	userHasU2FTokens, err := state.userHasU2FTokens(username)
+		// AUTHN has passed
+		logger.Debug(1, "Valid passwd AUTH login for %s", username)
+	}
+	// Compute the cert prefs
+	certBackends, err := state.getCertBackends(username)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
 		logger.Println(err)
 		return
 	}
 
-	//
+	// A Kerberos login gives the same auth level as a password.
 	_, err = state.setNewAuthCookie(w, username, AuthTypePassword)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
 		logger.Println(err)
 		return
 	}
-	eventNotifier.PublishAuthEvent(eventmon.AuthTypePassword, username)
+	eventNotifier.PublishAuthEvent(eventAuthType, username)
 
 	returnAcceptType := "application/json"
 	acceptHeader, ok := r.Header["Accept"]
@@ -1025,22 +1205,11 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Compute the cert prefs
-	var certBackends []string
-	for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
-		if certPref == proto.AuthTypePassword {
-			certBackends = append(certBackends, proto.AuthTypePassword)
-		}
-		if certPref == proto.AuthTypeU2F && userHasU2FTokens {
-			certBackends = append(certBackends, proto.AuthTypeU2F)
-		}
-		if certPref == proto.AuthTypeSymantecVIP && state.Config.SymantecVIP.Enabled {
-			certBackends = append(certBackends, proto.AuthTypeSymantecVIP)
-		}
-	}
-	// logger.Printf("current backends=%+v", certBackends)
-	if len(certBackends) == 0 {
-		certBackends = append(certBackends, proto.AuthTypeU2F)
+	err = state.beginSecondFactorChallenges(w, r, username)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "error internal")
+		logger.Println(err)
+		return
 	}
 
 	// TODO: The cert backend should depend also on per user preferences.
@@ -1050,46 +1219,19 @@ func (state *RuntimeState) loginHandler(w http.ResponseWriter, r *http.Request)
 	case "text/html":
 		loginDestination := getLoginDestination(r)
 		requiredAuth := state.getRequiredWebUIAuthLevel()
-		if (requiredAuth & AuthTypePassword) != 0 {
+		if (requiredAuth&AuthTypePassword) != 0 ||
+			strings.HasPrefix(loginDestination, enrollmentBypassPath) {
 			eventNotifier.PublishWebLoginEvent(username)
 			http.Redirect(w, r, loginDestination, 302)
 		} else {
 			//Go 2FA
-			if (requiredAuth & AuthTypeSymantecVIP) == AuthTypeSymantecVIP {
-				// set VIP cookie
-				cookieValue, err := genRandomString()
-				if err == nil { //Beware inverted Logic
-
-					expiration := time.Now().Add(maxAgeSecondsVIPCookie * time.Second)
-					vipPushCookie := http.Cookie{Name: vipTransactionCookieName,
-						Value: cookieValue, Expires: expiration,
-						Path: "/", HttpOnly: true, Secure: true}
-					http.SetCookie(w, &vipPushCookie)
-				}
+			showWebauthn, err := state.userHasWebauthnCredentials(username)
+			if err != nil {
+				logger.Println(err)
 			}
-			state.writeHTML2FAAuthPage(w, r, loginDestination, userHasU2FTokens)
+			state.writeHTML2FAAuthPage(w, r, loginDestination, showWebauthn)
 		}
 	default:
-		// add vippush cookie if we are using VIP
-		usesVIP := false
-		for _, certPref := range state.Config.Base.AllowedAuthBackendsForCerts {
-			if certPref == proto.AuthTypeSymantecVIP && state.Config.SymantecVIP.Enabled {
-				usesVIP = true
-			}
-		}
-		requiredWebAuth := state.getRequiredWebUIAuthLevel()
-		usesVIP = usesVIP || ((requiredWebAuth & AuthTypeSymantecVIP) == AuthTypeSymantecVIP)
-		if usesVIP {
-			cookieValue, err := genRandomString()
-			if err == nil { //Beware inverted Logic
-				expiration := time.Now().Add(maxAgeSecondsVIPCookie * time.Second)
-				vipPushCookie := http.Cookie{Name: vipTransactionCookieName,
-					Value: cookieValue, Expires: expiration,
-					Path: "/", HttpOnly: true, Secure: true}
-				http.SetCookie(w, &vipPushCookie)
-			}
-		}
-
 		w.WriteHeader(200)
 		json.NewEncoder(w).Encode(loginResponse)
 		//fmt.Fprintf(w, "Success!")
@@ -1242,7 +1384,7 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	/*
 	 */
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
 	if err != nil {
 		logger.Debugf(1, "%v", err)
 		return
@@ -1274,17 +1416,34 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 	JSSources := []string{"/static/jquery-3.4.1.min.js"}
 	showU2F := browserSupportsU2F(r)
 	if showU2F {
-		JSSources = append(JSSources, "/static/u2f-api.js", "/static/keymaster-u2f.js")
+		JSSources = append(JSSources, "/static/u2f-api.js",
+			"/static/keymaster-webauthn.js", "/static/keymaster-u2f.js")
 	}
 
 	var devices []registeredU2FTokenDisplayInfo
 	for i, tokenInfo := range profile.U2fAuthData {
 
 		deviceData := registeredU2FTokenDisplayInfo{
-			DeviceData: fmt.Sprintf("%+v", tokenInfo.Registration.AttestationCert.Subject.CommonName),
-			Enabled:    tokenInfo.Enabled,
-			Name:       tokenInfo.Name,
-			Index:      i}
+			DeviceData:   fmt.Sprintf("%+v", tokenInfo.Registration.AttestationCert.Subject.CommonName),
+			Enabled:      tokenInfo.Enabled,
+			Name:         tokenInfo.Name,
+			Index:        i,
+			Type:         "u2f",
+			LastUsedAt:   tokenInfo.LastUsedAt,
+			LastUsedAddr: tokenInfo.LastUsedAddr}
+		devices = append(devices, deviceData)
+	}
+	for i, tokenInfo := range profile.WebauthnData {
+		deviceData := registeredU2FTokenDisplayInfo{
+			DeviceData: strings.TrimSpace(fmt.Sprintf("WebAuthn %s %s",
+				tokenInfo.Credential.AttestationType,
+				tokenInfo.Credential.Authenticator.Attachment)),
+			Enabled:      tokenInfo.Enabled,
+			Name:         tokenInfo.Name,
+			Index:        i,
+			Type:         "webauthn",
+			LastUsedAt:   tokenInfo.LastUsedAt,
+			LastUsedAddr: tokenInfo.LastUsedAddr}
 		devices = append(devices, deviceData)
 	}
 	sort.Slice(devices, func(i, j int) bool {
@@ -1304,7 +1463,10 @@ func (state *RuntimeState) profileHandler(w http.ResponseWriter, r *http.Request
 		JSSources:       JSSources,
 		ReadOnlyMsg:     readOnlyMsg,
 		UsersLink:       state.IsAdminUser(authUser),
-		RegisteredToken: devices}
+		RegisteredToken: devices,
+		ShowTOTP:        state.Config.TOTP.Enabled,
+		TOTPEnrolled:    len(profile.TOTPKey) > 0,
+		RecoveryCodes:   len(profile.TOTPRecoveryCodes)}
 	logger.Debugf(1, "%v", displayData)
 
 	err = state.htmlTemplate.ExecuteTemplate(w, "userProfilePage", displayData)
@@ -1328,7 +1490,7 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	/*
 	 */
 	// TODO(camilo_viecco1): reorder checks so that simple checks are done before checking user creds
-	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredWebUIAuthLevel())
+	authUser, loginLevel, err := state.checkAuth(w, r, state.getRequiredEnrollmentAuthLevel())
 	if err != nil {
 		logger.Debugf(1, "%v", err)
 		http.Error(w, "error", http.StatusInternalServerError)
@@ -1378,8 +1540,24 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	}
 
 	// Todo: check for negative values
-	_, ok := profile.U2fAuthData[tokenIndex]
-	if !ok {
+	var tokenName *string
+	var tokenEnabled *bool
+	var deleteToken func()
+	switch r.Form.Get("type") {
+	case "", "u2f":
+		tokenData, ok := profile.U2fAuthData[tokenIndex]
+		if ok {
+			tokenName, tokenEnabled = &tokenData.Name, &tokenData.Enabled
+			deleteToken = func() { delete(profile.U2fAuthData, tokenIndex) }
+		}
+	case "webauthn":
+		tokenData, ok := profile.WebauthnData[tokenIndex]
+		if ok {
+			tokenName, tokenEnabled = &tokenData.Name, &tokenData.Enabled
+			deleteToken = func() { delete(profile.WebauthnData, tokenIndex) }
+		}
+	}
+	if deleteToken == nil {
 		//if tokenIndex >= len(profile.U2fAuthData) {
 		logger.Printf("bad index number")
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "bad index Value")
@@ -1390,19 +1568,19 @@ func (state *RuntimeState) u2fTokenManagerHandler(w http.ResponseWriter, r *http
 	actionName := r.Form.Get("action")
 	switch actionName {
 	case "Update":
-		tokenName := r.Form.Get("name")
-		if m, _ := regexp.MatchString("^[-/.a-zA-Z0-9_ ]+$", tokenName); !m {
-			logger.Printf("%s", tokenName)
+		newName := r.Form.Get("name")
+		if m, _ := regexp.MatchString("^[-/.a-zA-Z0-9_ ]+$", newName); !m {
+			logger.Printf("%s", newName)
 			state.writeFailureResponse(w, r, http.StatusBadRequest, "invalidtokenName")
 			return
 		}
-		profile.U2fAuthData[tokenIndex].Name = tokenName
+		*tokenName = newName
 	case "Disable":
-		profile.U2fAuthData[tokenIndex].Enabled = false
+		*tokenEnabled = false
 	case "Enable":
-		profile.U2fAuthData[tokenIndex].Enabled = true
+		*tokenEnabled = true
 	case "Delete":
-		delete(profile.U2fAuthData, tokenIndex)
+		deleteToken()
 	default:
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Operation")
 		return
@@ -1484,6 +1662,7 @@ func init() {
 	prometheus.MustRegister(certGenCounter)
 	prometheus.MustRegister(authOperationCounter)
 	prometheus.MustRegister(externalServiceDurationTotal)
+	prometheus.MustRegister(ldapServerDurationTotal)
 	prometheus.MustRegister(certDurationHistogram)
 	tricorder.RegisterMetric(
 		"keymaster/external-service-duration/LDAP",
@@ -1550,6 +1729,11 @@ func main() {
 	http.Handle("/", adminDashboard)
 	http.Handle("/prometheus_metrics", promhttp.Handler()) //lint:ignore SA1019 TODO: newer prometheus handler
 	http.HandleFunc(secretInjectorPath, runtimeState.secretInjectorHandler)
+	http.HandleFunc(certAuditPath, runtimeState.certAuditHandler)
+	http.HandleFunc(certAuditAPIPath, runtimeState.certAuditAPIHandler)
+	http.HandleFunc(caRotationPath, runtimeState.caRotationHandler)
+	http.HandleFunc(unsealStatusPath, runtimeState.unsealStatusHandler)
+	http.HandleFunc(peerUnsealPath, runtimeState.peerUnsealHandler)
 
 	serviceMux := http.NewServeMux()
 	serviceMux.HandleFunc(certgenPath, runtimeState.certGenHandler)
@@ -1558,6 +1742,10 @@ func main() {
 	serviceMux.HandleFunc(logoutPath, runtimeState.logoutHandler)
 	serviceMux.HandleFunc(profilePath, runtimeState.profileHandler)
 	serviceMux.HandleFunc(usersPath, runtimeState.usersHandler)
+	serviceMux.HandleFunc(secondFactorAdminPath, runtimeState.secondFactorAdminHandler)
+	serviceMux.HandleFunc(resetSecondFactorPath, runtimeState.resetSecondFactorHandler)
+	serviceMux.HandleFunc(issueEnrollmentBypassPath, runtimeState.issueEnrollmentBypassHandler)
+	serviceMux.HandleFunc(enrollmentBypassPath, runtimeState.enrollmentBypassHandler)
 
 	serviceMux.HandleFunc(idpOpenIDCConfigurationDocumentPath, runtimeState.idpOpenIDCDiscoveryHandler)
 	serviceMux.HandleFunc(idpOpenIDCJWKSPath, runtimeState.idpOpenIDCJWKSHandler)
@@ -1574,14 +1762,27 @@ func main() {
 	serviceMux.HandleFunc(u2fRegustisterRequestPath, runtimeState.u2fRegisterRequest)
 	serviceMux.HandleFunc(u2fRegisterRequesponsePath, runtimeState.u2fRegisterResponse)
 	serviceMux.HandleFunc(u2fSignRequestPath, runtimeState.u2fSignRequest)
-	serviceMux.HandleFunc(u2fSignResponsePath, runtimeState.u2fSignResponse)
-	serviceMux.HandleFunc(vipAuthPath, runtimeState.VIPAuthHandler)
+	serviceMux.HandleFunc(webauthnRegisterBeginPath, runtimeState.webauthnRegisterBegin)
+	serviceMux.HandleFunc(webauthnRegisterFinishPath, runtimeState.webauthnRegisterFinish)
+	serviceMux.HandleFunc(webauthnAuthBeginPath, runtimeState.webauthnAuthBegin)
+	serviceMux.HandleFunc(webauthnAuthFinishPath, runtimeState.webauthnAuthFinish)
 	serviceMux.HandleFunc(u2fTokenManagementPath, runtimeState.u2fTokenManagerHandler)
+	serviceMux.HandleFunc(totpEnrollPath, runtimeState.totpEnrollHandler)
+	for _, provider := range secondFactorProviders {
+		if provider.AuthPath() == "" {
+			continue
+		}
+		serviceMux.HandleFunc(provider.AuthPath(),
+			runtimeState.secondFactorAuthHandler(provider))
+	}
+	serviceMux.HandleFunc(revokeCertificatePath, runtimeState.revokeCertificateHandler)
+	serviceMux.HandleFunc(sshHostCertPath, runtimeState.sshHostCertHandler)
 	serviceMux.HandleFunc(oauth2LoginBeginPath, runtimeState.oauth2DoRedirectoToProviderHandler)
 	serviceMux.HandleFunc(redirectPath, runtimeState.oauth2RedirectPathHandler)
 	serviceMux.HandleFunc(clientConfHandlerPath, runtimeState.serveClientConfHandler)
 	serviceMux.HandleFunc(vipPushStartPath, runtimeState.vipPushStartHandler)
 	serviceMux.HandleFunc(vipPollCheckPath, runtimeState.VIPPollCheckHandler)
+	serviceMux.HandleFunc(pushApprovalCallbackPath, runtimeState.pushApprovalCallbackHandler)
 
 	serviceMux.HandleFunc("/", runtimeState.defaultPathHandler)
 
@@ -1597,6 +1798,9 @@ func main() {
 			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
 		},
 	}
+	if runtimeState.peerCertificate != nil {
+		cfg.GetCertificate = runtimeState.getAdminCertificate
+	}
 	logFilterHandler := NewLogFilterHandler(http.DefaultServeMux, publicLogs)
 	serviceHTTPLogger := httpLogger{AccessLogger: serviceAccessLogger}
 	adminHTTPLogger := httpLogger{AccessLogger: adminAccessLogger}
@@ -1620,6 +1824,9 @@ func main() {
 		}
 
 	}("done")
+	if runtimeState.peerCertificate != nil {
+		go runtimeState.peerUnsealLoop()
+	}
 
 	isReady := <-runtimeState.SignerIsReady
 	if isReady != true {
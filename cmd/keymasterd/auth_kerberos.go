@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Symantec/keymaster/lib/kerberos"
+)
+
+// newKerberosAuthenticator loads the keytab used to check the Kerberos
+// SPNEGO (Negotiate) tokens sent to the login handler.
+func (state *RuntimeState) newKerberosAuthenticator() error {
+	authenticator, err := kerberos.New(
+		state.Config.Base.KerberosKeytabFilename,
+		state.Config.Base.KerberosRealm, logger)
+	if err != nil {
+		return err
+	}
+	state.kerberosAuthenticator = authenticator
+	return nil
+}
+
+// getKerberosUsername returns the user authenticated by the Negotiate token
+// of r. It returns "" if r has no such token or if Kerberos logins are not
+// configured.
+func (state *RuntimeState) getKerberosUsername(r *http.Request) (
+	string, error) {
+	if state.kerberosAuthenticator == nil {
+		return "", nil
+	}
+	username, err := state.kerberosAuthenticator.Authenticate(r)
+	if err == kerberos.ErrNoToken {
+		return "", nil
+	}
+	metricLogAuthOperation(getClientType(r), "kerberos", err == nil)
+	return username, err
+}
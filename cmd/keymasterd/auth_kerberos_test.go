@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/kerberos"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const testKerberosRealm = "EXAMPLE.COM"
+
+func newTestKeytab(t *testing.T, password string) *keytab.Keytab {
+	kt := keytab.New()
+	err := kt.AddEntry("HTTP/keymaster.example.com", testKerberosRealm,
+		password, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kt
+}
+
+// newTestNegotiateHeader stands in for the KDC and the client: it issues a
+// service ticket for username encrypted with the key in kt and returns the
+// Authorization header made from it.
+func newTestNegotiateHeader(t *testing.T, kt *keytab.Keytab,
+	username string) string {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, username)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST,
+		"HTTP/keymaster.example.com")
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, testKerberosRealm,
+		sname, testKerberosRealm, types.NewKrbFlags(), kt,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour),
+		now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := client.NewWithPassword(username, testKerberosRealm, "unused",
+		config.New())
+	negTokenInit, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negTokenInit}
+	b, err := token.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Negotiate " + base64.StdEncoding.EncodeToString(b)
+}
+
+func TestKerberosLogin(t *testing.T) {
+	state, _, cleanup := setupProfileTestState(t)
+	defer cleanup()
+	kt := newTestKeytab(t, "service-password")
+	state.kerberosAuthenticator = kerberos.NewFromKeytab(kt,
+		testKerberosRealm, logger)
+	newRequest := func(authorization string) *http.Request {
+		req, err := http.NewRequest("POST", proto.LoginPath,
+			strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return req
+	}
+	// Without credentials the client is asked to negotiate.
+	rr, err := checkRequestHandlerCode(newRequest(""), state.loginHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	negotiate := false
+	for _, value := range rr.Result().Header["Www-Authenticate"] {
+		if value == "Negotiate" {
+			negotiate = true
+		}
+	}
+	if !negotiate {
+		t.Fatalf("no Negotiate challenge: %v", rr.Result().Header)
+	}
+	// A ticket encrypted with another key is rejected.
+	_, err = checkRequestHandlerCode(
+		newRequest(newTestNegotiateHeader(t,
+			newTestKeytab(t, "other-password"), "username")),
+		state.loginHandler, http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(
+		newRequest(newTestNegotiateHeader(t, kt, "UserName")),
+		state.loginHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkValidLoginResponse(rr.Result(), state, "username") {
+		t.Fatal("no valid login cookie for username")
+	}
+}
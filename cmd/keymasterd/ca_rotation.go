@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// CARotationConfig configures the CA key that replaces ssh_ca_filename at
+// SwitchTime (RFC3339). The next key may be a plain PEM key or a PGP armored
+// file encrypted with the same passphrase as the current key.
+type CARotationConfig struct {
+	NextCAFilename string `yaml:"next_ca_filename"`
+	SwitchTime     string `yaml:"switch_time"`
+}
+
+const (
+	caRotationPath  = "/caRotation"
+	sshCAPublicName = "sshCA"
+	// Retired CA keys stay published for longer than the lifetime of the
+	// user certificates and auth cookies they signed.
+	retiredCAPublishPeriod = 48 * time.Hour
+)
+
+func (config *CARotationConfig) validate() error {
+	if config.NextCAFilename == "" {
+		if config.SwitchTime != "" {
+			return errors.New("ca_rotation: switch_time without next_ca_filename")
+		}
+		return nil
+	}
+	if _, err := time.Parse(time.RFC3339, config.SwitchTime); err != nil {
+		return fmt.Errorf("ca_rotation: bad switch_time: %s", err)
+	}
+	return nil
+}
+
+func decryptArmoredPrivateKey(armoredKey []byte, password []byte) (
+	[]byte, error) {
+	armorBlock, err := armor.Decode(bytes.NewBuffer(armoredKey))
+	if err != nil {
+		return nil, err
+	}
+	failed := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// If the given passphrase isn't correct, the function will be called again, forever.
+		// This method will fail fast.
+		// Ref: https://godoc.org/golang.org/x/crypto/openpgp#PromptFunction
+		if failed {
+			return nil, errors.New("decryption failed")
+		}
+		failed = true
+		return password, nil
+	}
+	md, err := openpgp.ReadMessage(armorBlock.Body, nil, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(md.UnverifiedBody)
+}
+
+func (state *RuntimeState) addKeymasterPublicKey(
+	publicKey crypto.PublicKey) error {
+	newFingerprint, err := getKeyFingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	for _, key := range state.KeymasterPublicKeys {
+		fp, err := getKeyFingerprint(key)
+		if err != nil {
+			return err
+		}
+		if newFingerprint == fp {
+			return nil
+		}
+	}
+	state.KeymasterPublicKeys = append(state.KeymasterPublicKeys, publicKey)
+	return nil
+}
+
+func (state *RuntimeState) removeKeymasterPublicKey(
+	publicKey crypto.PublicKey) error {
+	oldFingerprint, err := getKeyFingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	var keys []crypto.PublicKey
+	for _, key := range state.KeymasterPublicKeys {
+		fp, err := getKeyFingerprint(key)
+		if err != nil {
+			return err
+		}
+		if oldFingerprint != fp {
+			keys = append(keys, key)
+		}
+	}
+	state.KeymasterPublicKeys = keys
+	return nil
+}
+
+// loadNextCASigner loads the configured next CA key. password is only used
+// for PGP armored keys. Must be called with the mutex held or before the
+// server starts.
+func (state *RuntimeState) loadNextCASigner(password []byte) error {
+	if len(state.nextCARawFileContent) == 0 || state.NextSigner != nil {
+		return nil
+	}
+	keyBytes := state.nextCARawFileContent
+	if !isPlainPEMPrivateKey(keyBytes) {
+		if password == nil {
+			return errors.New("next CA key is encrypted but current is not")
+		}
+		var err error
+		keyBytes, err = decryptArmoredPrivateKey(keyBytes, password)
+		if err != nil {
+			return err
+		}
+	}
+	signer, err := getSignerFromPEMBytes(keyBytes)
+	if err != nil {
+		return err
+	}
+	return state.setNextCASigner(signer)
+}
+
+// setNextCASigner installs signer as the next CA key. Must be called with the
+// mutex held or before the server starts.
+func (state *RuntimeState) setNextCASigner(signer crypto.Signer) error {
+	caCertDer, err := generateCADer(state, signer)
+	if err != nil {
+		return err
+	}
+	if err := state.addKeymasterPublicKey(signer.Public()); err != nil {
+		return err
+	}
+	state.nextCACertDer = caCertDer
+	state.NextSigner = signer
+	return nil
+}
+
+// applyCARotation switches signing to the next CA key once the switch time
+// has been reached, and stops publishing the retired key once everything it
+// signed has expired. Must be called with the mutex held.
+func (state *RuntimeState) applyCARotation(now time.Time) {
+	if state.Signer != nil && state.NextSigner != nil &&
+		!now.Before(state.caRotationTime) {
+		logger.Printf("Switching to next CA key")
+		state.retiredCAPublicKey = state.Signer.Public()
+		state.retiredCASigner = state.Signer
+		state.retiredCACertDer = state.caCertDer
+		state.retiredCAPublishedUntil = state.caRotationTime.Add(
+			retiredCAPublishPeriod)
+		state.caCertDer = state.nextCACertDer
+		state.Signer = state.NextSigner
+		state.NextSigner = nil
+		state.nextCACertDer = nil
+	}
+	if state.retiredCAPublicKey != nil &&
+		now.After(state.retiredCAPublishedUntil) {
+		logger.Printf("No longer publishing retired CA key")
+		if err := state.removeKeymasterPublicKey(
+			state.retiredCAPublicKey); err != nil {
+			logger.Printf("Cannot remove retired CA key: %s", err)
+			return
+		}
+		state.retiredCAPublicKey = nil
+		state.retiredCASigner = nil
+		state.retiredCACertDer = nil
+	}
+}
+
+// getX509CA returns the current CA key and X.509 certificate. They are read
+// together so that a rotation cannot come between them.
+func (state *RuntimeState) getX509CA() (crypto.Signer, []byte) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	return state.Signer, state.caCertDer
+}
+
+// getRetiredX509CA returns the retired CA key and X.509 certificate, which
+// are nil outside of the period after a rotation.
+func (state *RuntimeState) getRetiredX509CA() (crypto.Signer, []byte) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	return state.retiredCASigner, state.retiredCACertDer
+}
+
+// publishedCAKeys returns the current, next and retired CA public keys and
+// X.509 certificates, skipping the ones which are not loaded.
+func (state *RuntimeState) publishedCAKeys() (
+	[]crypto.PublicKey, [][]byte) {
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	var publicKeys []crypto.PublicKey
+	var certsDer [][]byte
+	if state.Signer != nil {
+		publicKeys = append(publicKeys, state.Signer.Public())
+		certsDer = append(certsDer, state.caCertDer)
+	}
+	if state.NextSigner != nil {
+		publicKeys = append(publicKeys, state.NextSigner.Public())
+		certsDer = append(certsDer, state.nextCACertDer)
+	}
+	if state.retiredCAPublicKey != nil {
+		publicKeys = append(publicKeys, state.retiredCAPublicKey)
+		certsDer = append(certsDer, state.retiredCACertDer)
+	}
+	return publicKeys, certsDer
+}
+
+func (state *RuntimeState) writeSSHCAPublicKeys(w http.ResponseWriter,
+	r *http.Request) {
+	publicKeys, _ := state.publishedCAKeys()
+	var buf bytes.Buffer
+	for _, publicKey := range publicKeys {
+		sshPublicKey, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			logger.Printf("Cannot convert CA key: %s", err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		buf.Write(ssh.MarshalAuthorizedKey(sshPublicKey))
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	buf.WriteTo(w)
+}
+
+func writeCARotationKeyRow(writer *bufio.Writer, name string,
+	publicKey crypto.PublicKey, when string) {
+	fingerprint := "none"
+	if publicKey != nil {
+		if sshPublicKey, err := ssh.NewPublicKey(publicKey); err == nil {
+			fingerprint = ssh.FingerprintSHA256(sshPublicKey) + " (" +
+				sshPublicKey.Type() + ")"
+		}
+	}
+	fmt.Fprintf(writer, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+		name, html.EscapeString(fingerprint), html.EscapeString(when))
+}
+
+func (state *RuntimeState) caRotationHandler(w http.ResponseWriter,
+	r *http.Request) {
+	adminUser, err := getValidAdminRemoteUsername(w, r)
+	if err != nil {
+		http.Error(w, "Check auth Failed", http.StatusInternalServerError)
+		return
+	}
+	if adminUser == "" {
+		http.Error(w, "Invalid/Unknown Authentication",
+			http.StatusUnauthorized)
+		return
+	}
+	state.Mutex.Lock()
+	var currentKey, nextKey crypto.PublicKey
+	if state.Signer != nil {
+		currentKey = state.Signer.Public()
+	}
+	if state.NextSigner != nil {
+		nextKey = state.NextSigner.Public()
+	}
+	retiredKey := state.retiredCAPublicKey
+	rotationTime := state.caRotationTime
+	retiredUntil := state.retiredCAPublishedUntil
+	nextConfigured := len(state.nextCARawFileContent) > 0
+	state.Mutex.Unlock()
+
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	setSecurityHeaders(w)
+	fmt.Fprintln(writer, "<title>keymaster CA rotation</title>")
+	fmt.Fprintln(writer, "<body>")
+	fmt.Fprintln(writer, "<h1>keymaster CA rotation</h1>")
+	fmt.Fprintln(writer, `<table border="1" style="border-collapse: collapse">`)
+	fmt.Fprintln(writer, "<tr><th>Key</th><th>Fingerprint</th><th></th></tr>")
+	if currentKey == nil {
+		fmt.Fprintln(writer, "<tr><td>Current</td><td>sealed</td><td></td></tr>")
+	} else {
+		writeCARotationKeyRow(writer, "Current", currentKey, "signing")
+	}
+	nextWhen := ""
+	if nextKey != nil {
+		nextWhen = "signing from " + rotationTime.UTC().Format(time.RFC3339)
+	} else if nextConfigured && retiredKey == nil {
+		nextWhen = "not loaded"
+	}
+	writeCARotationKeyRow(writer, "Next", nextKey, nextWhen)
+	retiredWhen := ""
+	if retiredKey != nil {
+		retiredWhen = "published until " +
+			retiredUntil.UTC().Format(time.RFC3339)
+	}
+	writeCARotationKeyRow(writer, "Retired", retiredKey, retiredWhen)
+	fmt.Fprintln(writer, "</table>")
+	fmt.Fprintln(writer, "</body>")
+}
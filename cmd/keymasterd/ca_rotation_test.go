@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func genTestNextCAKey(t *testing.T) []byte {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derKey, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey})
+}
+
+func TestCARotationConfigValidate(t *testing.T) {
+	goodConfigs := []CARotationConfig{
+		{},
+		{NextCAFilename: "next.key", SwitchTime: "2030-01-02T15:04:05Z"},
+	}
+	for _, config := range goodConfigs {
+		if err := config.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	badConfigs := []CARotationConfig{
+		{SwitchTime: "2030-01-02T15:04:05Z"},
+		{NextCAFilename: "next.key"},
+		{NextCAFilename: "next.key", SwitchTime: "tomorrow"},
+	}
+	for _, config := range badConfigs {
+		if err := config.validate(); err == nil {
+			t.Fatalf("should have failed: %+v", config)
+		}
+	}
+}
+
+func TestCARotation(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	oldSigner := state.Signer
+	now := time.Now()
+	state.nextCARawFileContent = genTestNextCAKey(t)
+	state.caRotationTime = now.Add(time.Hour)
+	if err := state.loadNextCASigner(nil); err != nil {
+		t.Fatal(err)
+	}
+	nextSigner := state.NextSigner
+	if nextSigner == nil {
+		t.Fatal("next signer not loaded")
+	}
+	publicKeys, certsDer := state.publishedCAKeys()
+	if len(publicKeys) != 2 || len(certsDer) != 2 ||
+		len(state.KeymasterPublicKeys) != 2 {
+		t.Fatal("next CA key not published")
+	}
+
+	state.applyCARotation(now)
+	if state.Signer != oldSigner {
+		t.Fatal("switched before switch time")
+	}
+	state.applyCARotation(state.caRotationTime)
+	if state.Signer != nextSigner || state.NextSigner != nil {
+		t.Fatal("did not switch at switch time")
+	}
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert.PublicKeyAlgorithm != x509.ECDSA {
+		t.Fatal("CA cert not switched")
+	}
+	publicKeys, _ = state.publishedCAKeys()
+	if len(publicKeys) != 2 {
+		t.Fatal("retired CA key not published")
+	}
+	if _, err := state.genSSHKRL(); err != nil {
+		t.Fatal(err)
+	}
+
+	state.applyCARotation(state.retiredCAPublishedUntil.Add(time.Second))
+	publicKeys, _ = state.publishedCAKeys()
+	if len(publicKeys) != 1 || len(state.KeymasterPublicKeys) != 1 {
+		t.Fatal("retired CA key still published")
+	}
+}
+
+func TestRevokeRetiredCAX509Cert(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	oldSigner, oldCACertDer := state.getX509CA()
+	oldCACert, err := x509.ParseCertificate(oldCACertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username := fmt.Sprintf("retired-ca-%d", time.Now().UnixNano())
+	derCert, err := certgen.GenUserX509Cert(username, userPub, oldCACert,
+		oldSigner, nil, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.recordIssuedX509Cert(username, derCert, certAuditInfo{})
+	userCert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := state.genRetiredX509CRL(); err != nil || data != nil {
+		t.Fatal("retired CRL before rotation")
+	}
+	state.nextCARawFileContent = genTestNextCAKey(t)
+	state.caRotationTime = time.Now()
+	if err := state.loadNextCASigner(nil); err != nil {
+		t.Fatal(err)
+	}
+	state.applyCARotation(state.caRotationTime)
+	if _, err := state.RevokeIssuedCerts("username", username, ""); err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := state.isOwnX509CertRevoked(userCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("cert of the retired CA not revoked")
+	}
+	crlBytes, err := state.genRetiredX509CRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := oldCACert.CheckCRLSignature(crl); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, revokedCert := range crl.TBSCertList.RevokedCertificates {
+		if revokedCert.SerialNumber.Cmp(userCert.SerialNumber) == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("serial not in retired CRL")
+	}
+	state.applyCARotation(state.retiredCAPublishedUntil.Add(time.Second))
+	if data, err := state.genRetiredX509CRL(); err != nil || data != nil {
+		t.Fatal("retired CRL after retirement")
+	}
+}
+
+func TestLoadNextCASignerArmored(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	password := []byte("passphrase")
+	armoredBuf := new(bytes.Buffer)
+	armoredWriter, err := armor.Encode(armoredBuf, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintextWriter, err := openpgp.SymmetricallyEncrypt(armoredWriter,
+		password, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintextWriter.Write(genTestNextCAKey(t))
+	plaintextWriter.Close()
+	armoredWriter.Close()
+	state.nextCARawFileContent = armoredBuf.Bytes()
+	if err := state.loadNextCASigner(nil); err == nil {
+		t.Fatal("should have failed without password")
+	}
+	if err := state.loadNextCASigner([]byte("wrong")); err == nil {
+		t.Fatal("should have failed with wrong password")
+	}
+	if err := state.loadNextCASigner(password); err != nil {
+		t.Fatal(err)
+	}
+	if state.NextSigner == nil {
+		t.Fatal("next signer not loaded")
+	}
+}
+
+func TestCARotationHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	req, err := http.NewRequest("GET", caRotationPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(req, state.caRotationHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCert := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{adminCert}}}
+	rr, err := checkRequestHandlerCode(req, state.caRotationHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rr.Body.String(), "SHA256:") {
+		t.Fatal("current CA fingerprint not shown")
+	}
+}
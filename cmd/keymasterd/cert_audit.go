@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	certAuditPath        = "/certificateAudit"
+	certAuditAPIPath     = "/api/v0/certificateAudit"
+	defaultCertAuditRows = 100
+	maxCertAuditRows     = 1000
+	maxAuditUserAgentLen = 512
+)
+
+// certAuditInfo holds the data about a certificate request that is not
+// contained in the issued certificate itself.
+type certAuditInfo struct {
+	AuthLevel         int
+	ClientIP          string
+	UserAgent         string
+	RequestedDuration time.Duration
+}
+
+func newCertAuditInfo(r *http.Request, authLevel int) certAuditInfo {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxAuditUserAgentLen {
+		userAgent = userAgent[:maxAuditUserAgentLen]
+	}
+	return certAuditInfo{
+		AuthLevel: authLevel,
+		ClientIP:  getClientIP(r),
+		UserAgent: userAgent,
+	}
+}
+
+func authLevelToNames(authLevel int) []string {
+	var names []string
+	if (authLevel & AuthTypePassword) != 0 {
+		names = append(names, proto.AuthTypePassword)
+	}
+	if (authLevel & AuthTypeFederated) != 0 {
+		names = append(names, proto.AuthTypeFederated)
+	}
+	if (authLevel & AuthTypeIPCertificate) != 0 {
+		names = append(names, proto.AuthTypeIPCertificate)
+	}
+	for _, provider := range secondFactorProviders {
+		if (authLevel & provider.AuthType()) != 0 {
+			names = append(names, provider.Name())
+		}
+	}
+	return names
+}
+
+func (state *RuntimeState) recordIssuedCert(cert issuedCertData,
+	auditInfo certAuditInfo) {
+	err := state.SaveIssuedCert(cert)
+	if err != nil {
+		logger.Printf("Cannot record issued %s cert for %s: %s",
+			cert.CertType, cert.Username, err)
+	}
+	err = state.SaveCertAuditRecord(certAuditRecord{
+		CertType:          cert.CertType,
+		Serial:            cert.Serial,
+		Username:          cert.Username,
+		KeyFingerprint:    cert.KeyFingerprint,
+		AuthMethods:       strings.Join(authLevelToNames(auditInfo.AuthLevel), ","),
+		ClientIP:          auditInfo.ClientIP,
+		UserAgent:         auditInfo.UserAgent,
+		RequestedDuration: int64(auditInfo.RequestedDuration.Seconds()),
+		GrantedDuration:   cert.ExpirationEpoch - cert.IssuedEpoch,
+		IssuedEpoch:       cert.IssuedEpoch,
+	})
+	if err != nil {
+		logger.Printf("Cannot write audit record for %s cert for %s: %s",
+			cert.CertType, cert.Username, err)
+	}
+}
+
+// recordIssuedSSHCert stores the serial and key of a freshly issued ssh user
+// or host cert so that it can later be revoked and audited. Failures are
+// logged but do not prevent the certificate from being delivered.
+func (state *RuntimeState) recordIssuedSSHCert(username string,
+	certBytes []byte, auditInfo certAuditInfo) {
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		logger.Printf("Cannot parse issued ssh cert for %s: %s", username, err)
+		return
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		logger.Printf("Issued ssh cert for %s is not a certificate", username)
+		return
+	}
+	certType := "ssh"
+	if cert.CertType == ssh.HostCert {
+		certType = "ssh-host"
+	}
+	state.recordIssuedCert(issuedCertData{
+		CertType:        certType,
+		Serial:          strconv.FormatUint(cert.Serial, 10),
+		Username:        username,
+		KeyFingerprint:  ssh.FingerprintSHA256(cert.Key),
+		IssuedEpoch:     int64(cert.ValidAfter),
+		ExpirationEpoch: int64(cert.ValidBefore),
+	}, auditInfo)
+}
+
+// recordIssuedX509Cert is the x509 equivalent of recordIssuedSSHCert. The
+// key fingerprint is computed in ssh format so that the same fingerprint
+// matches both kinds of certificates for a key.
+func (state *RuntimeState) recordIssuedX509Cert(username string,
+	derCert []byte, auditInfo certAuditInfo) {
+	cert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		logger.Printf("Cannot parse issued x509 cert for %s: %s", username, err)
+		return
+	}
+	var fingerprint string
+	sshPubKey, err := ssh.NewPublicKey(cert.PublicKey)
+	if err == nil {
+		fingerprint = ssh.FingerprintSHA256(sshPubKey)
+	}
+	state.recordIssuedCert(issuedCertData{
+		CertType:        "x509",
+		Serial:          cert.SerialNumber.String(),
+		Username:        username,
+		KeyFingerprint:  fingerprint,
+		IssuedEpoch:     cert.NotBefore.Unix(),
+		ExpirationEpoch: cert.NotAfter.Unix(),
+	}, auditInfo)
+}
+
+func parseCertAuditQuery(r *http.Request) (certAuditQuery, error) {
+	query := certAuditQuery{
+		Username: strings.TrimSpace(r.Form.Get("username")),
+		Serial:   strings.TrimSpace(r.Form.Get("serial")),
+		Since:    time.Unix(0, 0),
+		Until:    time.Now(),
+		Limit:    defaultCertAuditRows,
+	}
+	var err error
+	if value := r.Form.Get("since"); value != "" {
+		query.Since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, errors.New("invalid since, must be RFC3339")
+		}
+	}
+	if value := r.Form.Get("until"); value != "" {
+		query.Until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, errors.New("invalid until, must be RFC3339")
+		}
+	}
+	if value := r.Form.Get("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 1 || query.Limit > maxCertAuditRows {
+			return query, fmt.Errorf("limit must be between 1 and %d",
+				maxCertAuditRows)
+		}
+	}
+	return query, nil
+}
+
+// searchCertAudit authenticates an admin port request and runs the query it
+// contains. On failure the response has been written and nil is returned.
+func (state *RuntimeState) searchCertAudit(w http.ResponseWriter,
+	r *http.Request) (*certAuditQuery, []certAuditRecord) {
+	adminUser, err := getValidAdminRemoteUsername(w, r)
+	if err != nil {
+		http.Error(w, "Check auth Failed", http.StatusInternalServerError)
+		return nil, nil
+	}
+	if adminUser == "" {
+		http.Error(w, "Invalid/Unknown Authentication",
+			http.StatusUnauthorized)
+		return nil, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return nil, nil
+	}
+	query, err := parseCertAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil
+	}
+	records, err := state.SearchCertAuditLog(query)
+	if err != nil {
+		logger.Printf("Searching audit log error: %v", err)
+		http.Error(w, "error", http.StatusInternalServerError)
+		return nil, nil
+	}
+	logger.Debugf(1, "%s searched the cert audit log: %+v", adminUser, query)
+	return &query, records
+}
+
+func (state *RuntimeState) certAuditAPIHandler(w http.ResponseWriter,
+	r *http.Request) {
+	query, records := state.searchCertAudit(w, r)
+	if query == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+func (state *RuntimeState) certAuditHandler(w http.ResponseWriter,
+	r *http.Request) {
+	query, records := state.searchCertAudit(w, r)
+	if query == nil {
+		return
+	}
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+	setSecurityHeaders(w)
+	fmt.Fprintln(writer, "<title>keymaster certificate audit</title>")
+	fmt.Fprintln(writer, "<body>")
+	fmt.Fprintln(writer, "<h1>keymaster certificate audit</h1>")
+	fmt.Fprintf(writer, `<form method="get" action="%s">`, certAuditPath)
+	fmt.Fprintf(writer,
+		`Username: <input type="text" name="username" value="%s"> `,
+		html.EscapeString(query.Username))
+	fmt.Fprintf(writer,
+		`Serial: <input type="text" name="serial" value="%s"> `,
+		html.EscapeString(query.Serial))
+	fmt.Fprintf(writer,
+		`Since: <input type="text" name="since" value="%s"> `,
+		query.Since.UTC().Format(time.RFC3339))
+	fmt.Fprintf(writer,
+		`Until: <input type="text" name="until" value="%s"> `,
+		query.Until.UTC().Format(time.RFC3339))
+	fmt.Fprintln(writer, `<input type="submit" value="Search"></form>`)
+	fmt.Fprintln(writer, `<table border="1" style="border-collapse: collapse">`)
+	fmt.Fprint(writer, "<tr><th>Issued</th><th>Type</th><th>Serial</th>")
+	fmt.Fprint(writer, "<th>Username</th><th>Key Fingerprint</th>")
+	fmt.Fprint(writer, "<th>Auth Methods</th><th>Client IP</th>")
+	fmt.Fprint(writer, "<th>User Agent</th><th>Requested</th>")
+	fmt.Fprintln(writer, "<th>Granted</th></tr>")
+	for _, record := range records {
+		fmt.Fprintf(writer, "<tr><td>%s</td>",
+			time.Unix(record.IssuedEpoch, 0).UTC().Format(time.RFC3339))
+		for _, value := range []string{record.CertType, record.Serial,
+			record.Username, record.KeyFingerprint, record.AuthMethods,
+			record.ClientIP, record.UserAgent} {
+			fmt.Fprintf(writer, "<td>%s</td>", html.EscapeString(value))
+		}
+		fmt.Fprintf(writer, "<td>%s</td><td>%s</td></tr>\n",
+			time.Duration(record.RequestedDuration)*time.Second,
+			time.Duration(record.GrantedDuration)*time.Second)
+	}
+	fmt.Fprintln(writer, "</table>")
+	fmt.Fprintln(writer, "</body>")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthLevelToNames(t *testing.T) {
+	names := authLevelToNames(AuthTypePassword | AuthTypeU2F)
+	if len(names) != 2 || names[0] != "password" || names[1] != "U2F" {
+		t.Fatalf("bad names: %v", names)
+	}
+	if len(authLevelToNames(AuthTypeNone)) != 0 {
+		t.Fatal("expected no names")
+	}
+}
+
+func TestCertAuditDefaultDuration(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	since := time.Now().Add(-time.Second)
+	// No duration field, so the default is used.
+	body := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(body)
+	fileWriter, err := bodyWriter.CreateFormFile("pubkeyfile", "key.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write([]byte(testUserSSHPublicKey))
+	bodyWriter.Close()
+	req, err := http.NewRequest("POST", "/certgen/username", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: cookieVal})
+	_, err = checkRequestHandlerCode(req, state.certGenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := state.SearchCertAuditLog(certAuditQuery{
+		Username: "username", Since: since, Until: time.Now().Add(time.Minute),
+		Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record got %d", len(records))
+	}
+	if records[0].RequestedDuration != int64((24 * time.Hour).Seconds()) {
+		t.Fatalf("bad requested duration: %d", records[0].RequestedDuration)
+	}
+}
+
+func TestCertAuditRecordAndSearch(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	username := fmt.Sprintf("audit-%d", time.Now().UnixNano())
+	sshSigner, err := ssh.NewSignerFromSigner(state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", certgenPath+username, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("User-Agent", "keymaster-test")
+	auditInfo := newCertAuditInfo(req, AuthTypePassword|AuthTypeU2F)
+	auditInfo.RequestedDuration = time.Hour
+	var serial uint64
+	for i := 0; i < 3; i++ {
+		_, certBytes, err := certgen.GenSSHCertFileString(username,
+			testUserSSHPublicKey, sshSigner, "host", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.recordIssuedSSHCert(username, certBytes, auditInfo)
+		pubKey, err := ssh.ParsePublicKey(certBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial = pubKey.(*ssh.Certificate).Serial
+	}
+
+	// The API is only available to admin port clients with a certificate.
+	adminCert := &x509.Certificate{Subject: pkix.Name{CommonName: "admin"}}
+	values := url.Values{"username": {username}}
+	searchReq, err := http.NewRequest("GET",
+		certAuditAPIPath+"?"+values.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(searchReq, state.certAuditAPIHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searchReq.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{adminCert}}}
+	rr, err := checkRequestHandlerCode(searchReq, state.certAuditAPIHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []certAuditRecord
+	if err := json.NewDecoder(rr.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records got %d", len(records))
+	}
+	record := records[0]
+	if record.ClientIP != "10.1.2.3" || record.UserAgent != "keymaster-test" ||
+		record.AuthMethods != "password,U2F" || record.CertType != "ssh" ||
+		record.RequestedDuration != 3600 || record.GrantedDuration != 3600 {
+		t.Fatalf("bad record: %+v", record)
+	}
+
+	// Search by serial
+	values = url.Values{"serial": {strconv.FormatUint(serial, 10)}}
+	query, err := parseCertAuditQuery(&http.Request{Form: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = state.SearchCertAuditLog(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Username != username {
+		t.Fatalf("bad serial search result: %+v", records)
+	}
+
+	// Search by time range in the past
+	values = url.Values{"username": {username},
+		"until": {time.Now().Add(-time.Hour).Format(time.RFC3339)}}
+	query, err = parseCertAuditQuery(&http.Request{Form: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = state.SearchCertAuditLog(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no records got %d", len(records))
+	}
+}
+
+func TestParseCertAuditQueryFail(t *testing.T) {
+	for _, values := range []url.Values{
+		{"since": {"yesterday"}},
+		{"until": {"1234"}},
+		{"limit": {"0"}},
+		{"limit": {"100000"}},
+	} {
+		if _, err := parseCertAuditQuery(&http.Request{Form: values}); err == nil {
+			t.Fatalf("should have failed: %v", values)
+		}
+	}
+}
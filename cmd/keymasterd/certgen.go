@@ -22,19 +22,12 @@ import (
 const certgenPath = "/certgen/"
 
 func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request) {
-	var signerIsNull bool
-	var keySigner crypto.Signer
-
-	// copy runtime singer if not nil
-	state.Mutex.Lock()
-	signerIsNull = (state.Signer == nil)
-	if !signerIsNull {
-		keySigner = state.Signer
-	}
-	state.Mutex.Unlock()
+	// The CA certificate must be the one of the signer, even if the CA is
+	// rotated while the request is handled.
+	keySigner, caCertDer := state.getX509CA()
 
 	//local sanity tests
-	if signerIsNull {
+	if keySigner == nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		logger.Printf("Signer not loaded")
 		return
@@ -55,13 +48,11 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		if certPref == proto.AuthTypePassword {
 			sufficientAuthLevel = true
 		}
-		if certPref == proto.AuthTypeU2F && ((authLevel & AuthTypeU2F) == AuthTypeU2F) {
-			sufficientAuthLevel = true
-		}
-		if certPref == proto.AuthTypeSymantecVIP && ((authLevel & AuthTypeSymantecVIP) == AuthTypeSymantecVIP) {
+		if certPref == proto.AuthTypeIPCertificate && ((authLevel & AuthTypeIPCertificate) == AuthTypeIPCertificate) {
 			sufficientAuthLevel = true
 		}
-		if certPref == proto.AuthTypeIPCertificate && ((authLevel & AuthTypeIPCertificate) == AuthTypeIPCertificate) {
+		provider, ok := getSecondFactorProvider(certPref)
+		if ok && (authLevel&provider.AuthType()) == provider.AuthType() {
 			sufficientAuthLevel = true
 		}
 	}
@@ -75,6 +66,12 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Not enough auth level for getting certs")
 		return
 	}
+	err = state.checkSessionCertPolicy(r, authLevel, time.Now())
+	if err != nil {
+		logger.Printf("Session of %s cannot get certs: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
 
 	targetUser := r.URL.Path[len(certgenPath):]
 	if authUser != targetUser {
@@ -106,6 +103,7 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	auditInfo := newCertAuditInfo(r, authLevel)
 	duration := time.Duration(24 * time.Hour)
 	if formDuration, ok := r.Form["duration"]; ok {
 		stringDuration := formDuration[0]
@@ -123,6 +121,7 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 		}
 		duration = newDuration
 	}
+	auditInfo.RequestedDuration = duration
 
 	certType := "ssh"
 	if val, ok := r.Form["type"]; ok {
@@ -132,13 +131,24 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 
 	switch certType {
 	case "ssh":
-		state.postAuthSSHCertHandler(w, r, targetUser, keySigner, duration)
+		state.postAuthSSHCertHandler(w, r, targetUser, keySigner, duration,
+			auditInfo)
 		return
 	case "x509":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, false)
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, caCertDer,
+			duration, false, nil, auditInfo)
 		return
 	case "x509-kubernetes":
-		state.postAuthX509CertHandler(w, r, targetUser, keySigner, duration, true)
+		var cluster *KubernetesClusterConfig
+		if clusterName := r.Form.Get("cluster"); clusterName != "" {
+			cluster = state.getKubernetesCluster(clusterName)
+			if cluster == nil {
+				state.writeFailureResponse(w, r, http.StatusBadRequest, "Unknown kubernetes cluster")
+				return
+			}
+		}
+		state.postAuthX509CertHandler(w, r, targetUser, keySigner, caCertDer,
+			duration, true, cluster, auditInfo)
 		return
 	default:
 		state.writeFailureResponse(w, r, http.StatusBadRequest, "Unrecognized cert type")
@@ -148,7 +158,8 @@ func (state *RuntimeState) certGenHandler(w http.ResponseWriter, r *http.Request
 
 func (state *RuntimeState) postAuthSSHCertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
-	keySigner crypto.Signer, duration time.Duration) {
+	keySigner crypto.Signer, duration time.Duration,
+	auditInfo certAuditInfo) {
 	signer, err := ssh.NewSignerFromSigner(keySigner)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
@@ -156,11 +167,27 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 		return
 	}
 
+	principals, permissions, err := state.getSSHCertPrincipalsAndPermissions(
+		targetUser)
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		logger.Printf("Cannot resolve ssh cert policy for %s: %s",
+			targetUser, err)
+		return
+	}
+
 	var cert string
 	var certBytes []byte
 	switch r.Method {
 	case "GET":
-		cert, certBytes, err = certgen.GenSSHCertFileStringFromSSSDPublicKey(targetUser, signer, state.HostIdentity, duration)
+		userPubKey, err := certgen.GetUserPubKeyFromSSSD(targetUser)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		cert, certBytes, err = certgen.GenSSHCertFileStringWithPermissions(
+			targetUser, userPubKey, signer, state.HostIdentity, duration,
+			principals, permissions)
 		if err != nil {
 			http.NotFound(w, r)
 			return
@@ -177,7 +204,7 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 		buf.ReadFrom(file)
 		userPubKey := buf.String()
 		//validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp256|ssh-ed25519) [a-zA-Z0-9/+]+=?=? .*$", userPubKey)
-		validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp256|ssh-ed25519) [a-zA-Z0-9/+]+=?=? ?.{0,512}\n?$", userPubKey)
+		validKey, err := regexp.MatchString("^(ssh-rsa|ssh-dss|ecdsa-sha2-nistp(256|384|521)|ssh-ed25519) [a-zA-Z0-9/+]+=?=? ?.{0,512}\n?$", userPubKey)
 		if err != nil {
 			logger.Println(err)
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
@@ -190,7 +217,9 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 
 		}
 
-		cert, certBytes, err = certgen.GenSSHCertFileString(targetUser, userPubKey, signer, state.HostIdentity, duration)
+		cert, certBytes, err = certgen.GenSSHCertFileStringWithPermissions(
+			targetUser, userPubKey, signer, state.HostIdentity, duration,
+			principals, permissions)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			logger.Printf("signUserPubkey Err")
@@ -203,6 +232,7 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 
 	}
 	eventNotifier.PublishSSH(certBytes)
+	state.recordIssuedSSHCert(targetUser, certBytes, auditInfo)
 	metricLogCertDuration("ssh", "granted", float64(duration.Seconds()))
 
 	w.Header().Set("Content-Disposition", `attachment; filename="id_rsa-cert.pub"`)
@@ -218,6 +248,15 @@ func (state *RuntimeState) postAuthSSHCertHandler(
 
 func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
 	ldapConfig := state.Config.UserInfo.Ldap
+	if ldapConfig.LDAPTargetURLs == "" && state.oktaUserInfo != nil {
+		start := time.Now()
+		groups, err := state.oktaUserInfo.GetUserGroups(username)
+		if err != nil {
+			return nil, err
+		}
+		metricLogExternalServiceDuration("okta", time.Since(start))
+		return groups, nil
+	}
 	var timeoutSecs uint
 	timeoutSecs = 2
 	//for _, ldapUrl := range ldapConfig.LDAPTargetURLs {
@@ -251,8 +290,9 @@ func (state *RuntimeState) getUserGroups(username string) ([]string, error) {
 
 func (state *RuntimeState) postAuthX509CertHandler(
 	w http.ResponseWriter, r *http.Request, targetUser string,
-	keySigner crypto.Signer, duration time.Duration,
-	kubernetesHack bool) {
+	keySigner crypto.Signer, caCertDer []byte, duration time.Duration,
+	kubernetesHack bool, cluster *KubernetesClusterConfig,
+	auditInfo certAuditInfo) {
 
 	var userGroups, groups []string
 	// Getting user groups can be a failure, in this case we dont want to
@@ -271,9 +311,15 @@ func (state *RuntimeState) postAuthX509CertHandler(
 		groups = userGroups
 	}
 	organizations := []string{"keymaster"}
+	commonName := targetUser
 	if kubernetesHack {
 		organizations = userGroups
 	}
+	if cluster != nil {
+		organizations = cluster.mapGroups(userGroups)
+		commonName = cluster.commonName(targetUser)
+		duration = cluster.limitDuration(duration)
+	}
 	var cert string
 	switch r.Method {
 	case "POST":
@@ -302,20 +348,22 @@ func (state *RuntimeState) postAuthX509CertHandler(
 			logger.Printf("Cannot parse public key")
 			return
 		}
-		caCert, err := x509.ParseCertificate(state.caCertDer)
+		caCert, err := x509.ParseCertificate(caCertDer)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			logger.Printf("Cannot parse CA Der data")
 			return
 		}
-		derCert, err := certgen.GenUserX509Cert(targetUser, userPub, caCert,
-			keySigner, state.KerberosRealm, duration, groups, organizations)
+		derCert, err := certgen.GenUserX509CertWithCommonName(targetUser,
+			commonName, userPub, caCert, keySigner, state.KerberosRealm,
+			duration, groups, organizations)
 		if err != nil {
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 			logger.Printf("Cannot Generate x509cert")
 			return
 		}
 		eventNotifier.PublishX509(derCert)
+		state.recordIssuedX509Cert(targetUser, derCert, auditInfo)
 		cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
 			Bytes: derCert}))
 
@@ -22,7 +22,8 @@ func TestGenerateNewConfigInternal(t *testing.T) {
 	baseReader := strings.NewReader(readerContent)
 	reader := bufio.NewReader(baseReader)
 	passphrase := []byte("passphrase")
-	err = generateNewConfigInternal(reader, configFilename, 2048, passphrase)
+	err = generateNewConfigInternal(reader, configFilename, 2048, passphrase,
+		0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,3 +41,37 @@ func TestGenerateNewConfigInternal(t *testing.T) {
 	// TODO: test decrypt file
 
 }
+
+func TestKerberosKeytabWithoutRealm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFilename := filepath.Join(dir, "config-test.yml")
+	reader := bufio.NewReader(strings.NewReader(
+		dir + "\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n"))
+	err = generateNewConfigInternal(reader, configFilename, 2048,
+		[]byte("passphrase"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "var/lib/keymaster"),
+		0750); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = []byte(strings.Replace(string(config),
+		`kerberos_keytab_filename: ""`,
+		"kerberos_keytab_filename: "+filepath.Join(dir, "keytab"), 1))
+	if err := ioutil.WriteFile(configFilename, config, 0640); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadVerifyConfigFile(configFilename)
+	if err == nil || !strings.Contains(err.Error(), "kerberos_realm") {
+		t.Fatalf("keytab without realm: %v", err)
+	}
+}
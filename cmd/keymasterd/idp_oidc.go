@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
-	//"crypto"
+	"crypto"
+	"crypto/ed25519"
 	//"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -53,6 +55,10 @@ type openIDProviderMetadata struct {
 
 func (state *RuntimeState) idpOpenIDCDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
 	issuer := state.idpGetIssuer()
+	signingAlg := string(jose.RS256)
+	if state.Signer != nil {
+		signingAlg = string(signerJOSEAlgorithm(state.Signer))
+	}
 	metadata := openIDProviderMetadata{
 		Issuer:                 issuer,
 		AuthorizationEndpoint:  issuer + idpOpenIDCAuthorizationPath,
@@ -61,7 +67,7 @@ func (state *RuntimeState) idpOpenIDCDiscoveryHandler(w http.ResponseWriter, r *
 		JWKSURI:                issuer + idpOpenIDCJWKSPath,
 		ResponseTypesSupported: []string{"code"},               // We only support authorization code flow
 		SubjectTypesSupported:  []string{"pairwise", "public"}, // WHAT is THIS?
-		IDTokenSigningAlgValue: []string{"RS256"}}
+		IDTokenSigningAlgValue: []string{signingAlg}}
 	// need to agree on what scopes we will support
 
 	b, err := json.Marshal(metadata)
@@ -81,6 +87,15 @@ type jwsKeyList struct {
 	Keys []*gojwk.Key `json:"keys"`
 }
 
+// publicKeyToJWK adds Ed25519 (RFC 8037) support to gojwk.PublicKey.
+func publicKeyToJWK(key crypto.PublicKey) (*gojwk.Key, error) {
+	if edKey, ok := key.(ed25519.PublicKey); ok {
+		return &gojwk.Key{Kty: "OKP", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(edKey)}, nil
+	}
+	return gojwk.PublicKey(key)
+}
+
 // Need to improve this to account for adding the other signers here.
 func (state *RuntimeState) idpOpenIDCJWKSHandler(w http.ResponseWriter, r *http.Request) {
 	if state.sendFailureToClientIfLocked(w, r) {
@@ -88,7 +103,7 @@ func (state *RuntimeState) idpOpenIDCJWKSHandler(w http.ResponseWriter, r *http.
 	}
 	var currentKeys jwsKeyList
 	for _, key := range state.KeymasterPublicKeys {
-		jwkKey, err := gojwk.PublicKey(key)
+		jwkKey, err := publicKeyToJWK(key)
 		if err != nil {
 			log.Printf("error getting key idpOpenIDCJWKSHandler: %s", err)
 			state.writeFailureResponse(w, r, http.StatusInternalServerError, "Internal Error")
@@ -215,7 +230,7 @@ func (state *RuntimeState) idpOpenIDCAuthorizationHandler(w http.ResponseWriter,
 	//Dont check for now
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
 	//signerOptions.EmbedJWK = true
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: signerJOSEAlgorithm(state.Signer), Key: state.Signer}, signerOptions)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
@@ -402,7 +417,7 @@ func (state *RuntimeState) idpOpenIDCTokenHandler(w http.ResponseWriter, r *http
 	}
 
 	signerOptions = signerOptions.WithHeader("kid", kid)
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: signerJOSEAlgorithm(state.Signer), Key: state.Signer}, signerOptions)
 	if err != nil {
 		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
 		return
@@ -2,6 +2,9 @@ package main
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -24,6 +27,26 @@ func getKeyFingerprint(key crypto.PublicKey) (string, error) {
 	return fp, nil
 }
 
+// signerJOSEAlgorithm returns the JWS algorithm matching the type of the
+// signing key.
+func signerJOSEAlgorithm(signer crypto.Signer) jose.SignatureAlgorithm {
+	switch key := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return jose.ES384
+		case elliptic.P521():
+			return jose.ES512
+		default:
+			return jose.ES256
+		}
+	case ed25519.PublicKey:
+		return jose.EdDSA
+	default:
+		return jose.RS256
+	}
+}
+
 func (state *RuntimeState) idpGetIssuer() string {
 	issuer := "https://" + state.HostIdentity
 	if state.Config.Base.HttpAddress != ":443" {
@@ -48,7 +71,7 @@ func (state *RuntimeState) JWTClaims(t *jwt.JSONWebToken, dest ...interface{}) (
 
 func (state *RuntimeState) genNewSerializedAuthJWT(username string, authLevel int) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: signerJOSEAlgorithm(state.Signer), Key: state.Signer}, signerOptions)
 	if err != nil {
 		return "", err
 	}
@@ -83,12 +106,13 @@ func (state *RuntimeState) getAuthInfoFromAuthJWT(serializedToken string) (rvalu
 	rvalue.Username = inboundJWT.Subject
 	rvalue.AuthType = inboundJWT.AuthType
 	rvalue.ExpiresAt = time.Unix(inboundJWT.Expiration, 0)
+	rvalue.IssuedAt = time.Unix(inboundJWT.IssuedAt, 0)
 	return rvalue, nil
 }
 
 func (state *RuntimeState) updateAuthJWTWithNewAuthLevel(intoken string, newAuthLevel int) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: signerJOSEAlgorithm(state.Signer), Key: state.Signer}, signerOptions)
 	if err != nil {
 		return "", err
 	}
@@ -114,7 +138,7 @@ func (state *RuntimeState) updateAuthJWTWithNewAuthLevel(intoken string, newAuth
 
 func (state *RuntimeState) genNewSerializedStorageStringDataJWT(username string, dataType int, data string, expiration int64) (string, error) {
 	signerOptions := (&jose.SignerOptions{}).WithType("JWT")
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: state.Signer}, signerOptions)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: signerJOSEAlgorithm(state.Signer), Key: state.Signer}, signerOptions)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// KubernetesClusterConfig is a named profile for the x509-kubernetes
+// certificates of one cluster. GroupMap maps LDAP groups to the Kubernetes
+// groups placed in the certificate organizations; if it is empty all the
+// LDAP groups of the user are used. CommonNameFormat must contain a single
+// %s which is replaced by the username.
+type KubernetesClusterConfig struct {
+	Name             string            `yaml:"name"`
+	APIServerURL     string            `yaml:"api_server_url"`
+	CAFilename       string            `yaml:"ca_filename"`
+	GroupMap         map[string]string `yaml:"group_map"`
+	CommonNameFormat string            `yaml:"common_name_format"`
+	MaxDurationSecs  int               `yaml:"max_duration_secs"`
+	caData           []byte
+}
+
+const kubernetesClustersPublicName = "kubernetesClusters"
+
+var validKubernetesClusterNameRegexp = regexp.MustCompile(
+	"^[a-zA-Z0-9][-a-zA-Z0-9._]{0,62}$")
+
+func (cluster *KubernetesClusterConfig) validate() error {
+	if !validKubernetesClusterNameRegexp.MatchString(cluster.Name) {
+		return fmt.Errorf("kubernetes cluster: invalid name '%s'",
+			cluster.Name)
+	}
+	if cluster.APIServerURL != "" {
+		u, err := url.Parse(cluster.APIServerURL)
+		if err != nil {
+			return fmt.Errorf("kubernetes cluster '%s': %s", cluster.Name, err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("kubernetes cluster '%s': api_server_url must be https",
+				cluster.Name)
+		}
+	}
+	if cluster.CommonNameFormat != "" &&
+		(strings.Count(cluster.CommonNameFormat, "%s") != 1 ||
+			strings.Count(cluster.CommonNameFormat, "%") != 1) {
+		return fmt.Errorf("kubernetes cluster '%s': common_name_format must contain a single %%s",
+			cluster.Name)
+	}
+	if cluster.MaxDurationSecs < 0 {
+		return fmt.Errorf("kubernetes cluster '%s': negative max_duration_secs",
+			cluster.Name)
+	}
+	return nil
+}
+
+func validateKubernetesClusters(clusters []KubernetesClusterConfig) error {
+	names := make(map[string]struct{})
+	for _, cluster := range clusters {
+		if err := cluster.validate(); err != nil {
+			return err
+		}
+		if _, ok := names[cluster.Name]; ok {
+			return fmt.Errorf("duplicate kubernetes cluster '%s'", cluster.Name)
+		}
+		names[cluster.Name] = struct{}{}
+	}
+	return nil
+}
+
+// loadKubernetesClusterCAs reads the CA certificates which are given to
+// clients in their kubeconfig.
+func loadKubernetesClusterCAs(clusters []KubernetesClusterConfig) error {
+	for i := range clusters {
+		if clusters[i].CAFilename == "" {
+			continue
+		}
+		caData, err := ioutil.ReadFile(clusters[i].CAFilename)
+		if err != nil {
+			return err
+		}
+		clusters[i].caData = caData
+	}
+	return nil
+}
+
+func (state *RuntimeState) getKubernetesCluster(
+	name string) *KubernetesClusterConfig {
+	for i := range state.Config.KubernetesClusters {
+		if state.Config.KubernetesClusters[i].Name == name {
+			return &state.Config.KubernetesClusters[i]
+		}
+	}
+	return nil
+}
+
+func (cluster *KubernetesClusterConfig) commonName(username string) string {
+	if cluster.CommonNameFormat == "" {
+		return username
+	}
+	return fmt.Sprintf(cluster.CommonNameFormat, username)
+}
+
+// mapGroups returns the sorted Kubernetes groups for the given LDAP groups.
+func (cluster *KubernetesClusterConfig) mapGroups(userGroups []string) []string {
+	groupSet := make(map[string]struct{})
+	for _, group := range userGroups {
+		if len(cluster.GroupMap) < 1 {
+			groupSet[group] = struct{}{}
+		} else if kubernetesGroup, ok := cluster.GroupMap[group]; ok {
+			groupSet[kubernetesGroup] = struct{}{}
+		}
+	}
+	groups := make([]string, 0, len(groupSet))
+	for group := range groupSet {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (cluster *KubernetesClusterConfig) limitDuration(
+	duration time.Duration) time.Duration {
+	maxDuration := time.Duration(cluster.MaxDurationSecs) * time.Second
+	if maxDuration > 0 && duration > maxDuration {
+		return maxDuration
+	}
+	return duration
+}
+
+func (state *RuntimeState) writeKubernetesClusters(w http.ResponseWriter,
+	r *http.Request) {
+	clusters := make([]proto.KubernetesCluster, 0,
+		len(state.Config.KubernetesClusters))
+	for _, cluster := range state.Config.KubernetesClusters {
+		clusters = append(clusters, proto.KubernetesCluster{
+			Name:                     cluster.Name,
+			APIServerURL:             cluster.APIServerURL,
+			CertificateAuthorityData: cluster.caData,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clusters); err != nil {
+		logger.Printf("Error encoding kubernetes clusters: %s", err)
+	}
+}
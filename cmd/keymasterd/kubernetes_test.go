@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestValidateKubernetesClusters(t *testing.T) {
+	goodClusters := []KubernetesClusterConfig{
+		{Name: "prod", APIServerURL: "https://prod.example.com:6443",
+			CommonNameFormat: "keymaster:%s", MaxDurationSecs: 3600},
+		{Name: "dev"},
+	}
+	if err := validateKubernetesClusters(goodClusters); err != nil {
+		t.Fatal(err)
+	}
+	badClusters := [][]KubernetesClusterConfig{
+		{{Name: ""}},
+		{{Name: "bad/name"}},
+		{{Name: "prod"}, {Name: "prod"}},
+		{{Name: "prod", APIServerURL: "http://prod.example.com"}},
+		{{Name: "prod", CommonNameFormat: "keymaster"}},
+		{{Name: "prod", CommonNameFormat: "%s-%d"}},
+		{{Name: "prod", MaxDurationSecs: -1}},
+	}
+	for _, clusters := range badClusters {
+		if err := validateKubernetesClusters(clusters); err == nil {
+			t.Fatalf("should have failed: %+v", clusters)
+		}
+	}
+}
+
+func TestKubernetesClusterMapping(t *testing.T) {
+	cluster := KubernetesClusterConfig{
+		Name:             "prod",
+		CommonNameFormat: "keymaster:%s",
+		GroupMap: map[string]string{
+			"sre":    "system:masters",
+			"oncall": "system:masters",
+			"dev":    "developers",
+		},
+		MaxDurationSecs: 3600,
+	}
+	if cn := cluster.commonName("username"); cn != "keymaster:username" {
+		t.Fatalf("bad common name: %s", cn)
+	}
+	groups := cluster.mapGroups([]string{"sre", "oncall", "dev", "other"})
+	if !reflect.DeepEqual(groups, []string{"developers", "system:masters"}) {
+		t.Fatalf("bad groups: %v", groups)
+	}
+	if d := cluster.limitDuration(24 * time.Hour); d != time.Hour {
+		t.Fatalf("bad duration: %s", d)
+	}
+	cluster.GroupMap = nil
+	groups = cluster.mapGroups([]string{"sre", "dev"})
+	if !reflect.DeepEqual(groups, []string{"dev", "sre"}) {
+		t.Fatalf("bad unmapped groups: %v", groups)
+	}
+}
+
+func TestSigningKubernetesClusterCert(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	state.Config.KubernetesClusters = []KubernetesClusterConfig{
+		{Name: "prod", APIServerURL: "https://prod.example.com:6443",
+			CommonNameFormat: "keymaster:%s", MaxDurationSecs: 3600,
+			caData: []byte("ca")},
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCookie := http.Cookie{Name: authCookieName, Value: cookieVal}
+
+	req, err := createKeyBodyRequest("POST",
+		"/certgen/username?type=x509-kubernetes&cluster=dev",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	_, err = checkRequestHandlerCode(req, state.certGenHandler,
+		http.StatusBadRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = createKeyBodyRequest("POST",
+		"/certgen/username?type=x509-kubernetes&cluster=prod",
+		testUserPEMPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&authCookie)
+	rr, err := checkRequestHandlerCode(req, state.certGenHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(rr.Body.Bytes())
+	if block == nil {
+		t.Fatal("cannot decode certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "keymaster:username" {
+		t.Fatalf("bad common name: %s", cert.Subject.CommonName)
+	}
+	if cert.NotAfter.Sub(cert.NotBefore) > time.Hour {
+		t.Fatalf("duration not limited: %s", cert.NotAfter.Sub(cert.NotBefore))
+	}
+
+	req, err = http.NewRequest("GET", proto.KubernetesClustersPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err = checkRequestHandlerCode(req, state.publicPathHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clusters []proto.KubernetesCluster
+	if err := json.NewDecoder(rr.Body).Decode(&clusters); err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "prod" ||
+		string(clusters[0].CertificateAuthorityData) != "ca" {
+		t.Fatalf("bad clusters: %+v", clusters)
+	}
+}
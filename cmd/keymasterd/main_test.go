@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +26,7 @@ import (
 	"github.com/Symantec/keymaster/keymasterd/eventnotifier"
 	"github.com/Symantec/keymaster/lib/instrumentedwriter"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/square/go-jose.v2"
 )
 
 // copied from lib/certgen/cergen_test.go
@@ -203,6 +207,35 @@ func setupPasswdFile() (f *os.File, err error) {
 	return tmpfile, nil
 }
 
+// setupProfileTestState returns a state with a profile database and an auth
+// cookie of "username" logged in with a password.
+func setupProfileTestState(t *testing.T) (*RuntimeState, *http.Cookie, func()) {
+	state := &RuntimeState{localAuthData: make(map[string]localUserData)}
+	signer, err := getSignerFromPEMBytes([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Signer = signer
+	state.signerPublicKeyToKeymasterKeys()
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.DataDirectory = dir
+	state.Config.Base.AllowedAuthBackendsForWebUI = []string{"password"}
+	if err := initDB(state); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cookieVal, err := state.setNewAuthCookie(nil, "username", AuthTypePassword)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return state, &http.Cookie{Name: authCookieName, Value: cookieVal},
+		func() { os.RemoveAll(dir) }
+}
+
 //
 func setupValidRuntimeStateSigner() (*RuntimeState, *os.File, error) {
 	var state RuntimeState
@@ -1056,3 +1089,45 @@ func TestU2fTokenManagerHandlerDeleteSuccess(t *testing.T) {
 		t.Fatal("update not successul")
 	}
 }
+
+func TestEd25519SignerAuthJWT(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY",
+		Bytes: pkcs8Bytes})
+	if !isPlainPEMPrivateKey(pemKey) {
+		t.Fatal("PKCS#8 key not detected")
+	}
+	signer, err := getSignerFromPEMBytes(pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state RuntimeState
+	state.Signer = signer
+	if err := state.signerPublicKeyToKeymasterKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if signerJOSEAlgorithm(signer) != jose.EdDSA {
+		t.Fatal("bad JOSE algorithm")
+	}
+	if _, err := generateCADer(&state, signer); err != nil {
+		t.Fatal(err)
+	}
+	token, err := state.genNewSerializedAuthJWT("username", AuthTypePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := state.getAuthInfoFromAuthJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Username != "username" || info.AuthType != AuthTypePassword {
+		t.Fatalf("bad auth info: %+v", info)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/Symantec/keymaster/lib/pwauth/chain"
+	"github.com/Symantec/keymaster/lib/pwauth/command"
+	"github.com/Symantec/keymaster/lib/pwauth/htpasswd"
+	"github.com/Symantec/keymaster/lib/pwauth/ldap"
+)
+
+// PasswordAuthBackendConfig is an entry of password_auth_chain. Backend is
+// one of htpasswd, ldap, okta, radius or command, configured by their usual
+// settings.
+type PasswordAuthBackendConfig struct {
+	Backend          string   `yaml:"backend"`
+	UsernamePatterns []string `yaml:"username_patterns"`
+}
+
+func (state *RuntimeState) newLDAPPasswordAuthenticator() (
+	*ldap.PasswordAuthenticator, error) {
+	const timeoutSecs = 3
+	const healthCheckInterval = 30 * time.Second
+	pwdCache := state
+	if state.Config.Ldap.DisablePasswordCache {
+		pwdCache = nil
+	}
+	return ldap.NewWithConfig(
+		strings.Split(state.Config.Ldap.LDAPTargetURLs, ","),
+		[]string{state.Config.Ldap.BindPattern},
+		ldap.Config{
+			SearchBindDN:        state.Config.Ldap.BindUsername,
+			SearchBindPassword:  state.Config.Ldap.BindPassword,
+			SearchBaseDNs:       state.Config.Ldap.UserSearchBaseDNs,
+			SearchFilter:        state.Config.Ldap.UserSearchFilter,
+			HealthCheckInterval: healthCheckInterval,
+			LatencyRecorder:     metricLogLDAPServerDuration,
+		},
+		timeoutSecs, nil, pwdCache,
+		logger)
+}
+
+func (state *RuntimeState) newPasswordAuthBackend(backend string) (
+	pwauth.PasswordAuthenticator, error) {
+	switch backend {
+	case "htpasswd":
+		if state.Config.Base.HtpasswdFilename == "" {
+			return nil, errors.New("htpasswd_filename is not set")
+		}
+		return htpasswd.New(state.Config.Base.HtpasswdFilename, logger)
+	case "ldap":
+		if state.Config.Ldap.LDAPTargetURLs == "" {
+			return nil, errors.New("ldap_target_urls is not set")
+		}
+		return state.newLDAPPasswordAuthenticator()
+	case "okta":
+		if state.Config.Okta.Domain == "" {
+			return nil, errors.New("okta domain is not set")
+		}
+		return state.newOktaPasswordAuthenticator()
+	case "radius":
+		if len(state.Config.Radius.Servers) < 1 {
+			return nil, errors.New("radius servers are not set")
+		}
+		return state.newRadiusPasswordAuthenticator()
+	case "command":
+		if state.Config.Base.ExternalAuthCmd == "" {
+			return nil, errors.New("external_auth_command is not set")
+		}
+		return command.New(state.Config.Base.ExternalAuthCmd, nil, logger)
+	default:
+		return nil, errors.New("unknown backend")
+	}
+}
+
+// checkUserLookup returns an error if the backend cannot report unknown
+// users, so that the next backends would never be tried.
+func (state *RuntimeState) checkUserLookup(backend string,
+	authenticator pwauth.PasswordAuthenticator) error {
+	if _, ok := authenticator.(pwauth.UserLookup); !ok {
+		return errors.New(
+			"cannot look up users, so it must be last or have username_patterns")
+	}
+	if backend == "ldap" && state.Config.Ldap.BindUsername == "" {
+		return errors.New(
+			"bind_username is needed to look up users when not last")
+	}
+	return nil
+}
+
+// newPasswordAuthChain returns the password_auth_chain authenticator.
+func (state *RuntimeState) newPasswordAuthChain() (
+	*chain.PasswordAuthenticator, error) {
+	var backends []chain.Backend
+	for index, backendConfig := range state.Config.PasswordAuthChain {
+		authenticator, err := state.newPasswordAuthBackend(
+			backendConfig.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("password_auth_chain: %s: %s",
+				backendConfig.Backend, err)
+		}
+		if index < len(state.Config.PasswordAuthChain)-1 &&
+			len(backendConfig.UsernamePatterns) < 1 {
+			err := state.checkUserLookup(backendConfig.Backend, authenticator)
+			if err != nil {
+				return nil, fmt.Errorf("password_auth_chain: %s: %s",
+					backendConfig.Backend, err)
+			}
+		}
+		backends = append(backends, chain.Backend{
+			Name:             backendConfig.Backend,
+			Authenticator:    authenticator,
+			UsernamePatterns: backendConfig.UsernamePatterns,
+		})
+	}
+	return chain.New(backends, logger)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewPasswordAuthChain(t *testing.T) {
+	passwdFile, err := setupPasswdFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	var state RuntimeState
+	state.Config.Base.HtpasswdFilename = passwdFile.Name()
+	state.Config.PasswordAuthChain = []PasswordAuthBackendConfig{
+		{Backend: "htpasswd", UsernamePatterns: []string{"user*"}},
+	}
+	passwordChecker, err := state.newPasswordAuthChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := checkUserPassword("username", "password", state.Config,
+		passwordChecker, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("valid password rejected")
+	}
+	ok, err = checkUserPassword("username", "bad", state.Config,
+		passwordChecker, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("bad password accepted")
+	}
+	ok, err = checkUserPassword("other", "password", state.Config,
+		passwordChecker, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("unrouted user accepted")
+	}
+	state.Config.PasswordAuthChain = []PasswordAuthBackendConfig{
+		{Backend: "ldap"},
+	}
+	if _, err := state.newPasswordAuthChain(); err == nil {
+		t.Fatal("unconfigured backend accepted")
+	}
+	state.Config.PasswordAuthChain = []PasswordAuthBackendConfig{
+		{Backend: "bogus"},
+	}
+	if _, err := state.newPasswordAuthChain(); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+}
+
+func TestNewPasswordAuthChainUserLookup(t *testing.T) {
+	passwdFile, err := setupPasswdFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name())
+	var state RuntimeState
+	state.Config.Base.HtpasswdFilename = passwdFile.Name()
+	state.Config.Base.ExternalAuthCmd = "/bin/false"
+	state.Config.Ldap.LDAPTargetURLs = "ldaps://ldap.example.com"
+	state.Config.Ldap.BindPattern = "uid=%s,dc=example,dc=com"
+	tests := []struct {
+		chain []PasswordAuthBackendConfig
+		ok    bool
+	}{
+		{[]PasswordAuthBackendConfig{{Backend: "htpasswd"},
+			{Backend: "command"}}, true},
+		{[]PasswordAuthBackendConfig{{Backend: "command"},
+			{Backend: "htpasswd"}}, false},
+		{[]PasswordAuthBackendConfig{{Backend: "command",
+			UsernamePatterns: []string{"svc-*"}}, {Backend: "htpasswd"}}, true},
+		{[]PasswordAuthBackendConfig{{Backend: "ldap"},
+			{Backend: "htpasswd"}}, false},
+	}
+	for _, test := range tests {
+		state.Config.PasswordAuthChain = test.chain
+		_, err := state.newPasswordAuthChain()
+		if (err == nil) != test.ok {
+			t.Errorf("%v: err=%v", test.chain, err)
+		}
+	}
+	state.Config.Ldap.BindUsername = "cn=keymaster,dc=example,dc=com"
+	state.Config.Ldap.UserSearchBaseDNs = []string{"dc=example,dc=com"}
+	state.Config.PasswordAuthChain = []PasswordAuthBackendConfig{
+		{Backend: "ldap"}, {Backend: "htpasswd"}}
+	if _, err := state.newPasswordAuthChain(); err != nil {
+		t.Fatal(err)
+	}
+}
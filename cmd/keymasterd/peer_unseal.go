@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	peerUnsealPath = "/admin/peerUnseal"
+	// Peers offer this ALPN protocol to get the peer certificate instead of
+	// the regular TLS certificate of the admin port.
+	peerUnsealProto               = "keymaster-peer-unseal"
+	secsBetweenPeerUnsealAttempts = 30
+)
+
+// peerUnsealResponse is the response of peerUnsealPath. Ciphertext is a
+// nacl box of peerUnsealKeys from PublicKey, which is ephemeral, to the
+// public key of the request.
+type peerUnsealResponse struct {
+	PublicKey  []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+type peerUnsealKeys struct {
+	CAKey     []byte
+	NextCAKey []byte `json:",omitempty"`
+}
+
+func (config *baseConfig) validateUnsealPeers() error {
+	if len(config.UnsealPeers) < 1 {
+		return nil
+	}
+	if config.PeerCertFilename == "" || config.PeerKeyFilename == "" {
+		return errors.New(
+			"unseal_peers requires peer_cert_filename and peer_key_filename")
+	}
+	for _, peer := range config.UnsealPeers {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return fmt.Errorf("bad unseal peer %s: %s", peer, err)
+		}
+	}
+	return nil
+}
+
+func (state *RuntimeState) loadPeerCertificate() error {
+	if len(state.Config.Base.UnsealPeers) < 1 {
+		return nil
+	}
+	if state.ClientCAPool == nil {
+		return errors.New("unseal_peers requires client_ca_filename")
+	}
+	cert, err := tls.LoadX509KeyPair(state.Config.Base.PeerCertFilename,
+		state.Config.Base.PeerKeyFilename)
+	if err != nil {
+		return err
+	}
+	state.peerCertificate = &cert
+	return nil
+}
+
+// getAdminCertificate presents the peer certificate, which is signed by the
+// admin CA, to peers requesting an unseal.
+func (state *RuntimeState) getAdminCertificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+	for _, proto := range hello.SupportedProtos {
+		if proto == peerUnsealProto {
+			return state.peerCertificate, nil
+		}
+	}
+	return nil, nil
+}
+
+func (state *RuntimeState) isUnsealPeer(cert *x509.Certificate) bool {
+	for _, peer := range state.Config.Base.UnsealPeers {
+		host, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			continue
+		}
+		if cert.Subject.CommonName == host || cert.VerifyHostname(host) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func marshalSignerToPEM(signer crypto.Signer) ([]byte, error) {
+	derKey, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: derKey}),
+		nil
+}
+
+func sealPeerUnsealKeys(keys *peerUnsealKeys, peerPublicKey *[32]byte) (
+	*peerUnsealResponse, error) {
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return &peerUnsealResponse{
+		PublicKey:  publicKey[:],
+		Nonce:      nonce[:],
+		Ciphertext: box.Seal(nil, plaintext, &nonce, peerPublicKey, privateKey),
+	}, nil
+}
+
+func openPeerUnsealResponse(response *peerUnsealResponse,
+	privateKey *[32]byte) (*peerUnsealKeys, error) {
+	var peerPublicKey [32]byte
+	var nonce [24]byte
+	if len(response.PublicKey) != len(peerPublicKey) ||
+		len(response.Nonce) != len(nonce) {
+		return nil, errors.New("malformed peer unseal response")
+	}
+	copy(peerPublicKey[:], response.PublicKey)
+	copy(nonce[:], response.Nonce)
+	plaintext, ok := box.Open(nil, response.Ciphertext, &nonce, &peerPublicKey,
+		privateKey)
+	if !ok {
+		return nil, errors.New("cannot decrypt peer unseal response")
+	}
+	var keys peerUnsealKeys
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// peerUnsealHandler gives the CA keys of an unsealed instance to a sealed
+// peer, encrypted to the ephemeral key of the request.
+func (state *RuntimeState) peerUnsealHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+		state.writeFailureResponse(w, r, http.StatusForbidden, "")
+		return
+	}
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	peerCert := r.TLS.VerifiedChains[0][0]
+	if !state.isUnsealPeer(peerCert) {
+		logger.Printf("Peer unseal request from unknown peer %s",
+			peerCert.Subject.CommonName)
+		state.writeFailureResponse(w, r, http.StatusForbidden, "")
+		return
+	}
+	r.ParseForm()
+	requestKey, err := base64.StdEncoding.DecodeString(r.Form.Get("public_key"))
+	var peerPublicKey [32]byte
+	if err != nil || len(requestKey) != len(peerPublicKey) {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Post, bad public_key")
+		return
+	}
+	copy(peerPublicKey[:], requestKey)
+	var keys peerUnsealKeys
+	state.Mutex.Lock()
+	signer := state.Signer
+	nextSigner := state.NextSigner
+	state.Mutex.Unlock()
+	if signer == nil {
+		state.writeFailureResponse(w, r, http.StatusConflict, "Signer is sealed")
+		return
+	}
+	keys.CAKey, err = marshalSignerToPEM(signer)
+	if err == nil && nextSigner != nil {
+		keys.NextCAKey, err = marshalSignerToPEM(nextSigner)
+	}
+	if err != nil {
+		logger.Printf("Cannot export CA key for peer: %s", err)
+		state.writeFailureResponse(w, r, http.StatusConflict, "CA key cannot be exported")
+		return
+	}
+	response, err := sealPeerUnsealKeys(&keys, &peerPublicKey)
+	if err != nil {
+		logger.Printf("Cannot encrypt CA key for peer: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Printf("Sending CA keys to peer %s", peerCert.Subject.CommonName)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Printf("Error encoding peer unseal response: %s", err)
+	}
+}
+
+// requestPeerUnseal asks the keymasterd at peerURL for the CA keys and
+// installs them.
+func (state *RuntimeState) requestPeerUnseal(client *http.Client,
+	peerURL string) error {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	resp, err := client.PostForm(peerURL+peerUnsealPath, url.Values{
+		"public_key": {base64.StdEncoding.EncodeToString(publicKey[:])}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	var response peerUnsealResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	keys, err := openPeerUnsealResponse(&response, privateKey)
+	if err != nil {
+		return err
+	}
+	signer, err := getSignerFromPEMBytes(keys.CAKey)
+	if err != nil {
+		return err
+	}
+	var nextSigner crypto.Signer
+	if len(keys.NextCAKey) > 0 {
+		nextSigner, err = getSignerFromPEMBytes(keys.NextCAKey)
+		if err != nil {
+			return err
+		}
+	}
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+	if state.Signer != nil {
+		return nil
+	}
+	return state.installUnsealedSigner(signer, nextSigner, nil)
+}
+
+// peerUnsealLoop tries the unseal peers in turn until the signer is loaded,
+// either by a peer or by an operator.
+func (state *RuntimeState) peerUnsealLoop() {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{*state.peerCertificate},
+		RootCAs:      state.ClientCAPool,
+		NextProtos:   []string{peerUnsealProto, "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   10 * time.Second,
+	}
+	for {
+		state.Mutex.Lock()
+		sealed := state.Signer == nil
+		state.Mutex.Unlock()
+		if !sealed {
+			return
+		}
+		for _, peer := range state.Config.Base.UnsealPeers {
+			err := state.requestPeerUnseal(client, "https://"+peer)
+			if err != nil {
+				logger.Debugf(1, "Cannot unseal from peer %s: %s", peer, err)
+				continue
+			}
+			logger.Printf("Unsealed by peer %s", peer)
+			return
+		}
+		time.Sleep(secsBetweenPeerUnsealAttempts * time.Second)
+	}
+}
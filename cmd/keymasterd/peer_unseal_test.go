@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withPeerCert fakes a TLS connection from a client certificate with
+// commonName.
+func withPeerCert(commonName string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var subjectCert x509.Certificate
+		subjectCert.Subject.CommonName = commonName
+		r.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{&subjectCert}},
+		}
+		handler(w, r)
+	})
+}
+
+func TestValidateUnsealPeers(t *testing.T) {
+	config := baseConfig{UnsealPeers: []string{"peer1.example.com:6920"}}
+	if err := config.validateUnsealPeers(); err == nil {
+		t.Fatal("should have failed without peer certificate")
+	}
+	config.PeerCertFilename = "peer.pem"
+	config.PeerKeyFilename = "peer.key"
+	if err := config.validateUnsealPeers(); err != nil {
+		t.Fatal(err)
+	}
+	config.UnsealPeers = append(config.UnsealPeers, "peer2.example.com")
+	if err := config.validateUnsealPeers(); err == nil {
+		t.Fatal("should have failed without port")
+	}
+}
+
+func TestPeerUnseal(t *testing.T) {
+	unsealedState, cleanup := setupRevocationState(t)
+	defer cleanup()
+	unsealedState.Config.Base.UnsealPeers = []string{"peer1.example.com:6920"}
+
+	var sealedState RuntimeState
+	sealedState.SignerIsReady = make(chan bool, 1)
+	client := &http.Client{}
+
+	server := httptest.NewServer(withPeerCert("unknown.example.com",
+		unsealedState.peerUnsealHandler))
+	err := sealedState.requestPeerUnseal(client, server.URL)
+	server.Close()
+	if err == nil {
+		t.Fatal("unknown peer should have been rejected")
+	}
+	if sealedState.Signer != nil {
+		t.Fatal("signer loaded from rejected request")
+	}
+
+	server = httptest.NewServer(withPeerCert("peer1.example.com",
+		unsealedState.peerUnsealHandler))
+	defer server.Close()
+	if err := sealedState.requestPeerUnseal(client, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if sealedState.Signer == nil {
+		t.Fatal("The signer should now be loaded")
+	}
+	if !<-sealedState.SignerIsReady {
+		t.Fatal("signer not ready")
+	}
+	fp, err := getKeyFingerprint(sealedState.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFp, err := getKeyFingerprint(unsealedState.Signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != expectedFp {
+		t.Fatal("received a different CA key")
+	}
+
+	// A sealed peer cannot help.
+	var sealedPeer RuntimeState
+	sealedPeer.Config.Base.UnsealPeers = unsealedState.Config.Base.UnsealPeers
+	server2 := httptest.NewServer(withPeerCert("peer1.example.com",
+		sealedPeer.peerUnsealHandler))
+	defer server2.Close()
+	var otherState RuntimeState
+	if err := otherState.requestPeerUnseal(client, server2.URL); err == nil {
+		t.Fatal("sealed peer should have failed")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/Symantec/keymaster/lib/pkcs11signer"
+)
+
+const (
+	signerTypeFile   = "file"
+	signerTypePKCS11 = "pkcs11"
+)
+
+func (config *baseConfig) validateSignerType() error {
+	switch config.SignerType {
+	case "", signerTypeFile:
+		return nil
+	case signerTypePKCS11:
+		if config.PKCS11ModulePath == "" || config.PKCS11TokenLabel == "" ||
+			config.PKCS11KeyLabel == "" {
+			return errors.New("pkcs11 signer needs pkcs11_module_path, " +
+				"pkcs11_token_label and pkcs11_key_label")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown signer_type: %s", config.SignerType)
+	}
+}
+
+func newPKCS11Signer(config baseConfig, pin string) (crypto.Signer, error) {
+	return pkcs11signer.New(config.PKCS11ModulePath, config.PKCS11TokenLabel,
+		config.PKCS11KeyLabel, pin)
+}
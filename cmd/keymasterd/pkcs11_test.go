@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateSignerType(t *testing.T) {
+	goodConfigs := []baseConfig{
+		{},
+		{SignerType: signerTypeFile},
+		{SignerType: signerTypePKCS11, PKCS11ModulePath: "/lib/softhsm2.so",
+			PKCS11TokenLabel: "keymaster", PKCS11KeyLabel: "ca"},
+	}
+	for _, config := range goodConfigs {
+		if err := config.validateSignerType(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	badConfigs := []baseConfig{
+		{SignerType: "tpm"},
+		{SignerType: signerTypePKCS11, PKCS11ModulePath: "/lib/softhsm2.so"},
+	}
+	for _, config := range badConfigs {
+		if err := config.validateSignerType(); err == nil {
+			t.Fatalf("should have failed: %+v", config)
+		}
+	}
+	_, err := newPKCS11Signer(baseConfig{SignerType: signerTypePKCS11,
+		PKCS11ModulePath: "/nonexistent/pkcs11.so",
+		PKCS11TokenLabel: "keymaster", PKCS11KeyLabel: "ca"}, "1234")
+	if err == nil {
+		t.Fatal("should have failed with missing module")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	revokeCertificatePath = "/api/v0/revokeCertificate"
+	sshKRLPublicName      = "krl"
+	x509CRLPublicName     = "x509crl"
+	// The CRL of the CA retired by the last rotation, see ca_rotation.go
+	retiredX509CRLPublicName = "retiredX509crl"
+	crlValidity              = 10 * time.Minute
+	maxRevocationReason      = 256
+)
+
+func (state *RuntimeState) revokedSSHSerials(certType string) ([]uint64, error) {
+	revokedCerts, _, err := state.GetRevokedCerts(certType)
+	if err != nil {
+		return nil, err
+	}
+	var serials []uint64
+	for _, cert := range revokedCerts {
+		serial, err := strconv.ParseUint(cert.Serial, 10, 64)
+		if err != nil {
+			logger.Printf("Invalid ssh serial in DB: %s", cert.Serial)
+			continue
+		}
+		serials = append(serials, serial)
+	}
+	return serials, nil
+}
+
+func (state *RuntimeState) genSSHKRL() ([]byte, error) {
+	state.Mutex.Lock()
+	signer := state.Signer
+	hostSigner := state.HostSigner
+	state.Mutex.Unlock()
+	if signer == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	serials, err := state.revokedSSHSerials("ssh")
+	if err != nil {
+		return nil, err
+	}
+	// Serials are random so revoking them for all published CA keys is safe
+	caKeys, _ := state.publishedCAKeys()
+	var sections []certgen.SSHKRLCertSection
+	for _, caKey := range caKeys {
+		sshCAKey, err := ssh.NewPublicKey(caKey)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, certgen.SSHKRLCertSection{
+			CAKey: sshCAKey, Serials: serials})
+	}
+	if hostSigner != nil {
+		sshHostSigner, err := ssh.NewSignerFromSigner(hostSigner)
+		if err != nil {
+			return nil, err
+		}
+		hostSerials, err := state.revokedSSHSerials("ssh-host")
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, certgen.SSHKRLCertSection{
+			CAKey: sshHostSigner.PublicKey(), Serials: hostSerials})
+	}
+	return certgen.GenSSHKRLSections(sections, time.Now(), state.HostIdentity)
+}
+
+func (state *RuntimeState) genX509CRL() ([]byte, error) {
+	signer, caCertDer := state.getX509CA()
+	if signer == nil {
+		return nil, errors.New("signer not loaded")
+	}
+	return state.genX509CRLForCA(signer, caCertDer)
+}
+
+// genRetiredX509CRL returns the CRL of the retired CA, which is nil if there
+// is none.
+func (state *RuntimeState) genRetiredX509CRL() ([]byte, error) {
+	signer, caCertDer := state.getRetiredX509CA()
+	if signer == nil {
+		return nil, nil
+	}
+	return state.genX509CRLForCA(signer, caCertDer)
+}
+
+// genX509CRLForCA returns the CRL signed by signer. Serials are random so
+// listing all of them in the CRL of every CA is safe.
+func (state *RuntimeState) genX509CRLForCA(signer crypto.Signer,
+	caCertDer []byte) ([]byte, error) {
+	caCert, err := x509.ParseCertificate(caCertDer)
+	if err != nil {
+		return nil, err
+	}
+	revokedCerts, _, err := state.GetRevokedCerts("x509")
+	if err != nil {
+		return nil, err
+	}
+	revokedList := make([]pkix.RevokedCertificate, 0, len(revokedCerts))
+	for _, cert := range revokedCerts {
+		serial, ok := new(big.Int).SetString(cert.Serial, 10)
+		if !ok {
+			logger.Printf("Invalid x509 serial in DB: %s", cert.Serial)
+			continue
+		}
+		revokedList = append(revokedList, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: time.Unix(cert.RevokedEpoch, 0).UTC(),
+		})
+	}
+	now := time.Now()
+	return caCert.CreateCRL(rand.Reader, signer, revokedList, now,
+		now.Add(crlValidity))
+}
+
+// isOwnX509CertRevoked checks certificates issued by this keymaster, with
+// the current or the retired CA, against the local revocation ledger.
+// Certificates from other issuers are reported as not revoked.
+func (state *RuntimeState) isOwnX509CertRevoked(
+	userCert *x509.Certificate) (bool, error) {
+	_, caCertDer := state.getX509CA()
+	_, retiredCACertDer := state.getRetiredX509CA()
+	ownIssuer := false
+	for _, certDer := range [][]byte{caCertDer, retiredCACertDer} {
+		if len(certDer) == 0 {
+			continue
+		}
+		caCert, err := x509.ParseCertificate(certDer)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(userCert.RawIssuer, caCert.RawSubject) {
+			ownIssuer = true
+		}
+	}
+	if !ownIssuer {
+		return false, nil
+	}
+	revokedCerts, _, err := state.GetRevokedCerts("x509")
+	if err != nil {
+		return false, err
+	}
+	serial := userCert.SerialNumber.String()
+	for _, cert := range revokedCerts {
+		if cert.Serial == serial {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type revokeCertificateResponse struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Revoked int64  `json:"revoked"`
+}
+
+func (state *RuntimeState) revokeCertificateHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	authUser, loginLevel, err := state.checkAuth(w, r,
+		state.getRequiredWebUIAuthLevel())
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if !state.IsAdminUserAndU2F(authUser, loginLevel) {
+		state.writeFailureResponse(w, r, http.StatusUnauthorized,
+			"Not an admin")
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	var field, value string
+	for _, name := range []string{"serial", "username", "fingerprint"} {
+		formValue := strings.TrimSpace(r.Form.Get(name))
+		if formValue == "" {
+			continue
+		}
+		if field != "" {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Only one of serial, username or fingerprint may be given")
+			return
+		}
+		field = name
+		value = formValue
+	}
+	if field == "" {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"One of serial, username or fingerprint is required")
+		return
+	}
+	reason := r.Form.Get("reason")
+	if len(reason) > maxRevocationReason {
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Reason too long")
+		return
+	}
+	count, err := state.RevokeIssuedCerts(field, value, reason)
+	if err != nil {
+		logger.Printf("Revoking certs error: %v", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	logger.Printf("%s revoked %d certificates with %s=%s reason='%s'",
+		authUser, count, field, value, reason)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revokeCertificateResponse{
+		Field:   field,
+		Value:   value,
+		Revoked: count,
+	})
+}
+
+func (state *RuntimeState) writeRevocationList(w http.ResponseWriter,
+	r *http.Request, target string) {
+	var data []byte
+	var err error
+	var contentType, filename string
+	switch target {
+	case sshKRLPublicName:
+		data, err = state.genSSHKRL()
+		contentType = "application/octet-stream"
+		filename = "keymaster.krl"
+	case x509CRLPublicName:
+		data, err = state.genX509CRL()
+		contentType = "application/pkix-crl"
+		filename = "keymaster.crl"
+	case retiredX509CRLPublicName:
+		data, err = state.genRetiredX509CRL()
+		if err == nil && data == nil {
+			state.writeFailureResponse(w, r, http.StatusNotFound,
+				"No retired CA")
+			return
+		}
+		contentType = "application/pkix-crl"
+		filename = "keymaster-retired.crl"
+	default:
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		logger.Printf("Cannot generate %s: %s", target, err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(200)
+	w.Write(data)
+}
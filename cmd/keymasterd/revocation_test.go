@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+func setupRevocationState(t *testing.T) (*RuntimeState, func()) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = initDB(state)
+	if err != nil {
+		os.Remove(passwdFile.Name())
+		t.Fatal(err)
+	}
+	return state, func() { os.Remove(passwdFile.Name()) }
+}
+
+func TestRevokeSSHCertAndPublishKRL(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	username := fmt.Sprintf("revoke-ssh-%d", time.Now().UnixNano())
+	sshSigner, err := ssh.NewSignerFromSigner(state.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serials []uint64
+	for i := 0; i < 2; i++ {
+		_, certBytes, err := certgen.GenSSHCertFileString(username,
+			testUserSSHPublicKey, sshSigner, "host", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.recordIssuedSSHCert(username, certBytes, certAuditInfo{})
+		pubKey, err := ssh.ParsePublicKey(certBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, pubKey.(*ssh.Certificate).Serial)
+	}
+	count, err := state.RevokeIssuedCerts("serial",
+		strconv.FormatUint(serials[0], 10), "lost laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("revoked %d certs, expected 1", count)
+	}
+	// Already revoked certs are not counted again.
+	count, err = state.RevokeIssuedCerts("username", username, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("revoked %d certs, expected 1", count)
+	}
+	krl, err := state.genSSHKRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range serials {
+		var encoded [8]byte
+		binary.BigEndian.PutUint64(encoded[:], serial)
+		if !strings.Contains(string(krl), string(encoded[:])) {
+			t.Fatalf("serial %d not in KRL", serial)
+		}
+	}
+	if _, err := state.RevokeIssuedCerts("badfield", "x", ""); err == nil {
+		t.Fatal("should have failed with bad field")
+	}
+}
+
+func TestRevokeX509CertAndPublishCRL(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	username := fmt.Sprintf("revoke-x509-%d", time.Now().UnixNano())
+	caCert, err := x509.ParseCertificate(state.caCertDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, err := getPubKeyFromPem(testUserPEMPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derCert, err := certgen.GenUserX509Cert(username, userPub, caCert,
+		state.Signer, nil, time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.recordIssuedX509Cert(username, derCert, certAuditInfo{})
+	userCert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := state.isOwnX509CertRevoked(userCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("cert should not be revoked yet")
+	}
+	sshPubKey, err := ssh.NewPublicKey(userCert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := state.RevokeIssuedCerts("fingerprint",
+		ssh.FingerprintSHA256(sshPubKey), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 1 {
+		t.Fatal("nothing revoked")
+	}
+	revoked, err = state.isOwnX509CertRevoked(userCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("cert should be revoked")
+	}
+	crlBytes, err := state.genX509CRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := caCert.CheckCRLSignature(crl); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, revokedCert := range crl.TBSCertList.RevokedCertificates {
+		if revokedCert.SerialNumber.Cmp(userCert.SerialNumber) == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("serial not in CRL")
+	}
+}
+
+func TestRevokeCertificateHandlerNonAdmin(t *testing.T) {
+	state, cleanup := setupRevocationState(t)
+	defer cleanup()
+	form := url.Values{}
+	form.Add("username", "someone")
+	req, err := http.NewRequest("POST", revokeCertificatePath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	_, err = checkRequestHandlerCode(req, state.revokeCertificateHandler,
+		http.StatusUnauthorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
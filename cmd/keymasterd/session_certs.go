@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// SessionCertsConfig is the policy for getting certificates with an
+// existing login session, which clients use to renew their certificates
+// without asking for the password again.
+type SessionCertsConfig struct {
+	// MaxAgeSecs limits how long after the login a session can get
+	// certificates. 0 means until the auth cookie expires.
+	MaxAgeSecs int `yaml:"max_age_secs"`
+	// RenewalAuthBackends are the backends a session must have used to get
+	// certificates after sessionCertsGracePeriod. Empty means any of
+	// allowed_auth_backends_for_certs.
+	RenewalAuthBackends []string `yaml:"renewal_auth_backends"`
+}
+
+// sessionCertsGracePeriod is how long after the login the certificates of
+// the login itself are requested.
+const sessionCertsGracePeriod = 5 * time.Minute
+
+func (config *SessionCertsConfig) validate() error {
+	if config.MaxAgeSecs < 0 {
+		return errors.New("session_certs: negative max_age_secs")
+	}
+	for _, backend := range config.RenewalAuthBackends {
+		if backend == proto.AuthTypePassword {
+			continue
+		}
+		if _, ok := getSecondFactorProvider(backend); !ok {
+			return fmt.Errorf("session_certs: unknown auth backend: %s",
+				backend)
+		}
+	}
+	return nil
+}
+
+// checkSessionCertPolicy returns an error if the auth cookie of r, with
+// authLevel, cannot be used to get certificates at now. Requests without an
+// auth cookie are not sessions and are always allowed.
+func (state *RuntimeState) checkSessionCertPolicy(r *http.Request,
+	authLevel int, now time.Time) error {
+	var authCookie *http.Cookie
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != authCookieName {
+			continue
+		}
+		authCookie = cookie
+	}
+	if authCookie == nil {
+		return nil
+	}
+	info, err := state.getAuthInfoFromAuthJWT(authCookie.Value)
+	if err != nil {
+		return err
+	}
+	age := now.Sub(info.IssuedAt)
+	if age <= sessionCertsGracePeriod {
+		return nil
+	}
+	config := state.Config.SessionCerts
+	if config.MaxAgeSecs > 0 &&
+		age > time.Duration(config.MaxAgeSecs)*time.Second {
+		return errors.New("session too old to get certificates, login again")
+	}
+	if len(config.RenewalAuthBackends) == 0 {
+		return nil
+	}
+	for _, backend := range config.RenewalAuthBackends {
+		if backend == proto.AuthTypePassword {
+			return nil
+		}
+		provider, ok := getSecondFactorProvider(backend)
+		if ok && (authLevel&provider.AuthType()) == provider.AuthType() {
+			return nil
+		}
+	}
+	return errors.New("session auth level too low to renew certificates, login again")
+}
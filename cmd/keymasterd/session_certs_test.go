@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+func TestSessionCertsConfigValidate(t *testing.T) {
+	config := SessionCertsConfig{MaxAgeSecs: 3600,
+		RenewalAuthBackends: []string{proto.AuthTypeU2F, proto.AuthTypeTOTP}}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.RenewalAuthBackends = []string{"bogus"}
+	if err := config.validate(); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+	config = SessionCertsConfig{MaxAgeSecs: -1}
+	if err := config.validate(); err == nil {
+		t.Fatal("negative max age accepted")
+	}
+}
+
+func TestCheckSessionCertPolicy(t *testing.T) {
+	state, passwordCookie, cleanup := setupProfileTestState(t)
+	defer cleanup()
+	u2fCookieVal, err := state.setNewAuthCookie(nil, "username",
+		AuthTypePassword|AuthTypeU2F)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2fCookie := &http.Cookie{Name: authCookieName, Value: u2fCookieVal}
+	newRequest := func(cookie *http.Cookie) *http.Request {
+		req, err := http.NewRequest("POST", certgenPath+"username", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+	now := time.Now()
+	later := now.Add(2 * time.Hour)
+	tests := []struct {
+		config    SessionCertsConfig
+		cookie    *http.Cookie
+		authLevel int
+		now       time.Time
+		ok        bool
+	}{
+		{SessionCertsConfig{}, passwordCookie, AuthTypePassword, later, true},
+		{SessionCertsConfig{MaxAgeSecs: 3600}, passwordCookie,
+			AuthTypePassword, now, true},
+		{SessionCertsConfig{MaxAgeSecs: 3600}, passwordCookie,
+			AuthTypePassword, later, false},
+		{SessionCertsConfig{MaxAgeSecs: 3600}, nil, AuthTypeIPCertificate,
+			later, true},
+		{SessionCertsConfig{RenewalAuthBackends: []string{proto.AuthTypeU2F}},
+			passwordCookie, AuthTypePassword, now, true},
+		{SessionCertsConfig{RenewalAuthBackends: []string{proto.AuthTypeU2F}},
+			passwordCookie, AuthTypePassword, later, false},
+		{SessionCertsConfig{RenewalAuthBackends: []string{proto.AuthTypeU2F}},
+			u2fCookie, AuthTypePassword | AuthTypeU2F, later, true},
+	}
+	for i, test := range tests {
+		state.Config.SessionCerts = test.config
+		err := state.checkSessionCertPolicy(newRequest(test.cookie),
+			test.authLevel, test.now)
+		if (err == nil) != test.ok {
+			t.Errorf("test %d: expected ok=%v, got %v", i, test.ok, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCertPolicyConfig describes how the ssh certificates issued to a set of
+// users and/or group members differ from the default user certificate.
+// A policy applies to a user if the username is listed in Users or if the
+// user is a member of any of the listed Groups.
+type SSHCertPolicyConfig struct {
+	Name            string   `yaml:"name"`
+	Users           []string `yaml:"users"`
+	Groups          []string `yaml:"groups"`
+	ExtraPrincipals []string `yaml:"extra_principals"`
+	DropExtensions  []string `yaml:"drop_extensions"`
+	ForceCommand    string   `yaml:"force_command"`
+	SourceAddresses []string `yaml:"source_addresses"`
+}
+
+const (
+	sshCriticalOptionForceCommand  = "force-command"
+	sshCriticalOptionSourceAddress = "source-address"
+)
+
+func (policy *SSHCertPolicyConfig) validate() error {
+	if len(policy.Users) < 1 && len(policy.Groups) < 1 {
+		return fmt.Errorf("ssh cert policy '%s' has no users or groups",
+			policy.Name)
+	}
+	for _, principal := range policy.ExtraPrincipals {
+		if len(principal) < 1 || strings.ContainsAny(principal, ", \t\n") {
+			return fmt.Errorf("ssh cert policy '%s': invalid principal '%s'",
+				policy.Name, principal)
+		}
+	}
+	for _, address := range policy.SourceAddresses {
+		if strings.Contains(address, "/") {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				return fmt.Errorf("ssh cert policy '%s': %s", policy.Name, err)
+			}
+			continue
+		}
+		if net.ParseIP(address) == nil {
+			return fmt.Errorf("ssh cert policy '%s': invalid address '%s'",
+				policy.Name, address)
+		}
+	}
+	return nil
+}
+
+func (policy *SSHCertPolicyConfig) appliesTo(username string,
+	groups map[string]struct{}) bool {
+	for _, user := range policy.Users {
+		if user == username {
+			return true
+		}
+	}
+	for _, group := range policy.Groups {
+		if _, ok := groups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *RuntimeState) sshCertPoliciesNeedGroups() bool {
+	for _, policy := range state.Config.SSHCertPolicies {
+		if len(policy.Groups) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getSSHCertPrincipalsAndPermissions returns the principals and permissions
+// for an ssh certificate issued to username. All matching policies are
+// merged: principals are added, extensions dropped by any policy are removed
+// and for critical options the first matching policy in config order wins.
+func (state *RuntimeState) getSSHCertPrincipalsAndPermissions(
+	username string) ([]string, ssh.Permissions, error) {
+	principals := []string{username}
+	permissions := ssh.Permissions{Extensions: certgen.DefaultSSHExtensions()}
+	if len(state.Config.SSHCertPolicies) < 1 {
+		return principals, permissions, nil
+	}
+	groups := make(map[string]struct{})
+	if state.sshCertPoliciesNeedGroups() {
+		// Fail closed: a policy may be restricting members of a group.
+		userGroups, err := state.getUserGroups(username)
+		if err != nil {
+			return nil, permissions, err
+		}
+		for _, group := range userGroups {
+			groups[group] = struct{}{}
+		}
+	}
+	seenPrincipals := map[string]struct{}{username: struct{}{}}
+	criticalOptions := make(map[string]string)
+	for _, policy := range state.Config.SSHCertPolicies {
+		if !policy.appliesTo(username, groups) {
+			continue
+		}
+		logger.Debugf(1, "ssh cert policy '%s' applies to %s",
+			policy.Name, username)
+		for _, principal := range policy.ExtraPrincipals {
+			if _, ok := seenPrincipals[principal]; ok {
+				continue
+			}
+			seenPrincipals[principal] = struct{}{}
+			principals = append(principals, principal)
+		}
+		for _, extension := range policy.DropExtensions {
+			delete(permissions.Extensions, extension)
+		}
+		if _, ok := criticalOptions[sshCriticalOptionForceCommand]; !ok &&
+			policy.ForceCommand != "" {
+			criticalOptions[sshCriticalOptionForceCommand] = policy.ForceCommand
+		}
+		if _, ok := criticalOptions[sshCriticalOptionSourceAddress]; !ok &&
+			len(policy.SourceAddresses) > 0 {
+			criticalOptions[sshCriticalOptionSourceAddress] = strings.Join(
+				policy.SourceAddresses, ",")
+		}
+	}
+	if len(criticalOptions) > 0 {
+		permissions.CriticalOptions = criticalOptions
+	}
+	return principals, permissions, nil
+}
+
+func validateSSHCertPolicies(policies []SSHCertPolicyConfig) error {
+	names := make(map[string]struct{})
+	for i := range policies {
+		if err := policies[i].validate(); err != nil {
+			return err
+		}
+		if policies[i].Name == "" {
+			continue
+		}
+		if _, ok := names[policies[i].Name]; ok {
+			return errors.New("duplicate ssh cert policy name: " +
+				policies[i].Name)
+		}
+		names[policies[i].Name] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetSSHCertPrincipalsAndPermissionsDefault(t *testing.T) {
+	var state RuntimeState
+	principals, permissions, err := state.getSSHCertPrincipalsAndPermissions(
+		"username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(principals) != 1 || principals[0] != "username" {
+		t.Fatalf("bad principals: %v", principals)
+	}
+	if len(permissions.Extensions) != 5 {
+		t.Fatalf("bad extensions: %v", permissions.Extensions)
+	}
+	if len(permissions.CriticalOptions) != 0 {
+		t.Fatalf("unexpected critical options: %v",
+			permissions.CriticalOptions)
+	}
+}
+
+func TestGetSSHCertPrincipalsAndPermissionsMerge(t *testing.T) {
+	var state RuntimeState
+	state.Config.SSHCertPolicies = []SSHCertPolicyConfig{
+		{
+			Name:            "deployers",
+			Users:           []string{"username"},
+			ExtraPrincipals: []string{"deploy", "username"},
+			DropExtensions:  []string{"permit-X11-forwarding"},
+			ForceCommand:    "/usr/bin/deploy",
+		},
+		{
+			Name:            "restricted",
+			Users:           []string{"username", "other"},
+			ExtraPrincipals: []string{"backup", "deploy"},
+			DropExtensions:  []string{"permit-port-forwarding"},
+			ForceCommand:    "/bin/false",
+			SourceAddresses: []string{"10.0.0.0/8", "192.168.1.1"},
+		},
+		{
+			Name:            "admins",
+			Groups:          []string{"admins"},
+			ExtraPrincipals: []string{"root"},
+		},
+	}
+	principals, permissions, err := state.getSSHCertPrincipalsAndPermissions(
+		"username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPrincipals := []string{"username", "deploy", "backup"}
+	if len(principals) != len(expectedPrincipals) {
+		t.Fatalf("bad principals: %v", principals)
+	}
+	for i, principal := range expectedPrincipals {
+		if principals[i] != principal {
+			t.Fatalf("bad principals: %v", principals)
+		}
+	}
+	if len(permissions.Extensions) != 3 {
+		t.Fatalf("bad extensions: %v", permissions.Extensions)
+	}
+	if _, ok := permissions.Extensions["permit-port-forwarding"]; ok {
+		t.Fatal("permit-port-forwarding not dropped")
+	}
+	if permissions.CriticalOptions["force-command"] != "/usr/bin/deploy" {
+		t.Fatalf("bad force-command: %v", permissions.CriticalOptions)
+	}
+	if permissions.CriticalOptions["source-address"] !=
+		"10.0.0.0/8,192.168.1.1" {
+		t.Fatalf("bad source-address: %v", permissions.CriticalOptions)
+	}
+	// Policies for other users must not leak.
+	principals, permissions, err = state.getSSHCertPrincipalsAndPermissions(
+		"nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(principals) != 1 || len(permissions.CriticalOptions) != 0 {
+		t.Fatalf("policy applied to wrong user: %v %v", principals,
+			permissions.CriticalOptions)
+	}
+}
+
+func TestValidateSSHCertPolicies(t *testing.T) {
+	badPolicies := [][]SSHCertPolicyConfig{
+		{{Name: "empty"}},
+		{{Name: "badcidr", Users: []string{"a"},
+			SourceAddresses: []string{"10.0.0.0/33"}}},
+		{{Name: "badip", Users: []string{"a"},
+			SourceAddresses: []string{"not-an-ip"}}},
+		{{Name: "badprincipal", Users: []string{"a"},
+			ExtraPrincipals: []string{"a,b"}}},
+		{{Name: "dup", Users: []string{"a"}}, {Name: "dup", Users: []string{"b"}}},
+	}
+	for _, policies := range badPolicies {
+		if err := validateSSHCertPolicies(policies); err == nil {
+			t.Fatalf("should have failed: %+v", policies)
+		}
+	}
+	goodPolicies := []SSHCertPolicyConfig{
+		{Name: "good", Groups: []string{"g"},
+			SourceAddresses: []string{"10.0.0.0/8", "::1"}},
+	}
+	if err := validateSSHCertPolicies(goodPolicies); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"github.com/Symantec/keymaster/lib/instrumentedwriter"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHHostCertIdentityConfig lists the hostnames an identity may obtain host
+// certificates for. Identity is the common name of an IP restricted
+// certificate or the name of an automation user. Patterns use shell glob
+// syntax, so "*.example.com" matches any host below example.com.
+type SSHHostCertIdentityConfig struct {
+	Identity         string   `yaml:"identity"`
+	HostnamePatterns []string `yaml:"hostname_patterns"`
+}
+
+type SSHHostCertConfig struct {
+	CAFilename      string                      `yaml:"ca_filename"`
+	MaxDurationSecs int                         `yaml:"max_duration_secs"`
+	Identities      []SSHHostCertIdentityConfig `yaml:"identities"`
+}
+
+const (
+	sshHostCertPath               = "/api/v0/sshHostCert"
+	sshHostCAPublicName           = "sshHostCA"
+	defaultSSHHostCertMaxDuration = 7 * 24 * time.Hour
+	maxSSHHostCertHostnames       = 32
+)
+
+var validHostnameRegexp = regexp.MustCompile(
+	"^[a-zA-Z0-9]([-a-zA-Z0-9.]{0,251}[a-zA-Z0-9])?$")
+
+func (config *SSHHostCertConfig) validate() error {
+	if config.CAFilename == "" {
+		if len(config.Identities) > 0 {
+			return errors.New("ssh_host_cert: identities without ca_filename")
+		}
+		return nil
+	}
+	if config.MaxDurationSecs < 0 {
+		return errors.New("ssh_host_cert: negative max_duration_secs")
+	}
+	for _, identity := range config.Identities {
+		if identity.Identity == "" {
+			return errors.New("ssh_host_cert: empty identity")
+		}
+		for _, pattern := range identity.HostnamePatterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("ssh_host_cert: bad pattern '%s' for %s",
+					pattern, identity.Identity)
+			}
+		}
+	}
+	return nil
+}
+
+func (config *SSHHostCertConfig) maxDuration() time.Duration {
+	if config.MaxDurationSecs > 0 {
+		return time.Duration(config.MaxDurationSecs) * time.Second
+	}
+	return defaultSSHHostCertMaxDuration
+}
+
+func loadSSHHostCASigner(filename string) (crypto.Signer, error) {
+	keyBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rawKey, err := ssh.ParseRawPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := rawKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ssh host CA key is not a signer")
+	}
+	return signer, nil
+}
+
+// checkHostnamesAllowed verifies that identity may obtain a host certificate
+// valid for all of hostnames.
+func (state *RuntimeState) checkHostnamesAllowed(identity string,
+	hostnames []string) error {
+	var patterns []string
+	for _, identityConfig := range state.Config.SSHHostCert.Identities {
+		if identityConfig.Identity == identity {
+			patterns = append(patterns, identityConfig.HostnamePatterns...)
+		}
+	}
+	if len(patterns) < 1 {
+		return fmt.Errorf("%s is not allowed to request host certs", identity)
+	}
+	for _, hostname := range hostnames {
+		allowed := false
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, hostname); matched {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s is not allowed a host cert for %s",
+				identity, hostname)
+		}
+	}
+	return nil
+}
+
+func parseHostnames(value string) ([]string, error) {
+	var hostnames []string
+	for _, hostname := range strings.Split(value, ",") {
+		hostname = strings.ToLower(strings.TrimSpace(hostname))
+		if hostname == "" {
+			continue
+		}
+		if net.ParseIP(hostname) == nil &&
+			!validHostnameRegexp.MatchString(hostname) {
+			return nil, fmt.Errorf("invalid hostname: %s", hostname)
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	if len(hostnames) < 1 {
+		return nil, errors.New("no hostnames")
+	}
+	if len(hostnames) > maxSSHHostCertHostnames {
+		return nil, errors.New("too many hostnames")
+	}
+	return hostnames, nil
+}
+
+func (state *RuntimeState) sshHostCertHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if state.sendFailureToClientIfLocked(w, r) {
+		return
+	}
+	state.Mutex.Lock()
+	hostSigner := state.HostSigner
+	state.Mutex.Unlock()
+	if hostSigner == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound,
+			"Host certificates are not enabled")
+		return
+	}
+	authUser, authLevel, err := state.checkAuth(w, r, AuthTypeAny)
+	if err != nil {
+		logger.Debugf(1, "%v", err)
+		return
+	}
+	w.(*instrumentedwriter.LoggingWriter).SetUsername(authUser)
+	if r.Method != "POST" {
+		state.writeFailureResponse(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+	// IP restricted certs have already been checked to belong to automation
+	// users, other credentials must be checked here.
+	if (authLevel & AuthTypeIPCertificate) == 0 {
+		ok, err := state.isAutomationUser(authUser)
+		if err != nil {
+			logger.Printf("Error checking automation user %s: %s", authUser, err)
+			state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusForbidden,
+				"Not an automation user")
+			return
+		}
+	}
+	err = r.ParseMultipartForm(1e7)
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Error parsing form")
+		return
+	}
+	hostnames, err := parseHostnames(r.Form.Get("hostnames"))
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := state.checkHostnamesAllowed(authUser, hostnames); err != nil {
+		logger.Printf("Host cert denied: %s", err)
+		state.writeFailureResponse(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	auditInfo := newCertAuditInfo(r, authLevel)
+	duration := state.Config.SSHHostCert.maxDuration()
+	if formDuration := r.Form.Get("duration"); formDuration != "" {
+		newDuration, err := time.ParseDuration(formDuration)
+		if err != nil || newDuration <= 0 || newDuration > duration {
+			state.writeFailureResponse(w, r, http.StatusBadRequest,
+				"Error parsing form (invalid duration)")
+			return
+		}
+		duration = newDuration
+	}
+	auditInfo.RequestedDuration = duration
+	file, _, err := r.FormFile("pubkeyfile")
+	if err != nil {
+		logger.Println(err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Missing public key file")
+		return
+	}
+	defer file.Close()
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(file)
+
+	signer, err := ssh.NewSignerFromSigner(hostSigner)
+	if err != nil {
+		logger.Printf("Host signer failed to load: %s", err)
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	keyID := state.HostIdentity + "_" + authUser + "_" + hostnames[0]
+	cert, certBytes, err := certgen.GenSSHHostCertFileString(hostnames,
+		buf.String(), signer, keyID, duration)
+	if err != nil {
+		logger.Printf("Cannot generate host cert for %s: %s", authUser, err)
+		state.writeFailureResponse(w, r, http.StatusBadRequest,
+			"Invalid public key")
+		return
+	}
+	eventNotifier.PublishSSH(certBytes)
+	state.recordIssuedSSHCert(authUser, certBytes, auditInfo)
+	metricLogCertDuration("ssh-host", "granted", float64(duration.Seconds()))
+
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="ssh_host-cert.pub"`)
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "%s", cert)
+	logger.Printf("Generated SSH host certificate for %s: %v", authUser,
+		hostnames)
+}
+
+func (state *RuntimeState) writeSSHHostCAPublicKey(w http.ResponseWriter,
+	r *http.Request) {
+	state.Mutex.Lock()
+	hostSigner := state.HostSigner
+	state.Mutex.Unlock()
+	if hostSigner == nil {
+		state.writeFailureResponse(w, r, http.StatusNotFound, "")
+		return
+	}
+	pubKey, err := ssh.NewPublicKey(hostSigner.Public())
+	if err != nil {
+		state.writeFailureResponse(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "@cert-authority * %s", ssh.MarshalAuthorizedKey(pubKey))
+}
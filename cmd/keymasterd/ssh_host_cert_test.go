@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseHostnames(t *testing.T) {
+	hostnames, err := parseHostnames(" Web1.example.com,10.0.0.1,, ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostnames) != 2 || hostnames[0] != "web1.example.com" {
+		t.Fatalf("bad hostnames: %v", hostnames)
+	}
+	for _, value := range []string{"", "bad host", "-bad.example.com",
+		"a/b"} {
+		if _, err := parseHostnames(value); err == nil {
+			t.Fatalf("should have failed: '%s'", value)
+		}
+	}
+}
+
+func TestCheckHostnamesAllowed(t *testing.T) {
+	var state RuntimeState
+	state.Config.SSHHostCert.Identities = []SSHHostCertIdentityConfig{
+		{Identity: "web", HostnamePatterns: []string{"*.web.example.com",
+			"web??.example.com"}},
+	}
+	if err := state.checkHostnamesAllowed("web",
+		[]string{"a.web.example.com", "web01.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkHostnamesAllowed("web",
+		[]string{"a.web.example.com", "db01.example.com"}); err == nil {
+		t.Fatal("should have failed for db01")
+	}
+	if err := state.checkHostnamesAllowed("db",
+		[]string{"a.web.example.com"}); err == nil {
+		t.Fatal("should have failed for unknown identity")
+	}
+}
+
+func TestSSHHostCertHandler(t *testing.T) {
+	state, passwdFile, err := setupValidRuntimeStateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwdFile.Name()) // clean up
+	url := sshHostCertPath + "?hostnames=web01.example.com"
+
+	// Disabled if there is no host CA
+	req, err := createKeyBodyRequest("POST", url, testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	_, err = checkRequestHandlerCode(req, state.sshHostCertHandler,
+		http.StatusNotFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.HostSigner = state.Signer
+	state.Config.SSHHostCert.Identities = []SSHHostCertIdentityConfig{
+		{Identity: validUsernameConst,
+			HostnamePatterns: []string{"web*.example.com"}},
+	}
+
+	// Only automation users can get host certs
+	req, err = createKeyBodyRequest("POST", url, testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	_, err = checkRequestHandlerCode(req, state.sshHostCertHandler,
+		http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Config.Base.AutomationUsers = []string{validUsernameConst}
+	req, err = createKeyBodyRequest("POST", url, testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	rr, err := checkRequestHandlerCode(req, state.sshHostCertHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("not a certificate")
+	}
+	if cert.CertType != ssh.HostCert || len(cert.ValidPrincipals) != 1 ||
+		cert.ValidPrincipals[0] != "web01.example.com" {
+		t.Fatalf("bad cert: %+v", cert)
+	}
+	checker := ssh.CertChecker{IsHostAuthority: func(auth ssh.PublicKey,
+		address string) bool {
+		return strings.HasPrefix(address, "web01.example.com")
+	}}
+	if err := checker.CheckCert("web01.example.com", cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hostnames outside the patterns are denied
+	req, err = createKeyBodyRequest("POST",
+		sshHostCertPath+"?hostnames=db01.example.com", testUserSSHPublicKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(validUsernameConst, validPasswordConst)
+	_, err = checkRequestHandlerCode(req, state.sshHostCertHandler,
+		http.StatusForbidden)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -36,9 +36,25 @@ function checkError(resp) {
       location.reload();
     }).fail(serverError);
   }
+  function webauthnRegistered() {
+    alert('Success');
+    location.reload();
+  }
+  function residentKeyRequirement() {
+    var checkbox = document.getElementById('resident_key_checkbox');
+    if (checkbox && checkbox.checked) {
+      return 'required';
+    }
+    return '';
+  }
   function register() {
     var username = document.getElementById('username').textContent;
     document.getElementById('register_action_text').style.display="block";
+    if (webauthnSupported()) {
+      webauthnRegister(username, 'cross-platform', residentKeyRequirement(),
+                       webauthnRegistered, serverError);
+      return;
+    }
     $.getJSON('/u2f/RegisterRequest/' + username).done(function(req) {
       console.log(req);
       u2f.register(req.appId, req.registerRequests, req.registeredKeys, u2fRegistered, 30);
@@ -54,8 +70,21 @@ function checkError(resp) {
       alert('Success');
     }).fail(serverError);
   }
+  function registerPlatform() {
+    var username = document.getElementById('username').textContent;
+    document.getElementById('register_action_text').style.display="block";
+    webauthnRegister(username, 'platform', residentKeyRequirement(),
+                     webauthnRegistered, serverError);
+  }
   function sign() {
      document.getElementById('auth_action_text').style.display="block";
+    if (webauthnSupported()) {
+      webauthnSign(function() {
+        document.getElementById('auth_action_text').style.display="none";
+        alert('Success');
+      }, serverError);
+      return;
+    }
     $.getJSON('/u2f/SignRequest').done(function(req) {
       console.log(req);
       u2f.sign(req.appId, req.challenge, req.registeredKeys, u2fSigned, 30);
@@ -65,5 +94,13 @@ function checkError(resp) {
 document.addEventListener('DOMContentLoaded', function () {
 	  document.getElementById('auth_button').addEventListener('click', sign);
 	  document.getElementById('register_button').addEventListener('click', register);
+	  var platformButton = document.getElementById('register_platform_button');
+	  if (platformButton) {
+	    if (webauthnSupported()) {
+	      platformButton.addEventListener('click', registerPlatform);
+	    } else {
+	      platformButton.parentNode.style.display="none";
+	    }
+	  }
 	  //  main();
 });
@@ -0,0 +1,87 @@
+// Helpers for the WebAuthn endpoints of keymasterd. The server encodes
+// binary values as unpadded base64url.
+
+function webauthnSupported() {
+    return !!(window.PublicKeyCredential && navigator.credentials);
+  }
+
+function bufferDecode(value) {
+    var s = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (s.length % 4) {
+      s += '=';
+    }
+    return Uint8Array.from(atob(s), function(c) { return c.charCodeAt(0); });
+  }
+
+function bufferEncode(value) {
+    var s = String.fromCharCode.apply(null, new Uint8Array(value));
+    return btoa(s).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+function webauthnRegister(username, attachment, residentKey, done, fail) {
+    var params = {};
+    if (attachment) {
+      params.attachment = attachment;
+    }
+    if (residentKey) {
+      params.resident_key = residentKey;
+    }
+    $.getJSON('/webauthn/RegisterBegin/' + username, params).done(function(req) {
+      var options = req.publicKey;
+      options.challenge = bufferDecode(options.challenge);
+      options.user.id = bufferDecode(options.user.id);
+      if (options.excludeCredentials) {
+        options.excludeCredentials.forEach(function(c) { c.id = bufferDecode(c.id); });
+      }
+      navigator.credentials.create({publicKey: options}).then(function(cred) {
+        var resp = {
+          id: cred.id,
+          rawId: bufferEncode(cred.rawId),
+          type: cred.type,
+          authenticatorAttachment: cred.authenticatorAttachment,
+          clientExtensionResults: cred.getClientExtensionResults(),
+          response: {
+            attestationObject: bufferEncode(cred.response.attestationObject),
+            clientDataJSON: bufferEncode(cred.response.clientDataJSON),
+          },
+        };
+        if (cred.response.getTransports) {
+          resp.response.transports = cred.response.getTransports();
+        }
+        $.post('/webauthn/RegisterFinish/' + username, JSON.stringify(resp)).done(done).fail(fail);
+      }).catch(function(err) {
+        console.log(err);
+        alert('WebAuthn error: ' + err);
+      });
+    }).fail(fail);
+  }
+
+function webauthnSign(done, fail) {
+    $.getJSON('/webauthn/AuthBegin').done(function(req) {
+      var options = req.publicKey;
+      options.challenge = bufferDecode(options.challenge);
+      if (options.allowCredentials) {
+        options.allowCredentials.forEach(function(c) { c.id = bufferDecode(c.id); });
+      }
+      navigator.credentials.get({publicKey: options}).then(function(cred) {
+        var resp = {
+          id: cred.id,
+          rawId: bufferEncode(cred.rawId),
+          type: cred.type,
+          clientExtensionResults: cred.getClientExtensionResults(),
+          response: {
+            authenticatorData: bufferEncode(cred.response.authenticatorData),
+            clientDataJSON: bufferEncode(cred.response.clientDataJSON),
+            signature: bufferEncode(cred.response.signature),
+          },
+        };
+        if (cred.response.userHandle) {
+          resp.response.userHandle = bufferEncode(cred.response.userHandle);
+        }
+        $.post('/webauthn/AuthFinish', JSON.stringify(resp)).done(done).fail(fail);
+      }).catch(function(err) {
+        console.log(err);
+        alert('WebAuthn error: ' + err);
+      });
+    }).fail(fail);
+  }
@@ -49,8 +49,17 @@ function checkError(resp) {
       window.location.href = destination;
     }).fail(serverError);
   }
+  function webauthnSigned() {
+    hideAllU2FElements();
+    var destination = document.getElementById("u2f_login_destination").innerHTML;
+    window.location.href = destination;
+  }
   function sign() {
      document.getElementById('auth_action_text').style.display="block";
+    if (webauthnSupported()) {
+      webauthnSign(webauthnSigned, serverError);
+      return;
+    }
     $.getJSON('/u2f/SignRequest').done(function(req) {
       console.log(req);
       u2f.sign(req.appId, req.challenge, req.registeredKeys, u2fSigned, 45);
@@ -79,6 +79,18 @@ func initDBPostgres(state *RuntimeState) (err error) {
 			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
 			return err
 		}
+		sqlStmt = `create table if not exists certificate_audit_log(id serial not null primary key, cert_type text not null, serial text not null, username text not null, key_fingerprint text not null, auth_methods text not null, client_ip text not null, user_agent text not null, requested_duration integer not null, granted_duration integer not null, issued_epoch integer not null);`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
+		sqlStmt = `create table if not exists issued_certificates(id serial not null primary key, cert_type text not null, serial text not null, username text not null, key_fingerprint text not null, issued_epoch integer not null, expiration_epoch integer not null, revoked_epoch integer not null default 0, revocation_reason text not null default '', UNIQUE(cert_type,serial));`
+		_, err = state.db.Exec(sqlStmt)
+		if err != nil {
+			logger.Printf("init postgres err: %s: %q\n", err, sqlStmt)
+			return err
+		}
 	}
 
 	return nil
@@ -95,6 +107,8 @@ func initDBSQlite(state *RuntimeState) (err error) {
 var sqliteinitializationStatements = []string{
 	`create table if not exists user_profile (id integer not null primary key, username text unique, profile_data blob);`,
 	`create table if not exists expiring_signed_user_data(id integer not null primary key, username text not null, jws_data text not null, type integer not null, expiration_epoch integer not null, update_epoch integer no null, UNIQUE(username,type));`,
+	`create table if not exists certificate_audit_log(id integer not null primary key, cert_type text not null, serial text not null, username text not null, key_fingerprint text not null, auth_methods text not null, client_ip text not null, user_agent text not null, requested_duration integer not null, granted_duration integer not null, issued_epoch integer not null);`,
+	`create table if not exists issued_certificates(id integer not null primary key, cert_type text not null, serial text not null, username text not null, key_fingerprint text not null, issued_epoch integer not null, expiration_epoch integer not null, revoked_epoch integer not null default 0, revocation_reason text not null default '', UNIQUE(cert_type,serial));`,
 }
 
 func initializeSQLitetables(db *sql.DB) error {
@@ -174,6 +188,14 @@ func cleanupDBData(db *sql.DB) error {
 		return err
 	}
 	defer rows.Close()
+	// Expired certificates no longer need to be published as revoked.
+	queryStr = fmt.Sprintf("DELETE from issued_certificates WHERE expiration_epoch < %d", time.Now().Unix())
+	certRows, err := db.Query(queryStr)
+	if err != nil {
+		logger.Printf("err='%s'", err)
+		return err
+	}
+	defer certRows.Close()
 	return nil
 }
 
@@ -198,6 +220,14 @@ func copyDBIntoSQLite(source, destination *sql.DB, destinationType string) error
 	}
 	defer genericRows.Close()
 
+	queryStr = fmt.Sprintf("SELECT cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch, revoked_epoch, revocation_reason FROM issued_certificates WHERE expiration_epoch > %d", time.Now().Unix())
+	certRows, err := source.Query(queryStr)
+	if err != nil {
+		logger.Printf("err='%s'", err)
+		return err
+	}
+	defer certRows.Close()
+
 	tx, err := destination.Begin()
 	if err != nil {
 		logger.Printf("err='%s'", err)
@@ -256,6 +286,29 @@ func copyDBIntoSQLite(source, destination *sql.DB, destinationType string) error
 			return err
 		}
 	}
+	certUpsertText := copyIssuedCertStmt[destinationType]
+	certUpsertStmt, err := tx.Prepare(certUpsertText)
+	if err != nil {
+		logger.Printf("err='%s'", err)
+		return err
+	}
+	defer certUpsertStmt.Close()
+	for certRows.Next() {
+		var cert issuedCertData
+		if err := certRows.Scan(&cert.CertType, &cert.Serial, &cert.Username,
+			&cert.KeyFingerprint, &cert.IssuedEpoch, &cert.ExpirationEpoch,
+			&cert.RevokedEpoch, &cert.RevocationReason); err != nil {
+			logger.Printf("err='%s'", err)
+			return err
+		}
+		_, err = certUpsertStmt.Exec(cert.CertType, cert.Serial, cert.Username,
+			cert.KeyFingerprint, cert.IssuedEpoch, cert.ExpirationEpoch,
+			cert.RevokedEpoch, cert.RevocationReason)
+		if err != nil {
+			logger.Printf("err='%s'", err)
+			return err
+		}
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -361,6 +414,7 @@ type loadUserProfileData struct {
 func (state *RuntimeState) LoadUserProfile(username string) (profile *userProfile, ok bool, fromCache bool, err error) {
 	var defaultProfile userProfile
 	defaultProfile.U2fAuthData = make(map[int64]*u2fAuthData)
+	defaultProfile.WebauthnData = make(map[int64]*webauthnAuthData)
 
 	ch := make(chan loadUserProfileData, 1)
 	start := time.Now()
@@ -633,3 +687,294 @@ func (state *RuntimeState) UpsertSigned(username string, dataType int, expiratio
 
 	return nil
 }
+
+type issuedCertData struct {
+	CertType         string
+	Serial           string
+	Username         string
+	KeyFingerprint   string
+	IssuedEpoch      int64
+	ExpirationEpoch  int64
+	RevokedEpoch     int64
+	RevocationReason string
+}
+
+var copyIssuedCertStmt = map[string]string{
+	"sqlite":   "insert or replace into issued_certificates(cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch, revoked_epoch, revocation_reason) values(?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into issued_certificates(cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch, revoked_epoch, revocation_reason) values($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT(cert_type,serial) DO UPDATE SET revoked_epoch = excluded.revoked_epoch, revocation_reason = excluded.revocation_reason",
+}
+
+var saveIssuedCertStmt = map[string]string{
+	"sqlite":   "insert into issued_certificates(cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch) values(?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into issued_certificates(cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch) values($1, $2, $3, $4, $5, $6)",
+}
+
+func (state *RuntimeState) SaveIssuedCert(cert issuedCertData) error {
+	if state.db == nil {
+		return errors.New("nil database on SaveIssuedCert")
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmtText := saveIssuedCertStmt[state.dbType]
+	stmt, err := tx.Prepare(stmtText)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(cert.CertType, cert.Serial, cert.Username,
+		cert.KeyFingerprint, cert.IssuedEpoch, cert.ExpirationEpoch)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metricLogExternalServiceDuration("storage-save", time.Since(start))
+	return nil
+}
+
+// The revocation statements are indexed by the field used to select the
+// certificates to revoke. Only certificates that have not yet expired and
+// that are not already revoked are updated.
+var revokeIssuedCertsStmt = map[string]map[string]string{
+	"serial": {
+		"sqlite":   "update issued_certificates set revoked_epoch = ?, revocation_reason = ? where serial = ? and revoked_epoch = 0 and expiration_epoch > ?",
+		"postgres": "update issued_certificates set revoked_epoch = $1, revocation_reason = $2 where serial = $3 and revoked_epoch = 0 and expiration_epoch > $4",
+	},
+	"username": {
+		"sqlite":   "update issued_certificates set revoked_epoch = ?, revocation_reason = ? where username = ? and revoked_epoch = 0 and expiration_epoch > ?",
+		"postgres": "update issued_certificates set revoked_epoch = $1, revocation_reason = $2 where username = $3 and revoked_epoch = 0 and expiration_epoch > $4",
+	},
+	"fingerprint": {
+		"sqlite":   "update issued_certificates set revoked_epoch = ?, revocation_reason = ? where key_fingerprint = ? and revoked_epoch = 0 and expiration_epoch > ?",
+		"postgres": "update issued_certificates set revoked_epoch = $1, revocation_reason = $2 where key_fingerprint = $3 and revoked_epoch = 0 and expiration_epoch > $4",
+	},
+}
+
+// RevokeIssuedCerts marks as revoked all the unexpired certificates where
+// field (one of serial, username or fingerprint) matches value. It returns
+// the number of certificates revoked.
+func (state *RuntimeState) RevokeIssuedCerts(field string, value string,
+	reason string) (int64, error) {
+	stmts, ok := revokeIssuedCertsStmt[field]
+	if !ok {
+		return 0, fmt.Errorf("invalid revocation field: %s", field)
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare(stmts[state.dbType])
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+	now := time.Now().Unix()
+	result, err := stmt.Exec(now, reason, value, now)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	metricLogExternalServiceDuration("storage-save", time.Since(start))
+	return count, nil
+}
+
+var getRevokedCertsStmt = map[string]string{
+	"sqlite":   "select cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch, revoked_epoch, revocation_reason from issued_certificates where cert_type = ? and revoked_epoch > 0 and expiration_epoch > ? order by revoked_epoch",
+	"postgres": "select cert_type, serial, username, key_fingerprint, issued_epoch, expiration_epoch, revoked_epoch, revocation_reason from issued_certificates where cert_type = $1 and revoked_epoch > 0 and expiration_epoch > $2 order by revoked_epoch",
+}
+
+type getRevokedCertsData struct {
+	Certs []issuedCertData
+	Err   error
+}
+
+func gatherRevokedCerts(stmt *sql.Stmt, certType string) ([]issuedCertData, error) {
+	rows, err := stmt.Query(certType, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var certs []issuedCertData
+	for rows.Next() {
+		var cert issuedCertData
+		if err := rows.Scan(&cert.CertType, &cert.Serial, &cert.Username,
+			&cert.KeyFingerprint, &cert.IssuedEpoch, &cert.ExpirationEpoch,
+			&cert.RevokedEpoch, &cert.RevocationReason); err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// GetRevokedCerts returns the revoked certificates of certType that have not
+// yet expired. If the primary DB does not answer in time the cache DB is used.
+func (state *RuntimeState) GetRevokedCerts(certType string) ([]issuedCertData, bool, error) {
+	if state.db == nil || state.cacheDB == nil {
+		return nil, false, errors.New("nil database on GetRevokedCerts")
+	}
+	ch := make(chan getRevokedCertsData, 1)
+	start := time.Now()
+	go func() {
+		stmtText := getRevokedCertsStmt[state.dbType]
+		stmt, err := state.db.Prepare(stmtText)
+		if err != nil {
+			logger.Printf("Error Preparing getRevokedCerts statement primary DB: %s", err)
+			return
+		}
+		defer stmt.Close()
+		if state.remoteDBQueryTimeout == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		certs, dbErr := gatherRevokedCerts(stmt, certType)
+		ch <- getRevokedCertsData{Certs: certs, Err: dbErr}
+		close(ch)
+	}()
+	select {
+	case dbMessage := <-ch:
+		if dbMessage.Err != nil {
+			logger.Printf("Problem with db ='%s'", dbMessage.Err)
+		} else {
+			metricLogExternalServiceDuration("storage-read", time.Since(start))
+		}
+		return dbMessage.Certs, false, dbMessage.Err
+	case <-time.After(state.remoteDBQueryTimeout):
+		logger.Printf("GOT a timeout")
+		stmtText := getRevokedCertsStmt["sqlite"]
+		stmt, err := state.cacheDB.Prepare(stmtText)
+		if err != nil {
+			logger.Printf("Error Preparing getRevokedCerts statement cached DB: %s", err)
+			return nil, false, err
+		}
+		defer stmt.Close()
+		certs, dbErr := gatherRevokedCerts(stmt, certType)
+		if dbErr != nil {
+			logger.Printf("Problem with db = '%s'", dbErr)
+		} else {
+			logger.Println("GOT data from db cache")
+		}
+		return certs, true, dbErr
+	}
+}
+
+type certAuditRecord struct {
+	CertType          string `json:"cert_type"`
+	Serial            string `json:"serial"`
+	Username          string `json:"username"`
+	KeyFingerprint    string `json:"key_fingerprint"`
+	AuthMethods       string `json:"auth_methods"`
+	ClientIP          string `json:"client_ip"`
+	UserAgent         string `json:"user_agent"`
+	RequestedDuration int64  `json:"requested_duration_seconds"`
+	GrantedDuration   int64  `json:"granted_duration_seconds"`
+	IssuedEpoch       int64  `json:"issued_epoch"`
+}
+
+var saveCertAuditRecordStmt = map[string]string{
+	"sqlite":   "insert into certificate_audit_log(cert_type, serial, username, key_fingerprint, auth_methods, client_ip, user_agent, requested_duration, granted_duration, issued_epoch) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	"postgres": "insert into certificate_audit_log(cert_type, serial, username, key_fingerprint, auth_methods, client_ip, user_agent, requested_duration, granted_duration, issued_epoch) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+}
+
+func (state *RuntimeState) SaveCertAuditRecord(record certAuditRecord) error {
+	if state.db == nil {
+		return errors.New("nil database on SaveCertAuditRecord")
+	}
+	start := time.Now()
+	tx, err := state.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmtText := saveCertAuditRecordStmt[state.dbType]
+	stmt, err := tx.Prepare(stmtText)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(record.CertType, record.Serial, record.Username,
+		record.KeyFingerprint, record.AuthMethods, record.ClientIP,
+		record.UserAgent, record.RequestedDuration, record.GrantedDuration,
+		record.IssuedEpoch)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	metricLogExternalServiceDuration("storage-save", time.Since(start))
+	return nil
+}
+
+// Empty username or serial parameters match any value.
+var searchCertAuditStmt = map[string]string{
+	"sqlite":   "select cert_type, serial, username, key_fingerprint, auth_methods, client_ip, user_agent, requested_duration, granted_duration, issued_epoch from certificate_audit_log where (?1 = '' or username = ?1) and (?2 = '' or serial = ?2) and issued_epoch >= ?3 and issued_epoch <= ?4 order by issued_epoch desc limit ?5",
+	"postgres": "select cert_type, serial, username, key_fingerprint, auth_methods, client_ip, user_agent, requested_duration, granted_duration, issued_epoch from certificate_audit_log where ($1 = '' or username = $1) and ($2 = '' or serial = $2) and issued_epoch >= $3 and issued_epoch <= $4 order by issued_epoch desc limit $5",
+}
+
+type certAuditQuery struct {
+	Username string
+	Serial   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// SearchCertAuditLog returns the newest audit records matching query. The
+// audit log is not copied to the cache DB, so this always uses the primary.
+func (state *RuntimeState) SearchCertAuditLog(query certAuditQuery) ([]certAuditRecord, error) {
+	if state.db == nil {
+		return nil, errors.New("nil database on SearchCertAuditLog")
+	}
+	start := time.Now()
+	stmtText := searchCertAuditStmt[state.dbType]
+	stmt, err := state.db.Prepare(stmtText)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(query.Username, query.Serial, query.Since.Unix(),
+		query.Until.Unix(), query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]certAuditRecord, 0)
+	for rows.Next() {
+		var record certAuditRecord
+		if err := rows.Scan(&record.CertType, &record.Serial, &record.Username,
+			&record.KeyFingerprint, &record.AuthMethods, &record.ClientIP,
+			&record.UserAgent, &record.RequestedDuration,
+			&record.GrantedDuration, &record.IssuedEpoch); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	metricLogExternalServiceDuration("storage-read", time.Since(start))
+	return records, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"html/template"
 	"time"
 )
 
@@ -88,7 +89,12 @@ type secondFactorAuthTemplateData struct {
 	AuthUsername     string
 	JSSources        []string
 	ShowOTP          bool
+	ShowTOTP         bool
+	ShowDuo          bool
+	ShowOkta         bool
+	ShowRADIUS       bool
 	ShowU2F          bool
+	ShowPush         bool
 	LoginDestination string
 }
 
@@ -113,6 +119,46 @@ const secondFactorAuthFormText = `
 	{{template "header" .}}
 	<div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
         <h2> Keymaster second factor authentication </h2>
+	{{if .ShowTOTP}}
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/totpAuth" method="post">
+            <p>
+	    Enter authenticator app (TOTP) or recovery code: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
+	    <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
+            <input type="submit" value="Submit" />
+	    </p>
+        </form>
+	{{end}}
+	{{if .ShowDuo}}
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/duoAuth" method="post">
+            <p>
+	    Enter Duo passcode: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
+	    <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
+            <input type="submit" value="Submit" />
+	    </p>
+        </form>
+	{{end}}
+	{{if .ShowOkta}}
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/oktaAuth" method="post">
+            <p>
+	    Enter Okta Verify code: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
+	    <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
+            <input type="submit" value="Submit" />
+	    </p>
+        </form>
+	{{end}}
+	{{if .ShowRADIUS}}
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/radiusAuth" method="post">
+            <p>
+	    Enter RADIUS challenge response: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
+	    <INPUT TYPE="hidden" NAME="login_destination" VALUE={{.LoginDestination}}>
+            <input type="submit" value="Submit" />
+	    </p>
+        </form>
+	{{end}}
+	{{if .ShowPush}}
+	<div id="vip_login_destination" style="display: none;">{{.LoginDestination}}</div>
+	<p> Waiting for you to approve the push request sent to you.</p>
+	{{end}}
 	{{if .ShowOTP}}
 	<div id="vip_login_destination" style="display: none;">{{.LoginDestination}}</div>
         <form enctype="application/x-www-form-urlencoded" action="/api/v0/vipAuth" method="post">
@@ -188,7 +234,7 @@ const usersHTML = `
     <h1>{{.Title}}</h1>
     <ul>
     {{range .Users}}
-       <li><a href="/profile/{{.}}">{{.}}</a></li>
+       <li><a href="/profile/{{.}}">{{.}}</a> (<a href="/secondFactors/{{.}}">second factors</a>)</li>
     {{end}}
     </ul>
     </div>
@@ -199,12 +245,72 @@ const usersHTML = `
 {{end}}
 `
 
+type totpEnrollPageTemplateData struct {
+	Title         string
+	AuthUsername  string
+	Username      string
+	JSSources     []string
+	QRCode        template.URL
+	Secret        string
+	ErrorMessage  string
+	RecoveryCodes []string
+}
+
+const totpEnrollHTML = `
+{{define "totpEnrollPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    <h1>{{.Title}}</h1>
+    {{if .RecoveryCodes}}
+      <p>Your authenticator app is now enrolled. These are your recovery codes,
+      each can be used once instead of a TOTP code. Store them somewhere safe,
+      they will not be shown again.</p>
+      <pre>
+      {{- range .RecoveryCodes}}
+{{.}}
+      {{- end}}
+      </pre>
+      <a href="/profile/">Back to profile</a>
+    {{else}}
+      <p>Scan this QR code with your authenticator app, or enter the secret
+      manually, then enter the code it shows.</p>
+      <img src="{{.QRCode}}" alt="TOTP QR code">
+      <p>Secret: <code>{{.Secret}}</code></p>
+      {{if .ErrorMessage}}<p style="color: red;">{{.ErrorMessage}}</p>{{end}}
+      <form enctype="application/x-www-form-urlencoded" action="/api/v0/totpEnroll" method="post">
+        <input type="hidden" name="username" value="{{.Username}}">
+        <input type="hidden" name="action" value="Verify">
+        Code: <INPUT TYPE="text" NAME="OTP" SIZE=18  autocomplete="off">
+        <input type="submit" value="Verify" />
+      </form>
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
 type registeredU2FTokenDisplayInfo struct {
 	RegistrationDate time.Time
 	DeviceData       string
 	Name             string
 	Index            int64
 	Enabled          bool
+	Type             string
+	LastUsedAt       time.Time
+	LastUsedAddr     string
 }
 type profilePageTemplateData struct {
 	Title           string
@@ -215,6 +321,9 @@ type profilePageTemplateData struct {
 	ReadOnlyMsg     string
 	UsersLink       bool
 	RegisteredToken []registeredU2FTokenDisplayInfo
+	ShowTOTP        bool
+	TOTPEnrolled    bool
+	RecoveryCodes   int
 }
 
 //{{ .Date | formatAsDate}} {{ printf "%-20s" .Description }} {{.AmountInCents | formatAsDollars -}}
@@ -253,6 +362,8 @@ const profileHTML = `
          <a id="register_button" href="#">Register token</a>
          <div id="register_action_text" style="color: blue;background-color: yellow; display: none;"> Please Touch the blinking device to register(insert if not inserted yet) </div>
       </li>
+      <li><a id="register_platform_button" href="#">Register this device (platform authenticator)</a></li>
+      <li><input type="checkbox" id="resident_key_checkbox"> Store the credential on the authenticator (resident key)</li>
       {{end}}
       <li><a id="auth_button" href="#">Authenticate</a>
       <div id="auth_action_text" style="color: blue;background-color: yellow; display: none;"> Please Touch the blinking device to authenticate(insert if not inserted yet) </div>
@@ -270,15 +381,18 @@ const profileHTML = `
 	    <tr>
 	    <th>Name</th>
 	    <th>Device Data</th>
+	    <th>Last Used</th>
 	    <th>Actions</th>
 	    </tr>
 	    {{- range .RegisteredToken }}
             <tr>
 	     <form enctype="application/x-www-form-urlencoded" action="/api/v0/manageU2FToken" method="post">
 	     <input type="hidden" name="index" value="{{.Index}}">
+	     <input type="hidden" name="type" value="{{.Type}}">
 	     <input type="hidden" name="username" value="{{$top.Username}}">
 	     <td> <input type="text" name="name" value="{{ .Name}}" SIZE=18  {{if $top.ReadOnlyMsg}} readonly{{end}} > </td>
 	     <td> {{ .DeviceData}} </td>
+	     <td> {{if .LastUsedAt.IsZero}}unknown{{else}}{{.LastUsedAt.UTC.Format "2006-01-02 15:04:05 MST"}} from {{.LastUsedAddr}}{{end}} </td>
 	     <td>
 	         {{if not $top.ReadOnlyMsg}}
 	         <input type="submit" name="action" value="Update" {{if not .Enabled}} disabled {{end}}/>
@@ -297,6 +411,135 @@ const profileHTML = `
     {{- else}}
 	You Dont have any registered tokens.
     {{- end}}
+    {{if .ShowTOTP}}
+    <h3>Authenticator App (TOTP)</h3>
+    <form enctype="application/x-www-form-urlencoded" action="/api/v0/totpEnroll" method="post">
+    <input type="hidden" name="username" value="{{.Username}}">
+    {{if .TOTPEnrolled}}
+      Enrolled, {{.RecoveryCodes}} recovery code(s) left.
+      {{if not .ReadOnlyMsg}}
+      {{if eq .Username .AuthUsername}}<input type="submit" name="action" value="Start" title="Replace the enrolled authenticator"/>{{end}}
+      <input type="submit" name="action" value="Delete"/>
+      {{end}}
+    {{else}}
+      Not enrolled.
+      {{if and (not .ReadOnlyMsg) (eq .Username .AuthUsername)}}<input type="submit" name="action" value="Start"/>{{end}}
+    {{end}}
+    </form>
+    {{end}}
+    {{end}}
+    </div>
+    {{template "footer" . }}
+    </div>
+  </body>
+</html>
+{{end}}
+`
+
+type secondFactorAdminFactorDisplayInfo struct {
+	Name        string
+	Enrollments []secondFactorEnrollment
+	Error       string
+}
+
+type secondFactorAdminPageTemplateData struct {
+	Title           string
+	AuthUsername    string
+	Username        string
+	ReadOnlyMsg     string
+	Factors         []secondFactorAdminFactorDisplayInfo
+	BypassExpiresAt time.Time
+	BypassLink      string
+}
+
+const secondFactorAdminHTML = `
+{{define "secondFactorAdminPage"}}
+<!DOCTYPE html>
+<html style="height:100%; padding:0;border:0;margin:0">
+  <head>
+    <title>{{.Title}}</title>
+    <link rel="stylesheet" type="text/css" href="//fonts.googleapis.com/css?family=Droid+Sans" />
+    <link rel="stylesheet" type="text/css" href="/custom_static/customization.css">
+    <link rel="stylesheet" type="text/css" href="/static/keymaster.css">
+  </head>
+  <body>
+    <div style="min-height:100%;position:relative;">
+    {{template "header" .}}
+    <div style="padding-bottom:60px; margin:1em auto; max-width:80em; padding-left:20px ">
+    {{with $top := . }}
+    <h1>{{.Title}}</h1>
+    <h2 id="username">{{.Username}}</h2>
+    <p>{{.ReadOnlyMsg}}</p>
+    <ul>
+      <li><a href="/profile/{{.Username}}">Profile</a></li>
+      <li><a href="/users/">Users</a></li>
+    </ul>
+    {{range .Factors}}
+    {{with $factor := .}}
+    <h3>{{.Name}}</h3>
+    {{if .Error}}<p style="color: red;">{{.Error}}</p>{{end}}
+    {{if .Enrollments}}
+    <table>
+      <tr>
+      <th>ID</th>
+      <th>Name</th>
+      <th>Enabled</th>
+      <th>Created</th>
+      <th>Last Used</th>
+      <th>Counter</th>
+      {{if not $top.ReadOnlyMsg}}<th>Reset</th>{{end}}
+      </tr>
+      {{range .Enrollments}}
+      <tr>
+      <td>{{.ID}}</td>
+      <td>{{.Name}}</td>
+      <td>{{.Enabled}}</td>
+      <td>{{if not .CreatedAt.IsZero}}{{.CreatedAt.UTC.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+      <td>{{if not .LastUsedAt.IsZero}}{{.LastUsedAt.UTC.Format "2006-01-02 15:04:05 MST"}}{{else}}unknown{{end}}</td>
+      <td>{{.Counter}}</td>
+      {{if not $top.ReadOnlyMsg}}
+      <td>
+        <form enctype="application/x-www-form-urlencoded" action="/api/v0/resetSecondFactor" method="post">
+        <input type="hidden" name="username" value="{{$top.Username}}">
+        <input type="hidden" name="factor" value="{{$factor.Name}}">
+        <input type="hidden" name="id" value="{{.ID}}">
+        Reason: <input type="text" name="reason" SIZE=30 required>
+        <input type="submit" value="Reset"/>
+        </form>
+      </td>
+      {{end}}
+      </tr>
+      {{end}}
+    </table>
+    {{if not $top.ReadOnlyMsg}}
+    <form enctype="application/x-www-form-urlencoded" action="/api/v0/resetSecondFactor" method="post">
+    <input type="hidden" name="username" value="{{$top.Username}}">
+    <input type="hidden" name="factor" value="{{.Name}}">
+    Reason: <input type="text" name="reason" SIZE=30 required>
+    <input type="submit" value="Reset all {{.Name}}"/>
+    </form>
+    {{end}}
+    {{else}}
+    <p>Nothing enrolled.</p>
+    {{end}}
+    {{end}}
+    {{end}}
+    <h3>Enrollment Bypass</h3>
+    {{if .BypassLink}}
+    <p>Send this one-time link to {{.Username}}, it lets them enroll a new
+    second factor after logging in with their password:</p>
+    <pre>{{.BypassLink}}</pre>
+    {{end}}
+    {{if not .BypassExpiresAt.IsZero}}
+    <p>An unused enrollment bypass expires at {{.BypassExpiresAt.UTC.Format "2006-01-02 15:04:05 MST"}}.</p>
+    {{end}}
+    {{if not .ReadOnlyMsg}}
+    <form enctype="application/x-www-form-urlencoded" action="/api/v0/issueEnrollmentBypass" method="post">
+    <input type="hidden" name="username" value="{{.Username}}">
+    Reason: <input type="text" name="reason" SIZE=30 required>
+    <input type="submit" value="Issue enrollment bypass link"/>
+    </form>
+    {{end}}
     {{end}}
     </div>
     {{template "footer" . }}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/shamir"
+)
+
+const (
+	unsealStatusPath     = "/admin/unsealStatus"
+	defaultUnsealTimeout = 15 * time.Minute
+	unsealPassphraseLen  = 32
+)
+
+// unsealStatus is the response of unsealStatusPath.
+type unsealStatus struct {
+	Sealed         bool
+	Threshold      int        `json:",omitempty"`
+	SharesReceived int        `json:",omitempty"`
+	Operators      []string   `json:",omitempty"`
+	ExpiresAt      *time.Time `json:",omitempty"`
+}
+
+func (config *baseConfig) validateUnsealShares() error {
+	if config.UnsealShareThreshold < 0 || config.UnsealShareThreshold == 1 {
+		return errors.New("unseal_share_threshold must be 0 or at least 2")
+	}
+	if config.UnsealTimeoutSecs < 0 {
+		return errors.New("negative unseal_timeout_secs")
+	}
+	return nil
+}
+
+func (config *baseConfig) unsealTimeout() time.Duration {
+	if config.UnsealTimeoutSecs > 0 {
+		return time.Duration(config.UnsealTimeoutSecs) * time.Second
+	}
+	return defaultUnsealTimeout
+}
+
+// genUnsealShares creates a random CA passphrase and writes its shares to
+// unsealShare-N.txt files in directory.
+func genUnsealShares(directory string, parts, threshold int) (
+	[]byte, error) {
+	randomBytes := make([]byte, unsealPassphraseLen)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+	passphrase := []byte(base64.StdEncoding.EncodeToString(randomBytes))
+	shares, err := shamir.Split(passphrase, parts, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for i, share := range shares {
+		filename := filepath.Join(directory,
+			fmt.Sprintf("unsealShare-%d.txt", i+1))
+		err := ioutil.WriteFile(filename,
+			[]byte(base64.StdEncoding.EncodeToString(share)+"\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("Wrote unseal share to %s", filename)
+	}
+	return passphrase, nil
+}
+
+// addUnsealShare records the share of operator. It returns the reconstructed
+// passphrase once the threshold is reached and nil before that. Partial
+// progress older than the unseal timeout is discarded. Must be called with
+// the mutex held.
+func (state *RuntimeState) addUnsealShare(operator string, share []byte,
+	now time.Time) ([]byte, error) {
+	if state.unsealShares != nil &&
+		now.After(state.unsealStartedAt.Add(state.Config.Base.unsealTimeout())) {
+		logger.Printf("Unseal progress expired, discarding %d shares",
+			len(state.unsealShares))
+		state.unsealShares = nil
+	}
+	if state.unsealShares == nil {
+		state.unsealShares = make(map[string][]byte)
+		state.unsealStartedAt = now
+	}
+	if len(share) < 2 {
+		return nil, errors.New("invalid share")
+	}
+	if _, ok := state.unsealShares[operator]; ok {
+		return nil, fmt.Errorf("%s already submitted a share", operator)
+	}
+	for _, otherShare := range state.unsealShares {
+		if len(otherShare) != len(share) {
+			return nil, errors.New("share does not match other shares")
+		}
+		if otherShare[len(otherShare)-1] == share[len(share)-1] {
+			return nil, errors.New("share already submitted")
+		}
+	}
+	state.unsealShares[operator] = share
+	if len(state.unsealShares) < state.Config.Base.UnsealShareThreshold {
+		return nil, nil
+	}
+	shares := make([][]byte, 0, len(state.unsealShares))
+	for _, share := range state.unsealShares {
+		shares = append(shares, share)
+	}
+	state.unsealShares = nil
+	return shamir.Combine(shares)
+}
+
+// getUnsealPassword returns the CA passphrase from the request. With
+// threshold unsealing the passphrase is nil until enough shares have been
+// received. Must be called with the mutex held.
+func (state *RuntimeState) getUnsealPassword(w http.ResponseWriter,
+	r *http.Request, operator string) ([]byte, bool) {
+	if state.Config.Base.UnsealShareThreshold < 1 {
+		sshCAPassword, ok := r.Form["ssh_ca_password"]
+		if !ok {
+			state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Post, missing data")
+			logger.Printf("missing ssh_ca_password")
+			return nil, false
+		}
+		return []byte(sshCAPassword[0]), true
+	}
+	share, err := base64.StdEncoding.DecodeString(r.Form.Get("ssh_ca_share"))
+	if err != nil || len(share) < 1 {
+		state.writeFailureResponse(w, r, http.StatusBadRequest, "Invalid Post, missing or bad ssh_ca_share")
+		logger.Printf("missing or bad ssh_ca_share from %s", operator)
+		return nil, false
+	}
+	password, err := state.addUnsealShare(operator, share, time.Now())
+	if err != nil {
+		logger.Printf("Rejected unseal share from %s: %s", operator, err)
+		state.writeFailureResponse(w, r, http.StatusConflict, err.Error())
+		return nil, false
+	}
+	if password == nil {
+		logger.Printf("Accepted unseal share from %s (%d of %d)", operator,
+			len(state.unsealShares), state.Config.Base.UnsealShareThreshold)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Share accepted (%d of %d)\n", len(state.unsealShares),
+			state.Config.Base.UnsealShareThreshold)
+		return nil, false
+	}
+	logger.Printf("Unseal threshold reached with share from %s", operator)
+	return password, true
+}
+
+func (state *RuntimeState) unsealStatusHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+		state.writeFailureResponse(w, r, http.StatusForbidden, "")
+		return
+	}
+	state.Mutex.Lock()
+	status := unsealStatus{
+		Sealed:    state.Signer == nil,
+		Threshold: state.Config.Base.UnsealShareThreshold,
+	}
+	expiresAt := state.unsealStartedAt.Add(state.Config.Base.unsealTimeout())
+	if status.Sealed && state.unsealShares != nil &&
+		time.Now().Before(expiresAt) {
+		status.SharesReceived = len(state.unsealShares)
+		for operator := range state.unsealShares {
+			status.Operators = append(status.Operators, operator)
+		}
+		status.ExpiresAt = &expiresAt
+	}
+	state.Mutex.Unlock()
+	sort.Strings(status.Operators)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Printf("Error encoding unseal status: %s", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/shamir"
+)
+
+func makeShareRequest(t *testing.T, operator string,
+	share []byte) *http.Request {
+	form := url.Values{}
+	form.Add("ssh_ca_share", base64.StdEncoding.EncodeToString(share))
+	req, err := http.NewRequest("POST", secretInjectorPath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var subjectCert x509.Certificate
+	subjectCert.Subject.CommonName = operator
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&subjectCert}},
+	}
+	return req
+}
+
+func TestValidateUnsealShares(t *testing.T) {
+	for _, threshold := range []int{0, 2, 5} {
+		config := baseConfig{UnsealShareThreshold: threshold}
+		if err := config.validateUnsealShares(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, threshold := range []int{-1, 1} {
+		config := baseConfig{UnsealShareThreshold: threshold}
+		if err := config.validateUnsealShares(); err == nil {
+			t.Fatalf("threshold %d should have failed", threshold)
+		}
+	}
+}
+
+func TestAddUnsealShareExpiry(t *testing.T) {
+	var state RuntimeState
+	state.Config.Base.UnsealShareThreshold = 2
+	state.Config.Base.UnsealTimeoutSecs = 60
+	shares, err := shamir.Split([]byte("password"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	password, err := state.addUnsealShare("foo", shares[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != nil {
+		t.Fatal("password returned before threshold")
+	}
+	if _, err := state.addUnsealShare("bar", shares[0], now); err == nil {
+		t.Fatal("duplicate share should have failed")
+	}
+	// The first share expires, so this starts over.
+	password, err = state.addUnsealShare("bar", shares[1],
+		now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != nil {
+		t.Fatal("expired share was used")
+	}
+	password, err = state.addUnsealShare("baz", shares[2],
+		now.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "password" {
+		t.Fatalf("bad password: %q", password)
+	}
+	if state.unsealShares != nil {
+		t.Fatal("progress not cleared")
+	}
+}
+
+func TestInjectingSecretShares(t *testing.T) {
+	var state RuntimeState
+	state.SSHCARawFileContent = []byte(encryptedTestSignerPrivateKey)
+	state.SignerIsReady = make(chan bool, 1)
+	state.Config.Base.UnsealShareThreshold = 2
+	shares, err := shamir.Split([]byte("password"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkRequestHandlerCode(makeShareRequest(t, "foo", shares[0]),
+		state.secretInjectorHandler, http.StatusAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same operator cannot submit another share.
+	_, err = checkRequestHandlerCode(makeShareRequest(t, "foo", shares[1]),
+		state.secretInjectorHandler, http.StatusConflict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusReq := makeShareRequest(t, "foo", nil)
+	statusReq.Method = "GET"
+	rr, err := checkRequestHandlerCode(statusReq, state.unsealStatusHandler,
+		http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status unsealStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Sealed || status.Threshold != 2 ||
+		status.SharesReceived != 1 || status.Operators[0] != "foo" {
+		t.Fatalf("bad status: %+v", status)
+	}
+	if state.Signer != nil {
+		t.Fatal("signer loaded before threshold")
+	}
+	_, err = checkRequestHandlerCode(makeShareRequest(t, "bar", shares[2]),
+		state.secretInjectorHandler, http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Signer == nil {
+		t.Fatal("The signer should now be loaded")
+	}
+}
@@ -13,6 +13,12 @@ const (
 	AuthTypePassword
 	AuthTypeSymantecVIP
 	AuthTypeU2F
+	AuthTypeTOTP
+	AuthTypePushApproval
+	AuthTypeDuo
+	AuthTypeOkta
+	AuthTypeRADIUS
+	AuthTypeKerberos
 )
 
 const (
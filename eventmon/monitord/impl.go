@@ -290,6 +290,16 @@ func (m *Monitor) notify(event eventmon.EventV0, logger log.Logger) {
 		}:
 		default:
 		}
+	case eventmon.EventTypeSecurity:
+		message := event.SecurityEventType
+		if event.AuthType != "" {
+			message += " " + event.AuthType
+		}
+		if event.AdminUsername != "" {
+			message += " by " + event.AdminUsername
+		}
+		logger.Printf("Security event for %s: %s: %s\n", event.Username,
+			message, event.Reason)
 	case eventmon.EventTypeServiceProviderLogin:
 		logger.Printf("User %s logged into service: %s\n",
 			event.Username, event.ServiceProviderUrl)
@@ -23,6 +23,15 @@ func (n *EventNotifier) PublishAuthEvent(authType, username string) {
 	n.publishAuthEvent(authType, username)
 }
 
+// PublishSecurityEvent publishes a security relevant change to the second
+// factors of username. adminUsername is empty when the change was not made
+// by an admin.
+func (n *EventNotifier) PublishSecurityEvent(securityEventType, username,
+	adminUsername, authType, reason string) {
+	n.publishSecurityEvent(securityEventType, username, adminUsername,
+		authType, reason)
+}
+
 func (n *EventNotifier) PublishServiceProviderLoginEvent(url, username string) {
 	n.publishServiceProviderLoginEvent(url, username)
 }
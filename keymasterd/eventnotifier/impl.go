@@ -45,6 +45,19 @@ func (n *EventNotifier) publishCert(certType string, certData []byte) {
 	}
 }
 
+func (n *EventNotifier) publishSecurityEvent(securityEventType, username,
+	adminUsername, authType, reason string) {
+	transmitData := eventmon.EventV0{
+		Type:              eventmon.EventTypeSecurity,
+		SecurityEventType: securityEventType,
+		Username:          username,
+		AdminUsername:     adminUsername,
+		AuthType:          authType,
+		Reason:            reason,
+	}
+	n.transmitEvent(transmitData)
+}
+
 func (n *EventNotifier) publishServiceProviderLoginEvent(url, username string) {
 	transmitData := eventmon.EventV0{
 		Type:               eventmon.EventTypeServiceProviderLogin,
@@ -149,6 +149,18 @@ func CheckLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) er
 	return nil
 }
 
+// NewLDAPConnection opens a connection to the LDAP server at u, with a
+// timeout of timeoutSecs for the connection and for each request.
+func NewLDAPConnection(u url.URL, timeoutSecs uint, rootCAs *x509.CertPool) (*ldap.Conn, error) {
+	conn, _, err := getLDAPConnection(u, timeoutSecs, rootCAs)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(time.Duration(time.Duration(timeoutSecs) * time.Second))
+	conn.Start()
+	return conn, nil
+}
+
 func CheckLDAPUserPassword(u url.URL, bindDN string, bindPassword string, timeoutSecs uint, rootCAs *x509.CertPool) (bool, error) {
 	timeout := time.Duration(time.Duration(timeoutSecs) * time.Second)
 	conn, server, err := getLDAPConnection(u, timeoutSecs, rootCAs)
@@ -6,6 +6,9 @@ package certgen
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -39,43 +42,61 @@ func goCertToFileString(c ssh.Certificate, username string) (string, error) {
 	certBytes := c.Marshal()
 	encoded := base64.StdEncoding.EncodeToString(certBytes)
 	fileComment := "/tmp/" + username + "-cert.pub"
-	return "ssh-rsa-cert-v01@openssh.com " + encoded + " " + fileComment, nil
+	return c.Type() + " " + encoded + " " + fileComment, nil
+}
+
+// DefaultSSHExtensions returns the set of extensions granted to user
+// certificates. The values of the permissions are taken from the default
+// values used by ssh-keygen.
+func DefaultSSHExtensions() map[string]string {
+	return map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          ""}
 }
 
 // gen_user_cert a username and key, returns a short lived cert for that user
 func GenSSHCertFileString(username string, userPubKey string, signer ssh.Signer, host_identity string, duration time.Duration) (string, []byte, error) {
+	permissions := ssh.Permissions{Extensions: DefaultSSHExtensions()}
+	return GenSSHCertFileStringWithPermissions(username, userPubKey, signer,
+		host_identity, duration, []string{username}, permissions)
+}
+
+// GenSSHCertFileStringWithPermissions returns a short lived cert for username
+// valid for the given principals and carrying the given extensions and
+// critical options.
+func GenSSHCertFileStringWithPermissions(username string, userPubKey string,
+	signer ssh.Signer, hostIdentity string, duration time.Duration,
+	principals []string, permissions ssh.Permissions) (string, []byte, error) {
 	userKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userPubKey))
 	if err != nil {
 		return "", nil, err
 	}
-	keyIdentity := host_identity + "_" + username
+	if len(principals) < 1 {
+		return "", nil, errors.New("no principals for certificate")
+	}
+	keyIdentity := hostIdentity + "_" + username
 
 	currentEpoch := uint64(time.Now().Unix())
 	expireEpoch := currentEpoch + uint64(duration.Seconds())
 
-	nBig, err := rand.Int(rand.Reader, big.NewInt(0xFFFFFFFF))
+	serial, err := genSSHCertSerial(currentEpoch)
 	if err != nil {
 		return "", nil, err
 	}
-	serial := (currentEpoch << 32) | nBig.Uint64()
 
-	// The values of the permissions are taken from the default values used
-	// by ssh-keygen
 	cert := ssh.Certificate{
 		Key:             userKey,
 		CertType:        ssh.UserCert,
 		SignatureKey:    signer.PublicKey(),
-		ValidPrincipals: []string{username},
+		ValidPrincipals: principals,
 		KeyId:           keyIdentity,
 		ValidAfter:      currentEpoch,
 		ValidBefore:     expireEpoch,
 		Serial:          serial,
-		Permissions: ssh.Permissions{Extensions: map[string]string{
-			"permit-X11-forwarding":   "",
-			"permit-agent-forwarding": "",
-			"permit-port-forwarding":  "",
-			"permit-pty":              "",
-			"permit-user-rc":          ""}}}
+		Permissions:     permissions}
 
 	err = cert.SignCert(bytes.NewReader(cert.Marshal()), signer)
 	if err != nil {
@@ -88,6 +109,50 @@ func GenSSHCertFileString(username string, userPubKey string, signer ssh.Signer,
 	return certString, cert.Marshal(), nil
 }
 
+func genSSHCertSerial(currentEpoch uint64) (uint64, error) {
+	nBig, err := rand.Int(rand.Reader, big.NewInt(0xFFFFFFFF))
+	if err != nil {
+		return 0, err
+	}
+	return (currentEpoch << 32) | nBig.Uint64(), nil
+}
+
+// GenSSHHostCertFileString returns a short lived host cert for hostPubKey
+// valid for the given hostnames, in known_hosts/authorized_keys format.
+func GenSSHHostCertFileString(hostnames []string, hostPubKey string,
+	signer ssh.Signer, keyID string, duration time.Duration) (string, []byte, error) {
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostPubKey))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(hostnames) < 1 {
+		return "", nil, errors.New("no hostnames for certificate")
+	}
+	if _, ok := hostKey.(*ssh.Certificate); ok {
+		return "", nil, errors.New("cannot certify a certificate")
+	}
+	currentEpoch := uint64(time.Now().Unix())
+	serial, err := genSSHCertSerial(currentEpoch)
+	if err != nil {
+		return "", nil, err
+	}
+	cert := ssh.Certificate{
+		Key:             hostKey,
+		CertType:        ssh.HostCert,
+		SignatureKey:    signer.PublicKey(),
+		ValidPrincipals: hostnames,
+		KeyId:           keyID,
+		ValidAfter:      currentEpoch,
+		ValidBefore:     currentEpoch + uint64(duration.Seconds()),
+		Serial:          serial,
+	}
+	err = cert.SignCert(rand.Reader, signer)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(ssh.MarshalAuthorizedKey(&cert)), cert.Marshal(), nil
+}
+
 func GenSSHCertFileStringFromSSSDPublicKey(userName string, signer ssh.Signer, hostIdentity string, duration time.Duration) (string, []byte, error) {
 
 	userPubKey, err := GetUserPubKeyFromSSSD(userName)
@@ -125,6 +190,16 @@ func GetSignerFromPEMBytes(privateKey []byte) (crypto.Signer, error) {
 		return x509.ParsePKCS1PrivateKey(block.Bytes)
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("Cannot process that key")
+		}
+		return signer, nil
 	default:
 		err := errors.New("Cannot process that key")
 		return nil, err
@@ -136,13 +211,37 @@ func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
 		return &k.PublicKey
-	//case *ecdsa.PrivateKey:
-	//	return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
 	default:
 		return nil
 	}
 }
 
+// x509SignatureAlgorithm returns the signature algorithm used for
+// certificates signed by a CA with the given public key.
+func x509SignatureAlgorithm(caPub crypto.PublicKey) x509.SignatureAlgorithm {
+	switch k := caPub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512
+		default:
+			return x509.ECDSAWithSHA256
+		}
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
+
 /*
 func derBytesCertToCertAndPem(derBytes []byte) (*x509.Certificate, string, error) {
 	cert, err := x509.ParseCertificate(derBytes)
@@ -166,8 +265,15 @@ func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.S
 	if err != nil {
 		return nil, err
 	}
-	sum := sha256.Sum256([]byte(commonName))
-	signedCN, err := caPriv.Sign(rand.Reader, sum[:], crypto.SHA256)
+	var signedCN []byte
+	if _, ok := caPriv.Public().(ed25519.PublicKey); ok {
+		// Ed25519 signs the message itself, not a digest.
+		signedCN, err = caPriv.Sign(rand.Reader, []byte(commonName),
+			crypto.Hash(0))
+	} else {
+		sum := sha256.Sum256([]byte(commonName))
+		signedCN, err = caPriv.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +292,7 @@ func GenSelfSignedCACert(commonName string, organization string, caPriv crypto.S
 		//ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
+		SignatureAlgorithm:    x509SignatureAlgorithm(caPriv.Public()),
 	}
 
 	return x509.CreateCertificate(rand.Reader, &template, &template, publicKey(caPriv), caPriv)
@@ -283,6 +390,16 @@ func GenUserX509Cert(userName string, userPub interface{},
 	caCert *x509.Certificate, caPriv crypto.Signer,
 	kerberosRealm *string, duration time.Duration,
 	groups []string, organizations []string) ([]byte, error) {
+	return GenUserX509CertWithCommonName(userName, userName, userPub, caCert,
+		caPriv, kerberosRealm, duration, groups, organizations)
+}
+
+// GenUserX509CertWithCommonName is like GenUserX509Cert but with a subject
+// common name other than userName. The SAN still names userName.
+func GenUserX509CertWithCommonName(userName string, commonName string,
+	userPub interface{}, caCert *x509.Certificate, caPriv crypto.Signer,
+	kerberosRealm *string, duration time.Duration,
+	groups []string, organizations []string) ([]byte, error) {
 	//// Now do the actual work...
 	notBefore := time.Now()
 	notAfter := notBefore.Add(duration)
@@ -302,7 +419,7 @@ func GenUserX509Cert(userName string, userPub interface{},
 	// and also the client key usage.
 	kerberosClientExtKeyUsage := []int{1, 3, 6, 1, 5, 2, 3, 4}
 	subject := pkix.Name{
-		CommonName:   userName,
+		CommonName:   commonName,
 		Organization: organizations,
 	}
 	groupListExtension, err := getGroupListExtension(groups)
@@ -319,6 +436,7 @@ func GenUserX509Cert(userName string, userPub interface{},
 		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{kerberosClientExtKeyUsage},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
+		SignatureAlgorithm:    x509SignatureAlgorithm(caPriv.Public()),
 	}
 	if groupListExtension != nil {
 		template.ExtraExtensions = append(template.ExtraExtensions,
@@ -1,13 +1,19 @@
 package certgen
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"os"
 	"os/user"
+	"strings"
 	"testing"
 	"time"
 
@@ -183,6 +189,47 @@ func TestGenSSHCertFileStringGenerateFailBadPublicKey(t *testing.T) {
 	}
 }
 
+func TestGenSSHCertFileStringWithPermissions(t *testing.T) {
+	username := "foo"
+	hostIdentity := "bar"
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions := ssh.Permissions{
+		CriticalOptions: map[string]string{"force-command": "/bin/true"},
+		Extensions:      map[string]string{"permit-pty": ""}}
+	_, certBytes, err := GenSSHCertFileStringWithPermissions(username,
+		testUserPublicKey, goodSigner, hostIdentity, testDuration,
+		[]string{username, "deploy"}, permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("not a certificate")
+	}
+	if len(cert.ValidPrincipals) != 2 || cert.ValidPrincipals[1] != "deploy" {
+		t.Fatalf("bad principals: %v", cert.ValidPrincipals)
+	}
+	if cert.CriticalOptions["force-command"] != "/bin/true" {
+		t.Fatal("missing force-command")
+	}
+	if _, ok := cert.Extensions["permit-port-forwarding"]; ok {
+		t.Fatal("unexpected extension")
+	}
+	_, _, err = GenSSHCertFileStringWithPermissions(username,
+		testUserPublicKey, goodSigner, hostIdentity, testDuration,
+		nil, permissions)
+	if err == nil {
+		t.Fatal("should have failed with no principals")
+	}
+}
+
 func TestGetUserPubKeyFromSSSD(t *testing.T) {
 	username, err := canDoSSSDTests()
 	if err != nil {
@@ -318,6 +365,28 @@ func TestGenUserX509CertGoodNoRealm(t *testing.T) {
 	// 6. kerberos realm info!
 }
 
+func TestGenUserX509CertWithCommonName(t *testing.T) {
+	userPub, caCert, caPriv := setupX509Generator(t)
+	derCert, err := GenUserX509CertWithCommonName("username",
+		"keymaster:username", userPub, caCert, caPriv, nil, testDuration, nil,
+		[]string{"system:masters"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := derBytesCertToCertAndPem(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "keymaster:username" {
+		t.Fatalf("Subject.CommonName: %s != keymaster:username",
+			cert.Subject.CommonName)
+	}
+	if len(cert.Subject.Organization) != 1 ||
+		cert.Subject.Organization[0] != "system:masters" {
+		t.Fatalf("bad organizations: %v", cert.Subject.Organization)
+	}
+}
+
 func TestGenx509CertGoodWithRealm(t *testing.T) {
 	userPub, caCert, caPriv := setupX509Generator(t)
 	/*
@@ -391,3 +460,107 @@ func TestGetPubKeyFromPem(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGenSSHHostCertFileString(t *testing.T) {
+	goodSigner, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostnames := []string{"host.example.com", "10.0.0.1"}
+	certString, certBytes, err := GenSSHHostCertFileString(hostnames,
+		testUserPublicKey, goodSigner, "keyid", testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certString))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatal("not a certificate")
+	}
+	if cert.CertType != ssh.HostCert || len(cert.ValidPrincipals) != 2 {
+		t.Fatalf("bad cert %+v", cert)
+	}
+	if !bytes.Equal(cert.Marshal(), certBytes) {
+		t.Fatal("cert bytes do not match cert string")
+	}
+	_, _, err = GenSSHHostCertFileString(nil, testUserPublicKey, goodSigner,
+		"keyid", testDuration)
+	if err == nil {
+		t.Fatal("should have failed with no hostnames")
+	}
+}
+
+func TestGenCertsNonRSAKeys(t *testing.T) {
+	_, ed25519Priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(ed25519Priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPriv, err := GetSignerFromPEMBytes(pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPriv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SSH user certificate for an ECDSA key signed by an Ed25519 CA
+	sshSigner, err := ssh.NewSignerFromSigner(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshUserPub, err := ssh.NewPublicKey(&userPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certString, _, err := GenSSHCertFileString("username",
+		string(ssh.MarshalAuthorizedKey(sshUserPub)), sshSigner, "host",
+		testDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(certString,
+		"ecdsa-sha2-nistp384-cert-v01@openssh.com ") {
+		t.Fatalf("bad cert prefix: %s", certString)
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certString)); err != nil {
+		t.Fatal(err)
+	}
+
+	// X509 certificates
+	derCACert, err := GenSelfSignedCACert("some hostname", "some organization",
+		caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(derCACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert.SignatureAlgorithm != x509.PureEd25519 {
+		t.Fatalf("bad CA signature algorithm: %s", caCert.SignatureAlgorithm)
+	}
+	derUserCert, err := GenUserX509Cert("username", &userPriv.PublicKey,
+		caCert, caPriv, nil, testDuration, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCert, err := x509.ParseCertificate(derUserCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userCert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatal(err)
+	}
+	if x509SignatureAlgorithm(&userPriv.PublicKey) != x509.ECDSAWithSHA384 {
+		t.Fatal("bad signature algorithm for P-384 key")
+	}
+}
@@ -0,0 +1,68 @@
+package certgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenSSHKRL(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	krl, err := GenSSHKRL(signer.PublicKey(), []uint64{30, 10, 20, 10},
+		time.Unix(1000, 0), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(krl)
+	var header struct {
+		Magic     uint64
+		Format    uint32
+		Version   uint64
+		Generated uint64
+		Flags     uint64
+	}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Magic != krlMagic || header.Format != krlFormatVersion ||
+		header.Generated != 1000 {
+		t.Fatalf("bad header: %+v", header)
+	}
+	caBlob := signer.PublicKey().Marshal()
+	if !bytes.Contains(krl, caBlob) {
+		t.Fatal("CA key not in KRL")
+	}
+	// Serials are sorted, deduplicated and at the end of the KRL.
+	var expected bytes.Buffer
+	for _, serial := range []uint64{10, 20, 30} {
+		binary.Write(&expected, binary.BigEndian, serial)
+	}
+	if !bytes.HasSuffix(krl, expected.Bytes()) {
+		t.Fatal("bad serial list")
+	}
+	if _, err := GenSSHKRL(signer.PublicKey(), []uint64{0}, time.Now(),
+		""); err == nil {
+		t.Fatal("should have failed on serial 0")
+	}
+}
+
+func TestGenSSHKRLEmpty(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey([]byte(testSignerPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	krl, err := GenSSHKRL(signer.PublicKey(), nil, time.Now(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header (8+4+8+8+8) + two empty strings
+	if len(krl) != 44 {
+		t.Fatalf("unexpected length %d", len(krl))
+	}
+}
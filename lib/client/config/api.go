@@ -11,6 +11,7 @@ type BaseConfig struct {
 	Username      string `yaml:"username"`
 	FilePrefix    string `yaml:"file_prefix"`
 	AddGroups     bool   `yaml:"add_groups"`
+	KeyType       string `yaml:"key_type"`
 }
 
 // AppConfigFile represents a keymaster client configuration file
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/util"
 	"gopkg.in/yaml.v2"
 )
 
@@ -32,6 +33,13 @@ func loadVerifyConfigFile(configFilename string) (AppConfigFile, error) {
 		return config, err
 	}
 	// TODO: ensure all enpoints are https urls
+	switch config.Base.KeyType {
+	case "", util.KeyTypeRSA, util.KeyTypeEd25519, util.KeyTypeECDSAP256,
+		util.KeyTypeECDSAP384:
+	default:
+		err = errors.New("Invalid key_type in config file")
+		return config, err
+	}
 
 	return config, nil
 }
@@ -16,6 +16,11 @@ const simpleValidConfigFile = `base:
     gen_cert_urls: "https://localhost:33443/"
 `
 
+const invalidConfigFileBadKeyType = `base:
+    gen_cert_urls: "https://localhost:33443/"
+    key_type: "dsa"
+`
+
 const invalidConfigFileNoGenUrls = `base:
 	    `
 
@@ -83,6 +88,22 @@ func TestLoadVerifyConfigFileFailNoGenCertUrls(t *testing.T) {
 	}
 }
 
+func TestLoadVerifyConfigFileFailBadKeyType(t *testing.T) {
+	tmpfile, err := createTempFileWithStringContent("test_LoadVerifyConfigFail_", invalidConfigFileBadKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name()) // clean up
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadVerifyConfigFile(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Should have failed with bad key type")
+	}
+}
+
 func TestLoadVerifyConfigFileFailNoSuchFile(t *testing.T) {
 	_, err := loadVerifyConfigFile("NonExistentFile")
 	if err == nil {
@@ -0,0 +1,33 @@
+// Package kerberos gets the Kerberos SPNEGO (Negotiate) tokens used to log in
+// to keymaster with the Kerberos tickets of the user instead of a password.
+package kerberos
+
+import (
+	"net/http"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+)
+
+type Client struct {
+	krb5Client *client.Client
+	tickets    serviceTicketGetter
+}
+
+// NewClient returns a Client using the Kerberos tickets in the credential
+// cache of the user, given by KRB5CCNAME (by default /tmp/krb5cc_<uid>), and
+// the configuration in krb5.conf, given by KRB5_CONFIG (by default
+// /etc/krb5.conf). Only credential cache files are supported.
+func NewClient() (*Client, error) {
+	return newClient()
+}
+
+// UserName returns the name, without realm, of the owner of the tickets.
+func (c *Client) UserName() string {
+	return c.userName()
+}
+
+// SetNegotiateHeader sets the Authorization header of req to a Negotiate
+// token for the HTTP service principal of the host of req.
+func (c *Client) SetNegotiateHeader(req *http.Request) error {
+	return c.setNegotiateHeader(req)
+}
@@ -0,0 +1,104 @@
+package kerberos
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	defaultConfigFilename = "/etc/krb5.conf"
+	fileCCachePrefix      = "FILE:"
+)
+
+// serviceTicketGetter is implemented by the gokrb5 client, which gets the
+// service tickets from the KDC.
+type serviceTicketGetter interface {
+	GetServiceTicket(spn string) (messages.Ticket, types.EncryptionKey, error)
+}
+
+func getCCacheFilename() (string, error) {
+	filename := os.Getenv("KRB5CCNAME")
+	if filename == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()), nil
+	}
+	if strings.HasPrefix(filename, fileCCachePrefix) {
+		return filename[len(fileCCachePrefix):], nil
+	}
+	if index := strings.Index(filename, ":"); index > 0 {
+		return "", fmt.Errorf("unsupported credential cache type: %s",
+			filename[:index])
+	}
+	return filename, nil
+}
+
+func loadConfig() (*config.Config, error) {
+	filename := os.Getenv("KRB5_CONFIG")
+	if filename == "" {
+		filename = defaultConfigFilename
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			// Find the KDCs of the realm with DNS.
+			return config.New(), nil
+		}
+	}
+	return config.Load(filename)
+}
+
+func newClient() (*Client, error) {
+	ccacheFilename, err := getCCacheFilename()
+	if err != nil {
+		return nil, err
+	}
+	ccache, err := credentials.LoadCCache(ccacheFilename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load Kerberos credential cache: %s",
+			err)
+	}
+	krb5Config, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load Kerberos configuration: %s", err)
+	}
+	krb5Client, err := client.NewFromCCache(ccache, krb5Config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{krb5Client: krb5Client, tickets: krb5Client}, nil
+}
+
+func (c *Client) userName() string {
+	return c.krb5Client.Credentials.UserName()
+}
+
+func (c *Client) setNegotiateHeader(req *http.Request) error {
+	host := strings.TrimSuffix(strings.ToLower(req.URL.Hostname()), ".")
+	if host == "" {
+		return errors.New("no host in request URL")
+	}
+	tkt, sessionKey, err := c.tickets.GetServiceTicket("HTTP/" + host)
+	if err != nil {
+		return fmt.Errorf("cannot get Kerberos service ticket: %s", err)
+	}
+	negTokenInit, err := spnego.NewNegTokenInitKRB5(c.krb5Client, tkt,
+		sessionKey)
+	if err != nil {
+		return err
+	}
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negTokenInit}
+	b, err := token.Marshal()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization",
+		"Negotiate "+base64.StdEncoding.EncodeToString(b))
+	return nil
+}
@@ -0,0 +1,101 @@
+package kerberos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"github.com/Symantec/keymaster/lib/kerberos"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	testRealm = "EXAMPLE.COM"
+	testEType = etypeID.AES256_CTS_HMAC_SHA1_96
+)
+
+// testKDC stands in for the KDC: it issues the service tickets of the
+// client with the keys of the service keytab.
+type testKDC struct {
+	cname         types.PrincipalName
+	serviceKeytab *keytab.Keytab
+	requestedSPNs []string
+}
+
+func (kdc *testKDC) GetServiceTicket(spn string) (
+	messages.Ticket, types.EncryptionKey, error) {
+	kdc.requestedSPNs = append(kdc.requestedSPNs, spn)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, spn)
+	now := time.Now().UTC()
+	return messages.NewTicket(kdc.cname, testRealm, sname, testRealm,
+		types.NewKrbFlags(), kdc.serviceKeytab, testEType, 1, now, now,
+		now.Add(time.Hour), now.Add(time.Hour))
+}
+
+func TestSetNegotiateHeader(t *testing.T) {
+	kt := keytab.New()
+	err := kt.AddEntry("HTTP/keymaster.example.com", testRealm,
+		"service-password", time.Now(), 1, testEType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdc := &testKDC{
+		cname:         types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "alice"),
+		serviceKeytab: kt,
+	}
+	c := &Client{
+		krb5Client: client.NewWithPassword("alice", testRealm, "unused",
+			config.New()),
+		tickets: kdc,
+	}
+	if c.UserName() != "alice" {
+		t.Fatalf("username: %s != alice", c.UserName())
+	}
+	req, err := http.NewRequest("POST",
+		"https://Keymaster.example.com:8443/api/v0/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetNegotiateHeader(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(kdc.requestedSPNs) != 1 ||
+		kdc.requestedSPNs[0] != "HTTP/keymaster.example.com" {
+		t.Fatalf("requested SPNs: %v", kdc.requestedSPNs)
+	}
+	username, err := kerberos.NewFromKeytab(kt, testRealm,
+		testlogger.New(t)).Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" {
+		t.Fatalf("authenticated username: %s != alice", username)
+	}
+}
+
+func TestNewClientNoCCache(t *testing.T) {
+	defer os.Setenv("KRB5CCNAME", os.Getenv("KRB5CCNAME"))
+	dir, err := ioutil.TempDir("", "kerberos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("KRB5CCNAME", "FILE:"+filepath.Join(dir, "krb5cc"))
+	if _, err := NewClient(); err == nil {
+		t.Fatal("client created without credential cache")
+	}
+	os.Setenv("KRB5CCNAME", "KEYRING:persistent:1000")
+	if _, err := NewClient(); err == nil {
+		t.Fatal("client created with keyring credential cache")
+	}
+}
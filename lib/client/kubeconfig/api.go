@@ -0,0 +1,31 @@
+// Package kubeconfig writes kubectl configuration for the Kubernetes cluster
+// profiles of a keymaster server.
+package kubeconfig
+
+import (
+	"net/http"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+// GetCluster returns the named Kubernetes cluster profile of the keymaster
+// server at baseURL.
+func GetCluster(client *http.Client, baseURL string, name string) (
+	*proto.KubernetesCluster, error) {
+	return getCluster(client, baseURL, name)
+}
+
+// ContextName returns the name of the cluster, user and context entries
+// written for the named cluster.
+func ContextName(clusterName string) string {
+	return contextName(clusterName)
+}
+
+// UpdateFile adds or replaces the cluster, user and context entries for
+// cluster in the kubeconfig file filename and makes that context the current
+// one. Other entries in the file are preserved. The user entry references
+// certFilename and keyFilename.
+func UpdateFile(filename string, cluster *proto.KubernetesCluster,
+	certFilename string, keyFilename string) error {
+	return updateFile(filename, cluster, certFilename, keyFilename)
+}
@@ -0,0 +1,113 @@
+package kubeconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+type namedEntry struct {
+	Name  string                 `yaml:"name"`
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+type kubeConfig struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Clusters       []namedEntry           `yaml:"clusters"`
+	Contexts       []namedEntry           `yaml:"contexts"`
+	Users          []namedEntry           `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Other          map[string]interface{} `yaml:",inline"`
+}
+
+func getCluster(client *http.Client, baseURL string, name string) (
+	*proto.KubernetesCluster, error) {
+	resp, err := client.Get(strings.TrimSuffix(baseURL, "/") +
+		proto.KubernetesClustersPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got error from call %s", resp.Status)
+	}
+	var clusters []proto.KubernetesCluster
+	if err := json.NewDecoder(resp.Body).Decode(&clusters); err != nil {
+		return nil, err
+	}
+	for _, cluster := range clusters {
+		if cluster.Name == name {
+			return &cluster, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kubernetes cluster: %s", name)
+}
+
+func contextName(clusterName string) string {
+	return "keymaster-" + clusterName
+}
+
+func setEntry(entries []namedEntry, name string, key string,
+	value map[string]interface{}) []namedEntry {
+	entry := namedEntry{
+		Name:  name,
+		Other: map[string]interface{}{key: value},
+	}
+	for i := range entries {
+		if entries[i].Name == name {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+func updateFile(filename string, cluster *proto.KubernetesCluster,
+	certFilename string, keyFilename string) error {
+	var config kubeConfig
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("cannot parse %s: %s", filename, err)
+	}
+	if config.APIVersion == "" {
+		config.APIVersion = "v1"
+	}
+	if config.Kind == "" {
+		config.Kind = "Config"
+	}
+	name := contextName(cluster.Name)
+	clusterValue := map[string]interface{}{"server": cluster.APIServerURL}
+	if len(cluster.CertificateAuthorityData) > 0 {
+		clusterValue["certificate-authority-data"] =
+			base64.StdEncoding.EncodeToString(cluster.CertificateAuthorityData)
+	}
+	config.Clusters = setEntry(config.Clusters, name, "cluster", clusterValue)
+	config.Users = setEntry(config.Users, name, "user",
+		map[string]interface{}{
+			"client-certificate": certFilename,
+			"client-key":         keyFilename,
+		})
+	config.Contexts = setEntry(config.Contexts, name, "context",
+		map[string]interface{}{"cluster": name, "user": name})
+	config.CurrentContext = name
+	configText, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, configText, 0600)
+}
@@ -0,0 +1,107 @@
+package kubeconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"gopkg.in/yaml.v2"
+)
+
+const existingKubeConfig = `apiVersion: v1
+kind: Config
+preferences: {}
+clusters:
+- name: other
+  cluster:
+    server: https://other.example.com
+users:
+- name: other
+  user:
+    token: secret
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+current-context: other
+`
+
+func TestGetCluster(t *testing.T) {
+	clusters := []proto.KubernetesCluster{
+		{Name: "prod", APIServerURL: "https://prod.example.com:6443"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != proto.KubernetesClustersPath {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(clusters)
+		}))
+	defer server.Close()
+	cluster, err := GetCluster(server.Client(), server.URL+"/", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.APIServerURL != "https://prod.example.com:6443" {
+		t.Fatalf("bad cluster: %+v", cluster)
+	}
+	if _, err := GetCluster(server.Client(), server.URL, "dev"); err == nil {
+		t.Fatal("unknown cluster should have failed")
+	}
+}
+
+func TestUpdateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, ".kube", "config")
+	cluster := &proto.KubernetesCluster{
+		Name:                     "prod",
+		APIServerURL:             "https://prod.example.com:6443",
+		CertificateAuthorityData: []byte("ca"),
+	}
+	// Creates the file and directory.
+	if err := UpdateFile(filename, cluster, "user.cert", "user.key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(existingKubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err := UpdateFile(filename, cluster, "user.cert", "user.key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config kubeConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentContext != "keymaster-prod" {
+		t.Fatalf("bad current context: %s", config.CurrentContext)
+	}
+	if len(config.Clusters) != 2 || len(config.Users) != 2 ||
+		len(config.Contexts) != 2 {
+		t.Fatalf("bad entries:\n%s", data)
+	}
+	for _, expected := range []string{"token: secret", "preferences: {}",
+		"certificate-authority-data: Y2E=", "client-key: user.key"} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("missing %q:\n%s", expected, data)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Package session caches the login session of the keymaster client, so that
+// certificates can be renewed without entering the password again.
+package session
+
+import (
+	"net/http"
+)
+
+// Save writes the session cookies that the jar of client holds for
+// targetURLs, the keymaster servers, to filename. Only the user can read the
+// file.
+func Save(filename string, userName string, targetURLs []string,
+	client *http.Client) error {
+	return save(filename, userName, targetURLs, client)
+}
+
+// Load adds the session cookies of userName cached in filename to the jar
+// of client. It fails if filename can be accessed by other users.
+func Load(filename string, userName string, client *http.Client) error {
+	return load(filename, userName, client)
+}
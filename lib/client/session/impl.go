@@ -0,0 +1,96 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+type cachedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type sessionFile struct {
+	Username string                    `json:"username"`
+	Cookies  map[string][]cachedCookie `json:"cookies"`
+}
+
+func save(filename string, userName string, targetURLs []string,
+	client *http.Client) error {
+	if client.Jar == nil {
+		return errors.New("session: client has no cookie jar")
+	}
+	session := sessionFile{Username: userName,
+		Cookies: make(map[string][]cachedCookie)}
+	for _, targetURL := range targetURLs {
+		parsedURL, err := url.Parse(targetURL)
+		if err != nil {
+			return err
+		}
+		for _, cookie := range client.Jar.Cookies(parsedURL) {
+			session.Cookies[targetURL] = append(session.Cookies[targetURL],
+				cachedCookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tmpFilename := filename + "~"
+	os.Remove(tmpFilename)
+	err = ioutil.WriteFile(tmpFilename, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func load(filename string, userName string, client *http.Client) error {
+	if client.Jar == nil {
+		return errors.New("session: client has no cookie jar")
+	}
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	// Windows has no unix permissions, the file is protected by the ACL of
+	// the user profile directory.
+	if runtime.GOOS != "windows" && fileInfo.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("session: %s can be accessed by other users",
+			filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var session sessionFile
+	if err := json.Unmarshal(data, &session); err != nil {
+		return err
+	}
+	if session.Username != userName {
+		return fmt.Errorf("session: %s is for another user", filename)
+	}
+	for targetURL, cachedCookies := range session.Cookies {
+		parsedURL, err := url.Parse(targetURL)
+		if err != nil {
+			return err
+		}
+		var cookies []*http.Cookie
+		for _, cookie := range cachedCookies {
+			cookies = append(cookies, &http.Cookie{Name: cookie.Name,
+				Value: cookie.Value, Path: "/"})
+		}
+		client.Jar.SetCookies(parsedURL, cookies)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testURL = "https://keymaster.example.com"
+
+func newTestClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "session")
+	serverURL, err := url.Parse(testURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t)
+	client.Jar.SetCookies(serverURL, []*http.Cookie{
+		{Name: "auth_cookie", Value: "jwt", Path: "/"}})
+	err = Save(filename, "username", []string{testURL}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = newTestClient(t)
+	if err := Load(filename, "other", client); err == nil {
+		t.Fatal("session of another user loaded")
+	}
+	if err := Load(filename, "username", client); err != nil {
+		t.Fatal(err)
+	}
+	cookies := client.Jar.Cookies(serverURL)
+	if len(cookies) != 1 || cookies[0].Value != "jwt" {
+		t.Fatalf("bad cookies: %v", cookies)
+	}
+
+	if err := os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(filename, "username", newTestClient(t)); err == nil {
+		t.Fatal("world readable session loaded")
+	}
+}
@@ -0,0 +1,90 @@
+// Package sshagent loads keymaster SSH keys and certificates into an SSH
+// agent.
+package sshagent
+
+import (
+	"crypto"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned by Dial when no agent socket is given and
+// SSH_AUTH_SOCK is not set.
+var ErrNoAgent = errors.New("sshagent: SSH_AUTH_SOCK is not set")
+
+// Agent is a connection to an SSH agent.
+type Agent struct {
+	agent agent.Agent
+	conn  net.Conn
+}
+
+// Dial connects to the SSH agent listening on socketPath. An empty
+// socketPath means the agent given by the SSH_AUTH_SOCK environment variable.
+func Dial(socketPath string) (*Agent, error) {
+	return dial(socketPath)
+}
+
+// New returns an Agent using sshAgent, which is not closed by Close.
+func New(sshAgent agent.Agent) *Agent {
+	return &Agent{agent: sshAgent}
+}
+
+// Close closes the connection to the agent.
+func (a *Agent) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	return a.conn.Close()
+}
+
+// AddCertificate adds privateKey and the SSH certificate sshCert (in
+// authorized_keys format) to the agent with comment. The keys expire from
+// the agent when the certificate does. Keys already in the agent with the
+// same comment, such as the previous keymaster certificate, are removed first.
+func (a *Agent) AddCertificate(privateKey crypto.Signer, sshCert []byte,
+	comment string) error {
+	return a.addCertificate(privateKey, sshCert, comment)
+}
+
+// RemoveKeys removes all the keys with comment from the agent.
+func (a *Agent) RemoveKeys(comment string) error {
+	return a.removeKeys(comment)
+}
+
+// Keyring is an SSH agent that holds the keymaster key and certificate in
+// memory and forwards the requests for all other keys to an upstream agent.
+type Keyring struct {
+	comment   string
+	keymaster agent.Agent
+	upstream  agent.Agent
+}
+
+// NewKeyring returns a Keyring where the keys added with comment, the
+// comment given to AddCertificate by the keymaster client, are held in
+// memory. Other keys are forwarded to upstream. If upstream is nil they are
+// held in memory too.
+func NewKeyring(upstream *Agent, comment string) *Keyring {
+	return newKeyring(upstream, comment)
+}
+
+// CertificateExpiry returns when the keymaster certificate held by k
+// expires, or the zero time if k holds none.
+func (k *Keyring) CertificateExpiry() time.Time {
+	return k.certificateExpiry()
+}
+
+// SetCertificate replaces the keymaster key and certificate held by k with
+// privateKey and sshCert, like AddCertificate.
+func (k *Keyring) SetCertificate(privateKey crypto.Signer,
+	sshCert []byte) error {
+	return New(k.keymaster).AddCertificate(privateKey, sshCert, k.comment)
+}
+
+// Serve serves k to the connections accepted on listener. It returns when
+// Accept fails.
+func (k *Keyring) Serve(listener net.Listener) error {
+	return k.serve(listener)
+}
@@ -0,0 +1,87 @@
+package sshagent
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func dial(socketPath string) (*Agent, error) {
+	if socketPath == "" {
+		socketPath = os.Getenv("SSH_AUTH_SOCK")
+		if socketPath == "" {
+			return nil, ErrNoAgent
+		}
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{agent: agent.NewClient(conn), conn: conn}, nil
+}
+
+func parseCertificate(sshCert []byte) (*ssh.Certificate, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(sshCert)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("sshagent: not an SSH certificate")
+	}
+	return cert, nil
+}
+
+func (a *Agent) addCertificate(privateKey crypto.Signer, sshCert []byte,
+	comment string) error {
+	cert, err := parseCertificate(sshCert)
+	if err != nil {
+		return err
+	}
+	lifetime := time.Until(time.Unix(int64(cert.ValidBefore), 0))
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		lifetime = 0
+	} else if lifetime <= 0 {
+		return fmt.Errorf("sshagent: certificate expired at %s",
+			time.Unix(int64(cert.ValidBefore), 0))
+	}
+	if err := a.removeKeys(comment); err != nil {
+		return err
+	}
+	// Add the plain key as well, like ssh-add does for a key with a
+	// certificate.
+	for _, certificate := range []*ssh.Certificate{nil, cert} {
+		err := a.agent.Add(agent.AddedKey{
+			PrivateKey:   privateKey,
+			Certificate:  certificate,
+			Comment:      comment,
+			LifetimeSecs: uint32(lifetime.Seconds()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Agent) removeKeys(comment string) error {
+	keys, err := a.agent.List()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.Comment != comment {
+			continue
+		}
+		if err := a.agent.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
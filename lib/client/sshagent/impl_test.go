@@ -0,0 +1,109 @@
+package sshagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newTestCertificate(t *testing.T, key *ecdsa.PrivateKey,
+	validBefore time.Time) []byte {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		CertType:        ssh.UserCert,
+		KeyId:           "username",
+		ValidPrincipals: []string{"username"},
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+func TestAddCertificate(t *testing.T) {
+	keyring := agent.NewKeyring()
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"keymaster", "other"} {
+		err := keyring.Add(agent.AddedKey{PrivateKey: otherKey,
+			Comment: comment})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshAgent := New(keyring)
+	defer sshAgent.Close()
+	err = sshAgent.AddCertificate(key,
+		newTestCertificate(t, key, time.Now().Add(-time.Minute)), "keymaster")
+	if err == nil {
+		t.Fatal("expired certificate added")
+	}
+	err = sshAgent.AddCertificate(key,
+		newTestCertificate(t, key, time.Now().Add(time.Hour)), "keymaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numKeymaster, numCerts int
+	for _, agentKey := range keys {
+		if agentKey.Comment != "keymaster" {
+			continue
+		}
+		numKeymaster++
+		if agentKey.Type() == ssh.CertAlgoECDSA256v01 {
+			numCerts++
+		}
+	}
+	if len(keys) != 3 || numKeymaster != 2 || numCerts != 1 {
+		t.Fatalf("bad agent keys: %v", keys)
+	}
+	if err := sshAgent.RemoveKeys("keymaster"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = keyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "other" {
+		t.Fatalf("bad agent keys after remove: %v", keys)
+	}
+}
+
+func TestDialWithoutAgent(t *testing.T) {
+	oldSSHSock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	if ok {
+		os.Unsetenv("SSH_AUTH_SOCK")
+		defer os.Setenv("SSH_AUTH_SOCK", oldSSHSock)
+	}
+	if _, err := Dial(""); err != ErrNoAgent {
+		t.Fatalf("expected ErrNoAgent, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package sshagent
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKeyring(upstream *Agent, comment string) *Keyring {
+	k := &Keyring{comment: comment, keymaster: agent.NewKeyring()}
+	if upstream == nil {
+		k.upstream = agent.NewKeyring()
+	} else {
+		k.upstream = upstream.agent
+	}
+	return k
+}
+
+func (k *Keyring) certificateExpiry() time.Time {
+	keys, err := k.keymaster.List()
+	if err != nil {
+		return time.Time{}
+	}
+	for _, key := range keys {
+		pubKey, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			continue
+		}
+		if cert, ok := pubKey.(*ssh.Certificate); ok {
+			return time.Unix(int64(cert.ValidBefore), 0)
+		}
+	}
+	return time.Time{}
+}
+
+// agentFor returns the agent holding key.
+func (k *Keyring) agentFor(key ssh.PublicKey) agent.Agent {
+	keys, err := k.keymaster.List()
+	if err != nil {
+		return k.upstream
+	}
+	blob := key.Marshal()
+	for _, keymasterKey := range keys {
+		if bytes.Equal(keymasterKey.Blob, blob) {
+			return k.keymaster
+		}
+	}
+	return k.upstream
+}
+
+func (k *Keyring) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(k, conn)
+		}()
+	}
+}
+
+// The agent.ExtendedAgent methods.
+
+func (k *Keyring) List() ([]*agent.Key, error) {
+	keys, err := k.keymaster.List()
+	if err != nil {
+		return nil, err
+	}
+	upstreamKeys, err := k.upstream.List()
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, upstreamKeys...), nil
+}
+
+func (k *Keyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return k.agentFor(key).Sign(key, data)
+}
+
+func (k *Keyring) SignWithFlags(key ssh.PublicKey, data []byte,
+	flags agent.SignatureFlags) (*ssh.Signature, error) {
+	keyAgent := k.agentFor(key)
+	if extendedAgent, ok := keyAgent.(agent.ExtendedAgent); ok {
+		return extendedAgent.SignWithFlags(key, data, flags)
+	}
+	return keyAgent.Sign(key, data)
+}
+
+func (k *Keyring) Add(key agent.AddedKey) error {
+	if key.Comment == k.comment {
+		return k.keymaster.Add(key)
+	}
+	return k.upstream.Add(key)
+}
+
+func (k *Keyring) Remove(key ssh.PublicKey) error {
+	return k.agentFor(key).Remove(key)
+}
+
+func (k *Keyring) RemoveAll() error {
+	if err := k.keymaster.RemoveAll(); err != nil {
+		return err
+	}
+	return k.upstream.RemoveAll()
+}
+
+func (k *Keyring) Lock(passphrase []byte) error {
+	if err := k.keymaster.Lock(passphrase); err != nil {
+		return err
+	}
+	return k.upstream.Lock(passphrase)
+}
+
+func (k *Keyring) Unlock(passphrase []byte) error {
+	if err := k.keymaster.Unlock(passphrase); err != nil {
+		return err
+	}
+	return k.upstream.Unlock(passphrase)
+}
+
+func (k *Keyring) Signers() ([]ssh.Signer, error) {
+	signers, err := k.keymaster.Signers()
+	if err != nil {
+		return nil, err
+	}
+	upstreamSigners, err := k.upstream.Signers()
+	if err != nil {
+		return nil, err
+	}
+	return append(signers, upstreamSigners...), nil
+}
+
+func (k *Keyring) Extension(extensionType string,
+	contents []byte) ([]byte, error) {
+	if extendedAgent, ok := k.upstream.(agent.ExtendedAgent); ok {
+		return extendedAgent.Extension(extensionType, contents)
+	}
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -0,0 +1,117 @@
+package sshagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestKeyring(t *testing.T) {
+	upstreamKeyring := agent.NewKeyring()
+	keyring := NewKeyring(New(upstreamKeyring), "keymaster")
+	if !keyring.CertificateExpiry().IsZero() {
+		t.Fatal("empty keyring has a certificate")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = keyring.SetCertificate(key, newTestCertificate(t, key, validBefore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.CertificateExpiry().Equal(validBefore) {
+		t.Fatalf("bad expiry: %s", keyring.CertificateExpiry())
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "sshagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go keyring.Serve(listener)
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	err = client.agent.Add(agent.AddedKey{PrivateKey: otherKey,
+		Comment: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := client.agent.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("bad agent keys: %v", keys)
+	}
+	upstreamKeys, err := upstreamKeyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upstreamKeys) != 1 || upstreamKeys[0].Comment != "other" {
+		t.Fatalf("other key not forwarded: %v", upstreamKeys)
+	}
+	for _, agentKey := range keys {
+		signature, err := client.agent.Sign(agentKey, []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := agentKey.Verify([]byte("data"), signature); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Keys from a keymaster client run are held by the keyring.
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.AddCertificate(newKey,
+		newTestCertificate(t, newKey, validBefore.Add(time.Hour)), "keymaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyring.CertificateExpiry().Equal(validBefore.Add(time.Hour)) {
+		t.Fatalf("certificate not replaced: %s", keyring.CertificateExpiry())
+	}
+	upstreamKeys, err = upstreamKeyring.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upstreamKeys) != 1 {
+		t.Fatalf("keymaster key forwarded: %v", upstreamKeys)
+	}
+	pubKey, err := ssh.NewPublicKey(&otherKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.agent.Remove(pubKey); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := upstreamKeyring.List(); len(keys) != 0 {
+		t.Fatalf("other key not removed: %v", keys)
+	}
+}
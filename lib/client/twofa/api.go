@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/kerberos"
 )
 
 var (
@@ -17,6 +18,18 @@ var (
 	noU2F = flag.Bool("noU2F", false, "Don't use U2F as second factor")
 	// If set, Do not use VIPAccess as second factor.
 	noVIPAccess = flag.Bool("noVIPAccess", false, "Don't use VIPAccess as second factor")
+	// If set, Do not use TOTP as second factor.
+	noTOTP = flag.Bool("noTOTP", false, "Don't use TOTP as second factor")
+	// If set, Do not use Duo as second factor.
+	noDuo = flag.Bool("noDuo", false, "Don't use Duo as second factor")
+	// If set, Do not use push approval as second factor.
+	noPushApproval = flag.Bool("noPushApproval", false, "Don't use push approval as second factor")
+	// If set, Do not use Okta as second factor.
+	noOkta = flag.Bool("noOkta", false, "Don't use Okta as second factor")
+	// If set, Do not answer RADIUS challenges as second factor.
+	noRADIUS = flag.Bool("noRADIUS", false, "Don't answer RADIUS challenges as second factor")
+	// If set, get the Kubernetes certificate for this cluster profile.
+	KubernetesCluster = flag.String("kubernetesCluster", "", "Get a Kubernetes certificate and kubeconfig for this cluster")
 )
 
 // GetCertFromTargetUrls gets a signed cert from the given target URLs.
@@ -31,6 +44,74 @@ func GetCertFromTargetUrls(
 	userAgentString string,
 	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
 	return getCertFromTargetUrls(
-		signer, userName, password, targetUrls, skipu2f, addGroups,
+		signer, userName, password, nil, targetUrls, skipu2f, addGroups,
 		client, userAgentString, logger)
 }
+
+// GetCertFromTargetUrlsWithKerberos is like GetCertFromTargetUrls but logs
+// in with a Kerberos Negotiate token from krbClient instead of a password.
+func GetCertFromTargetUrlsWithKerberos(
+	signer crypto.Signer,
+	userName string,
+	krbClient *kerberos.Client,
+	targetUrls []string,
+	skipu2f bool,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	return getCertFromTargetUrls(
+		signer, userName, nil, krbClient, targetUrls, skipu2f, addGroups,
+		client, userAgentString, logger)
+}
+
+// GetCertFromSession is like GetCertFromTargetUrls but does not login. It
+// uses the session cookies in the jar of client, such as those of an earlier
+// login or those loaded by session.Load.
+func GetCertFromSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	return getCertFromSession(
+		signer, userName, targetUrls, addGroups, client, userAgentString,
+		logger)
+}
+
+// GetSSHCertFromSession gets a new SSH certificate for signer without a new
+// login. It uses the session cookies kept in the jar of client by an earlier
+// GetCertFromTargetUrls, and fails once the session has expired.
+func GetSSHCertFromSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, err error) {
+	return getSSHCertFromSession(
+		signer, userName, targetUrls, client, userAgentString, logger)
+}
+
+// SecondFactor is a second factor the client can use to complete a login
+// with a keymaster server.
+type SecondFactor interface {
+	// Name returns the name the server uses for the factor in
+	// proto.LoginResponse.CertAuthBackend.
+	Name() string
+	// Available reports whether the factor can be used from this host.
+	Available(logger log.DebugLogger) bool
+	// Authenticate performs the factor against the server at baseURL.
+	// The session cookies are kept in the jar of client.
+	Authenticate(client *http.Client, baseURL string, userAgentString string,
+		logger log.DebugLogger) error
+}
+
+// RegisterSecondFactor adds factor to the second factors tried after a
+// password login. Factors are tried in order of registration, after the
+// built in U2F, TOTP, VIP, Duo, push approval, Okta and RADIUS factors.
+func RegisterSecondFactor(factor SecondFactor) {
+	registerSecondFactor(factor)
+}
@@ -0,0 +1,18 @@
+// Package duo does two factor authentication with Duo Security
+package duo
+
+import (
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// DoDuoAuthenticate performs two factor authentication with Duo Security.
+// It accepts a passcode typed by the user while waiting for a Duo push.
+func DoDuoAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doDuoAuthenticate(client, baseURL, userAgentString, logger)
+}
@@ -0,0 +1,98 @@
+package duo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/twofa/vip"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const duoCheckTimeoutSecs = 180
+
+func duoAuthenticateWithPasscode(
+	client *http.Client,
+	baseURL string,
+	passcode string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	form := url.Values{}
+	form.Add("OTP", passcode)
+	req, err := http.NewRequest("POST", baseURL+"/api/v0/duoAuth",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgentString)
+	loginResp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer loginResp.Body.Close()
+	if loginResp.StatusCode != 200 {
+		io.Copy(ioutil.Discard, loginResp.Body)
+		logger.Printf("got error from duoAuth call %s", loginResp.Status)
+		return fmt.Errorf("duo passcode rejected: %s", loginResp.Status)
+	}
+	loginJSONResponse := proto.LoginResponse{}
+	if err := json.NewDecoder(loginResp.Body).Decode(&loginJSONResponse); err != nil {
+		return err
+	}
+	logger.Debugf(1, "This the login response=%v\n", loginJSONResponse)
+	return nil
+}
+
+func readPasscodeAndAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter Duo passcode (or wait for Duo push): ")
+	passcode, err := reader.ReadString('\n')
+	if err != nil {
+		logger.Debugf(0, "passcode: Failure to get string %s", err)
+		return err
+	}
+	return duoAuthenticateWithPasscode(client, baseURL,
+		strings.TrimSpace(passcode), userAgentString, logger)
+}
+
+func doDuoAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	timeout := time.Duration(duoCheckTimeoutSecs) * time.Second
+	ch := make(chan error, 2)
+	go func() {
+		ch <- readPasscodeAndAuthenticate(client, baseURL, userAgentString,
+			logger)
+	}()
+	go func() {
+		ch <- vip.DoPushAuthenticate(client, baseURL, proto.AuthTypeDuo,
+			userAgentString, logger)
+	}()
+	select {
+	case err := <-ch:
+		if err != nil {
+			logger.Printf("Problem with duo ='%s'", err)
+			return err
+		}
+		return nil
+	case <-time.After(timeout):
+		return errors.New("duo timeout")
+	}
+}
@@ -0,0 +1,19 @@
+// Package okta does two factor authentication with Okta Verify
+package okta
+
+import (
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// DoOktaAuthenticate performs two factor authentication with the Okta
+// factors of the user. It accepts a TOTP code typed by the user while
+// waiting for an Okta Verify push.
+func DoOktaAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doOktaAuthenticate(client, baseURL, userAgentString, logger)
+}
@@ -0,0 +1,97 @@
+package okta
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/twofa/vip"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+)
+
+const oktaCheckTimeoutSecs = 180
+
+func oktaAuthenticateWithOTP(
+	client *http.Client,
+	baseURL string,
+	otpValue string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	form := url.Values{}
+	form.Add("OTP", otpValue)
+	req, err := http.NewRequest("POST", baseURL+"/api/v0/oktaAuth",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgentString)
+	loginResp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer loginResp.Body.Close()
+	if loginResp.StatusCode != 200 {
+		io.Copy(ioutil.Discard, loginResp.Body)
+		logger.Printf("got error from oktaAuth call %s", loginResp.Status)
+		return fmt.Errorf("okta code rejected: %s", loginResp.Status)
+	}
+	loginJSONResponse := proto.LoginResponse{}
+	if err := json.NewDecoder(loginResp.Body).Decode(&loginJSONResponse); err != nil {
+		return err
+	}
+	logger.Debugf(1, "This the login response=%v\n", loginJSONResponse)
+	return nil
+}
+
+func readOTPAndAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter Okta Verify code (or wait for Okta Verify push): ")
+	otpValue, err := reader.ReadString('\n')
+	if err != nil {
+		logger.Debugf(0, "okta code: Failure to get string %s", err)
+		return err
+	}
+	return oktaAuthenticateWithOTP(client, baseURL,
+		strings.TrimSpace(otpValue), userAgentString, logger)
+}
+
+func doOktaAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	timeout := time.Duration(oktaCheckTimeoutSecs) * time.Second
+	ch := make(chan error, 2)
+	go func() {
+		ch <- readOTPAndAuthenticate(client, baseURL, userAgentString, logger)
+	}()
+	go func() {
+		ch <- vip.DoPushAuthenticate(client, baseURL, proto.AuthTypeOkta,
+			userAgentString, logger)
+	}()
+	select {
+	case err := <-ch:
+		if err != nil {
+			logger.Printf("Problem with okta ='%s'", err)
+			return err
+		}
+		return nil
+	case <-time.After(timeout):
+		return errors.New("okta timeout")
+	}
+}
@@ -0,0 +1,19 @@
+// Package radius does two factor authentication with RADIUS challenges
+package radius
+
+import (
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// DoRADIUSAuthenticate prompts for the response to the RADIUS challenge
+// of the password authentication and sends it to the keymaster server at
+// baseURL.
+func DoRADIUSAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doRADIUSAuthenticate(client, baseURL, userAgentString, logger)
+}
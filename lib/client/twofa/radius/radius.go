@@ -0,0 +1,70 @@
+package radius
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const radiusAuthPath = "/api/v0/radiusAuth"
+
+func readResponse(reader io.Reader) (string, error) {
+	fmt.Print("Enter RADIUS challenge response: ")
+	responseText, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(responseText), nil
+}
+
+func sendResponse(client *http.Client,
+	baseURL string,
+	challengeResponse string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	form := url.Values{}
+	form.Add("OTP", challengeResponse)
+	req, err := http.NewRequest("POST", baseURL+radiusAuthPath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgentString)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		logger.Debugf(1, "got error from radius call %s", resp.Status)
+		return fmt.Errorf("RADIUS authentication failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func doRADIUSAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	challengeResponse, err := readResponse(os.Stdin)
+	if err != nil {
+		logger.Debugf(0, "Failure to read RADIUS challenge response %s", err)
+		return err
+	}
+	return sendResponse(client, baseURL, challengeResponse, userAgentString,
+		logger)
+}
@@ -0,0 +1,144 @@
+package twofa
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/client/twofa/duo"
+	"github.com/Symantec/keymaster/lib/client/twofa/okta"
+	"github.com/Symantec/keymaster/lib/client/twofa/radius"
+	"github.com/Symantec/keymaster/lib/client/twofa/totp"
+	"github.com/Symantec/keymaster/lib/client/twofa/u2f"
+	"github.com/Symantec/keymaster/lib/client/twofa/vip"
+	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
+	"github.com/flynn/u2f/u2fhid" // client side (interface with hardware)
+)
+
+var secondFactors = []SecondFactor{
+	u2fFactor{}, totpFactor{}, vipFactor{}, duoFactor{}, pushApprovalFactor{},
+	oktaFactor{}, radiusFactor{}}
+
+func registerSecondFactor(factor SecondFactor) {
+	secondFactors = append(secondFactors, factor)
+}
+
+// doSecondFactor performs the first available factor offered by the
+// server in certAuthBackends.
+func doSecondFactor(
+	certAuthBackends []string,
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	offered := make(map[string]bool)
+	for _, backend := range certAuthBackends {
+		offered[backend] = true
+	}
+	for _, factor := range secondFactors {
+		if !offered[factor.Name()] || !factor.Available(logger) {
+			continue
+		}
+		logger.Debugf(1, "Using %s as second factor", factor.Name())
+		return factor.Authenticate(client, baseURL, userAgentString, logger)
+	}
+	return errors.New("Failed to Pefrom 2FA (as requested from server)")
+}
+
+type u2fFactor struct{}
+
+func (u2fFactor) Name() string { return proto.AuthTypeU2F }
+
+func (u2fFactor) Available(logger log.DebugLogger) bool {
+	if *noU2F {
+		return false
+	}
+	// on linux disable U2F is the /sys/class/hidraw is missing
+	if runtime.GOOS == "linux" {
+		if _, err := os.Stat("/sys/class/hidraw"); os.IsNotExist(err) {
+			return false
+		}
+	}
+	devices, err := u2fhid.Devices()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return len(devices) > 0
+}
+
+func (u2fFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return u2f.DoU2FAuthenticate(client, baseURL, userAgentString, logger)
+}
+
+type totpFactor struct{}
+
+func (totpFactor) Name() string { return proto.AuthTypeTOTP }
+
+func (totpFactor) Available(logger log.DebugLogger) bool { return !*noTOTP }
+
+func (totpFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return totp.DoTOTPAuthenticate(client, baseURL, userAgentString, logger)
+}
+
+type vipFactor struct{}
+
+func (vipFactor) Name() string { return proto.AuthTypeSymantecVIP }
+
+func (vipFactor) Available(logger log.DebugLogger) bool { return !*noVIPAccess }
+
+func (vipFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return vip.DoVIPAuthenticate(client, baseURL, userAgentString, logger)
+}
+
+type duoFactor struct{}
+
+func (duoFactor) Name() string { return proto.AuthTypeDuo }
+
+func (duoFactor) Available(logger log.DebugLogger) bool { return !*noDuo }
+
+func (duoFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return duo.DoDuoAuthenticate(client, baseURL, userAgentString, logger)
+}
+
+type pushApprovalFactor struct{}
+
+func (pushApprovalFactor) Name() string { return proto.AuthTypePushApproval }
+
+func (pushApprovalFactor) Available(logger log.DebugLogger) bool {
+	return !*noPushApproval
+}
+
+func (pushApprovalFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return vip.DoPushAuthenticate(client, baseURL, proto.AuthTypePushApproval,
+		userAgentString, logger)
+}
+
+type oktaFactor struct{}
+
+func (oktaFactor) Name() string { return proto.AuthTypeOkta }
+
+func (oktaFactor) Available(logger log.DebugLogger) bool { return !*noOkta }
+
+func (oktaFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return okta.DoOktaAuthenticate(client, baseURL, userAgentString, logger)
+}
+
+type radiusFactor struct{}
+
+func (radiusFactor) Name() string { return proto.AuthTypeRADIUS }
+
+func (radiusFactor) Available(logger log.DebugLogger) bool { return !*noRADIUS }
+
+func (radiusFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	return radius.DoRADIUSAuthenticate(client, baseURL, userAgentString,
+		logger)
+}
@@ -0,0 +1,54 @@
+package twofa
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+type testFactor struct {
+	name          string
+	available     bool
+	authenticated *string
+}
+
+func (f testFactor) Name() string { return f.name }
+
+func (f testFactor) Available(logger log.DebugLogger) bool { return f.available }
+
+func (f testFactor) Authenticate(client *http.Client, baseURL string,
+	userAgentString string, logger log.DebugLogger) error {
+	*f.authenticated = f.name
+	return nil
+}
+
+func TestDoSecondFactor(t *testing.T) {
+	savedFactors := secondFactors
+	defer func() { secondFactors = savedFactors }()
+	var authenticated string
+	secondFactors = nil
+	RegisterSecondFactor(testFactor{"first", false, &authenticated})
+	RegisterSecondFactor(testFactor{"second", true, &authenticated})
+	RegisterSecondFactor(testFactor{"third", true, &authenticated})
+	logger := testlogger.New(t)
+
+	err := doSecondFactor([]string{"third", "first", "second"},
+		http.DefaultClient, "", "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authenticated != "second" {
+		t.Fatalf("expected second factor to be used, got %q", authenticated)
+	}
+	authenticated = ""
+	err = doSecondFactor([]string{"first", "unknown"},
+		http.DefaultClient, "", "", logger)
+	if err == nil {
+		t.Fatal("expected failure when no offered factor is available")
+	}
+	if authenticated != "" {
+		t.Fatalf("unexpected factor used: %q", authenticated)
+	}
+}
@@ -0,0 +1,18 @@
+// Package totp does two factor authentication with TOTP codes
+package totp
+
+import (
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+// DoTOTPAuthenticate prompts for a TOTP or recovery code and sends it to
+// the keymaster server at baseURL.
+func DoTOTPAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doTOTPAuthenticate(client, baseURL, userAgentString, logger)
+}
@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Symantec/Dominator/lib/log"
+)
+
+const totpAuthPath = "/api/v0/totpAuth"
+
+func readCode(reader io.Reader) (string, error) {
+	fmt.Print("Enter TOTP code (or a recovery code): ")
+	codeText, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(codeText), nil
+}
+
+func sendCode(client *http.Client,
+	baseURL string,
+	code string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	form := url.Values{}
+	form.Add("OTP", code)
+	req, err := http.NewRequest("POST", baseURL+totpAuthPath,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgentString)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		logger.Debugf(1, "got error from totp call %s", resp.Status)
+		return fmt.Errorf("TOTP authentication failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func doTOTPAuthenticate(
+	client *http.Client,
+	baseURL string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	code, err := readCode(os.Stdin)
+	if err != nil {
+		logger.Debugf(0, "Failure to read TOTP code %s", err)
+		return err
+	}
+	return sendCode(client, baseURL, code, userAgentString, logger)
+}
@@ -13,16 +13,12 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"runtime"
 	"strconv"
 	"strings"
 
 	"github.com/Symantec/Dominator/lib/log"
-	"github.com/Symantec/keymaster/lib/client/twofa/u2f"
-	"github.com/Symantec/keymaster/lib/client/twofa/vip"
+	"github.com/Symantec/keymaster/lib/client/kerberos"
 	"github.com/Symantec/keymaster/lib/webapi/v0/proto"
-	"github.com/flynn/u2f/u2fhid" // client side (interface with hardware)
 	"golang.org/x/crypto/ssh"
 )
 
@@ -95,6 +91,7 @@ func getCertsFromServer(
 	signer crypto.Signer,
 	userName string,
 	password []byte,
+	krbClient *kerberos.Client,
 	baseUrl string,
 	skip2fa bool,
 	addGroups bool,
@@ -105,12 +102,19 @@ func getCertsFromServer(
 	loginUrl := baseUrl + proto.LoginPath
 	form := url.Values{}
 	form.Add("username", userName)
-	form.Add("password", string(password[:]))
+	if krbClient == nil {
+		form.Add("password", string(password[:]))
+	}
 	req, err := http.NewRequest("POST", loginUrl,
 		strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if krbClient != nil {
+		if err := krbClient.SetNegotiateHeader(req); err != nil {
+			return nil, nil, nil, err
+		}
+	}
 	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Accept", "application/json")
@@ -146,78 +150,35 @@ func getCertsFromServer(
 	loginResp.Body.Close()                  //so that we can reuse the channel
 	logger.Debugf(1, "This the login response=%v\n", loginJSONResponse)
 
-	allowVIP := false
-	allowU2F := false
 	for _, backend := range loginJSONResponse.CertAuthBackend {
 		if backend == proto.AuthTypePassword {
 			skip2fa = true
 		}
-		if backend == proto.AuthTypeSymantecVIP {
-			allowVIP = true
-			//remote next statemente later
-			//skipu2f = true
-		}
-		if backend == proto.AuthTypeU2F {
-			allowU2F = true
-		}
-	}
-
-	// Dont try U2F if chosen by user
-	if *noU2F {
-		allowU2F = false
 	}
-	if *noVIPAccess {
-		allowVIP = false
-	}
-
-	// on linux disable U2F is the /sys/class/hidraw is missing
-	if runtime.GOOS == "linux" && allowU2F {
-		if _, err := os.Stat("/sys/class/hidraw"); os.IsNotExist(err) {
-			allowU2F = false
-		}
-
-	}
-
-	// upgrade to u2f
-	successful2fa := false
 	if !skip2fa {
-		if allowU2F {
-			devices, err := u2fhid.Devices()
-			if err != nil {
-				logger.Fatal(err)
-				return nil, nil, nil, err
-			}
-			if len(devices) > 0 {
-
-				err = u2f.DoU2FAuthenticate(
-					client, baseUrl, userAgentString, logger)
-				if err != nil {
-
-					return nil, nil, nil, err
-				}
-				successful2fa = true
-			}
-		}
-
-		if allowVIP && !successful2fa {
-			err = vip.DoVIPAuthenticate(
-				client, baseUrl, userAgentString, logger)
-			if err != nil {
-
-				return nil, nil, nil, err
-			}
-			successful2fa = true
-		}
-
-		if !successful2fa {
-			err = errors.New("Failed to Pefrom 2FA (as requested from server)")
+		err = doSecondFactor(loginJSONResponse.CertAuthBackend,
+			client, baseUrl, userAgentString, logger)
+		if err != nil {
 			return nil, nil, nil, err
 		}
-
 	}
 
 	logger.Debugf(1, "Authentication Phase complete")
+	return getCertsWithCookies(signer, userName, baseUrl, loginResp.Cookies(),
+		addGroups, client, userAgentString, logger)
+}
 
+// getCertsWithCookies gets the certs from an authenticated session, given
+// by authCookies and the cookies in the jar of client.
+func getCertsWithCookies(
+	signer crypto.Signer,
+	userName string,
+	baseUrl string,
+	authCookies []*http.Cookie,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
 	//now get x509 cert
 	pubKey := signer.Public()
 	derKey, err := x509.MarshalPKIXPublicKey(pubKey)
@@ -234,7 +195,7 @@ func getCertsFromServer(
 	// TODO: urlencode the userName
 	x509Cert, err = doCertRequest(
 		client,
-		loginResp.Cookies(),
+		authCookies,
 		baseUrl+"/certgen/"+userName+"?type=x509"+urlPostfix,
 		pemKey,
 		userAgentString,
@@ -243,13 +204,20 @@ func getCertsFromServer(
 		return nil, nil, nil, err
 	}
 
+	kubernetesURL := baseUrl + "/certgen/" + userName + "?type=x509-kubernetes"
+	if *KubernetesCluster != "" {
+		kubernetesURL += "&cluster=" + url.QueryEscape(*KubernetesCluster)
+	}
 	kubernetesCert, err = doCertRequest(
 		client,
-		loginResp.Cookies(),
-		baseUrl+"/certgen/"+userName+"?type=x509-kubernetes",
+		authCookies,
+		kubernetesURL,
 		pemKey,
 		userAgentString,
 		logger)
+	if err != nil && *KubernetesCluster != "" {
+		return nil, nil, nil, err
+	}
 	if err != nil {
 		//logger.Printf("Warning: could not get the kubernets cert (old server?) err=%s \n", err)
 		kubernetesCert = nil
@@ -257,30 +225,42 @@ func getCertsFromServer(
 	}
 
 	//// Now we do sshCert!
-	// generate and write public key
-	sshPub, err := ssh.NewPublicKey(pubKey)
+	sshCert, err = getSSHCertFromServer(pubKey, userName, baseUrl,
+		authCookies, client, userAgentString, logger)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+
+	return sshCert, x509Cert, kubernetesCert, nil
+}
+
+func getSSHCertFromServer(
+	pubKey crypto.PublicKey,
+	userName string,
+	baseUrl string,
+	authCookies []*http.Cookie,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) ([]byte, error) {
+	sshPub, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
 	sshAuthFile := string(ssh.MarshalAuthorizedKey(sshPub))
-	sshCert, err = doCertRequest(
+	return doCertRequest(
 		client,
-		loginResp.Cookies(),
+		authCookies,
 		baseUrl+"/certgen/"+userName+"?type=ssh",
 		sshAuthFile,
 		userAgentString,
 		logger)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	return sshCert, x509Cert, kubernetesCert, nil
 }
 
 func getCertFromTargetUrls(
 	signer crypto.Signer,
 	userName string,
 	password []byte,
+	krbClient *kerberos.Client,
 	targetUrls []string,
 	skipu2f bool,
 	addGroups bool,
@@ -292,8 +272,8 @@ func getCertFromTargetUrls(
 	for _, baseUrl := range targetUrls {
 		logger.Printf("attempting to target '%s' for '%s'\n", baseUrl, userName)
 		sshCert, x509Cert, kubernetesCert, err = getCertsFromServer(
-			signer, userName, password, baseUrl, skipu2f, addGroups,
-			client, userAgentString, logger)
+			signer, userName, password, krbClient, baseUrl, skipu2f,
+			addGroups, client, userAgentString, logger)
 		if err != nil {
 			logger.Println(err)
 			continue
@@ -309,3 +289,43 @@ func getCertFromTargetUrls(
 
 	return sshCert, x509Cert, kubernetesCert, nil
 }
+
+func getSSHCertFromSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, err error) {
+	for _, baseUrl := range targetUrls {
+		logger.Debugf(1, "renewing from '%s' for '%s'\n", baseUrl, userName)
+		sshCert, err = getSSHCertFromServer(signer.Public(), userName,
+			baseUrl, nil, client, userAgentString, logger)
+		if err == nil {
+			return sshCert, nil
+		}
+		logger.Println(err)
+	}
+	return nil, errors.New("Failed to renew creds from session")
+}
+
+func getCertFromSession(
+	signer crypto.Signer,
+	userName string,
+	targetUrls []string,
+	addGroups bool,
+	client *http.Client,
+	userAgentString string,
+	logger log.DebugLogger) (sshCert []byte, x509Cert []byte, kubernetesCert []byte, err error) {
+	for _, baseUrl := range targetUrls {
+		logger.Debugf(1, "using session with '%s' for '%s'\n", baseUrl, userName)
+		sshCert, x509Cert, kubernetesCert, err = getCertsWithCookies(
+			signer, userName, baseUrl, nil, addGroups, client,
+			userAgentString, logger)
+		if err == nil {
+			return sshCert, x509Cert, kubernetesCert, nil
+		}
+		logger.Debugf(1, "%s\n", err)
+	}
+	return nil, nil, nil, errors.New("Failed to get creds from session")
+}
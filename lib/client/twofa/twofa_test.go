@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/Symantec/Dominator/lib/log/testlogger"
@@ -199,3 +201,48 @@ func TestGetCertFromTargetUrlsFailUntrustedCA(t *testing.T) {
 		t.Fatal("Should have failed to connect untrusted CA")
 	}
 }
+
+func TestGetSSHCertFromSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/certgen/username" ||
+				r.URL.Query().Get("type") != "ssh" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			if _, err := r.Cookie("auth_cookie"); err != nil {
+				http.Error(w, "no session", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ssh-cert"))
+		}))
+	defer server.Close()
+	client, err := util.GetHttpClient(&tls.Config{}, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := util.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := testlogger.New(t)
+	_, err = GetSSHCertFromSession(privateKey, "username",
+		[]string{server.URL}, client, "someUserAgent", logger)
+	if err == nil {
+		t.Fatal("renewed without a session")
+	}
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Jar.SetCookies(serverURL,
+		[]*http.Cookie{{Name: "auth_cookie", Value: "session"}})
+	sshCert, err := GetSSHCertFromSession(privateKey, "username",
+		[]string{server.URL}, client, "someUserAgent", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sshCert) != "ssh-cert" {
+		t.Fatalf("bad cert: %s", sshCert)
+	}
+}
@@ -15,3 +15,16 @@ func DoVIPAuthenticate(
 	logger log.DebugLogger) error {
 	return doVIPAuthenticate(client, baseURL, userAgentString, logger)
 }
+
+// DoPushAuthenticate starts a push of the push factor named provider
+// through the keymaster push endpoints and waits for it to be approved. It
+// works with every push factor: VIP push, push approval, Duo and Okta.
+func DoPushAuthenticate(
+	client *http.Client,
+	baseURL string,
+	provider string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	return doPushAuthenticate(client, baseURL, provider, userAgentString,
+		logger)
+}
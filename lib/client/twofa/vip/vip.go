@@ -20,12 +20,19 @@ import (
 
 const vipCheckTimeoutSecs = 180
 
+// pushURL returns the URL of the push endpoint path for provider.
+func pushURL(baseURL string, path string, provider string) string {
+	return baseURL + path + "?" +
+		url.Values{"provider": {provider}}.Encode()
+}
+
 func startVIPPush(client *http.Client,
 	baseURL string,
+	provider string,
 	userAgentString string,
 	logger log.DebugLogger) error {
 
-	VIPPushStartURL := baseURL + "/api/v0/vipPushStart"
+	VIPPushStartURL := pushURL(baseURL, "/api/v0/vipPushStart", provider)
 
 	req, err := http.NewRequest("GET", VIPPushStartURL, nil)
 	if err != nil {
@@ -54,10 +61,11 @@ func startVIPPush(client *http.Client,
 
 func checkVIPPollStatus(client *http.Client,
 	baseURL string,
+	provider string,
 	userAgentString string,
 	logger log.DebugLogger) (bool, error) {
 
-	VIPPollCheckURL := baseURL + "/api/v0/vipPollCheck"
+	VIPPollCheckURL := pushURL(baseURL, "/api/v0/vipPollCheck", provider)
 
 	req, err := http.NewRequest("GET", VIPPollCheckURL, nil)
 	if err != nil {
@@ -90,11 +98,12 @@ func checkVIPPollStatus(client *http.Client,
 
 func doVIPPushCheck(client *http.Client,
 	baseURL string,
+	provider string,
 	userAgentString string,
 	logger log.DebugLogger,
 	errorReturnDuration time.Duration) error {
 
-	err := startVIPPush(client, baseURL, userAgentString, logger)
+	err := startVIPPush(client, baseURL, provider, userAgentString, logger)
 	if err != nil {
 		logger.Printf("got error from pushStart, will sleep to allow code to be entered")
 		logger.Println(err)
@@ -104,7 +113,8 @@ func doVIPPushCheck(client *http.Client,
 	endTime := time.Now().Add(errorReturnDuration)
 	//initial sleep
 	for time.Now().Before(endTime) {
-		ok, err := checkVIPPollStatus(client, baseURL, userAgentString, logger)
+		ok, err := checkVIPPollStatus(client, baseURL, provider,
+			userAgentString, logger)
 		if err != nil {
 			logger.Printf("got error from vipPollCheck, will sleep to allow code to be entered")
 			logger.Println(err)
@@ -201,7 +211,7 @@ func doVIPAuthenticate(
 	}()
 	go func() {
 		err := doVIPPushCheck(client, baseURL,
-			userAgentString,
+			proto.AuthTypeSymantecVIP, userAgentString,
 			logger, timeout)
 		ch <- err
 
@@ -219,3 +229,15 @@ func doVIPAuthenticate(
 	}
 	return nil
 }
+
+func doPushAuthenticate(
+	client *http.Client,
+	baseURL string,
+	provider string,
+	userAgentString string,
+	logger log.DebugLogger) error {
+	logger.Printf("Waiting for push approval")
+	timeout := time.Duration(vipCheckTimeoutSecs) * time.Second
+	return doVIPPushCheck(client, baseURL, provider, userAgentString, logger,
+		timeout)
+}
@@ -13,6 +13,14 @@ import (
 	"github.com/Symantec/keymaster/lib/client/net"
 )
 
+// Key types supported by GenKeyPairWithType and GenerateKeyWithType.
+const (
+	KeyTypeRSA       = "rsa"
+	KeyTypeEd25519   = "ed25519"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+)
+
 // GetUserCreds prompts the user for thier password and returns it.
 func GetUserCreds(userName string) (password []byte, err error) {
 	return getUserCreds(userName)
@@ -28,7 +36,16 @@ func GetUserHomeDir(usr *user.User) (string, error) {
 func GenKeyPair(
 	privateKeyPath string, identity string, logger log.Logger) (
 	privateKey crypto.Signer, publicKeyPath string, err error) {
-	return genKeyPair(privateKeyPath, identity, logger)
+	return genKeyPair(privateKeyPath, identity, KeyTypeRSA, logger)
+}
+
+// GenKeyPairWithType is like GenKeyPair but generates a key of the given
+// type. An empty keyType means KeyTypeRSA.
+func GenKeyPairWithType(
+	privateKeyPath string, identity string, keyType string,
+	logger log.Logger) (
+	privateKey crypto.Signer, publicKeyPath string, err error) {
+	return genKeyPair(privateKeyPath, identity, keyType, logger)
 }
 
 // GetHttpClient returns an http client instance to use given a
@@ -42,3 +59,9 @@ func GetHttpClient(tlsConfig *tls.Config,
 func GenerateKey() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, rsaKeySize)
 }
+
+// GenerateKeyWithType generates a random key of the given type. An empty
+// keyType means KeyTypeRSA.
+func GenerateKeyWithType(keyType string) (crypto.Signer, error) {
+	return generateKey(keyType)
+}
@@ -3,6 +3,9 @@ package util
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -25,6 +28,46 @@ import (
 
 const rsaKeySize = 2048
 
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// marshalPrivateKeyToPEM uses the traditional PEM encodings for RSA and
+// ECDSA keys and PKCS#8 for Ed25519 keys, which have no other encoding.
+func marshalPrivateKeyToPEM(privateKey crypto.Signer) ([]byte, error) {
+	var block *pem.Block
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case *ecdsa.PrivateKey:
+		derKey, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: derKey}
+	default:
+		derKey, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: derKey}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
 func getUserCreds(userName string) (password []byte, err error) {
 	fmt.Printf("Password for %s: ", userName)
 	password, err = gopass.GetPasswd()
@@ -37,26 +80,27 @@ func getUserCreds(userName string) (password []byte, err error) {
 
 // mostly comes from: http://stackoverflow.com/questions/21151714/go-generate-an-ssh-public-key
 func genKeyPair(
-	privateKeyPath string, identity string, logger log.Logger) (
-	crypto.Signer, string, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	privateKeyPath string, identity string, keyType string,
+	logger log.Logger) (crypto.Signer, string, error) {
+	privateKey, err := generateKey(keyType)
 	if err != nil {
 		return nil, "", err
 	}
 	// privateKeyPath := BasePath + prefix
 	pubKeyPath := privateKeyPath + ".pub"
 
-	err = ioutil.WriteFile(
-		privateKeyPath,
-		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}),
-		0600)
+	pemKey, err := marshalPrivateKeyToPEM(privateKey)
+	if err != nil {
+		return nil, "", err
+	}
+	err = ioutil.WriteFile(privateKeyPath, pemKey, 0600)
 	if err != nil {
 		logger.Printf("Failed to save privkey")
 		return nil, "", err
 	}
 
 	// generate and write public key
-	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	pub, err := ssh.NewPublicKey(privateKey.Public())
 	if err != nil {
 		return nil, "", err
 	}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"os/user"
+	"reflect"
 	"testing"
 
 	"github.com/Symantec/Dominator/lib/log/testlogger"
@@ -36,6 +37,37 @@ func TestGenKeyPairSuccess(t *testing.T) {
 	//TODO: verify written signer matches our signer.
 }
 
+func TestGenKeyPairWithTypeSuccess(t *testing.T) {
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeEd25519,
+		KeyTypeECDSAP256, KeyTypeECDSAP384} {
+		tmpfile, err := ioutil.TempFile("", "test_genKeyPair_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		signer, pubKeyPath, err := GenKeyPairWithType(tmpfile.Name(), "test",
+			keyType, testlogger.New(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(pubKeyPath)
+		fileBytes, err := ioutil.ReadFile(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileSigner, err := certgen.GetSignerFromPEMBytes(fileBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fileSigner.Public(), signer.Public()) {
+			t.Fatalf("written %s key does not match", keyType)
+		}
+	}
+	if _, err := GenerateKeyWithType("dsa"); err == nil {
+		t.Fatal("Should have failed with unsupported key type")
+	}
+}
+
 func TestGenKeyPairFailNoPerms(t *testing.T) {
 	_, _, err := GenKeyPair("/proc/something", "test", testlogger.New(t))
 	if err == nil {
@@ -0,0 +1,212 @@
+// Package duo is a client for the Duo Auth API (v2).
+package duo
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	authPath       = "/auth/v2/auth"
+	authStatusPath = "/auth/v2/auth_status"
+	preauthPath    = "/auth/v2/preauth"
+)
+
+// ErrDenied is returned by PushHasBeenApproved when the user denied the push.
+var ErrDenied = errors.New("duo: push denied")
+
+type Client struct {
+	IntegrationKey string
+	SecretKey      string
+	// APIHost is the API hostname of the Duo account, it is part of the
+	// signed request.
+	APIHost string
+	// BaseURL is where requests are sent, "https://" + APIHost by default.
+	BaseURL string
+	// PushType is shown in the Duo Mobile push notification.
+	PushType   string
+	HTTPClient *http.Client
+}
+
+type authResponse struct {
+	Stat     string `json:"stat"`
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	Response struct {
+		Result    string `json:"result"`
+		Status    string `json:"status"`
+		StatusMsg string `json:"status_msg"`
+		Txid      string `json:"txid"`
+	} `json:"response"`
+}
+
+func NewClient(integrationKey, secretKey, apiHost string) (client Client, err error) {
+	if integrationKey == "" || secretKey == "" || apiHost == "" {
+		return client, errors.New("duo: integration key, secret key and API host are required")
+	}
+	client.IntegrationKey = integrationKey
+	client.SecretKey = secretKey
+	client.APIHost = apiHost
+	client.BaseURL = "https://" + apiHost
+	client.PushType = "Keymaster login"
+	client.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	return client, nil
+}
+
+// escape encodes s as required by the Duo request signature (RFC 3986).
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func canonParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range params[key] {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sign returns the Authorization header value for a request.
+func (client *Client) sign(date, method, path string, params url.Values) string {
+	canon := strings.Join([]string{
+		date,
+		strings.ToUpper(method),
+		strings.ToLower(client.APIHost),
+		path,
+		canonParams(params),
+	}, "\n")
+	mac := hmac.New(sha1.New, []byte(client.SecretKey))
+	mac.Write([]byte(canon))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	return "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(client.IntegrationKey+":"+signature))
+}
+
+func (client *Client) call(method, path string, params url.Values) (*authResponse, error) {
+	date := time.Now().UTC().Format(time.RFC1123Z)
+	body := canonParams(params)
+	targetURL := client.BaseURL + path
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		if body != "" {
+			targetURL += "?" + body
+		}
+		req, err = http.NewRequest(method, targetURL, nil)
+	} else {
+		req, err = http.NewRequest(method, targetURL, strings.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Date", date)
+	req.Header.Set("Authorization", client.sign(date, method, path, params))
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response authResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("duo: bad response (%s): %s", resp.Status, err)
+	}
+	if response.Stat != "OK" {
+		return nil, fmt.Errorf("duo: %s call failed: %d %s", path,
+			response.Code, response.Message)
+	}
+	return &response, nil
+}
+
+// Preauth returns the result of a preauth for userID: "auth" if the user
+// must authenticate, "allow", "deny" or "enroll".
+func (client *Client) Preauth(userID string) (string, error) {
+	response, err := client.call("POST", preauthPath,
+		url.Values{"username": {userID}})
+	if err != nil {
+		return "", err
+	}
+	return response.Response.Result, nil
+}
+
+// ValidateUserPasscode checks a passcode (Duo Mobile, hardware token or
+// SMS passcode) of userID.
+func (client *Client) ValidateUserPasscode(userID string, passcode string) (bool, error) {
+	response, err := client.call("POST", authPath, url.Values{
+		"username": {userID},
+		"factor":   {"passcode"},
+		"passcode": {passcode},
+	})
+	if err != nil {
+		return false, err
+	}
+	return response.Response.Result == "allow", nil
+}
+
+// StartUserPush sends a push to the first capable device of userID and
+// returns the transaction ID to poll with PushHasBeenApproved.
+func (client *Client) StartUserPush(userID string) (transactionID string, err error) {
+	params := url.Values{
+		"username": {userID},
+		"factor":   {"push"},
+		"device":   {"auto"},
+		"async":    {"1"},
+	}
+	if client.PushType != "" {
+		params.Set("type", client.PushType)
+	}
+	response, err := client.call("POST", authPath, params)
+	if err != nil {
+		return "", err
+	}
+	if response.Response.Txid == "" {
+		return "", errors.New("duo: no transaction ID in push response")
+	}
+	return response.Response.Txid, nil
+}
+
+// PushHasBeenApproved reports whether the push transactionID has been
+// approved. It returns ErrDenied once the push is denied or times out.
+func (client *Client) PushHasBeenApproved(transactionID string) (bool, error) {
+	response, err := client.call("GET", authStatusPath,
+		url.Values{"txid": {transactionID}})
+	if err != nil {
+		return false, err
+	}
+	switch response.Response.Result {
+	case "allow":
+		return true, nil
+	case "waiting":
+		return false, nil
+	default:
+		return false, ErrDenied
+	}
+}
@@ -0,0 +1,155 @@
+package duo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const (
+	testIntegrationKey = "DIWJ8X6AEYOR5OMC6TQ1"
+	testSecretKey      = "Zh5eGmUq9zpfQnyUIu5OL9iWoMMv5ZNmk3zLJ4Ep"
+	testAPIHost        = "api-xxxxxxxx.duosecurity.com"
+)
+
+// fakeDuo checks the request signatures and answers like the Duo Auth API.
+type fakeDuo struct {
+	t          *testing.T
+	client     Client
+	pushResult string
+}
+
+func (fake *fakeDuo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fake.t.Fatal(err)
+	}
+	params := r.Form
+	if r.Method == "GET" {
+		params = r.URL.Query()
+	}
+	expected := fake.client.sign(r.Header.Get("Date"), r.Method, r.URL.Path,
+		params)
+	response := map[string]interface{}{"stat": "OK"}
+	result := map[string]string{}
+	switch {
+	case r.Header.Get("Authorization") != expected:
+		response = map[string]interface{}{"stat": "FAIL", "code": 40103,
+			"message": "Invalid signature in request credentials"}
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.URL.Path == preauthPath:
+		result["result"] = "auth"
+	case r.URL.Path == authPath && params.Get("factor") == "passcode":
+		result["result"] = "deny"
+		if params.Get("passcode") == "123456" {
+			result["result"] = "allow"
+		}
+	case r.URL.Path == authPath && params.Get("factor") == "push":
+		if params.Get("async") != "1" {
+			fake.t.Error("push is not async")
+		}
+		result["txid"] = "txid-" + params.Get("username")
+	case r.URL.Path == authStatusPath:
+		if params.Get("txid") != "txid-username" {
+			fake.t.Errorf("bad txid: %s", params.Get("txid"))
+		}
+		result["result"] = fake.pushResult
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+	if _, ok := response["code"]; !ok {
+		response["response"] = result
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeDuo, func()) {
+	client, err := NewClient(testIntegrationKey, testSecretKey, testAPIHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDuo{t: t, client: client, pushResult: "waiting"}
+	server := httptest.NewServer(fake)
+	client.BaseURL = server.URL
+	return &client, fake, server.Close
+}
+
+func TestSign(t *testing.T) {
+	client := Client{IntegrationKey: testIntegrationKey,
+		SecretKey: testSecretKey, APIHost: "API-XXXXXXXX.duosecurity.com"}
+	params := url.Values{
+		"realname": {"First Last"},
+		"username": {"root"},
+	}
+	// Example from the Duo API documentation.
+	expected := "Basic RElXSjhYNkFFWU9SNU9NQzZUUTE6MmQ5N2Q2MTY2MzE5NzgxYjVhM2EwN2FmMzlkMzY2ZjQ5MTIzNGVkYw=="
+	authorization := client.sign("Tue, 21 Aug 2012 17:29:18 -0000", "post",
+		"/accounts/v1/account/list", params)
+	if authorization != expected {
+		t.Fatalf("bad signature %s", authorization)
+	}
+}
+
+func TestNewClientRequiresKeys(t *testing.T) {
+	if _, err := NewClient(testIntegrationKey, "", testAPIHost); err == nil {
+		t.Fatal("expected error for missing secret key")
+	}
+}
+
+func TestValidateUserPasscode(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+	ok, err := client.ValidateUserPasscode("username", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("valid passcode rejected")
+	}
+	ok, err = client.ValidateUserPasscode("username", "654321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("invalid passcode accepted")
+	}
+	result, err := client.Preauth("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "auth" {
+		t.Fatalf("bad preauth result: %s", result)
+	}
+}
+
+func TestPush(t *testing.T) {
+	client, fake, cleanup := newTestClient(t)
+	defer cleanup()
+	txid, err := client.StartUserPush("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved, err := client.PushHasBeenApproved(txid)
+	if err != nil || approved {
+		t.Fatalf("push should be waiting: %v", err)
+	}
+	fake.pushResult = "allow"
+	approved, err = client.PushHasBeenApproved(txid)
+	if err != nil || !approved {
+		t.Fatalf("push should be approved: %v", err)
+	}
+	fake.pushResult = "deny"
+	if _, err := client.PushHasBeenApproved(txid); err != ErrDenied {
+		t.Fatalf("expected ErrDenied, got %v", err)
+	}
+}
+
+func TestBadSecretKey(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+	client.SecretKey = "wrong"
+	if _, err := client.ValidateUserPasscode("username", "123456"); err == nil {
+		t.Fatal("expected error for bad signature")
+	}
+}
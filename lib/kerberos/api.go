@@ -0,0 +1,45 @@
+// Package kerberos authenticates users from the Kerberos SPNEGO (Negotiate)
+// tokens sent by HTTP clients, checked against the keys in a keytab.
+package kerberos
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+)
+
+// ErrNoToken is returned by Authenticate when the request carries no
+// Negotiate token.
+var ErrNoToken = errors.New("kerberos: no Negotiate token")
+
+type Authenticator struct {
+	realm    string
+	settings *service.Settings
+	logger   log.DebugLogger
+}
+
+// New creates an Authenticator which checks the tokens against the service
+// keys in the keytab file keytabFilename. Only users of realm, which must
+// not be empty, are accepted. Log messages are written to logger.
+func New(keytabFilename string, realm string,
+	logger log.DebugLogger) (*Authenticator, error) {
+	return newAuthenticator(keytabFilename, realm, logger)
+}
+
+// NewFromKeytab is like New but uses the already loaded keytab kt. If realm
+// is empty no user is accepted.
+func NewFromKeytab(kt *keytab.Keytab, realm string,
+	logger log.DebugLogger) *Authenticator {
+	return newFromKeytab(kt, realm, logger)
+}
+
+// Authenticate checks the Negotiate token in the Authorization header of r
+// and returns the name (without realm) of the authenticated user. It returns
+// ErrNoToken if r has no Negotiate token and another error if the token is
+// not valid.
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	return a.authenticate(r)
+}
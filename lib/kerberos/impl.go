@@ -0,0 +1,97 @@
+package kerberos
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+const negotiateScheme = "Negotiate"
+
+func newAuthenticator(keytabFilename string, realm string,
+	logger log.DebugLogger) (*Authenticator, error) {
+	// The username is returned without its realm, so it must be checked.
+	if realm == "" {
+		return nil, errors.New("kerberos: no realm")
+	}
+	kt, err := keytab.Load(keytabFilename)
+	if err != nil {
+		return nil, fmt.Errorf("kerberos: cannot load keytab: %s", err)
+	}
+	return newFromKeytab(kt, realm, logger), nil
+}
+
+func newFromKeytab(kt *keytab.Keytab, realm string,
+	logger log.DebugLogger) *Authenticator {
+	return &Authenticator{
+		realm: realm,
+		// The PAC of Active Directory tickets is not needed: the groups
+		// come from the userinfo sources.
+		settings: service.NewSettings(kt, service.DecodePAC(false)),
+		logger:   logger,
+	}
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], negotiateScheme) {
+		return "", ErrNoToken
+	}
+	token, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("kerberos: bad token encoding: %s", err)
+	}
+	apReq, err := getAPReq(token)
+	if err != nil {
+		return "", err
+	}
+	ok, creds, err := service.VerifyAPREQ(apReq, a.settings)
+	if err != nil {
+		return "", fmt.Errorf("kerberos: %s", err)
+	}
+	if !ok {
+		return "", errors.New("kerberos: AP-REQ not valid")
+	}
+	cname := creds.CName()
+	if len(cname.NameString) != 1 {
+		return "", fmt.Errorf("kerberos: not a user principal: %s",
+			cname.PrincipalNameString())
+	}
+	if creds.Domain() != a.realm {
+		return "", fmt.Errorf("kerberos: %s@%s is not in realm %s",
+			cname.PrincipalNameString(), creds.Domain(), a.realm)
+	}
+	a.logger.Debugf(1, "kerberos: authenticated %s@%s",
+		cname.PrincipalNameString(), creds.Domain())
+	return cname.NameString[0], nil
+}
+
+// getAPReq extracts the AP-REQ from token, which is either an SPNEGO
+// NegTokenInit or a bare Kerberos GSS-API token.
+func getAPReq(token []byte) (*messages.APReq, error) {
+	mechToken := token
+	var spnegoToken spnego.SPNEGOToken
+	if err := spnegoToken.Unmarshal(token); err == nil {
+		if !spnegoToken.Init {
+			return nil, errors.New("kerberos: not an SPNEGO NegTokenInit")
+		}
+		mechToken = spnegoToken.NegTokenInit.MechTokenBytes
+	}
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(mechToken); err != nil {
+		return nil, fmt.Errorf("kerberos: %s", err)
+	}
+	if !krb5Token.IsAPReq() {
+		return nil, errors.New("kerberos: token has no AP-REQ")
+	}
+	return &krb5Token.APReq, nil
+}
@@ -0,0 +1,170 @@
+package kerberos
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	testRealm = "EXAMPLE.COM"
+	testSPN   = "HTTP/keymaster.example.com"
+	testEType = etypeID.AES256_CTS_HMAC_SHA1_96
+)
+
+// testKDC stands in for the KDC: it issues service tickets for testSPN
+// encrypted with the key in its keytab.
+type testKDC struct {
+	keytab *keytab.Keytab
+}
+
+func newTestKDC(t *testing.T, servicePassword string) *testKDC {
+	kt := keytab.New()
+	err := kt.AddEntry(testSPN, testRealm, servicePassword, time.Now(), 1,
+		testEType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKDC{keytab: kt}
+}
+
+// token returns the SPNEGO token of username@realm for a ticket valid
+// from start to end.
+func (kdc *testKDC) token(t *testing.T, username, realm string,
+	start, end time.Time) []byte {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, username)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, testSPN)
+	tkt, sessionKey, err := messages.NewTicket(cname, realm, sname,
+		testRealm, types.NewKrbFlags(), kdc.keytab, testEType, 1,
+		start, start, end, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := client.NewWithPassword(username, realm, "unused", config.New())
+	negTokenInit, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negTokenInit}
+	b, err := token.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (kdc *testKDC) request(t *testing.T, username, realm string) *http.Request {
+	now := time.Now().UTC()
+	return negotiateRequest(t,
+		kdc.token(t, username, realm, now, now.Add(time.Hour)))
+}
+
+func negotiateRequest(t *testing.T, token []byte) *http.Request {
+	req, err := http.NewRequest("POST", "/api/v0/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization",
+		"Negotiate "+base64.StdEncoding.EncodeToString(token))
+	return req
+}
+
+func TestAuthenticate(t *testing.T) {
+	kdc := newTestKDC(t, "service-password")
+	a := NewFromKeytab(kdc.keytab, testRealm, testlogger.New(t))
+	username, err := a.Authenticate(kdc.request(t, "alice", testRealm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" {
+		t.Fatalf("username: %s != alice", username)
+	}
+	// The bare Kerberos token, without the SPNEGO wrapping, is accepted too.
+	now := time.Now().UTC()
+	var spnegoToken spnego.SPNEGOToken
+	err = spnegoToken.Unmarshal(kdc.token(t, "bob", testRealm, now,
+		now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, err = a.Authenticate(
+		negotiateRequest(t, spnegoToken.NegTokenInit.MechTokenBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "bob" {
+		t.Fatalf("username: %s != bob", username)
+	}
+}
+
+func TestAuthenticateNoToken(t *testing.T) {
+	kdc := newTestKDC(t, "service-password")
+	a := NewFromKeytab(kdc.keytab, testRealm, testlogger.New(t))
+	req, err := http.NewRequest("POST", "/api/v0/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(req); err != ErrNoToken {
+		t.Fatalf("no header: %v", err)
+	}
+	req.SetBasicAuth("alice", "password")
+	if _, err := a.Authenticate(req); err != ErrNoToken {
+		t.Fatalf("basic auth: %v", err)
+	}
+}
+
+func TestAuthenticateFail(t *testing.T) {
+	kdc := newTestKDC(t, "service-password")
+	a := NewFromKeytab(kdc.keytab, testRealm, testlogger.New(t))
+	now := time.Now().UTC()
+	badRequests := map[string]*http.Request{
+		"wrong service key": newTestKDC(t, "other-password").request(t,
+			"alice", testRealm),
+		"expired ticket": negotiateRequest(t, kdc.token(t, "alice", testRealm,
+			now.Add(-2*time.Hour), now.Add(-time.Hour))),
+		"other realm":      kdc.request(t, "alice", "OTHER.EXAMPLE.COM"),
+		"service instance": kdc.request(t, "alice/admin", testRealm),
+		"garbage":          negotiateRequest(t, []byte("not a token")),
+	}
+	for name, req := range badRequests {
+		if username, err := a.Authenticate(req); err == nil {
+			t.Errorf("%s: authenticated as %s", name, username)
+		}
+	}
+}
+
+func TestNoRealm(t *testing.T) {
+	if _, err := New("/nonexistent.keytab", "", testlogger.New(t)); err == nil ||
+		err.Error() != "kerberos: no realm" {
+		t.Fatalf("empty realm: %v", err)
+	}
+	kdc := newTestKDC(t, "service-password")
+	a := NewFromKeytab(kdc.keytab, "", testlogger.New(t))
+	if username, err := a.Authenticate(kdc.request(t, "alice",
+		testRealm)); err == nil {
+		t.Fatalf("authenticated as %s without a realm", username)
+	}
+}
+
+func TestAuthenticateReplay(t *testing.T) {
+	kdc := newTestKDC(t, "service-password")
+	a := NewFromKeytab(kdc.keytab, testRealm, testlogger.New(t))
+	req := kdc.request(t, "carol", testRealm)
+	if _, err := a.Authenticate(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(req); err == nil {
+		t.Fatal("replayed token accepted")
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Package pkcs11signer provides a crypto.Signer for RSA and ECDSA private
+keys held in a PKCS#11 token such as an HSM, so the private key never
+enters process memory.
+*/
+package pkcs11signer
+
+import (
+	"crypto"
+	"io"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Signer implements crypto.Signer using a private key in a PKCS#11 token.
+// It is safe for concurrent use.
+type Signer struct {
+	mutex     sync.Mutex
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	key       pkcs11.ObjectHandle
+	publicKey crypto.PublicKey
+}
+
+// New loads the PKCS#11 module at modulePath, logs into the token with the
+// given label using pin and returns a Signer for the private key with label
+// keyLabel. The matching public key object must have the same label.
+func New(modulePath, tokenLabel, keyLabel, pin string) (*Signer, error) {
+	return newSigner(modulePath, tokenLabel, keyLabel, pin)
+}
+
+// Public returns the public key matching the private key in the token.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs digest with the private key in the token. For RSA keys only
+// PKCS#1 v1.5 signatures are supported. The rand argument is ignored.
+func (s *Signer) Sign(rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+	return s.sign(digest, opts)
+}
+
+// Close logs out of the token and unloads the PKCS#11 module.
+func (s *Signer) Close() error {
+	return s.close()
+}
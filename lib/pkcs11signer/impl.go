@@ -0,0 +1,275 @@
+package pkcs11signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+// DER encoded DigestInfo prefixes from RFC 8017 section 9.2.
+var rsaDigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1: {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03,
+		0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48,
+		0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48,
+		0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48,
+		0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+func newSigner(modulePath, tokenLabel, keyLabel, pin string) (
+	*Signer, error) {
+	ctx := pkcs11.New(modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load PKCS#11 module: %s", modulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+	signer := &Signer{ctx: ctx}
+	if err := signer.open(tokenLabel, keyLabel, pin); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return signer, nil
+}
+
+func (s *Signer) open(tokenLabel, keyLabel, pin string) error {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	found := false
+	var slot uint
+	for _, slot = range slots {
+		tokenInfo, err := s.ctx.GetTokenInfo(slot)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(tokenInfo.Label) == tokenLabel {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("PKCS#11 token not found: %s", tokenLabel)
+	}
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	err = s.ctx.Login(s.session, pkcs11.CKU_USER, pin)
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		s.ctx.CloseSession(s.session)
+		return err
+	}
+	if err := s.loadKey(keyLabel); err != nil {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+		return err
+	}
+	return nil
+}
+
+func (s *Signer) findObject(class, keyType uint, label string) (
+	pkcs11.ObjectHandle, bool, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, false, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, false, err
+	}
+	switch len(objects) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return objects[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("more than one PKCS#11 key with label %s",
+			label)
+	}
+}
+
+func (s *Signer) loadKey(label string) error {
+	for _, keyType := range []uint{pkcs11.CKK_RSA, pkcs11.CKK_EC} {
+		key, found, err := s.findObject(pkcs11.CKO_PRIVATE_KEY, keyType, label)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		publicKeyObject, found, err := s.findObject(pkcs11.CKO_PUBLIC_KEY,
+			keyType, label)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("PKCS#11 public key not found: %s", label)
+		}
+		if keyType == pkcs11.CKK_RSA {
+			s.publicKey, err = s.getRSAPublicKey(publicKeyObject)
+		} else {
+			s.publicKey, err = s.getECDSAPublicKey(publicKeyObject)
+		}
+		if err != nil {
+			return err
+		}
+		s.key = key
+		return nil
+	}
+	return fmt.Errorf("PKCS#11 private key not found: %s", label)
+}
+
+func (s *Signer) getRSAPublicKey(object pkcs11.ObjectHandle) (
+	*rsa.PublicKey, error) {
+	attributes, err := s.ctx.GetAttributeValue(s.session, object,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(attributes[1].Value)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA public exponent too large")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attributes[0].Value),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+func (s *Signer) getECDSAPublicKey(object pkcs11.ObjectHandle) (
+	*ecdsa.PublicKey, error) {
+	attributes, err := s.ctx.GetAttributeValue(s.session, object,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+	if err != nil {
+		return nil, err
+	}
+	return parseECPublicKey(attributes[0].Value, attributes[1].Value)
+}
+
+// parseECPublicKey decodes the CKA_EC_PARAMS and CKA_EC_POINT attributes.
+func parseECPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, err
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		curve = elliptic.P384()
+	case oid.Equal(oidNamedCurveP521):
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", oid)
+	}
+	// The point is a DER OCTET STRING containing the uncompressed point
+	var rawPoint []byte
+	if _, err := asn1.Unmarshal(point, &rawPoint); err != nil {
+		return nil, err
+	}
+	x, y := elliptic.Unmarshal(curve, rawPoint)
+	if x == nil {
+		return nil, errors.New("invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// ecdsaSignatureToASN1 converts the r||s signature returned by CKM_ECDSA to
+// the ASN.1 encoding used by crypto/ecdsa.
+func ecdsaSignatureToASN1(signature []byte) ([]byte, error) {
+	if len(signature) == 0 || len(signature)%2 != 0 {
+		return nil, errors.New("invalid ECDSA signature length")
+	}
+	half := len(signature) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(signature[:half]),
+		S: new(big.Int).SetBytes(signature[half:]),
+	})
+}
+
+func (s *Signer) sign(digest []byte, opts crypto.SignerOpts) (
+	[]byte, error) {
+	var mechanism uint
+	data := digest
+	switch s.publicKey.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("RSA PSS signatures are not supported")
+		}
+		mechanism = pkcs11.CKM_RSA_PKCS
+		if hash := opts.HashFunc(); hash != 0 {
+			prefix, ok := rsaDigestInfoPrefixes[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash: %v", hash)
+			}
+			if len(digest) != hash.Size() {
+				return nil, errors.New("digest length does not match hash")
+			}
+			data = append(append([]byte{}, prefix...), digest...)
+		}
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.CKM_ECDSA
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.ctx.SignInit(s.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.key)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, err
+	}
+	if mechanism == pkcs11.CKM_ECDSA {
+		return ecdsaSignatureToASN1(signature)
+	}
+	return signature, nil
+}
+
+func (s *Signer) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ctx.Logout(s.session)
+	s.ctx.CloseSession(s.session)
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	return err
+}
@@ -0,0 +1,154 @@
+package pkcs11signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Symantec/keymaster/lib/certgen"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseECPublicKey(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(oidNamedCurveP384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := asn1.Marshal(elliptic.Marshal(elliptic.P384(),
+		privateKey.X, privateKey.Y))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := parseECPublicKey(params, point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey.X.Cmp(privateKey.X) != 0 || publicKey.Y.Cmp(privateKey.Y) != 0 {
+		t.Fatal("public key does not match")
+	}
+	badParams, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseECPublicKey(badParams, point); err == nil {
+		t.Fatal("should have failed with unknown curve")
+	}
+}
+
+func TestECDSASignatureToASN1(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawSignature := make([]byte, 64)
+	r.FillBytes(rawSignature[:32])
+	s.FillBytes(rawSignature[32:])
+	signature, err := ecdsaSignatureToASN1(rawSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&privateKey.PublicKey, digest[:], signature) {
+		t.Fatal("converted signature does not verify")
+	}
+	if _, err := ecdsaSignatureToASN1(rawSignature[:63]); err == nil {
+		t.Fatal("should have failed with odd length")
+	}
+}
+
+// TestSoftHSM needs a token with an RSA or ECDSA key pair, e.g. created with
+//
+//	softhsm2-util --init-token --free --label keymaster --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $PKCS11_TEST_MODULE --login --pin 1234 \
+//	  --token-label keymaster --keypairgen --key-type rsa:2048 --label ca
+func TestSoftHSM(t *testing.T) {
+	modulePath := os.Getenv("PKCS11_TEST_MODULE")
+	if modulePath == "" {
+		t.Skip("PKCS11_TEST_MODULE not set")
+	}
+	signer, err := New(modulePath, os.Getenv("PKCS11_TEST_TOKEN"),
+		os.Getenv("PKCS11_TEST_KEY_LABEL"), os.Getenv("PKCS11_TEST_PIN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch publicKey := signer.Public().(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+			t.Fatal("ECDSA signature does not verify")
+		}
+	default:
+		t.Fatal("unexpected public key type")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both SSH and X.509 signing paths must work
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromSigner(mustGenerateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, certBytes, err := certgen.GenSSHCertFileString("username",
+		string(ssh.MarshalAuthorizedKey(userSigner.PublicKey())), sshSigner,
+		"host", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.ParsePublicKey(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := ssh.CertChecker{}
+	if err := checker.CheckCert("username", pubKey.(*ssh.Certificate)); err != nil {
+		t.Fatal(err)
+	}
+	derCert, err := certgen.GenSelfSignedCACert("hostname", "organization",
+		signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(derCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := caCert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustGenerateKey(t *testing.T) crypto.Signer {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
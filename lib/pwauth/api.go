@@ -13,3 +13,10 @@ type PasswordAuthenticator interface {
 	PasswordAuthenticate(username string, password []byte) (bool, error)
 	UpdateStorage(storage simplestorage.SimpleStore) error
 }
+
+// UserLookup may be implemented by a PasswordAuthenticator that can tell
+// whether a user exists in its backend, independently of the password.
+type UserLookup interface {
+	// UserExists returns whether username is known to the backend.
+	UserExists(username string) (bool, error)
+}
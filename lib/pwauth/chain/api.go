@@ -0,0 +1,46 @@
+package chain
+
+import (
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+// Backend is a link of the chain.
+type Backend struct {
+	// Name is used in log messages.
+	Name          string
+	Authenticator pwauth.PasswordAuthenticator
+	// UsernamePatterns are shell patterns (as used by path.Match), such as
+	// "svc-*" or "*@example.com", selecting the usernames sent to this
+	// backend. Empty means all the usernames.
+	UsernamePatterns []string
+}
+
+type PasswordAuthenticator struct {
+	backends []Backend
+	logger   log.DebugLogger
+}
+
+// New creates a new PasswordAuthenticator trying backends in order. Only
+// the backends with a pattern matching the username are tried. The next
+// backend is only tried when a backend implementing pwauth.UserLookup does
+// not know the user: a wrong password or an error from a backend is final.
+func New(backends []Backend, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticator(backends, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username
+// and password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+// UpdateStorage updates the storage of all the backends.
+func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return pa.updateStorage(storage)
+}
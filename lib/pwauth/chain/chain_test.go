@@ -0,0 +1,92 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+// testAuthenticator accepts the passwords in passwords.
+type testAuthenticator struct {
+	passwords map[string]string
+	err       error
+}
+
+func (ta *testAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	if ta.err != nil {
+		return false, ta.err
+	}
+	expected, ok := ta.passwords[username]
+	return ok && expected == string(password), nil
+}
+
+func (ta *testAuthenticator) UpdateStorage(
+	storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+// testLookupAuthenticator also tells which users it knows.
+type testLookupAuthenticator struct {
+	testAuthenticator
+}
+
+func (ta *testLookupAuthenticator) UserExists(username string) (bool, error) {
+	_, ok := ta.passwords[username]
+	return ok, nil
+}
+
+func TestChain(t *testing.T) {
+	local := &testLookupAuthenticator{testAuthenticator{
+		passwords: map[string]string{"svc-build": "local", "alice": "local"}}}
+	directory := &testAuthenticator{
+		passwords: map[string]string{"alice": "directory", "bob": "directory"}}
+	failing := &testAuthenticator{err: errors.New("unavailable")}
+	pa, err := New([]Backend{
+		{Name: "broken", Authenticator: failing,
+			UsernamePatterns: []string{"*@broken.example.com"}},
+		{Name: "local", Authenticator: local},
+		{Name: "directory", Authenticator: directory},
+	}, testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username string
+		password string
+		ok       bool
+		err      bool
+	}{
+		{"svc-build", "local", true, false},
+		{"svc-build", "directory", false, false},
+		// Known locally, so a bad password does not fall back.
+		{"alice", "directory", false, false},
+		{"alice", "local", true, false},
+		// Unknown locally.
+		{"bob", "directory", true, false},
+		{"carol", "directory", false, false},
+		{"dave@broken.example.com", "local", false, true},
+	}
+	for _, test := range tests {
+		ok, err := pa.PasswordAuthenticate(test.username,
+			[]byte(test.password))
+		if ok != test.ok || (err != nil) != test.err {
+			t.Errorf("%s/%s: got %v, %v", test.username, test.password, ok,
+				err)
+		}
+	}
+}
+
+func TestNewBadPattern(t *testing.T) {
+	_, err := New([]Backend{{Name: "local",
+		Authenticator:    &testAuthenticator{},
+		UsernamePatterns: []string{"["}}}, testlogger.New(t))
+	if err == nil {
+		t.Fatal("bad pattern accepted")
+	}
+	if _, err := New(nil, testlogger.New(t)); err == nil {
+		t.Fatal("empty chain accepted")
+	}
+}
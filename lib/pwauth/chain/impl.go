@@ -0,0 +1,76 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/pwauth"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+func newAuthenticator(backends []Backend, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("chain: no backends")
+	}
+	for _, backend := range backends {
+		if backend.Authenticator == nil {
+			return nil, fmt.Errorf("chain: backend %s has no authenticator",
+				backend.Name)
+		}
+		for _, pattern := range backend.UsernamePatterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("chain: bad pattern '%s' for %s",
+					pattern, backend.Name)
+			}
+		}
+	}
+	return &PasswordAuthenticator{backends: backends, logger: logger}, nil
+}
+
+func (backend *Backend) matches(username string) bool {
+	if len(backend.UsernamePatterns) == 0 {
+		return true
+	}
+	for _, pattern := range backend.UsernamePatterns {
+		if matched, _ := path.Match(pattern, username); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	for _, backend := range pa.backends {
+		if !backend.matches(username) {
+			continue
+		}
+		if lookup, ok := backend.Authenticator.(pwauth.UserLookup); ok {
+			exists, err := lookup.UserExists(username)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				pa.logger.Debugf(1, "%s: user %s unknown, trying next backend",
+					backend.Name, username)
+				continue
+			}
+		}
+		pa.logger.Debugf(1, "%s: authenticating %s", backend.Name, username)
+		return backend.Authenticator.PasswordAuthenticate(username, password)
+	}
+	return false, nil
+}
+
+func (pa *PasswordAuthenticator) updateStorage(
+	storage simplestorage.SimpleStore) error {
+	for _, backend := range pa.backends {
+		if err := backend.Authenticator.UpdateStorage(storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
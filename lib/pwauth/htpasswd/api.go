@@ -0,0 +1,37 @@
+package htpasswd
+
+import (
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+type PasswordAuthenticator struct {
+	filename string
+	logger   log.DebugLogger
+}
+
+// New creates a new PasswordAuthenticator using the htpasswd file filename,
+// which is read on every authentication so that changes apply right away.
+// Only bcrypt password hashes are supported.
+func New(filename string, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticator(filename, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username
+// and password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+// UserExists returns whether username has an entry in the htpasswd file.
+func (pa *PasswordAuthenticator) UserExists(username string) (bool, error) {
+	return pa.userExists(username)
+}
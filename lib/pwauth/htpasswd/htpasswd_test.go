@@ -0,0 +1,44 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+)
+
+// This DB has user 'username' with password 'password'
+const userdbContent = `username:$2y$05$D4qQmZbWYqfgtGtez2EGdOkcNne40EdEznOqMvZegQypT8Jdz42Jy`
+
+func TestPasswordAuthenticate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(userdbContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	pa, err := New(tmpfile.Name(), testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := pa.PasswordAuthenticate("username",
+		[]byte("password")); err != nil || !ok {
+		t.Fatalf("valid password rejected: %v", err)
+	}
+	if ok, _ := pa.PasswordAuthenticate("username", []byte("bad")); ok {
+		t.Fatal("bad password accepted")
+	}
+	if exists, err := pa.UserExists("username"); err != nil || !exists {
+		t.Fatalf("user not found: %v", err)
+	}
+	if exists, err := pa.UserExists("other"); err != nil || exists {
+		t.Fatalf("unknown user found: %v", err)
+	}
+	if _, err := New(tmpfile.Name()+".missing", testlogger.New(t)); err == nil {
+		t.Fatal("missing file accepted")
+	}
+}
@@ -0,0 +1,41 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/authutil"
+	"github.com/foomo/htpasswd"
+)
+
+func newAuthenticator(filename string, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return &PasswordAuthenticator{filename: filename, logger: logger}, nil
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	buffer, err := ioutil.ReadFile(pa.filename)
+	if err != nil {
+		return false, err
+	}
+	return authutil.CheckHtpasswdUserPassword(username, string(password),
+		buffer)
+}
+
+func (pa *PasswordAuthenticator) userExists(username string) (bool, error) {
+	buffer, err := ioutil.ReadFile(pa.filename)
+	if err != nil {
+		return false, err
+	}
+	passwords, err := htpasswd.ParseHtpasswd(buffer)
+	if err != nil {
+		return false, err
+	}
+	_, ok := passwords[username]
+	return ok, nil
+}
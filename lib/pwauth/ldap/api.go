@@ -14,9 +14,37 @@ type cacheCredentialEntry struct {
 	Hash       string
 }
 
+// LatencyRecorder is called with the duration of every operation (connect,
+// bind or search) that an LDAP server answered.
+type LatencyRecorder func(server string, operation string,
+	duration time.Duration)
+
+// Config holds the optional settings of a PasswordAuthenticator.
+type Config struct {
+	// If SearchBindDN is set, the DN of the user is found by searching
+	// SearchBaseDNs with SearchFilter while bound as SearchBindDN, instead
+	// of being derived from the username with the bind patterns.
+	SearchBindDN       string
+	SearchBindPassword string
+	SearchBaseDNs      []string
+	// SearchFilter is a format string given the escaped username. The
+	// default is "(uid=%s)".
+	SearchFilter string
+	// MaxIdleConnsPerServer is the number of idle connections kept open to
+	// each server. The default is 2.
+	MaxIdleConnsPerServer int
+	// HealthCheckInterval is how often the idle connections are checked.
+	// The checks are disabled if it is zero (the default) or negative.
+	// They run until Close is called.
+	HealthCheckInterval time.Duration
+	LatencyRecorder     LatencyRecorder
+}
+
 type PasswordAuthenticator struct {
 	ldapURL            []*url.URL
 	bindPattern        []string
+	config             Config
+	pool               *connPoolType
 	timeoutSecs        uint
 	rootCAs            *x509.CertPool
 	logger             log.DebugLogger
@@ -30,6 +58,21 @@ func New(url []string, bindPattern []string, timeoutSecs uint, rootCAs *x509.Cer
 	return newAuthenticator(url, bindPattern, timeoutSecs, rootCAs, storage, logger)
 }
 
+// NewWithConfig is like New, but takes the optional settings in config.
+func NewWithConfig(url []string, bindPattern []string, config Config,
+	timeoutSecs uint, rootCAs *x509.CertPool,
+	storage simplestorage.SimpleStore, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticatorWithConfig(url, bindPattern, config, timeoutSecs,
+		rootCAs, storage, logger)
+}
+
+// Close stops the health checks and closes the idle connections.
+func (pa *PasswordAuthenticator) Close() error {
+	pa.pool.close()
+	return nil
+}
+
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
 	pa.storage = storage
 	return nil
@@ -44,3 +87,11 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	password []byte) (bool, error) {
 	return pa.passwordAuthenticate(username, password)
 }
+
+// UserExists returns whether username is found in the search base DNs. It
+// needs Config.SearchBindDN: with bind patterns users cannot be looked up
+// without their password. If no server answers, users with a cached
+// password are reported as existing.
+func (pa *PasswordAuthenticator) UserExists(username string) (bool, error) {
+	return pa.userExists(username)
+}
@@ -4,21 +4,33 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/Symantec/Dominator/lib/log"
 	"github.com/Symantec/keymaster/lib/authutil"
 	"github.com/Symantec/keymaster/lib/simplestorage"
+	"gopkg.in/ldap.v2"
 )
 
 const defaultCacheDuration = time.Hour * 96
 const passwordDataType = 1
 const browserResponseTimeoutSeconds = 7
+const defaultSearchFilter = "(uid=%s)"
+const defaultMaxIdleConnsPerServer = 2
 
 func newAuthenticator(urllist []string, bindPattern []string,
 	timeoutSecs uint, rootCAs *x509.CertPool,
 	storage simplestorage.SimpleStore, logger log.DebugLogger) (
 	*PasswordAuthenticator, error) {
+	return newAuthenticatorWithConfig(urllist, bindPattern, Config{},
+		timeoutSecs, rootCAs, storage, logger)
+}
+
+func newAuthenticatorWithConfig(urllist []string, bindPattern []string,
+	config Config, timeoutSecs uint, rootCAs *x509.CertPool,
+	storage simplestorage.SimpleStore, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
 	var authenticator PasswordAuthenticator
 	for _, stringURL := range urllist {
 		url, err := authutil.ParseLDAPURL(stringURL)
@@ -37,9 +49,29 @@ func newAuthenticator(urllist []string, bindPattern []string,
 	authenticator.expirationDuration = defaultCacheDuration
 	authenticator.storage = storage
 	authenticator.cachedCredentials = make(map[string]cacheCredentialEntry)
+	if config.SearchBindDN != "" && len(config.SearchBaseDNs) < 1 {
+		return nil, errors.New("search bind DN given without search base DNs")
+	}
+	if config.SearchFilter == "" {
+		config.SearchFilter = defaultSearchFilter
+	}
+	if config.MaxIdleConnsPerServer < 1 {
+		config.MaxIdleConnsPerServer = defaultMaxIdleConnsPerServer
+	}
+	authenticator.config = config
+	authenticator.pool = newConnPool(authenticator.ldapURL,
+		authenticator.dial, config.MaxIdleConnsPerServer,
+		config.LatencyRecorder, logger)
+	if config.HealthCheckInterval > 0 {
+		go authenticator.pool.healthCheckLoop(config.HealthCheckInterval)
+	}
 	return &authenticator, nil
 }
 
+func (pa *PasswordAuthenticator) dial(u *url.URL) (ldapConn, error) {
+	return authutil.NewLDAPConnection(*u, pa.timeoutSecs, pa.rootCAs)
+}
+
 func convertToBindDN(username string, bind_pattern string) string {
 	return fmt.Sprintf(bind_pattern, username)
 }
@@ -79,27 +111,128 @@ func (pa *PasswordAuthenticator) updateOrDeletePasswordHash(valid bool, username
 	return nil
 }
 
-func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
-	password []byte) (valid bool, err error) {
-	valid = false
-	for _, u := range pa.ldapURL {
-		for _, bindPattern := range pa.bindPattern {
-			bindDN := convertToBindDN(username, bindPattern)
-			valid, err = authutil.CheckLDAPUserPassword(*u, bindDN, string(password), pa.timeoutSecs, pa.rootCAs)
-			if err != nil {
-				if pa.logger != nil {
-					pa.logger.Debugf(1, "Error checking LDAP user password url= %s", u)
-				}
+// findUserDN returns the DN of username found by searching the base DNs
+// while bound as the search account.
+func (pa *PasswordAuthenticator) findUserDN(conn *pooledConn,
+	username string) (string, error) {
+	if conn.boundDN != pa.config.SearchBindDN {
+		err := conn.bind(pa.config.SearchBindDN, pa.config.SearchBindPassword)
+		if err != nil {
+			return "", err
+		}
+	}
+	filter := fmt.Sprintf(pa.config.SearchFilter, ldap.EscapeFilter(username))
+	for _, baseDN := range pa.config.SearchBaseDNs {
+		result, err := conn.search(ldap.NewSearchRequest(baseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+			filter, []string{"1.1"}, nil))
+		if err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
 				continue
 			}
-			err = pa.updateOrDeletePasswordHash(valid, username, password)
-			if err != nil && pa.logger != nil {
-				pa.logger.Debugf(0, "Updating local password hash for user %s", username)
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+				return "", fmt.Errorf("multiple entries for user %s", username)
 			}
-			return valid, nil
+			return "", err
+		}
+		switch len(result.Entries) {
+		case 0:
+			continue
+		case 1:
+			return result.Entries[0].DN, nil
+		default:
+			return "", fmt.Errorf("multiple entries for user %s", username)
+		}
+	}
+	return "", nil
+}
 
+// checkPasswordWithConn returns an error only if the password could not be
+// checked.
+func (pa *PasswordAuthenticator) checkPasswordWithConn(conn *pooledConn,
+	username string, password string) (bool, error) {
+	var bindDNs []string
+	if pa.config.SearchBindDN != "" {
+		userDN, err := pa.findUserDN(conn, username)
+		if err != nil {
+			return false, err
+		}
+		if userDN == "" {
+			return false, nil
+		}
+		bindDNs = []string{userDN}
+	} else {
+		for _, bindPattern := range pa.bindPattern {
+			bindDNs = append(bindDNs, convertToBindDN(username, bindPattern))
 		}
 	}
+	err := errors.New("no bind patterns")
+	for _, bindDN := range bindDNs {
+		err = conn.bind(bindDN, password)
+		if err == nil {
+			return true, nil
+		}
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return false, nil
+		}
+		if conn.failed {
+			return false, err
+		}
+		pa.pool.debugf(1, "Bind failure for server:%s bindDN:'%s' (%s)",
+			conn.server.url.Host, bindDN, err)
+	}
+	return false, err
+}
+
+// isStaleConnError returns true if err may be caused by the server closing
+// the idle connection conn, in which case a new connection should be tried.
+// Timeouts are not retried, since the server is unlikely to answer faster.
+func isStaleConnError(conn *pooledConn, err error) bool {
+	return conn.reused && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) &&
+		!isTimeoutError(err)
+}
+
+func (pa *PasswordAuthenticator) checkPassword(server *serverType,
+	username string, password string) (bool, error) {
+	conn, err := pa.pool.get(server)
+	if err != nil {
+		return false, err
+	}
+	valid, err := pa.checkPasswordWithConn(conn, username, password)
+	conn.release()
+	// An idle connection may have been closed by the server, so retry once
+	// on a new connection.
+	if isStaleConnError(conn, err) {
+		conn, err = pa.pool.connect(server)
+		if err != nil {
+			return false, err
+		}
+		valid, err = pa.checkPasswordWithConn(conn, username, password)
+		conn.release()
+	}
+	return valid, err
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (valid bool, err error) {
+	// An empty password makes an unauthenticated bind, which succeeds.
+	if len(password) < 1 {
+		return false, nil
+	}
+	for _, server := range pa.pool.orderedServers() {
+		valid, err = pa.checkPassword(server, username, string(password))
+		if err != nil {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "Error checking LDAP user password url= %s", server.url)
+			}
+			continue
+		}
+		err = pa.updateOrDeletePasswordHash(valid, username, password)
+		if err != nil && pa.logger != nil {
+			pa.logger.Debugf(0, "Updating local password hash for user %s", username)
+		}
+		return valid, nil
+	}
 	if pa.storage != nil {
 		if pa.logger != nil {
 			pa.logger.Printf("Failed to check password against LDAP servers, using local hash db")
@@ -119,3 +252,49 @@ func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 
 	return false, nil
 }
+
+func (pa *PasswordAuthenticator) findUserDNOnServer(server *serverType,
+	username string) (string, error) {
+	conn, err := pa.pool.get(server)
+	if err != nil {
+		return "", err
+	}
+	userDN, err := pa.findUserDN(conn, username)
+	conn.release()
+	if isStaleConnError(conn, err) {
+		conn, err = pa.pool.connect(server)
+		if err != nil {
+			return "", err
+		}
+		userDN, err = pa.findUserDN(conn, username)
+		conn.release()
+	}
+	return userDN, err
+}
+
+func (pa *PasswordAuthenticator) userExists(username string) (bool, error) {
+	if pa.config.SearchBindDN == "" {
+		return false, errors.New("ldap: user lookup needs a search bind DN")
+	}
+	err := errors.New("no LDAP servers")
+	for _, server := range pa.pool.orderedServers() {
+		var userDN string
+		userDN, err = pa.findUserDNOnServer(server, username)
+		if err != nil {
+			if pa.logger != nil {
+				pa.logger.Debugf(1, "Error looking up LDAP user url= %s",
+					server.url)
+			}
+			continue
+		}
+		return userDN != "", nil
+	}
+	// Users with a cached password hash can still be authenticated.
+	if pa.storage != nil {
+		ok, _, storageErr := pa.storage.GetSigned(username, passwordDataType)
+		if storageErr == nil && ok {
+			return true, nil
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,295 @@
+package ldap
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"gopkg.in/ldap.v2"
+)
+
+const maxIdleTime = 2 * time.Minute
+
+// ldapConn is the part of *ldap.Conn used by the authenticator.
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type dialFunc func(u *url.URL) (ldapConn, error)
+
+type idleConnType struct {
+	conn      ldapConn
+	boundDN   string
+	idleSince time.Time
+}
+
+type serverType struct {
+	url     *url.URL
+	mutex   sync.Mutex // Protect everything below.
+	healthy bool
+	idle    []idleConnType
+}
+
+type connPoolType struct {
+	servers         []*serverType
+	dial            dialFunc
+	maxIdle         int
+	latencyRecorder LatencyRecorder
+	logger          log.DebugLogger
+	stop            chan struct{}
+	stopOnce        sync.Once
+}
+
+type pooledConn struct {
+	conn    ldapConn
+	server  *serverType
+	pool    *connPoolType
+	boundDN string
+	reused  bool
+	failed  bool
+}
+
+func newConnPool(urls []*url.URL, dial dialFunc, maxIdle int,
+	latencyRecorder LatencyRecorder, logger log.DebugLogger) *connPoolType {
+	pool := &connPoolType{
+		dial:            dial,
+		maxIdle:         maxIdle,
+		latencyRecorder: latencyRecorder,
+		logger:          logger,
+		stop:            make(chan struct{}),
+	}
+	for _, u := range urls {
+		pool.servers = append(pool.servers, &serverType{url: u, healthy: true})
+	}
+	return pool
+}
+
+// isConnectionError returns true if err means that the server did not
+// answer, as opposed to answering with an LDAP error.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	ldapErr, ok := err.(*ldap.Error)
+	return !ok || ldapErr.ResultCode == ldap.ErrorNetwork
+}
+
+// isTimeoutError returns true if err is a timeout. gopkg.in/ldap.v2 reports
+// request timeouts as network errors, so they are told apart by the message.
+func isTimeoutError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	ldapErr, ok := err.(*ldap.Error)
+	return ok && ldapErr.ResultCode == ldap.ErrorNetwork &&
+		ldapErr.Err != nil && strings.Contains(ldapErr.Err.Error(), "timed out")
+}
+
+func (p *connPoolType) recordLatency(server *serverType, operation string,
+	start time.Time) {
+	if p.latencyRecorder != nil {
+		p.latencyRecorder(server.url.Host, operation, time.Since(start))
+	}
+}
+
+func (p *connPoolType) debugf(level uint8, format string, v ...interface{}) {
+	if p.logger != nil {
+		p.logger.Debugf(level, format, v...)
+	}
+}
+
+// orderedServers returns the healthy servers followed by the unhealthy
+// ones, each in the configured order.
+func (p *connPoolType) orderedServers() []*serverType {
+	var healthy, unhealthy []*serverType
+	for _, server := range p.servers {
+		server.mutex.Lock()
+		isHealthy := server.healthy
+		server.mutex.Unlock()
+		if isHealthy {
+			healthy = append(healthy, server)
+		} else {
+			unhealthy = append(unhealthy, server)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// get returns the most recently used idle connection to server, or a new
+// one if there are none.
+func (p *connPoolType) get(server *serverType) (*pooledConn, error) {
+	server.mutex.Lock()
+	for len(server.idle) > 0 {
+		last := len(server.idle) - 1
+		idleConn := server.idle[last]
+		server.idle = server.idle[:last]
+		if time.Since(idleConn.idleSince) > maxIdleTime {
+			idleConn.conn.Close()
+			continue
+		}
+		server.mutex.Unlock()
+		return &pooledConn{
+			conn:    idleConn.conn,
+			server:  server,
+			pool:    p,
+			boundDN: idleConn.boundDN,
+			reused:  true,
+		}, nil
+	}
+	server.mutex.Unlock()
+	return p.connect(server)
+}
+
+// connect returns a new connection to server.
+func (p *connPoolType) connect(server *serverType) (*pooledConn, error) {
+	start := time.Now()
+	conn, err := p.dial(server.url)
+	if err != nil {
+		p.debugf(1, "Error connecting to LDAP server %s: %s", server.url, err)
+		server.setHealthy(false)
+		return nil, err
+	}
+	p.recordLatency(server, "connect", start)
+	return &pooledConn{conn: conn, server: server, pool: p}, nil
+}
+
+// put returns c to the pool of its server, or closes it if it failed or
+// the pool is full or closed.
+func (p *connPoolType) put(c *pooledConn) {
+	server := c.server
+	server.mutex.Lock()
+	server.healthy = !c.failed
+	if !c.failed && !p.isClosed() && len(server.idle) < p.maxIdle {
+		server.idle = append(server.idle, idleConnType{
+			conn:      c.conn,
+			boundDN:   c.boundDN,
+			idleSince: time.Now(),
+		})
+		server.mutex.Unlock()
+		return
+	}
+	server.mutex.Unlock()
+	c.conn.Close()
+}
+
+func (server *serverType) setHealthy(healthy bool) {
+	server.mutex.Lock()
+	server.healthy = healthy
+	server.mutex.Unlock()
+}
+
+func (c *pooledConn) release() {
+	c.pool.put(c)
+}
+
+func (c *pooledConn) bind(dn, password string) error {
+	start := time.Now()
+	err := c.conn.Bind(dn, password)
+	if isConnectionError(err) {
+		c.failed = true
+		return err
+	}
+	c.pool.recordLatency(c.server, "bind", start)
+	if err != nil {
+		// A failed bind leaves the connection anonymous.
+		c.boundDN = ""
+		return err
+	}
+	c.boundDN = dn
+	return nil
+}
+
+func (c *pooledConn) search(request *ldap.SearchRequest) (
+	*ldap.SearchResult, error) {
+	start := time.Now()
+	result, err := c.conn.Search(request)
+	if isConnectionError(err) {
+		c.failed = true
+		return nil, err
+	}
+	c.pool.recordLatency(c.server, "search", start)
+	return result, err
+}
+
+// checkHealth checks every idle connection of server with a search of the
+// root DSE, dropping the connections which fail. If there are no idle
+// connections a new one is checked, so that servers marked unhealthy are
+// brought back once they answer again.
+func (p *connPoolType) checkHealth(server *serverType) {
+	server.mutex.Lock()
+	idle := server.idle
+	server.idle = nil
+	server.mutex.Unlock()
+	var conns []*pooledConn
+	for _, idleConn := range idle {
+		conns = append(conns, &pooledConn{
+			conn:    idleConn.conn,
+			server:  server,
+			pool:    p,
+			boundDN: idleConn.boundDN,
+			reused:  true,
+		})
+	}
+	if len(conns) < 1 {
+		conn, err := p.connect(server)
+		if err != nil {
+			return
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		// Any answer from the server, even an error, means it is up.
+		_, err := conn.search(ldap.NewSearchRequest("",
+			ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{"1.1"}, nil))
+		if err != nil && conn.failed {
+			p.debugf(1, "LDAP server %s failed health check: %s",
+				server.url, err)
+		}
+		conn.release()
+	}
+}
+
+// healthCheckLoop checks the servers every interval until close is called.
+func (p *connPoolType) healthCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, server := range p.servers {
+				p.checkHealth(server)
+			}
+		}
+	}
+}
+
+func (p *connPoolType) isClosed() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the health checks and closes the idle connections.
+func (p *connPoolType) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	for _, server := range p.servers {
+		server.mutex.Lock()
+		idle := server.idle
+		server.idle = nil
+		server.mutex.Unlock()
+		for _, idleConn := range idle {
+			idleConn.conn.Close()
+		}
+	}
+}
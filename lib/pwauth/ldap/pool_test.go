@@ -0,0 +1,378 @@
+package ldap
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	testServiceDN                = "cn=keymaster,ou=services,dc=example,dc=com"
+	testServicePassword          = "service-password"
+	testPeopleBaseDN             = "ou=people,dc=example,dc=com"
+	testInsufficientAccessRights = 50
+)
+
+var errTestConnectionClosed = &ldap.Error{
+	ResultCode: ldap.ErrorNetwork,
+	Err:        errors.New("ldap: connection closed"),
+}
+
+var errTestTimeout = &ldap.Error{
+	ResultCode: ldap.ErrorNetwork,
+	Err:        errors.New("ldap: connection timed out"),
+}
+
+// testDirectory stands in for an LDAP server. Users are found with the
+// filter "(uid=<uid>)".
+type testDirectory struct {
+	mutex     sync.Mutex
+	down      bool
+	timeout   bool
+	dials     int
+	passwords map[string]string // Key: DN.
+	conns     []*testConn
+}
+
+type testConn struct {
+	directory *testDirectory
+	boundDN   string
+	closed    bool
+}
+
+func newTestDirectory() *testDirectory {
+	return &testDirectory{passwords: map[string]string{
+		testServiceDN:                         testServicePassword,
+		"uid=alice," + testPeopleBaseDN:       "alice-password",
+		"uid=bob,ou=admins,dc=example,dc=com": "bob-password",
+		"uid=twin,ou=a," + testPeopleBaseDN:   "twin-password",
+		"uid=twin,ou=b," + testPeopleBaseDN:   "twin-password",
+	}}
+}
+
+func (d *testDirectory) dial() (ldapConn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	d.dials++
+	conn := &testConn{directory: d}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *testDirectory) setDown(down bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.down = down
+	if down {
+		for _, conn := range d.conns {
+			conn.closed = true
+		}
+	}
+}
+
+func (d *testDirectory) numDials() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.dials
+}
+
+func (c *testConn) Bind(username, password string) error {
+	d := c.directory
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if c.closed {
+		return errTestConnectionClosed
+	}
+	if d.timeout {
+		return errTestTimeout
+	}
+	if expected, ok := d.passwords[username]; ok && password == expected {
+		c.boundDN = username
+		return nil
+	}
+	c.boundDN = ""
+	return &ldap.Error{
+		ResultCode: ldap.LDAPResultInvalidCredentials,
+		Err:        errors.New("invalid credentials"),
+	}
+}
+
+func (c *testConn) Search(request *ldap.SearchRequest) (
+	*ldap.SearchResult, error) {
+	d := c.directory
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if c.closed {
+		return nil, errTestConnectionClosed
+	}
+	if request.BaseDN == "" {
+		return &ldap.SearchResult{}, nil
+	}
+	if c.boundDN != testServiceDN {
+		return nil, &ldap.Error{
+			ResultCode: testInsufficientAccessRights,
+			Err:        errors.New("insufficient access rights"),
+		}
+	}
+	baseExists := false
+	var result ldap.SearchResult
+	for dn := range d.passwords {
+		if !strings.HasSuffix(dn, ","+request.BaseDN) {
+			continue
+		}
+		baseExists = true
+		uid := strings.TrimPrefix(strings.SplitN(dn, ",", 2)[0], "uid=")
+		if matchTestFilter(request.Filter, uid) {
+			result.Entries = append(result.Entries, &ldap.Entry{DN: dn})
+		}
+	}
+	if !baseExists {
+		return nil, &ldap.Error{
+			ResultCode: ldap.LDAPResultNoSuchObject,
+			Err:        errors.New("no such object"),
+		}
+	}
+	return &result, nil
+}
+
+// matchTestFilter matches filters of the form (uid=value), where value may
+// end with a * wildcard.
+func matchTestFilter(filter string, uid string) bool {
+	if !strings.HasPrefix(filter, "(uid=") || !strings.HasSuffix(filter, ")") {
+		return false
+	}
+	value := filter[len("(uid=") : len(filter)-1]
+	if strings.HasSuffix(value, "*") {
+		return strings.HasPrefix(uid, strings.TrimSuffix(value, "*"))
+	}
+	return value == uid
+}
+
+func (c *testConn) Close() {
+	c.directory.mutex.Lock()
+	c.closed = true
+	c.directory.mutex.Unlock()
+}
+
+type testLatencyRecorder struct {
+	mutex      sync.Mutex
+	operations map[string]int // Key: server/operation.
+}
+
+func (r *testLatencyRecorder) record(server string, operation string,
+	duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.operations == nil {
+		r.operations = make(map[string]int)
+	}
+	r.operations[server+"/"+operation]++
+}
+
+func (r *testLatencyRecorder) count(server, operation string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.operations[server+"/"+operation]
+}
+
+// newTestAuthenticator returns an authenticator for the directories, which
+// are served as ldaps://server0, ldaps://server1...
+func newTestAuthenticator(t *testing.T, bindPattern []string, config Config,
+	directories ...*testDirectory) *PasswordAuthenticator {
+	var urls []string
+	byHost := make(map[string]*testDirectory)
+	for i, directory := range directories {
+		host := "server" + string('0'+rune(i))
+		urls = append(urls, "ldaps://"+host)
+		byHost[host] = directory
+	}
+	pa, err := newAuthenticatorWithConfig(urls, bindPattern, config, 1, nil,
+		nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa.pool.dial = func(u *url.URL) (ldapConn, error) {
+		return byHost[u.Host].dial()
+	}
+	return pa
+}
+
+func checkPasswordAuthenticate(t *testing.T, pa *PasswordAuthenticator,
+	username, password string, expected bool) {
+	valid, err := pa.passwordAuthenticate(username, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid != expected {
+		t.Fatalf("%s/%q: valid=%v, expected %v", username, password, valid,
+			expected)
+	}
+}
+
+func TestSearchThenBind(t *testing.T) {
+	directory := newTestDirectory()
+	recorder := &testLatencyRecorder{}
+	pa := newTestAuthenticator(t, nil, Config{
+		SearchBindDN:       testServiceDN,
+		SearchBindPassword: testServicePassword,
+		SearchBaseDNs: []string{"ou=missing,dc=example,dc=com",
+			testPeopleBaseDN},
+		LatencyRecorder: recorder.record,
+	}, directory)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	checkPasswordAuthenticate(t, pa, "alice", "bad-password", false)
+	checkPasswordAuthenticate(t, pa, "alice", "", false)
+	// Outside of the search bases.
+	checkPasswordAuthenticate(t, pa, "bob", "bob-password", false)
+	checkPasswordAuthenticate(t, pa, "nobody", "alice-password", false)
+	// The username is escaped in the filter.
+	checkPasswordAuthenticate(t, pa, "ali*", "alice-password", false)
+	// Ambiguous usernames are rejected.
+	checkPasswordAuthenticate(t, pa, "twin", "twin-password", false)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	if dials := directory.numDials(); dials != 1 {
+		t.Fatalf("%d connections made, expected 1", dials)
+	}
+	for _, operation := range []string{"connect", "bind", "search"} {
+		if recorder.count("server0", operation) < 1 {
+			t.Errorf("no latency recorded for %s", operation)
+		}
+	}
+}
+
+func TestNewWithConfigNoSearchBase(t *testing.T) {
+	_, err := NewWithConfig([]string{"ldaps://server0"}, nil,
+		Config{SearchBindDN: testServiceDN}, 1, nil, nil, nil)
+	if err == nil {
+		t.Fatal("search bind DN accepted without search base DNs")
+	}
+}
+
+func TestBindPatternFailover(t *testing.T) {
+	directories := []*testDirectory{newTestDirectory(), newTestDirectory()}
+	pa := newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, directories...)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	checkPasswordAuthenticate(t, pa, "alice", "bob-password", false)
+	directories[0].setDown(true)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	servers := pa.pool.orderedServers()
+	if servers[0].url.Host != "server1" {
+		t.Fatalf("failed server still tried first: %s", servers[0].url)
+	}
+	directories[0].setDown(false)
+	pa.pool.checkHealth(pa.pool.servers[0])
+	servers = pa.pool.orderedServers()
+	if servers[0].url.Host != "server0" {
+		t.Fatalf("recovered server not tried first: %s", servers[0].url)
+	}
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+}
+
+func TestStaleIdleConnection(t *testing.T) {
+	directory := newTestDirectory()
+	pa := newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, directory)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	// The server closes the idle connection.
+	directory.mutex.Lock()
+	for _, conn := range directory.conns {
+		conn.closed = true
+	}
+	directory.mutex.Unlock()
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	if dials := directory.numDials(); dials != 2 {
+		t.Fatalf("%d connections made, expected 2", dials)
+	}
+}
+
+func TestUserExists(t *testing.T) {
+	directory := newTestDirectory()
+	pa := newTestAuthenticator(t, nil, Config{
+		SearchBindDN:       testServiceDN,
+		SearchBindPassword: testServicePassword,
+		SearchBaseDNs:      []string{testPeopleBaseDN},
+	}, directory)
+	for username, expected := range map[string]bool{
+		"alice":  true,
+		"bob":    false,
+		"nobody": false,
+		"ali*":   false,
+	} {
+		exists, err := pa.UserExists(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Errorf("%s: exists=%v, expected %v", username, exists, expected)
+		}
+	}
+	if _, err := pa.UserExists("twin"); err == nil {
+		t.Error("ambiguous user looked up")
+	}
+	directory.setDown(true)
+	if _, err := pa.UserExists("alice"); err == nil {
+		t.Error("no error with the server down")
+	}
+	pa = newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, newTestDirectory())
+	if _, err := pa.UserExists("alice"); err == nil {
+		t.Error("user looked up without a search bind DN")
+	}
+}
+
+func TestEmptyPassword(t *testing.T) {
+	directory := newTestDirectory()
+	pa := newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, directory)
+	checkPasswordAuthenticate(t, pa, "alice", "", false)
+	if dials := directory.numDials(); dials != 0 {
+		t.Fatalf("%d connections made, expected 0", dials)
+	}
+}
+
+func TestTimeoutNotRetried(t *testing.T) {
+	directory := newTestDirectory()
+	pa := newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, directory)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	directory.mutex.Lock()
+	directory.timeout = true
+	directory.mutex.Unlock()
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", false)
+	if dials := directory.numDials(); dials != 1 {
+		t.Fatalf("%d connections made, expected 1", dials)
+	}
+}
+
+func TestClose(t *testing.T) {
+	directory := newTestDirectory()
+	pa := newTestAuthenticator(t, []string{"uid=%s," + testPeopleBaseDN},
+		Config{}, directory)
+	go pa.pool.healthCheckLoop(time.Millisecond)
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	if err := pa.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Connections in use when closing are not kept.
+	checkPasswordAuthenticate(t, pa, "alice", "alice-password", true)
+	time.Sleep(10 * time.Millisecond)
+	directory.mutex.Lock()
+	defer directory.mutex.Unlock()
+	for _, conn := range directory.conns {
+		if !conn.closed {
+			t.Fatal("connection left open")
+		}
+	}
+	if err := pa.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
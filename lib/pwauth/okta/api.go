@@ -1,18 +1,32 @@
 package okta
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/Symantec/Dominator/lib/log"
 	"github.com/Symantec/keymaster/lib/simplestorage"
 )
 
+var (
+	// ErrPushRejected is returned by UserPushApproved when the user
+	// rejected the push.
+	ErrPushRejected = errors.New("okta: push rejected")
+	// ErrPushTimeout is returned by UserPushApproved when the push expired.
+	ErrPushTimeout = errors.New("okta: push timed out")
+)
+
 type PasswordAuthenticator struct {
-	authnURL string
-	logger   log.Logger
+	authnURL   string
+	logger     log.Logger
+	mutex      sync.Mutex // Protect everything below.
+	pendingMFA map[string]pendingMFAType
 }
 
 // New creates a new PasswordAuthenticator using Okta as the backend. The Okta
 // Public Application API is used, so rate limits apply.
-// The Okta domain to check must be given by oktaDomain.
+// The Okta domain to check must be given by oktaDomain, either as the
+// subdomain of okta.com or as a URL such as https://example.oktapreview.com.
 // Log messages are written to logger. A new *PasswordAuthenticator is returned.
 func NewPublic(oktaDomain string, logger log.Logger) (
 	*PasswordAuthenticator, error) {
@@ -23,6 +37,9 @@ func NewPublic(oktaDomain string, logger log.Logger) (
 // password.
 // It returns true if the user is authenticated, else false (due to either
 // invalid username or incorrect password), and an error.
+// When Okta requires MFA the user is authenticated and the Okta transaction
+// is kept for a few minutes, so that the factors can be verified with
+// ValidateUserOTP and StartUserPush.
 func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 	password []byte) (bool, error) {
 	return pa.passwordAuthenticate(username, password)
@@ -31,3 +48,50 @@ func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
 func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
 	return nil
 }
+
+// UserHasMFA returns whether the last password authentication of username
+// requires MFA, is still pending and offers a factor supported here.
+func (pa *PasswordAuthenticator) UserHasMFA(username string) bool {
+	return pa.userHasMFA(username)
+}
+
+// ValidateUserOTP verifies otpValue against the Okta TOTP factors of
+// username. It returns false if the value is wrong or if no Okta
+// transaction is pending for username.
+func (pa *PasswordAuthenticator) ValidateUserOTP(username string,
+	otpValue string) (bool, error) {
+	return pa.validateUserOTP(username, otpValue)
+}
+
+// StartUserPush sends an Okta Verify push to username. The result is
+// polled with UserPushApproved.
+func (pa *PasswordAuthenticator) StartUserPush(username string) error {
+	return pa.startUserPush(username)
+}
+
+// UserPushApproved returns whether username approved the push sent by
+// StartUserPush. It returns false while the push is waiting, and
+// ErrPushRejected or ErrPushTimeout once it failed.
+func (pa *PasswordAuthenticator) UserPushApproved(username string) (
+	bool, error) {
+	return pa.userPushApproved(username)
+}
+
+// UserInfo looks up users with the Okta Users API, which needs an API token.
+type UserInfo struct {
+	baseURL  string
+	apiToken string
+	logger   log.Logger
+}
+
+// NewUserInfo creates a new UserInfo for the Okta domain oktaDomain (as for
+// NewPublic) using the API token apiToken. Log messages are written to logger.
+func NewUserInfo(oktaDomain string, apiToken string, logger log.Logger) (
+	*UserInfo, error) {
+	return newUserInfo(oktaDomain, apiToken, logger)
+}
+
+// GetUserGroups returns the names of the Okta groups of username.
+func (ui *UserInfo) GetUserGroups(username string) ([]string, error) {
+	return ui.getUserGroups(username)
+}
@@ -3,49 +3,136 @@ package okta
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/Symantec/Dominator/lib/log"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
 )
 
 const (
-	authPath           = "/api/v1/authn"
-	authEndpointFormat = "https://%s.okta.com" + authPath
+	authPath             = "/api/v1/authn"
+	baseURLFormat        = "https://%s.okta.com"
+	userGroupsPathFormat = "/api/v1/users/%s/groups"
+	userGroupsPageSize   = 200
+	userGroupsMaxPages   = 50
+	defaultMFALifetime   = 5 * time.Minute
+	factorTypePush       = "push"
+	factorTypeTOTP       = "token:software:totp"
+	requestTimeout       = 10 * time.Second
 )
 
+// httpClient is used for all the requests to Okta, so that a slow Okta
+// cannot hang logins or group lookups.
+var httpClient = &http.Client{Timeout: requestTimeout}
+
 type loginDataType struct {
 	Password string `json:"password,omitempty"`
 	Username string `json:"username,omitempty"`
 }
 
+type verifyDataType struct {
+	StateToken string `json:"stateToken,omitempty"`
+	PassCode   string `json:"passCode,omitempty"`
+}
+
+type linkType struct {
+	Href string `json:"href,omitempty"`
+}
+
+type factorType struct {
+	ID         string `json:"id,omitempty"`
+	FactorType string `json:"factorType,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Links      struct {
+		Verify linkType `json:"verify"`
+	} `json:"_links"`
+}
+
 type responseType struct {
-	Status string `json:"status,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	StateToken   string    `json:"stateToken,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	FactorResult string    `json:"factorResult,omitempty"`
+	Embedded     struct {
+		Factors []factorType `json:"factors,omitempty"`
+	} `json:"_embedded"`
+	Links struct {
+		Next linkType `json:"next"`
+	} `json:"_links"`
+}
+
+type groupType struct {
+	Profile struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+}
+
+type pendingMFAType struct {
+	expiresAt  time.Time
+	stateToken string
+	factors    []factorType
+	pushURL    string
+}
+
+// getBaseURL returns the URL of the Okta domain oktaDomain, which may
+// already be a URL (for preview or custom domains).
+func getBaseURL(oktaDomain string) string {
+	if strings.Contains(oktaDomain, "://") {
+		return strings.TrimSuffix(oktaDomain, "/")
+	}
+	return fmt.Sprintf(baseURLFormat, oktaDomain)
 }
 
 func newPublicAuthenticator(oktaDomain string, logger log.Logger) (
 	*PasswordAuthenticator, error) {
 	return &PasswordAuthenticator{
-		authnURL: fmt.Sprintf(authEndpointFormat, oktaDomain),
+		authnURL: getBaseURL(oktaDomain) + authPath,
 		logger:   logger,
 	}, nil
 }
 
-func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
-	password []byte) (bool, error) {
-	loginData := loginDataType{Password: string(password), Username: username}
+// postJSON posts data to url. The response body is decoded into response
+// if the status is 200.
+func postJSON(url string, data interface{}, response *responseType) (
+	*http.Response, error) {
 	body := &bytes.Buffer{}
 	encoder := json.NewEncoder(body)
 	encoder.SetIndent("", "    ") // Make life easier for debugging.
-	if err := encoder.Encode(loginData); err != nil {
-		return false, err
+	if err := encoder.Encode(data); err != nil {
+		return nil, err
 	}
-	req, err := http.NewRequest("POST", pa.authnURL, body)
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return resp, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	loginData := loginDataType{Password: string(password), Username: username}
+	var response responseType
+	resp, err := postJSON(pa.authnURL, loginData, &response)
 	if err != nil {
 		return false, err
 	}
@@ -55,15 +142,265 @@ func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
 	if resp.StatusCode != http.StatusOK {
 		return false, fmt.Errorf("bad status: %s", resp.Status)
 	}
-	decoder := json.NewDecoder(resp.Body)
+	switch response.Status {
+	case "SUCCESS":
+		pa.deletePendingMFA(username)
+		return true, nil
+	case "MFA_REQUIRED":
+		pa.setPendingMFA(username, response)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (pa *PasswordAuthenticator) setPendingMFA(username string,
+	response responseType) {
+	pending := pendingMFAType{
+		expiresAt:  response.ExpiresAt,
+		stateToken: response.StateToken,
+	}
+	if pending.expiresAt.IsZero() {
+		pending.expiresAt = time.Now().Add(defaultMFALifetime)
+	}
+	for _, factor := range response.Embedded.Factors {
+		if factor.Links.Verify.Href == "" {
+			continue
+		}
+		switch {
+		case factor.FactorType == factorTypePush && factor.Provider == "OKTA":
+		case factor.FactorType == factorTypeTOTP:
+		default:
+			continue
+		}
+		pending.factors = append(pending.factors, factor)
+	}
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	if pa.pendingMFA == nil {
+		pa.pendingMFA = make(map[string]pendingMFAType)
+	}
+	pa.pendingMFA[username] = pending
+}
+
+func (pa *PasswordAuthenticator) getPendingMFA(username string) (
+	pendingMFAType, bool) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	pending, ok := pa.pendingMFA[username]
+	if ok && time.Now().After(pending.expiresAt) {
+		delete(pa.pendingMFA, username)
+		return pendingMFAType{}, false
+	}
+	return pending, ok
+}
+
+func (pa *PasswordAuthenticator) deletePendingMFA(username string) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	delete(pa.pendingMFA, username)
+}
+
+func (pa *PasswordAuthenticator) userHasMFA(username string) bool {
+	pending, ok := pa.getPendingMFA(username)
+	return ok && len(pending.factors) > 0
+}
+
+func (pa *PasswordAuthenticator) validateUserOTP(username string,
+	otpValue string) (bool, error) {
+	pending, ok := pa.getPendingMFA(username)
+	if !ok {
+		return false, nil
+	}
+	for _, factor := range pending.factors {
+		if factor.FactorType != factorTypeTOTP {
+			continue
+		}
+		verifyData := verifyDataType{
+			StateToken: pending.stateToken,
+			PassCode:   otpValue,
+		}
+		var response responseType
+		resp, err := postJSON(factor.Links.Verify.Href, verifyData, &response)
+		if err != nil {
+			return false, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusForbidden: // Wrong passcode for this factor.
+			continue
+		default:
+			return false, fmt.Errorf("bad status: %s", resp.Status)
+		}
+		if response.Status == "SUCCESS" {
+			pa.deletePendingMFA(username)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (pa *PasswordAuthenticator) startUserPush(username string) error {
+	pending, ok := pa.getPendingMFA(username)
+	if !ok {
+		return errors.New("okta: no pending authentication")
+	}
+	for _, factor := range pending.factors {
+		if factor.FactorType != factorTypePush {
+			continue
+		}
+		verifyData := verifyDataType{StateToken: pending.stateToken}
+		var response responseType
+		resp, err := postJSON(factor.Links.Verify.Href, verifyData, &response)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("bad status: %s", resp.Status)
+		}
+		pushURL := response.Links.Next.Href
+		if pushURL == "" {
+			pushURL = factor.Links.Verify.Href
+		}
+		pa.mutex.Lock()
+		defer pa.mutex.Unlock()
+		if pending, ok := pa.pendingMFA[username]; ok {
+			pending.pushURL = pushURL
+			pa.pendingMFA[username] = pending
+		}
+		return nil
+	}
+	return errors.New("okta: no push factor")
+}
+
+func (pa *PasswordAuthenticator) userPushApproved(username string) (
+	bool, error) {
+	pending, ok := pa.getPendingMFA(username)
+	if !ok || pending.pushURL == "" {
+		return false, ErrPushTimeout
+	}
+	verifyData := verifyDataType{StateToken: pending.stateToken}
 	var response responseType
-	if err := decoder.Decode(&response); err != nil {
+	resp, err := postJSON(pending.pushURL, verifyData, &response)
+	if err != nil {
 		return false, err
 	}
-	switch response.Status {
-	case "SUCCESS", "MFA_REQUIRED":
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	if response.Status == "SUCCESS" {
+		pa.deletePendingMFA(username)
 		return true, nil
-	default:
+	}
+	switch response.FactorResult {
+	case "WAITING":
 		return false, nil
+	case "REJECTED":
+		pa.deletePendingMFA(username)
+		return false, ErrPushRejected
+	case "TIMEOUT":
+		pa.deletePendingMFA(username)
+		return false, ErrPushTimeout
+	default:
+		return false, fmt.Errorf("okta: unexpected push result: %s/%s",
+			response.Status, response.FactorResult)
+	}
+}
+
+func newUserInfo(oktaDomain string, apiToken string, logger log.Logger) (
+	*UserInfo, error) {
+	if apiToken == "" {
+		return nil, errors.New("okta: empty API token")
+	}
+	return &UserInfo{
+		baseURL:  getBaseURL(oktaDomain),
+		apiToken: apiToken,
+		logger:   logger,
+	}, nil
+}
+
+// getNextLink returns the URL of the next page given in the Link headers.
+func getNextLink(header http.Header) string {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			fields := strings.Split(link, ";")
+			if len(fields) < 2 {
+				continue
+			}
+			for _, param := range fields[1:] {
+				if strings.TrimSpace(param) != `rel="next"` {
+					continue
+				}
+				target := strings.TrimSpace(fields[0])
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
+
+// checkNextURL returns an error if the API token must not be sent to
+// nextURL, which must be on the Okta domain and not already visited.
+func (ui *UserInfo) checkNextURL(nextURL string,
+	visited map[string]struct{}) error {
+	if _, ok := visited[nextURL]; ok {
+		return fmt.Errorf("okta: repeated next link: %s", nextURL)
+	}
+	if len(visited) >= userGroupsMaxPages {
+		return fmt.Errorf("okta: more than %d pages of groups",
+			userGroupsMaxPages)
+	}
+	parsedURL, err := url.Parse(nextURL)
+	if err != nil {
+		return err
+	}
+	baseURL, err := url.Parse(ui.baseURL)
+	if err != nil {
+		return err
+	}
+	if parsedURL.Scheme != baseURL.Scheme || parsedURL.Host != baseURL.Host {
+		return fmt.Errorf("okta: next link not on %s: %s", ui.baseURL,
+			nextURL)
+	}
+	return nil
+}
+
+func (ui *UserInfo) getUserGroups(username string) ([]string, error) {
+	nextURL := fmt.Sprintf("%s"+userGroupsPathFormat+"?limit=%d",
+		ui.baseURL, url.PathEscape(username), userGroupsPageSize)
+	var groupNames []string
+	visited := make(map[string]struct{})
+	for nextURL != "" {
+		if err := ui.checkNextURL(nextURL, visited); err != nil {
+			return nil, err
+		}
+		visited[nextURL] = struct{}{}
+		req, err := http.NewRequest("GET", nextURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Accept", "application/json")
+		req.Header.Add("Authorization", "SSWS "+ui.apiToken)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("okta: cannot get groups of %s: %s",
+				username, resp.Status)
+		}
+		var groups []groupType
+		err = json.NewDecoder(resp.Body).Decode(&groups)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			groupNames = append(groupNames, group.Profile.Name)
+		}
+		nextURL = getNextLink(resp.Header)
 	}
+	return groupNames, nil
 }
@@ -4,11 +4,26 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-var authnURL string
+const (
+	pushVerifyPath = "/api/v1/authn/factors/push/verify"
+	totpVerifyPath = "/api/v1/authn/factors/totp/verify"
+	testStateToken = "a-state-token"
+	testAPIToken   = "an-api-token"
+)
+
+var (
+	authnURL   string
+	baseURL    string
+	pushResult string
+)
 
 func authnHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
@@ -30,7 +45,7 @@ func authnHandler(w http.ResponseWriter, req *http.Request) {
 		writeStatus(w, "SUCCESS")
 		return
 	case "needs-2FA":
-		writeStatus(w, "MFA_REQUIRED")
+		writeMfaRequired(w)
 		return
 	case "password-expired":
 		writeStatus(w, "PASSWORD_EXPIRED")
@@ -49,9 +64,13 @@ func setupServer() {
 		panic(err)
 	} else {
 		addr := listener.Addr().String()
-		authnURL = "http://" + addr + authPath
+		baseURL = "http://" + addr
+		authnURL = baseURL + authPath
 		serveMux := http.NewServeMux()
 		serveMux.HandleFunc(authPath, authnHandler)
+		serveMux.HandleFunc(pushVerifyPath, pushVerifyHandler)
+		serveMux.HandleFunc(totpVerifyPath, totpVerifyHandler)
+		serveMux.HandleFunc("/api/v1/users/a-user/groups", groupsHandler)
 		go http.Serve(listener, serveMux)
 		for {
 			if conn, err := net.Dial("tcp", addr); err == nil {
@@ -65,13 +84,89 @@ func setupServer() {
 }
 
 func writeStatus(w http.ResponseWriter, status string) {
+	writeResponse(w, responseType{Status: status})
+}
+
+func writeResponse(w http.ResponseWriter, response responseType) {
 	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "    ") // Make life easier for debugging.
-	if err := encoder.Encode(responseType{status}); err != nil {
+	if err := encoder.Encode(response); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+func writeMfaRequired(w http.ResponseWriter) {
+	response := responseType{Status: "MFA_REQUIRED", StateToken: testStateToken}
+	var push, totp, sms factorType
+	push.FactorType = factorTypePush
+	push.Provider = "OKTA"
+	push.Links.Verify.Href = baseURL + pushVerifyPath
+	totp.FactorType = factorTypeTOTP
+	totp.Provider = "GOOGLE"
+	totp.Links.Verify.Href = baseURL + totpVerifyPath
+	sms.FactorType = "sms"
+	sms.Provider = "OKTA"
+	sms.Links.Verify.Href = baseURL + "/api/v1/authn/factors/sms/verify"
+	response.Embedded.Factors = []factorType{sms, push, totp}
+	writeResponse(w, response)
+}
+
+func decodeVerifyData(w http.ResponseWriter, req *http.Request) (
+	verifyDataType, bool) {
+	var verifyData verifyDataType
+	if err := json.NewDecoder(req.Body).Decode(&verifyData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return verifyData, false
+	}
+	if verifyData.StateToken != testStateToken {
+		w.WriteHeader(http.StatusForbidden)
+		return verifyData, false
+	}
+	return verifyData, true
+}
+
+func totpVerifyHandler(w http.ResponseWriter, req *http.Request) {
+	verifyData, ok := decodeVerifyData(w, req)
+	if !ok {
+		return
+	}
+	if verifyData.PassCode != "123456" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	writeStatus(w, "SUCCESS")
+}
+
+func pushVerifyHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := decodeVerifyData(w, req); !ok {
+		return
+	}
+	if pushResult == "SUCCESS" {
+		writeStatus(w, "SUCCESS")
+		return
+	}
+	response := responseType{Status: "MFA_CHALLENGE", FactorResult: pushResult}
+	response.Links.Next.Href = baseURL + pushVerifyPath
+	writeResponse(w, response)
+}
+
+func groupsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "SSWS "+testAPIToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var group groupType
+	if req.URL.Query().Get("after") == "" {
+		w.Header().Set("Link", "<"+baseURL+req.URL.Path+
+			"?limit=200>; rel=\"self\", <"+baseURL+req.URL.Path+
+			"?after=1&limit=200>; rel=\"next\"")
+		group.Profile.Name = "group-1"
+	} else {
+		group.Profile.Name = "group-2"
+	}
+	json.NewEncoder(w).Encode([]groupType{group})
+}
+
 func TestNonExistantUser(t *testing.T) {
 	setupServer()
 	pa := &PasswordAuthenticator{authnURL: authnURL}
@@ -126,3 +221,169 @@ func TestUserLockedOut(t *testing.T) {
 		t.Fatalf("expired password suceeded")
 	}
 }
+
+func TestMfaTOTP(t *testing.T) {
+	setupServer()
+	pa := &PasswordAuthenticator{authnURL: authnURL}
+	if ok, err := pa.ValidateUserOTP("a-user", "123456"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("OTP accepted without pending authentication")
+	}
+	ok, err := pa.PasswordAuthenticate("a-user", []byte("needs-2FA"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("good password needing 2FA failed")
+	}
+	if !pa.UserHasMFA("a-user") {
+		t.Fatal("MFA not pending")
+	}
+	if ok, err := pa.ValidateUserOTP("a-user", "654321"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("bad OTP accepted")
+	}
+	if ok, err := pa.ValidateUserOTP("a-user", "123456"); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("good OTP rejected")
+	}
+	if pa.UserHasMFA("a-user") {
+		t.Fatal("MFA still pending after success")
+	}
+}
+
+func TestMfaPush(t *testing.T) {
+	setupServer()
+	pa := &PasswordAuthenticator{authnURL: authnURL}
+	if err := pa.StartUserPush("a-user"); err == nil {
+		t.Fatal("push started without pending authentication")
+	}
+	for _, test := range []struct {
+		result string
+		err    error
+	}{
+		{"REJECTED", ErrPushRejected},
+		{"TIMEOUT", ErrPushTimeout},
+		{"SUCCESS", nil},
+	} {
+		if _, err := pa.PasswordAuthenticate("a-user",
+			[]byte("needs-2FA")); err != nil {
+			t.Fatal(err)
+		}
+		pushResult = "WAITING"
+		if err := pa.StartUserPush("a-user"); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := pa.UserPushApproved("a-user"); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("waiting push approved")
+		}
+		pushResult = test.result
+		ok, err := pa.UserPushApproved("a-user")
+		if err != test.err {
+			t.Fatalf("%s: got error %v, want %v", test.result, err, test.err)
+		}
+		if ok != (test.err == nil) {
+			t.Fatalf("%s: approved=%v", test.result, ok)
+		}
+		if pa.UserHasMFA("a-user") {
+			t.Fatalf("%s: MFA still pending", test.result)
+		}
+	}
+}
+
+func TestGetUserGroups(t *testing.T) {
+	setupServer()
+	ui := &UserInfo{baseURL: baseURL, apiToken: testAPIToken}
+	groups, err := ui.GetUserGroups("a-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, []string{"group-1", "group-2"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+	if _, err := ui.GetUserGroups("bad-user"); err == nil {
+		t.Fatal("groups of unknown user returned")
+	}
+	ui.apiToken = "bad-token"
+	if _, err := ui.GetUserGroups("a-user"); err == nil {
+		t.Fatal("bad API token accepted")
+	}
+}
+
+func TestGetUserGroupsBadNextLink(t *testing.T) {
+	var requests int32
+	otherHost := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte("[]"))
+		}))
+	defer otherHost.Close()
+	var nextLink string
+	var pages int32
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			link := nextLink
+			if link == "" {
+				// A new page every time.
+				link = "http://" + req.Host +
+					"/api/v1/users/a-user/groups?after=" +
+					strconv.Itoa(int(atomic.AddInt32(&pages, 1)))
+			}
+			w.Header().Add("Link", "<"+link+">; rel=\"next\"")
+			w.Write([]byte(`[{"profile":{"name":"group-1"}}]`))
+		}))
+	defer server.Close()
+	ui := &UserInfo{baseURL: server.URL, apiToken: testAPIToken}
+	nextLink = otherHost.URL + "/api/v1/users/a-user/groups?after=1"
+	if _, err := ui.GetUserGroups("a-user"); err == nil {
+		t.Fatal("next link to another host followed")
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("API token sent to another host")
+	}
+	nextLink = server.URL + "/api/v1/users/a-user/groups?after=1"
+	if _, err := ui.GetUserGroups("a-user"); err == nil {
+		t.Fatal("repeated next link followed")
+	}
+	nextLink = ""
+	if _, err := ui.GetUserGroups("a-user"); err == nil {
+		t.Fatal("endless next links followed")
+	}
+	if pages != userGroupsMaxPages {
+		t.Fatalf("%d pages fetched, expected %d", pages, userGroupsMaxPages)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(time.Second)
+		}))
+	defer server.Close()
+	defer func(timeout time.Duration) { httpClient.Timeout = timeout }(
+		httpClient.Timeout)
+	httpClient.Timeout = 100 * time.Millisecond
+	ui := &UserInfo{baseURL: server.URL, apiToken: testAPIToken}
+	if _, err := ui.GetUserGroups("a-user"); err == nil {
+		t.Fatal("no error from hung group lookup")
+	}
+	pa := &PasswordAuthenticator{authnURL: server.URL + authPath}
+	if _, err := pa.PasswordAuthenticate("a-user",
+		[]byte("good-password")); err == nil {
+		t.Fatal("no error from hung authentication")
+	}
+}
+
+func TestGetBaseURL(t *testing.T) {
+	if url := getBaseURL("example"); url != "https://example.okta.com" {
+		t.Fatalf("bad URL: %s", url)
+	}
+	url := getBaseURL("https://example.oktapreview.com/")
+	if url != "https://example.oktapreview.com" {
+		t.Fatalf("bad URL: %s", url)
+	}
+}
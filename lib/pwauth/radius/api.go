@@ -0,0 +1,71 @@
+package radius
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"github.com/Symantec/keymaster/lib/simplestorage"
+)
+
+// Authentication methods.
+const (
+	MethodPAP      = "pap"
+	MethodMSCHAPv2 = "mschapv2"
+)
+
+type PasswordAuthenticator struct {
+	servers           []string
+	secret            []byte
+	method            string
+	timeout           time.Duration
+	enableChallenges  bool
+	logger            log.DebugLogger
+	mutex             sync.Mutex // Protect everything below.
+	pendingChallenges map[string]pendingChallengeType
+}
+
+// New creates a new PasswordAuthenticator using the RADIUS servers (as
+// host or host:port, the default port being 1812) with the shared secret
+// secret. The servers are tried in order until one answers within
+// timeoutSecs. method is MethodPAP or MethodMSCHAPv2 ("" means MethodPAP).
+// If enableChallenges is false, an Access-Challenge is a failed
+// authentication since nothing will answer it.
+// Log messages are written to logger.
+func New(servers []string, secret []byte, method string, timeoutSecs uint,
+	enableChallenges bool, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	return newAuthenticator(servers, secret, method, timeoutSecs,
+		enableChallenges, logger)
+}
+
+// PasswordAuthenticate will authenticate a user using the provided username
+// and password.
+// It returns true if the user is authenticated, else false (due to either
+// invalid username or incorrect password), and an error.
+// When the server answers with an Access-Challenge and challenges are
+// enabled, the user is authenticated and the challenge is kept for a few
+// minutes so that it can be answered with ValidateChallengeResponse.
+func (pa *PasswordAuthenticator) PasswordAuthenticate(username string,
+	password []byte) (bool, error) {
+	return pa.passwordAuthenticate(username, password)
+}
+
+func (pa *PasswordAuthenticator) UpdateStorage(storage simplestorage.SimpleStore) error {
+	return nil
+}
+
+// UserHasChallenge returns whether the last password authentication of
+// username was challenged and the challenge is still pending.
+func (pa *PasswordAuthenticator) UserHasChallenge(username string) bool {
+	return pa.userHasChallenge(username)
+}
+
+// ValidateChallengeResponse sends response to the challenge pending for
+// username, to the server that sent the challenge. The response is sent as
+// a PAP password whatever the method. It returns false if the response is
+// rejected, if the server challenges again or if no challenge is pending.
+func (pa *PasswordAuthenticator) ValidateChallengeResponse(username string,
+	response string) (bool, error) {
+	return pa.validateChallengeResponse(username, response)
+}
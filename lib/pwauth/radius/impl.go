@@ -0,0 +1,241 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const (
+	defaultPort          = "1812"
+	defaultTimeoutSecs   = 5
+	challengeLifetime    = 5 * time.Minute
+	nasIdentifier        = "keymaster"
+	retransmitInterval   = time.Second
+	maxPacketErrors      = 10
+	maxPendingChallenges = 10000
+)
+
+type pendingChallengeType struct {
+	expiresAt time.Time
+	server    string
+	state     []byte
+}
+
+func newAuthenticator(servers []string, secret []byte, method string,
+	timeoutSecs uint, enableChallenges bool, logger log.DebugLogger) (
+	*PasswordAuthenticator, error) {
+	if len(servers) < 1 {
+		return nil, errors.New("radius: no servers")
+	}
+	if len(secret) < 1 {
+		return nil, errors.New("radius: empty shared secret")
+	}
+	switch method {
+	case "":
+		method = MethodPAP
+	case MethodPAP, MethodMSCHAPv2:
+	default:
+		return nil, fmt.Errorf("radius: unknown method: %s", method)
+	}
+	if timeoutSecs < 1 {
+		timeoutSecs = defaultTimeoutSecs
+	}
+	authenticator := &PasswordAuthenticator{
+		secret:            secret,
+		method:            method,
+		timeout:           time.Duration(timeoutSecs) * time.Second,
+		enableChallenges:  enableChallenges,
+		logger:            logger,
+		pendingChallenges: make(map[string]pendingChallengeType),
+	}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, defaultPort)
+		}
+		authenticator.servers = append(authenticator.servers, server)
+	}
+	return authenticator, nil
+}
+
+func (pa *PasswordAuthenticator) newAccessRequest(username string) (
+	*radius.Packet, error) {
+	packet := radius.New(radius.CodeAccessRequest, pa.secret)
+	if err := rfc2865.UserName_SetString(packet, username); err != nil {
+		return nil, err
+	}
+	err := rfc2865.NASIdentifier_SetString(packet, nasIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// setUserPassword sets the User-Password of packet to password, padded with
+// NULs to a multiple of 16 bytes as RFC 2865 requires.
+func setUserPassword(packet *radius.Packet, password []byte) error {
+	paddedLength := (len(password) + 15) / 16 * 16
+	if paddedLength < 16 {
+		paddedLength = 16
+	}
+	paddedPassword := make([]byte, paddedLength)
+	copy(paddedPassword, password)
+	return rfc2865.UserPassword_Set(packet, paddedPassword)
+}
+
+// exchange sends packet to server and returns the verified response.
+func (pa *PasswordAuthenticator) exchange(packet *radius.Packet,
+	server string) (*radius.Packet, error) {
+	client := &radius.Client{
+		Retry:           retransmitInterval,
+		MaxPacketErrors: maxPacketErrors,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pa.timeout)
+	defer cancel()
+	return client.Exchange(ctx, packet, server)
+}
+
+func (pa *PasswordAuthenticator) passwordAuthenticate(username string,
+	password []byte) (bool, error) {
+	var lastErr error
+	for _, server := range pa.servers {
+		// Each request needs its own authenticator, which MS-CHAPv2 and
+		// the password encryption depend on.
+		packet, err := pa.newAccessRequest(username)
+		if err != nil {
+			return false, err
+		}
+		var mschap *mschapv2Request
+		switch pa.method {
+		case MethodPAP:
+			err = setUserPassword(packet, password)
+		case MethodMSCHAPv2:
+			mschap, err = addMSCHAPv2Request(packet, username, password)
+		}
+		if err != nil {
+			return false, err
+		}
+		response, err := pa.exchange(packet, server)
+		if err != nil {
+			pa.logger.Debugf(1, "radius: %s failed: %s", server, err)
+			lastErr = err
+			continue
+		}
+		return pa.handleResponse(username, server, response, mschap)
+	}
+	return false, fmt.Errorf("radius: no server answered: %s", lastErr)
+}
+
+func (pa *PasswordAuthenticator) handleResponse(username string,
+	server string, response *radius.Packet, mschap *mschapv2Request) (
+	bool, error) {
+	switch response.Code {
+	case radius.CodeAccessAccept:
+		pa.deleteChallenge(username)
+		if mschap != nil {
+			if err := mschap.checkSuccess(response); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case radius.CodeAccessChallenge:
+		if !pa.enableChallenges {
+			pa.logger.Debugf(1,
+				"radius: %s challenged %s, challenges are disabled", server,
+				username)
+			pa.deleteChallenge(username)
+			return false, nil
+		}
+		state := rfc2865.State_Get(response)
+		if len(state) < 1 {
+			return false, errors.New("radius: challenge without state")
+		}
+		pa.logger.Debugf(1, "radius: %s challenged %s: %s", server, username,
+			rfc2865.ReplyMessage_GetString(response))
+		pa.setChallenge(username, pendingChallengeType{
+			expiresAt: time.Now().Add(challengeLifetime),
+			server:    server,
+			state:     state,
+		})
+		return true, nil
+	case radius.CodeAccessReject:
+		pa.deleteChallenge(username)
+		return false, nil
+	default:
+		return false, fmt.Errorf("radius: unexpected response: %s",
+			response.Code)
+	}
+}
+
+func (pa *PasswordAuthenticator) setChallenge(username string,
+	challenge pendingChallengeType) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	if len(pa.pendingChallenges) >= maxPendingChallenges {
+		now := time.Now()
+		for name, challenge := range pa.pendingChallenges {
+			if now.After(challenge.expiresAt) {
+				delete(pa.pendingChallenges, name)
+			}
+		}
+	}
+	pa.pendingChallenges[username] = challenge
+}
+
+func (pa *PasswordAuthenticator) getChallenge(username string) (
+	pendingChallengeType, bool) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	challenge, ok := pa.pendingChallenges[username]
+	if ok && time.Now().After(challenge.expiresAt) {
+		delete(pa.pendingChallenges, username)
+		return pendingChallengeType{}, false
+	}
+	return challenge, ok
+}
+
+func (pa *PasswordAuthenticator) deleteChallenge(username string) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	delete(pa.pendingChallenges, username)
+}
+
+func (pa *PasswordAuthenticator) userHasChallenge(username string) bool {
+	_, ok := pa.getChallenge(username)
+	return ok
+}
+
+func (pa *PasswordAuthenticator) validateChallengeResponse(username string,
+	challengeResponse string) (bool, error) {
+	challenge, ok := pa.getChallenge(username)
+	if !ok {
+		pa.logger.Debugf(1, "radius: no pending challenge for %s", username)
+		return false, nil
+	}
+	packet, err := pa.newAccessRequest(username)
+	if err != nil {
+		return false, err
+	}
+	if err := setUserPassword(packet, []byte(challengeResponse)); err != nil {
+		return false, err
+	}
+	if err := rfc2865.State_Set(packet, challenge.state); err != nil {
+		return false, err
+	}
+	response, err := pa.exchange(packet, challenge.server)
+	if err != nil {
+		return false, err
+	}
+	if response.Code == radius.CodeAccessChallenge {
+		// Another round is not supported: fail rather than loop.
+		pa.deleteChallenge(username)
+		return false, nil
+	}
+	return pa.handleResponse(username, challenge.server, response, nil)
+}
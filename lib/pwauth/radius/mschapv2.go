@@ -0,0 +1,186 @@
+package radius
+
+import (
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// Microsoft vendor specific attributes, from RFC 2548.
+const (
+	vendorMicrosoft       = 311
+	msCHAPChallengeType   = 11
+	msCHAP2ResponseType   = 25
+	msCHAP2SuccessType    = 26
+	authenticatorRespSize = 42 // "S=" and 40 hex digits.
+)
+
+// Constants of the authenticator response, from RFC 2759.
+var (
+	magic1 = []byte("Magic server to client signing constant")
+	magic2 = []byte("Pad to make it do more than one iteration")
+)
+
+// mschapv2Request keeps what is needed to check the authenticator response
+// of the server.
+type mschapv2Request struct {
+	username      string
+	passwordHash  []byte
+	authChallenge []byte
+	peerChallenge []byte
+	ntResponse    []byte
+}
+
+func addMSCHAPv2Request(packet *radius.Packet, username string,
+	password []byte) (*mschapv2Request, error) {
+	request := &mschapv2Request{
+		username:      username,
+		passwordHash:  ntPasswordHash(password),
+		authChallenge: make([]byte, 16),
+		peerChallenge: make([]byte, 16),
+	}
+	if _, err := rand.Read(request.authChallenge); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(request.peerChallenge); err != nil {
+		return nil, err
+	}
+	request.ntResponse = generateNTResponse(request.authChallenge,
+		request.peerChallenge, username, request.passwordHash)
+	err := addVendorAttribute(packet, msCHAPChallengeType,
+		request.authChallenge)
+	if err != nil {
+		return nil, err
+	}
+	// Ident, Flags, Peer-Challenge, Reserved and NT-Response.
+	response := make([]byte, 0, 50)
+	response = append(response, packet.Identifier, 0)
+	response = append(response, request.peerChallenge...)
+	response = append(response, make([]byte, 8)...)
+	response = append(response, request.ntResponse...)
+	err = addVendorAttribute(packet, msCHAP2ResponseType, response)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// checkSuccess verifies the authenticator response in the MS-CHAP2-Success
+// attribute of response, which proves the server knows the password.
+func (request *mschapv2Request) checkSuccess(response *radius.Packet) error {
+	value := getVendorAttribute(response, msCHAP2SuccessType)
+	if len(value) < 1+authenticatorRespSize {
+		return errors.New("radius: missing MS-CHAP2-Success")
+	}
+	expected := generateAuthenticatorResponse(request.passwordHash,
+		request.ntResponse, request.peerChallenge, request.authChallenge,
+		request.username)
+	if subtle.ConstantTimeCompare(value[1:1+authenticatorRespSize],
+		[]byte(expected)) != 1 {
+		return errors.New("radius: bad MS-CHAPv2 authenticator response")
+	}
+	return nil
+}
+
+func addVendorAttribute(packet *radius.Packet, vendorType byte,
+	value []byte) error {
+	attribute := append([]byte{vendorType, byte(len(value) + 2)}, value...)
+	vsa, err := radius.NewVendorSpecific(vendorMicrosoft, attribute)
+	if err != nil {
+		return err
+	}
+	packet.Add(rfc2865.VendorSpecific_Type, vsa)
+	return nil
+}
+
+func getVendorAttribute(packet *radius.Packet, vendorType byte) []byte {
+	for _, vsa := range packet.Attributes[rfc2865.VendorSpecific_Type] {
+		vendorID, value, err := radius.VendorSpecific(vsa)
+		if err != nil || vendorID != vendorMicrosoft || len(value) < 2 {
+			continue
+		}
+		length := int(value[1])
+		if value[0] != vendorType || length < 2 || length > len(value) {
+			continue
+		}
+		return value[2:length]
+	}
+	return nil
+}
+
+func ntPasswordHash(password []byte) []byte {
+	hash := md4.New()
+	for _, code := range utf16.Encode([]rune(string(password))) {
+		hash.Write([]byte{byte(code), byte(code >> 8)})
+	}
+	return hash.Sum(nil)
+}
+
+func challengeHash(peerChallenge, authChallenge []byte,
+	username string) []byte {
+	hash := sha1.New()
+	hash.Write(peerChallenge)
+	hash.Write(authChallenge)
+	hash.Write([]byte(username))
+	return hash.Sum(nil)[:8]
+}
+
+// expandDESKey spreads the 56 bits of key over 8 bytes, leaving out the
+// parity bits.
+func expandDESKey(key []byte) []byte {
+	return []byte{
+		key[0] & 0xfe,
+		key[0]<<7 | key[1]>>1,
+		key[1]<<6 | key[2]>>2,
+		key[2]<<5 | key[3]>>3,
+		key[3]<<4 | key[4]>>4,
+		key[4]<<3 | key[5]>>5,
+		key[5]<<2 | key[6]>>6,
+		key[6] << 1,
+	}
+}
+
+func challengeResponse(challenge, passwordHash []byte) []byte {
+	zPasswordHash := make([]byte, 21)
+	copy(zPasswordHash, passwordHash)
+	response := make([]byte, 24)
+	for i := 0; i < 3; i++ {
+		block, err := des.NewCipher(expandDESKey(zPasswordHash[i*7:]))
+		if err != nil {
+			panic(err) // The key size is always right.
+		}
+		block.Encrypt(response[i*8:], challenge)
+	}
+	return response
+}
+
+func generateNTResponse(authChallenge, peerChallenge []byte,
+	username string, passwordHash []byte) []byte {
+	return challengeResponse(
+		challengeHash(peerChallenge, authChallenge, username), passwordHash)
+}
+
+func generateAuthenticatorResponse(passwordHash, ntResponse, peerChallenge,
+	authChallenge []byte, username string) string {
+	passwordHashHash := md4.New()
+	passwordHashHash.Write(passwordHash)
+	hash := sha1.New()
+	hash.Write(passwordHashHash.Sum(nil))
+	hash.Write(ntResponse)
+	hash.Write(magic1)
+	digest := hash.Sum(nil)
+	hash = sha1.New()
+	hash.Write(digest)
+	hash.Write(challengeHash(peerChallenge, authChallenge, username))
+	hash.Write(magic2)
+	return "S=" + strings.ToUpper(hex.EncodeToString(hash.Sum(nil)))
+}
@@ -0,0 +1,261 @@
+package radius
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Symantec/Dominator/lib/log/testlogger"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const testSecret = "a-shared-secret"
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Test vectors from RFC 2759 section 9.2.
+func TestMSCHAPv2Vectors(t *testing.T) {
+	authChallenge := mustDecodeHex(t, "5B5D7C7D7B3F2F3E3C2C602132262628")
+	peerChallenge := mustDecodeHex(t, "21402324255E262A28295F2B3A337C7E")
+	passwordHash := ntPasswordHash([]byte("clientPass"))
+	if !bytes.Equal(passwordHash,
+		mustDecodeHex(t, "44EBBA8D5312B8D611474411F56989AE")) {
+		t.Fatalf("bad password hash: %x", passwordHash)
+	}
+	challenge := challengeHash(peerChallenge, authChallenge, "User")
+	if !bytes.Equal(challenge, mustDecodeHex(t, "D02E4386BCE91226")) {
+		t.Fatalf("bad challenge: %x", challenge)
+	}
+	ntResponse := generateNTResponse(authChallenge, peerChallenge, "User",
+		passwordHash)
+	expectedResponse := mustDecodeHex(t,
+		"82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF")
+	if !bytes.Equal(ntResponse, expectedResponse) {
+		t.Fatalf("bad NT response: %x", ntResponse)
+	}
+	authResponse := generateAuthenticatorResponse(passwordHash, ntResponse,
+		peerChallenge, authChallenge, "User")
+	if authResponse != "S=407A5589115FD0D6209F510FE9C04566932CDA56" {
+		t.Fatalf("bad authenticator response: %s", authResponse)
+	}
+}
+
+// testHandler is an in-process RADIUS server knowing a-user and
+// challenged-user, both with good-password. challenged-user is then
+// challenged for 123456.
+type testHandler struct {
+	badAuthenticatorResponse bool
+}
+
+func (h *testHandler) checkPassword(r *radius.Request) bool {
+	username := rfc2865.UserName_GetString(r.Packet)
+	if password, err := rfc2865.UserPassword_Lookup(r.Packet); err == nil {
+		return string(password) == "good-password"
+	}
+	authChallenge := getVendorAttribute(r.Packet, msCHAPChallengeType)
+	response := getVendorAttribute(r.Packet, msCHAP2ResponseType)
+	if len(authChallenge) != 16 || len(response) != 50 {
+		return false
+	}
+	expected := generateNTResponse(authChallenge, response[2:18], username,
+		ntPasswordHash([]byte("good-password")))
+	return bytes.Equal(response[26:], expected)
+}
+
+func (h *testHandler) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	username := rfc2865.UserName_GetString(r.Packet)
+	if state := rfc2865.State_GetString(r.Packet); state != "" {
+		code := radius.CodeAccessReject
+		if state == "a-state" &&
+			rfc2865.UserPassword_GetString(r.Packet) == "123456" {
+			code = radius.CodeAccessAccept
+		}
+		w.Write(r.Response(code))
+		return
+	}
+	if (username != "a-user" && username != "challenged-user") ||
+		!h.checkPassword(r) {
+		w.Write(r.Response(radius.CodeAccessReject))
+		return
+	}
+	if username == "challenged-user" {
+		response := r.Response(radius.CodeAccessChallenge)
+		rfc2865.State_SetString(response, "a-state")
+		rfc2865.ReplyMessage_SetString(response, "Enter your code")
+		w.Write(response)
+		return
+	}
+	response := r.Response(radius.CodeAccessAccept)
+	authChallenge := getVendorAttribute(r.Packet, msCHAPChallengeType)
+	if authChallenge != nil {
+		mschapResponse := getVendorAttribute(r.Packet, msCHAP2ResponseType)
+		password := "good-password"
+		if h.badAuthenticatorResponse {
+			password = "other-password"
+		}
+		authResponse := generateAuthenticatorResponse(
+			ntPasswordHash([]byte(password)), mschapResponse[26:],
+			mschapResponse[2:18], authChallenge, username)
+		addVendorAttribute(response, msCHAP2SuccessType,
+			append([]byte{mschapResponse[0]}, authResponse...))
+	}
+	w.Write(response)
+}
+
+func startServer(t *testing.T, handler radius.Handler) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &radius.PacketServer{
+		Handler:      handler,
+		SecretSource: radius.StaticSecretSource([]byte(testSecret)),
+	}
+	go server.Serve(conn)
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func newTestAuthenticator(t *testing.T, servers []string,
+	method string) *PasswordAuthenticator {
+	pa, err := New(servers, []byte(testSecret), method, 1, true,
+		testlogger.New(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa.timeout = 200 * time.Millisecond
+	return pa
+}
+
+func checkAuthenticate(t *testing.T, pa *PasswordAuthenticator,
+	username, password string, expected bool) {
+	ok, err := pa.PasswordAuthenticate(username, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != expected {
+		t.Fatalf("%s/%s: got %v, want %v", username, password, ok, expected)
+	}
+}
+
+func TestPasswordAuthenticate(t *testing.T) {
+	addr, cleanup := startServer(t, &testHandler{})
+	defer cleanup()
+	for _, method := range []string{MethodPAP, MethodMSCHAPv2} {
+		pa := newTestAuthenticator(t, []string{addr}, method)
+		checkAuthenticate(t, pa, "a-user", "good-password", true)
+		checkAuthenticate(t, pa, "a-user", "bad-password", false)
+		checkAuthenticate(t, pa, "bad-user", "good-password", false)
+	}
+	badAddr, badCleanup := startServer(t,
+		&testHandler{badAuthenticatorResponse: true})
+	defer badCleanup()
+	pa := newTestAuthenticator(t, []string{badAddr}, MethodMSCHAPv2)
+	if _, err := pa.PasswordAuthenticate("a-user",
+		[]byte("good-password")); err == nil {
+		t.Fatal("bad authenticator response accepted")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	addr, cleanup := startServer(t, &testHandler{})
+	defer cleanup()
+	pa := newTestAuthenticator(t,
+		[]string{silent.LocalAddr().String(), addr}, MethodPAP)
+	checkAuthenticate(t, pa, "a-user", "good-password", true)
+	checkAuthenticate(t, pa, "a-user", "bad-password", false)
+	pa = newTestAuthenticator(t, []string{silent.LocalAddr().String()},
+		MethodPAP)
+	if _, err := pa.PasswordAuthenticate("a-user",
+		[]byte("good-password")); err == nil {
+		t.Fatal("no error without answering server")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	addr, cleanup := startServer(t, &testHandler{})
+	defer cleanup()
+	pa := newTestAuthenticator(t, []string{addr}, MethodPAP)
+	if pa.UserHasChallenge("challenged-user") {
+		t.Fatal("challenge pending before authentication")
+	}
+	checkAuthenticate(t, pa, "challenged-user", "good-password", true)
+	if !pa.UserHasChallenge("challenged-user") {
+		t.Fatal("challenge not pending")
+	}
+	ok, err := pa.ValidateChallengeResponse("challenged-user", "654321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("bad challenge response accepted")
+	}
+	if pa.UserHasChallenge("challenged-user") {
+		t.Fatal("challenge still pending after reject")
+	}
+	checkAuthenticate(t, pa, "challenged-user", "good-password", true)
+	ok, err = pa.ValidateChallengeResponse("challenged-user", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("good challenge response rejected")
+	}
+	ok, err = pa.ValidateChallengeResponse("challenged-user", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("challenge response accepted twice")
+	}
+}
+
+func TestChallengeDisabled(t *testing.T) {
+	addr, cleanup := startServer(t, &testHandler{})
+	defer cleanup()
+	pa := newTestAuthenticator(t, []string{addr}, MethodPAP)
+	pa.enableChallenges = false
+	checkAuthenticate(t, pa, "challenged-user", "good-password", false)
+	if pa.UserHasChallenge("challenged-user") {
+		t.Fatal("challenge kept while challenges are disabled")
+	}
+	checkAuthenticate(t, pa, "a-user", "good-password", true)
+}
+
+func TestNew(t *testing.T) {
+	logger := testlogger.New(t)
+	if _, err := New(nil, []byte(testSecret), "", 0, true, logger); err == nil {
+		t.Fatal("no servers accepted")
+	}
+	if _, err := New([]string{"a"}, nil, "", 0, true, logger); err == nil {
+		t.Fatal("empty secret accepted")
+	}
+	if _, err := New([]string{"a"}, []byte(testSecret), "chap", 0,
+		true, logger); err == nil {
+		t.Fatal("unknown method accepted")
+	}
+	pa, err := New([]string{"a", "b:1645"}, []byte(testSecret), "", 0, true,
+		logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa.servers[0] != "a:1812" || pa.servers[1] != "b:1645" {
+		t.Fatalf("bad servers: %v", pa.servers)
+	}
+	if pa.method != MethodPAP {
+		t.Fatalf("bad default method: %s", pa.method)
+	}
+}
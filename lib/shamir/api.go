@@ -0,0 +1,19 @@
+/*
+Package shamir implements Shamir's Secret Sharing over GF(2^8).
+
+Each share is one byte longer than the secret: the last byte holds the
+x coordinate of the share.
+*/
+package shamir
+
+// Split splits secret into parts shares, any threshold of which are needed
+// to reconstruct it. 2 <= threshold <= parts <= 255.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	return split(secret, parts, threshold)
+}
+
+// Combine reconstructs the secret from shares created by Split. If fewer
+// shares than the threshold are given the result is garbage, not an error.
+func Combine(shares [][]byte) ([]byte, error) {
+	return combine(shares)
+}
@@ -0,0 +1,108 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+func gfMul(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// gfInverse uses a^254 == a^-1 for a != 0.
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+func gfDiv(a, b byte) byte {
+	return gfMul(a, gfInverse(b))
+}
+
+// evaluate returns the value at x of the polynomial with the given
+// coefficients, lowest degree first.
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+func split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+	if threshold < 2 || parts < threshold || parts > 255 {
+		return nil, errors.New("invalid parts or threshold")
+	}
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold)
+	for byteIndex, secretByte := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		coefficients[0] = secretByte
+		for _, share := range shares {
+			share[byteIndex] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+func combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("need at least 2 shares")
+	}
+	shareLength := len(shares[0])
+	if shareLength < 2 {
+		return nil, errors.New("share too short")
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != shareLength {
+			return nil, errors.New("shares have different lengths")
+		}
+		x := share[shareLength-1]
+		if x == 0 || seen[x] {
+			return nil, errors.New("invalid or duplicate share")
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, shareLength-1)
+	for i := range shares {
+		basis := byte(1)
+		for j := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xs[j], xs[i]^xs[j]))
+			}
+		}
+		for byteIndex := range secret {
+			secret[byteIndex] ^= gfMul(shares[i][byteIndex], basis)
+		}
+	}
+	return secret, nil
+}
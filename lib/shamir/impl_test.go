@@ -0,0 +1,68 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInverse(byte(a))) != 1 {
+			t.Fatalf("bad inverse for %d", a)
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 || len(shares[0]) != len(secret)+1 {
+		t.Fatal("bad shares")
+	}
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4},
+		{0, 1, 2, 3, 4}} {
+		var selected [][]byte
+		for _, index := range subset {
+			selected = append(selected, shares[index])
+		}
+		combined, err := Combine(selected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Fatalf("bad secret from shares %v", subset)
+		}
+	}
+	combined, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Fatal("secret recovered below threshold")
+	}
+}
+
+func TestSplitCombineFail(t *testing.T) {
+	if _, err := Split(nil, 3, 2); err == nil {
+		t.Fatal("should have failed with empty secret")
+	}
+	if _, err := Split([]byte("secret"), 2, 3); err == nil {
+		t.Fatal("should have failed with threshold > parts")
+	}
+	if _, err := Split([]byte("secret"), 3, 1); err == nil {
+		t.Fatal("should have failed with threshold 1")
+	}
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Combine([][]byte{shares[0], shares[0]}); err == nil {
+		t.Fatal("should have failed with duplicate shares")
+	}
+	if _, err := Combine([][]byte{shares[0], shares[1][1:]}); err == nil {
+		t.Fatal("should have failed with different lengths")
+	}
+}
@@ -118,6 +118,36 @@ type userInfoResponseBody struct {
 	}
 }
 
+type removeCredentialRequest struct {
+	RequestId      string
+	UserId         string
+	CredentialId   string
+	CredentialType string
+}
+
+const removeCredentialRequestTemplate = `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:vip="https://schemas.symantec.com/vip/2011/04/vipuserservices">
+   <soapenv:Header/>
+   <soapenv:Body>
+      <vip:RemoveCredentialRequest>
+         <vip:requestId>{{.RequestId}}</vip:requestId>
+         <vip:userId>{{html .UserId}}</vip:userId>
+         <vip:credentialId>{{html .CredentialId}}</vip:credentialId>
+         <vip:credentialType>{{html .CredentialType}}</vip:credentialType>
+      </vip:RemoveCredentialRequest>
+   </soapenv:Body>
+</soapenv:Envelope>`
+
+type removeCredentialResponseBody struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		RemoveCredentialResponse struct {
+			RequestId     string `xml:"requestId"`
+			Status        string `xml:"status"`
+			StatusMessage string `xml:"statusMessage"`
+		} `xml:"RemoveCredentialResponse"`
+	}
+}
+
 type authenticateUserWithPushRequest struct {
 	RequestId             string `xml:"requestId"`
 	UserId                string `xml:"userId"`
@@ -309,10 +339,20 @@ successful poll responst
 </S:Envelope>
 */
 
+// Credential is a VIP credential bound to a user.
+type Credential struct {
+	ID            string
+	Type          string
+	Status        string
+	FriendlyName  string
+	LastAuthnTime time.Time
+}
+
 type Client struct {
 	Cert                            tls.Certificate
 	VipUserServicesURL              string
 	VipUserServiceAuthenticationURL string
+	VipUserServiceManagementURL     string
 	RootCAs                         *x509.CertPool
 	VipPushMessageText              string //what is shown on the shown on the alarm
 	VipPushDisplayMessageText       string // what is shown after
@@ -331,6 +371,7 @@ func NewClient(certPEMBlock, keyPEMBlock []byte) (client Client, err error) {
 	client.VipUserServicesURL = "https://userservices-auth.vip.symantec.com/vipuserservices/QueryService_1_8"
 	//https://userservices-auth.vip.symantec.com/vipuserservices/QueryService_1_8
 	client.VipUserServiceAuthenticationURL = "https://userservices-auth.vip.symantec.com/vipuserservices/AuthenticationService_1_8"
+	client.VipUserServiceManagementURL = "https://userservices-auth.vip.symantec.com/vipuserservices/ManagementService_1_8"
 
 	client.VipPushMessageText = "Symantec Push Authentication Request"
 	client.VipPushDisplayMessageText = "Sign In request from Some site"
@@ -379,6 +420,10 @@ func (client *Client) postBytesUserServicesAuthentication(data []byte) ([]byte,
 	return client.postBytesVip(data, client.VipUserServiceAuthenticationURL, "text/xml")
 }
 
+func (client *Client) postBytesUserServicesManagement(data []byte) ([]byte, error) {
+	return client.postBytesVip(data, client.VipUserServiceManagementURL, "text/xml")
+}
+
 func genNewRequestID() string {
 	nBig, err := rand.Int(rand.Reader, big.NewInt(1000000))
 	if err != nil {
@@ -470,6 +515,87 @@ func (client *Client) GetActiveTokens(userID string) ([]string, error) {
 	return enabledTokenID, nil
 }
 
+// GetUserCredentials returns all the credentials bound to userID.
+func (client *Client) GetUserCredentials(userID string) ([]Credential, error) {
+	userInfoRequest := vipUserInfoRequest{RequestId: genNewRequestID(),
+		UserId: userID}
+	tmpl, err := template.New("userInfo").Parse(userInfoRequestTemplate)
+	if err != nil {
+		panic(err)
+	}
+	var requestBuffer bytes.Buffer
+	err = tmpl.Execute(&requestBuffer, userInfoRequest)
+	if err != nil {
+		panic(err)
+	}
+	responseBytes, err := client.postBytesUserServices(requestBuffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var response userInfoResponseBody
+	err = xml.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	userInfo := response.Body.VipResponseGetUserInfo
+	if userInfo.Status != "0000" {
+		return nil, fmt.Errorf("vip: get user info failed: %s %s",
+			userInfo.Status, userInfo.StatusMessage)
+	}
+	var credentials []Credential
+	for _, binding := range userInfo.CredentialBindingDetail {
+		credential := Credential{
+			ID:           binding.CredentialId,
+			Type:         binding.CredentialType,
+			Status:       binding.CredentialStatus,
+			FriendlyName: binding.BindingDetail.FriendlyName,
+		}
+		if binding.BindingDetail.LastAuthnTime != "" {
+			credential.LastAuthnTime, _ = time.Parse(time.RFC3339,
+				binding.BindingDetail.LastAuthnTime)
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// RemoveCredential unbinds the credential credentialID from userID.
+func (client *Client) RemoveCredential(userID, credentialID,
+	credentialType string) error {
+	request := removeCredentialRequest{
+		RequestId:      genNewRequestID(),
+		UserId:         userID,
+		CredentialId:   credentialID,
+		CredentialType: credentialType,
+	}
+	tmpl, err := template.New("removeCredential").Parse(
+		removeCredentialRequestTemplate)
+	if err != nil {
+		panic(err)
+	}
+	var requestBuffer bytes.Buffer
+	err = tmpl.Execute(&requestBuffer, request)
+	if err != nil {
+		panic(err)
+	}
+	responseBytes, err := client.postBytesUserServicesManagement(
+		requestBuffer.Bytes())
+	if err != nil {
+		return err
+	}
+	var response removeCredentialResponseBody
+	err = xml.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return err
+	}
+	removeResponse := response.Body.RemoveCredentialResponse
+	if removeResponse.Status != "0000" {
+		return fmt.Errorf("vip: remove credential failed: %s %s",
+			removeResponse.Status, removeResponse.StatusMessage)
+	}
+	return nil
+}
+
 func (client *Client) ValidateUserOTP(userID string, OTPValue int) (bool, error) {
 	tokenList, err := client.GetActiveTokens(userID)
 	if err != nil {
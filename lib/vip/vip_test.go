@@ -1,10 +1,13 @@
 package vip
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -181,3 +184,110 @@ func TestVerifySingleTokenFail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+const exampleGetUserInfoResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/">
+  <S:Body>
+    <GetUserInfoResponse xmlns="https://schemas.symantec.com/vip/2011/04/vipuserservices">
+      <requestId>123</requestId>
+      <status>0000</status>
+      <statusMessage>Success</statusMessage>
+      <userId>username</userId>
+      <numBindings>2</numBindings>
+      <credentialBindingDetail>
+        <credentialId>VSMT12345678</credentialId>
+        <credentialType>STANDARD_OTP</credentialType>
+        <credentialStatus>ENABLED</credentialStatus>
+        <bindingDetail>
+          <bindStatus>ENABLED</bindStatus>
+          <friendlyName>phone</friendlyName>
+          <lastAuthnTime>2018-03-01T10:00:00.000Z</lastAuthnTime>
+        </bindingDetail>
+      </credentialBindingDetail>
+      <credentialBindingDetail>
+        <credentialId>VSMT87654321</credentialId>
+        <credentialType>STANDARD_OTP</credentialType>
+        <credentialStatus>DISABLED</credentialStatus>
+        <bindingDetail>
+          <bindStatus>ENABLED</bindStatus>
+        </bindingDetail>
+      </credentialBindingDetail>
+    </GetUserInfoResponse>
+  </S:Body>
+</S:Envelope>`
+
+const exampleRemoveCredentialResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/">
+  <S:Body>
+    <RemoveCredentialResponse xmlns="https://schemas.symantec.com/vip/2011/04/vipuserservices">
+      <requestId>123</requestId>
+      <status>%s</status>
+      <statusMessage>Success</statusMessage>
+    </RemoveCredentialResponse>
+  </S:Body>
+</S:Envelope>`
+
+func newUserServicesTestClient(t *testing.T,
+	handler http.HandlerFunc) (*Client, func()) {
+	server := httptest.NewTLSServer(handler)
+	client, err := NewClient([]byte(localhostCertPem), []byte(localhostKeyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RootCAs = x509.NewCertPool()
+	client.RootCAs.AddCert(server.Certificate())
+	client.VipUserServicesURL = server.URL + "/query"
+	client.VipUserServiceManagementURL = server.URL + "/management"
+	return &client, server.Close
+}
+
+func TestGetUserCredentials(t *testing.T) {
+	client, cleanup := newUserServicesTestClient(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, exampleGetUserInfoResponse)
+		})
+	defer cleanup()
+	credentials, err := client.GetUserCredentials("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 2 {
+		t.Fatalf("expected 2 credentials, got %d", len(credentials))
+	}
+	if credentials[0].ID != "VSMT12345678" ||
+		credentials[0].FriendlyName != "phone" ||
+		credentials[0].Status != "ENABLED" {
+		t.Fatalf("bad credential: %+v", credentials[0])
+	}
+	lastAuthn := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	if !credentials[0].LastAuthnTime.Equal(lastAuthn) {
+		t.Fatalf("bad last authn time: %s", credentials[0].LastAuthnTime)
+	}
+	if !credentials[1].LastAuthnTime.IsZero() {
+		t.Fatal("unexpected last authn time")
+	}
+}
+
+func TestRemoveCredential(t *testing.T) {
+	status := "0000"
+	client, cleanup := newUserServicesTestClient(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path != "/management" ||
+				!bytes.Contains(body, []byte("VSMT12345678")) ||
+				!bytes.Contains(body, []byte("a&amp;b")) {
+				t.Errorf("bad request to %s: %s", r.URL.Path, body)
+			}
+			fmt.Fprintf(w, exampleRemoveCredentialResponse, status)
+		})
+	defer cleanup()
+	err := client.RemoveCredential("a&b", "VSMT12345678", "STANDARD_OTP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = "6003"
+	err = client.RemoveCredential("a&b", "VSMT12345678", "STANDARD_OTP")
+	if err == nil {
+		t.Fatal("expected error for failed removal")
+	}
+}
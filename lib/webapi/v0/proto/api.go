@@ -8,9 +8,24 @@ const (
 	AuthTypeU2F           = "U2F"
 	AuthTypeSymantecVIP   = "SymantecVIP"
 	AuthTypeIPCertificate = "IPCertificate"
+	AuthTypeTOTP          = "TOTP"
+	AuthTypePushApproval  = "PushApproval"
+	AuthTypeDuo           = "Duo"
+	AuthTypeOkta          = "Okta"
+	AuthTypeRADIUS        = "RADIUS"
 )
 
 type LoginResponse struct {
 	Message         string   `json:"message"`
 	CertAuthBackend []string `json:"auth_backend"`
 }
+
+const KubernetesClustersPath = "/public/kubernetesClusters"
+
+// KubernetesCluster is the information needed to write a kubeconfig for a
+// Kubernetes cluster profile.
+type KubernetesCluster struct {
+	Name                     string `json:"name"`
+	APIServerURL             string `json:"api_server_url"`
+	CertificateAuthorityData []byte `json:"certificate_authority_data,omitempty"`
+}
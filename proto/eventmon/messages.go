@@ -4,16 +4,28 @@ const (
 	ConnectString = "200 Connected to keymaster eventmon service"
 	HttpPath      = "/eventmon/v0"
 
-	AuthTypePassword    = "Password"
-	AuthTypeSymantecVIP = "SymantecVIP"
-	AuthTypeU2F         = "U2F"
+	AuthTypeDuo          = "Duo"
+	AuthTypeKerberos     = "Kerberos"
+	AuthTypeOkta         = "Okta"
+	AuthTypePassword     = "Password"
+	AuthTypePushApproval = "PushApproval"
+	AuthTypeRADIUS       = "RADIUS"
+	AuthTypeSymantecVIP  = "SymantecVIP"
+	AuthTypeTOTP         = "TOTP"
+	AuthTypeU2F          = "U2F"
 
 	EventTypeAuth                 = "Auth"
+	EventTypeSecurity             = "Security"
 	EventTypeServiceProviderLogin = "ServiceProviderLogin"
 	EventTypeSSHCert              = "SSHCert"
 	EventTypeWebLogin             = "WebLogin"
 	EventTypeX509Cert             = "X509Cert"
 
+	SecurityEventTypeEnrollmentBypassIssued = "EnrollmentBypassIssued"
+	SecurityEventTypeEnrollmentBypassUsed   = "EnrollmentBypassUsed"
+	SecurityEventTypeSecondFactorReset      = "SecondFactorReset"
+	SecurityEventTypeU2FCounterRegression   = "U2FCounterRegression"
+
 	VIPAuthTypeOTP  = "VIPAuthOTP"
 	VIPAuthTypePush = "VIPAuthPush"
 )
@@ -26,9 +38,14 @@ type EventV0 struct {
 	// Present for SSH and X509 certificate events.
 	CertData []byte `json:",omitempty"`
 
-	AuthType           string `json:",omitempty"` // Present for Auth events.
+	AuthType           string `json:",omitempty"` // Auth and some Security.
 	ServiceProviderUrl string `json:",omitempty"` // Present for SPLogin events.
-	Username           string `json:",omitempty"` // Auth, SPLogin and WebLogin
+	Username           string `json:",omitempty"` // All but cert events.
+
+	// Present for Security events.
+	SecurityEventType string `json:",omitempty"`
+	AdminUsername     string `json:",omitempty"` // For admin actions.
+	Reason            string `json:",omitempty"`
 
 	VIPAuthType string `json:",omitempty"` // Present for VIP Auth events.
 }